@@ -36,6 +36,7 @@ import (
 	"github.com/BerithFoundation/berith-chain/core/rawdb"
 	"github.com/BerithFoundation/berith-chain/core/state"
 	"github.com/BerithFoundation/berith-chain/core/types"
+	"github.com/BerithFoundation/berith-chain/internals/era"
 	"github.com/BerithFoundation/berith-chain/params"
 	"github.com/BerithFoundation/berith-chain/rlp"
 	"github.com/BerithFoundation/berith-chain/rpc"
@@ -77,6 +78,43 @@ func (api *PublicBerithAPI) ChainId() hexutil.Uint64 {
 	return (hexutil.Uint64)(chainID.Uint64())
 }
 
+// GetSupply returns the supply of the given block along with the issuance, the
+// burnt balances, the fees and the staking flows of the block. It requires the
+// supply index to be enabled and to have caught up with the block.
+func (api *PublicBerithAPI) GetSupply(blockNr rpc.BlockNumber) (map[string]interface{}, error) {
+	if api.e.supplyIndexer == nil {
+		return nil, errors.New("supply index not enabled")
+	}
+	var header *types.Header
+	if blockNr == rpc.LatestBlockNumber || blockNr == rpc.PendingBlockNumber {
+		header = api.e.blockchain.CurrentHeader()
+	} else {
+		header = api.e.blockchain.GetHeaderByNumber(uint64(blockNr))
+	}
+	if header == nil {
+		return nil, fmt.Errorf("block #%d not found", blockNr)
+	}
+	supply := ReadSupply(api.e.chainDb, header.Hash(), header.Number.Uint64())
+	if supply == nil {
+		return nil, fmt.Errorf("block #%d not indexed yet", header.Number)
+	}
+	return map[string]interface{}{
+		"number":       hexutil.Uint64(header.Number.Uint64()),
+		"hash":         header.Hash(),
+		"total":        (*hexutil.Big)(supply.Total()),
+		"circulating":  (*hexutil.Big)(supply.Circulating),
+		"staked":       (*hexutil.Big)(supply.Staked),
+		"behind":       (*hexutil.Big)(supply.Behind),
+		"issuance":     (*hexutil.Big)(supply.Issuance),
+		"matured":      (*hexutil.Big)(supply.Matured),
+		"burnt":        (*hexutil.Big)(supply.Burnt),
+		"fees":         (*hexutil.Big)(supply.Fees),
+		"stakeIn":      (*hexutil.Big)(supply.StakeIn),
+		"stakeOut":     (*hexutil.Big)(supply.StakeOut),
+		"inconsistent": supply.Inconsistent,
+	}, nil
+}
+
 // PublicMinerAPI provides an API to control the miner.
 // It offers only methods that operate on data that pose no security risk when it is publicly accessible.
 type PublicMinerAPI struct {
@@ -249,6 +287,23 @@ func (api *PrivateAdminAPI) ImportChain(file string) (bool, error) {
 	return true, nil
 }
 
+// ExportHistory exports the canonical blocks [first, last] into a directory of
+// archive files, along with their receipts and total difficulties.
+func (api *PrivateAdminAPI) ExportHistory(dir string, first, last uint64) (bool, error) {
+	if err := era.Export(api.e.BlockChain(), dir, first, last); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ImportHistory verifies and imports the archive files of a directory.
+func (api *PrivateAdminAPI) ImportHistory(dir string) (bool, error) {
+	if err := era.Import(api.e.BlockChain(), dir); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 // PublicDebugAPI is the collection of Berith full node APIs exposed
 // over the public debugging endpoint.
 type PublicDebugAPI struct {
@@ -279,7 +334,7 @@ func (api *PublicDebugAPI) DumpBlock(blockNr rpc.BlockNumber) (state.Dump, error
 	if block == nil {
 		return state.Dump{}, fmt.Errorf("block #%d not found", blockNr)
 	}
-	stateDb, err := api.e.BlockChain().StateAt(block.Root())
+	stateDb, err := api.e.BlockChain().StateAtHeader(block.Header())
 	if err != nil {
 		return state.Dump{}, err
 	}
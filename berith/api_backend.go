@@ -119,7 +119,7 @@ func (b *BerAPIBackend) StateAndHeaderByNumber(ctx context.Context, blockNr rpc.
 	if header == nil || err != nil {
 		return nil, nil, err
 	}
-	stateDb, err := b.e.BlockChain().StateAt(header.Root)
+	stateDb, err := b.e.BlockChain().StateAtHeader(header)
 	return stateDb, header, err
 }
 
@@ -138,7 +138,7 @@ func (b *BerAPIBackend) StateAndHeaderByNumberOrHash(ctx context.Context, blockN
 		if blockNrOrHash.RequireCanonical && b.e.blockchain.GetCanonicalHash(header.Number.Uint64()) != hash {
 			return nil, nil, errors.New("hash is not currently canonical")
 		}
-		stateDb, err := b.e.BlockChain().StateAt(header.Root)
+		stateDb, err := b.e.BlockChain().StateAtHeader(header)
 		return stateDb, header, err
 	}
 	return nil, nil, errors.New("invalid arguments; neither block nor hash specified")
@@ -200,6 +200,14 @@ func (b *BerAPIBackend) SendTx(ctx context.Context, signedTx *types.Transaction)
 	return b.e.txPool.AddLocal(signedTx)
 }
 
+func (b *BerAPIBackend) SendPrivateTx(ctx context.Context, signedTx *types.Transaction) error {
+	return b.e.txPool.AddPrivate(signedTx)
+}
+
+func (b *BerAPIBackend) SendTxs(ctx context.Context, signedTxs types.Transactions) error {
+	return b.e.txPool.AddLocalBatch(signedTxs)
+}
+
 func (b *BerAPIBackend) GetPoolTransactions() (types.Transactions, error) {
 	pending, err := b.e.txPool.Pending()
 	if err != nil {
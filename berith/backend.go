@@ -88,6 +88,7 @@ type Berith struct {
 
 	bloomRequests chan chan *bloombits.Retrieval // Channel receiving bloom data retrieval requests
 	bloomIndexer  *core.ChainIndexer             // Bloom indexer operating during block imports
+	supplyIndexer *core.ChainIndexer             // [BERITH] Supply indexer, nil unless enabled
 
 	APIBackend *BerAPIBackend
 
@@ -118,7 +119,7 @@ func New(ctx *node.ServiceContext, config *Config) (*Berith, error) {
 		config.MinerGasPrice = new(big.Int).Set(DefaultConfig.MinerGasPrice)
 	}
 	// Assemble the Berith object
-	chainDb, err := CreateDB(ctx, config, "chaindata")
+	chainDb, err := ctx.OpenDatabaseWithFreezer("chaindata", config.DatabaseCache, config.DatabaseHandles, config.DatabaseFreezer, "berith/db/chaindata/")
 	if err != nil {
 		return nil, err
 	}
@@ -128,7 +129,7 @@ func New(ctx *node.ServiceContext, config *Config) (*Berith, error) {
 	}
 	log.Info("Initialised chain configuration", "config", chainConfig)
 
-	stakingDB := &staking.StakingDB{NoPruning: config.NoPruning}
+	stakingDB := &staking.StakingDB{NoPruning: config.NoPruning, Engine: ctx.DatabaseEngine()}
 	stakingDBPath := ctx.ResolvePath("stakingDB")
 	if stkErr := stakingDB.CreateDB(stakingDBPath, staking.NewStakers); stkErr != nil {
 		return nil, stkErr
@@ -165,9 +166,9 @@ func New(ctx *node.ServiceContext, config *Config) (*Berith, error) {
 			EWASMInterpreter:        config.EWASMInterpreter,
 			EVMInterpreter:          config.EVMInterpreter,
 		}
-		cacheConfig = &core.CacheConfig{Disabled: config.NoPruning, TrieCleanLimit: config.TrieCleanCache, TrieDirtyLimit: config.TrieDirtyCache, TrieTimeLimit: config.TrieTimeout}
+		cacheConfig = &core.CacheConfig{Disabled: config.NoPruning, TrieCleanLimit: config.TrieCleanCache, TrieDirtyLimit: config.TrieDirtyCache, TrieTimeLimit: config.TrieTimeout, SnapshotLimit: config.SnapshotCache, StateReexec: config.StateReexec}
 	)
-	ber.blockchain, err = core.NewBlockChain(stakingDB, chainDb, cacheConfig, ber.chainConfig, ber.engine, vmConfig, ber.shouldPreserve)
+	ber.blockchain, err = core.NewBlockChain(stakingDB, chainDb, cacheConfig, ber.chainConfig, ber.engine, vmConfig, ber.shouldPreserve, &config.TxLookupLimit)
 	if err != nil {
 		return nil, err
 	}
@@ -178,15 +179,29 @@ func New(ctx *node.ServiceContext, config *Config) (*Berith, error) {
 		rawdb.WriteChainConfig(chainDb, genesisHash, chainConfig)
 	}
 	ber.bloomIndexer.Start(ber.blockchain)
+	if config.SupplyIndex {
+		ber.supplyIndexer = NewSupplyIndexer(chainDb, ber.blockchain.StateCache(), ber.chainConfig)
+		ber.supplyIndexer.Start(ber.blockchain)
+	}
 
 	if config.TxPool.Journal != "" {
 		config.TxPool.Journal = ctx.ResolvePath(config.TxPool.Journal)
 	}
 	ber.txPool = core.NewTxPool(config.TxPool, ber.chainConfig, ber.blockchain)
 
-	if ber.protocolManager, err = NewProtocolManager(ber.chainConfig, config.SyncMode, config.NetworkId, ber.eventMux, ber.txPool, ber.engine, ber.blockchain, chainDb, config.Whitelist); err != nil {
+	if ber.protocolManager, err = NewProtocolManager(ber.chainConfig, config.SyncMode, config.NetworkId, ber.eventMux, ber.txPool, ber.engine, ber.blockchain, chainDb, config.Whitelist, config.PrivateTxPeers); err != nil {
+		return nil, err
+	}
+	// [BERITH] Dial the berith peers found in the DNS node lists as well
+	dialCandidates, err := setupDiscovery(config.DiscoveryURLs, ber.blockchain)
+	if err != nil {
 		return nil, err
 	}
+	for i := range ber.protocolManager.SubProtocols {
+		if ber.protocolManager.SubProtocols[i].Name == ProtocolName {
+			ber.protocolManager.SubProtocols[i].DialCandidates = dialCandidates
+		}
+	}
 
 	ber.miner = miner.New(ber, ber.chainConfig, ber.EventMux(), ber.engine, config.MinerRecommit, config.MinerGasFloor, config.MinerGasCeil, ber.isLocalBlock)
 	ber.miner.SetExtra(makeExtraData(config.MinerExtraData))
@@ -224,7 +239,7 @@ func CreateDB(ctx *node.ServiceContext, config *Config, name string) (berithdb.D
 	if err != nil {
 		return nil, err
 	}
-	if db, ok := db.(*berithdb.LDBDatabase); ok {
+	if db, ok := db.(berithdb.Store); ok {
 		db.Meter("berith/db/chaindata/")
 	}
 	return db, nil
@@ -494,6 +509,7 @@ func (s *Berith) Start(srvr *p2p.Server) error {
 	}
 	// Start the networking layer and the light server if requested
 	s.protocolManager.Start(maxPeers)
+	s.startBerithEntryUpdate(srvr.LocalNode())
 	if s.lesServer != nil {
 		s.lesServer.Start(srvr)
 	}
@@ -504,6 +520,9 @@ func (s *Berith) Start(srvr *p2p.Server) error {
 // Berith protocol.
 func (s *Berith) Stop() error {
 	s.bloomIndexer.Close()
+	if s.supplyIndexer != nil {
+		s.supplyIndexer.Close()
+	}
 	s.blockchain.Stop()
 	s.engine.Close()
 	s.protocolManager.Stop()
@@ -6,7 +6,11 @@ package brtapi
 
 import (
 	"berith-chain/core"
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"sort"
 	"strconv"
 
 	"github.com/BerithFoundation/berith-chain/accounts/keystore"
@@ -21,6 +25,7 @@ import (
 	"github.com/BerithFoundation/berith-chain/core/types"
 	"github.com/BerithFoundation/berith-chain/crypto"
 	"github.com/BerithFoundation/berith-chain/log"
+	"github.com/BerithFoundation/berith-chain/rlp"
 )
 
 // PrivateBerithAPI struct of berith private apis
@@ -29,6 +34,7 @@ type PrivateBerithAPI struct {
 	miner          *miner.Miner
 	nonceLock      *AddrLocker
 	accountManager *accounts.Manager
+	scheduler      *txScheduler
 }
 
 /*
@@ -41,6 +47,7 @@ func NewPrivateBerithAPI(b Backend, m *miner.Miner, nonceLock *AddrLocker) *Priv
 		miner:          m,
 		accountManager: b.AccountManager(),
 		nonceLock:      nonceLock,
+		scheduler:      newTxScheduler(b),
 	}
 }
 
@@ -126,36 +133,263 @@ func (s *PrivateBerithAPI) StopStaking(ctx context.Context, wallet WalletTxArgs)
 
 /*
 [BERITH]
-Functions that deal with actual transactions
+SendPrivateTransaction adds a signed transaction to the transaction pool without
+broadcasting it to the public network. The transaction is only given to the local
+miner and the trusted private peers, and falls back to the public broadcast once
+the configured number of blocks has passed without inclusion.
 */
-func (s *PrivateBerithAPI) sendTransaction(ctx context.Context, args SendTxArgs) (common.Hash, error) {
-	account := accounts.Account{Address: args.From}
+func (s *PrivateBerithAPI) SendPrivateTransaction(ctx context.Context, encodedTx hexutil.Bytes) (common.Hash, error) {
+	tx := new(types.Transaction)
+	if err := rlp.DecodeBytes(encodedTx, tx); err != nil {
+		return common.Hash{}, err
+	}
+	if err := s.backend.SendPrivateTx(ctx, tx); err != nil {
+		return common.Hash{}, err
+	}
+	if err := printTxLog(s.backend, tx); err != nil {
+		return common.Hash{}, err
+	}
+	return tx.Hash(), nil
+}
 
-	wallet, err := s.backend.AccountManager().Find(account)
+/*
+[BERITH]
+SendBatch signs a batch of Main to Main transfers and submits them all-or-nothing.
+The nonces of every sender are assigned consecutively while holding the nonce
+locks of all senders, so the batch cannot interleave with other transactions.
+The sending accounts must be unlocked.
+*/
+func (s *PrivateBerithAPI) SendBatch(ctx context.Context, batch []WalletTxArgs) ([]common.Hash, error) {
+	if len(batch) == 0 {
+		return nil, errors.New("empty transaction batch")
+	}
+	// Lock every distinct sender in a fixed order to avoid deadlocking other batches
+	var senders []common.Address
+	nonces := make(map[common.Address]uint64)
+	for i, wallet := range batch {
+		if wallet.To == nil {
+			return nil, fmt.Errorf("transaction %d: missing recipient", i)
+		}
+		if wallet.Nonce != nil {
+			return nil, fmt.Errorf("transaction %d: nonce is assigned by the batch", i)
+		}
+		if _, ok := nonces[wallet.From]; !ok {
+			nonces[wallet.From] = 0
+			senders = append(senders, wallet.From)
+		}
+	}
+	sort.Slice(senders, func(i, j int) bool { return bytes.Compare(senders[i][:], senders[j][:]) < 0 })
+	for _, from := range senders {
+		s.nonceLock.LockAddr(from)
+		defer s.nonceLock.UnlockAddr(from)
+
+		nonce, err := s.backend.GetPoolNonce(ctx, from)
+		if err != nil {
+			return nil, err
+		}
+		nonces[from] = nonce
+	}
+	// Sign the whole batch before anything is submitted
+	signed := make(types.Transactions, len(batch))
+	for i, wallet := range batch {
+		nonce := hexutil.Uint64(nonces[wallet.From])
+		nonces[wallet.From]++
+
+		tx, err := s.signTransaction(ctx, SendTxArgs{
+			From:     wallet.From,
+			To:       wallet.To,
+			Value:    wallet.Value,
+			Base:     types.Main,
+			Target:   types.Main,
+			Gas:      wallet.Gas,
+			GasPrice: wallet.GasPrice,
+			Nonce:    &nonce,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("transaction %d: %v", i, err)
+		}
+		signed[i] = tx
+	}
+	if err := s.backend.SendTxs(ctx, signed); err != nil {
+		return nil, err
+	}
+	hashes := make([]common.Hash, len(signed))
+	for i, tx := range signed {
+		if err := printTxLog(s.backend, tx); err != nil {
+			return nil, err
+		}
+		hashes[i] = tx.Hash()
+	}
+	return hashes, nil
+}
+
+/*
+[BERITH]
+ScheduleTransaction signs a Main to Main transfer now and holds it in the node
+until the chain reaches atBlock, at which point it is submitted to the pool.
+Unless given, the nonce follows those of the pool and of the transactions of the
+sender already scheduled. It is fixed at signing time, so other transactions of
+the sender sent in the meantime will make the scheduled one fail.
+*/
+func (s *PrivateBerithAPI) ScheduleTransaction(ctx context.Context, wallet WalletTxArgs, atBlock hexutil.Uint64) (common.Hash, error) {
+	if head := s.backend.CurrentBlock().NumberU64(); uint64(atBlock) <= head {
+		return common.Hash{}, fmt.Errorf("target block %d already reached, head is %d", atBlock, head)
+	}
+	if wallet.To == nil {
+		return common.Hash{}, errors.New("missing recipient")
+	}
+	s.nonceLock.LockAddr(wallet.From)
+	defer s.nonceLock.UnlockAddr(wallet.From)
+
+	nonce := wallet.Nonce
+	if nonce == nil {
+		pending, err := s.backend.GetPoolNonce(ctx, wallet.From)
+		if err != nil {
+			return common.Hash{}, err
+		}
+		if next, ok := s.scheduler.nextNonce(wallet.From); ok && next > pending {
+			pending = next
+		}
+		nonce = (*hexutil.Uint64)(&pending)
+	}
+	tx, err := s.signTransaction(ctx, SendTxArgs{
+		From:     wallet.From,
+		To:       wallet.To,
+		Value:    wallet.Value,
+		Base:     types.Main,
+		Target:   types.Main,
+		Gas:      wallet.Gas,
+		GasPrice: wallet.GasPrice,
+		Nonce:    nonce,
+	})
 	if err != nil {
 		return common.Hash{}, err
 	}
+	s.scheduler.schedule(tx, wallet.From, uint64(atBlock))
 
+	log.Info("Scheduled transaction", "fullhash", tx.Hash().Hex(), "recipient", tx.To(), "block", uint64(atBlock))
+	return tx.Hash(), nil
+}
+
+/*
+[BERITH]
+ScheduledTransactions returns the transactions held until their target block.
+*/
+func (s *PrivateBerithAPI) ScheduledTransactions() []*ScheduledTx {
+	return s.scheduler.list()
+}
+
+/*
+[BERITH]
+SignSponsoredTransaction signs a Main to Main transfer whose gas is paid by the
+given sponsor. The signed transaction is returned RLP encoded without being
+submitted; it has to be passed to the sponsor, who adds its own signature and
+submits it with SponsorTransaction. The sending account must be unlocked.
+*/
+func (s *PrivateBerithAPI) SignSponsoredTransaction(ctx context.Context, wallet WalletTxArgs, sponsor common.Address) (hexutil.Bytes, error) {
+	if wallet.To == nil {
+		return nil, errors.New("missing recipient")
+	}
+	if sponsor == wallet.From {
+		return nil, errors.New("sender can not sponsor its own transaction")
+	}
+	s.nonceLock.LockAddr(wallet.From)
+	defer s.nonceLock.UnlockAddr(wallet.From)
+
+	tx, err := s.signTransaction(ctx, SendTxArgs{
+		From:     wallet.From,
+		To:       wallet.To,
+		Value:    wallet.Value,
+		Base:     types.Main,
+		Target:   types.Main,
+		Gas:      wallet.Gas,
+		GasPrice: wallet.GasPrice,
+		Nonce:    wallet.Nonce,
+		Sponsor:  &sponsor,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rlp.EncodeToBytes(tx)
+}
+
+/*
+[BERITH]
+SponsorTransaction adds the signature of the sponsor to a transaction signed by
+its sender with SignSponsoredTransaction and submits it to the transaction pool.
+The sponsoring account must be unlocked.
+*/
+func (s *PrivateBerithAPI) SponsorTransaction(ctx context.Context, encodedTx hexutil.Bytes) (common.Hash, error) {
+	tx := new(types.Transaction)
+	if err := rlp.DecodeBytes(encodedTx, tx); err != nil {
+		return common.Hash{}, err
+	}
+	if !tx.IsSponsored() {
+		return common.Hash{}, errors.New("transaction does not name a sponsor")
+	}
+	signer := types.MakeSigner(s.backend.ChainConfig(), s.backend.CurrentBlock().Number())
+	hash, err := types.SponsorHash(signer, tx)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	account := accounts.Account{Address: *tx.Sponsor()}
+
+	wallet, err := s.accountManager.Find(account)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	sig, err := wallet.SignHash(account, hash.Bytes())
+	if err != nil {
+		return common.Hash{}, err
+	}
+	sponsored, err := tx.WithSponsorSignature(signer, sig)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return submitTransaction(ctx, s.backend, sponsored)
+}
+
+/*
+[BERITH]
+Functions that deal with actual transactions
+*/
+func (s *PrivateBerithAPI) sendTransaction(ctx context.Context, args SendTxArgs) (common.Hash, error) {
 	s.nonceLock.LockAddr(args.From)
 	defer s.nonceLock.UnlockAddr(args.From)
 
+	signed, err := s.signTransaction(ctx, args)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return submitTransaction(ctx, s.backend, signed)
+}
+
+// signTransaction fills in the defaults of args and signs the resulting
+// transaction with the wallet of the sender. The caller is expected to hold
+// the nonce lock of the sender.
+func (s *PrivateBerithAPI) signTransaction(ctx context.Context, args SendTxArgs) (*types.Transaction, error) {
+	account := accounts.Account{Address: args.From}
+
+	wallet, err := s.backend.AccountManager().Find(account)
+	if err != nil {
+		return nil, err
+	}
 	// Set some sanity defaults and terminate on failure
 	if err := args.setDefaults(ctx, s.backend); err != nil {
-		return common.Hash{}, err
+		return nil, err
 	}
 	// Assemble the transaction and sign with the wallet
-	tx := args.toTransaction()
+	return s.signWith(wallet, account, args.toTransaction())
+}
 
+// signWith signs tx with the given account of wallet, replay protected from
+// EIP155 on.
+func (s *PrivateBerithAPI) signWith(wallet accounts.Wallet, account accounts.Account, tx *types.Transaction) (*types.Transaction, error) {
 	var chainID *big.Int
 	if config := s.backend.ChainConfig(); config.IsEIP155(s.backend.CurrentBlock().Number()) {
 		chainID = config.ChainID
 	}
-	signed, err := wallet.SignTx(account, tx, chainID)
-	if err != nil {
-		return common.Hash{}, err
-	}
-
-	return submitTransaction(ctx, s.backend, signed)
+	return wallet.SignTx(account, tx, chainID)
 }
 
 // submitTransaction is a helper function that submits tx to txPool and logs a message.
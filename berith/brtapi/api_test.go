@@ -0,0 +1,205 @@
+package brtapi
+
+import (
+	"context"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/BerithFoundation/berith-chain/accounts"
+	"github.com/BerithFoundation/berith-chain/accounts/keystore"
+	"github.com/BerithFoundation/berith-chain/berithdb"
+	"github.com/BerithFoundation/berith-chain/common"
+	"github.com/BerithFoundation/berith-chain/common/hexutil"
+	"github.com/BerithFoundation/berith-chain/core"
+	"github.com/BerithFoundation/berith-chain/core/state"
+	"github.com/BerithFoundation/berith-chain/core/types"
+	"github.com/BerithFoundation/berith-chain/event"
+	"github.com/BerithFoundation/berith-chain/params"
+	"github.com/BerithFoundation/berith-chain/rpc"
+)
+
+// testBackend is a Backend over a transaction pool on top of a single block.
+type testBackend struct {
+	manager   *accounts.Manager
+	pool      *core.TxPool
+	statedb   *state.StateDB
+	headFeed  event.Feed
+	headBlock *types.Block
+}
+
+func newTestBackend(t *testing.T, funds *big.Int) (*testBackend, accounts.Account, func()) {
+	dir, err := ioutil.TempDir("", "brtapi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ks := keystore.NewKeyStore(dir, keystore.LightScryptN, keystore.LightScryptP)
+	account, err := ks.NewAccount("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.Unlock(account, ""); err != nil {
+		t.Fatal(err)
+	}
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(berithdb.NewMemDatabase()))
+	statedb.AddBalance(account.Address, funds)
+
+	b := &testBackend{
+		manager:   accounts.NewManager(&accounts.Config{}, ks),
+		statedb:   statedb,
+		headBlock: types.NewBlock(&types.Header{Number: new(big.Int), GasLimit: 10000000}, nil, nil, nil),
+	}
+	config := core.DefaultTxPoolConfig
+	config.Journal = ""
+	b.pool = core.NewTxPool(config, params.TestnetChainConfig, &testChain{testBackend: b})
+
+	return b, account, func() {
+		b.pool.Stop()
+		b.manager.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// testChain is the chain of the transaction pool, which never moves on.
+type testChain struct {
+	*testBackend
+	headFeed event.Feed
+}
+
+func (c *testChain) SubscribeChainHeadEvent(ch chan<- core.ChainHeadEvent) event.Subscription {
+	return c.headFeed.Subscribe(ch)
+}
+
+func (b *testBackend) GetBlock(common.Hash, uint64) *types.Block      { return b.headBlock }
+func (b *testBackend) StateAt(common.Hash) (*state.StateDB, error)    { return b.statedb, nil }
+func (b *testBackend) CurrentBlock() *types.Block                     { return b.headBlock }
+func (b *testBackend) ChainConfig() *params.ChainConfig               { return params.TestnetChainConfig }
+func (b *testBackend) AccountManager() *accounts.Manager              { return b.manager }
+func (b *testBackend) SuggestPrice(context.Context) (*big.Int, error) { return big.NewInt(1), nil }
+func (b *testBackend) SendTx(_ context.Context, tx *types.Transaction) error {
+	return b.pool.AddLocal(tx)
+}
+func (b *testBackend) SendPrivateTx(_ context.Context, tx *types.Transaction) error {
+	return b.pool.AddPrivate(tx)
+}
+func (b *testBackend) SendTxs(_ context.Context, txs types.Transactions) error {
+	return b.pool.AddLocalBatch(txs)
+}
+func (b *testBackend) GetPoolNonce(_ context.Context, addr common.Address) (uint64, error) {
+	return b.pool.State().GetNonce(addr), nil
+}
+func (b *testBackend) SubscribeChainHeadEvent(ch chan<- core.ChainHeadEvent) event.Subscription {
+	return b.headFeed.Subscribe(ch)
+}
+func (b *testBackend) StateAndHeaderByNumber(context.Context, rpc.BlockNumber) (*state.StateDB, *types.Header, error) {
+	return b.statedb, b.headBlock.Header(), nil
+}
+
+func transfer(from common.Address, value int64) WalletTxArgs {
+	gas := hexutil.Uint64(21000)
+	return WalletTxArgs{
+		From:  from,
+		To:    &common.Address{1},
+		Value: (*hexutil.Big)(big.NewInt(value)),
+		Gas:   &gas,
+	}
+}
+
+// Tests that a batch is signed with consecutive nonces and submitted as a
+// whole, and that a rejected batch leaves nothing behind.
+func TestSendBatch(t *testing.T) {
+	b, account, done := newTestBackend(t, big.NewInt(3*21000+300))
+	defer done()
+	api := NewPrivateBerithAPI(b, nil, new(AddrLocker))
+
+	// The third transfer is affordable on its own, but not after the others
+	if _, err := api.SendBatch(context.Background(), []WalletTxArgs{transfer(account.Address, 100), transfer(account.Address, 100), transfer(account.Address, 101)}); err == nil {
+		t.Fatalf("unaffordable batch accepted")
+	}
+	if pending, queued := b.pool.Stats(); pending != 0 || queued != 0 {
+		t.Fatalf("rejected batch left transactions: pending %d, queued %d", pending, queued)
+	}
+	hashes, err := api.SendBatch(context.Background(), []WalletTxArgs{transfer(account.Address, 100), transfer(account.Address, 100), transfer(account.Address, 100)})
+	if err != nil {
+		t.Fatalf("failed to send batch: %v", err)
+	}
+	for i, hash := range hashes {
+		tx := b.pool.Get(hash)
+		if tx == nil {
+			t.Fatalf("transaction %d not pooled", i)
+		}
+		if tx.Nonce() != uint64(i) {
+			t.Errorf("transaction %d: nonce mismatch: have %d, want %d", i, tx.Nonce(), i)
+		}
+	}
+	if pending, _ := b.pool.Stats(); pending != 3 {
+		t.Fatalf("pending mismatch: have %d, want 3", pending)
+	}
+	// Batches assign the nonces themselves
+	nonce := hexutil.Uint64(3)
+	args := transfer(account.Address, 100)
+	args.Nonce = &nonce
+	if _, err := api.SendBatch(context.Background(), []WalletTxArgs{args}); err == nil {
+		t.Fatalf("batch with nonce accepted")
+	}
+}
+
+// Tests that scheduled transactions are held until their target block and
+// dropped if they can't be submitted then.
+func TestScheduleTransaction(t *testing.T) {
+	b, account, done := newTestBackend(t, big.NewInt(2*21000+200))
+	defer done()
+	api := NewPrivateBerithAPI(b, nil, new(AddrLocker))
+
+	if _, err := api.ScheduleTransaction(context.Background(), transfer(account.Address, 100), 0); err == nil {
+		t.Fatalf("transaction scheduled at reached block")
+	}
+	later, err := api.ScheduleTransaction(context.Background(), transfer(account.Address, 100), 2)
+	if err != nil {
+		t.Fatalf("failed to schedule transaction: %v", err)
+	}
+	// The nonce of the held transaction is reserved, whatever the target blocks
+	sooner, err := api.ScheduleTransaction(context.Background(), transfer(account.Address, 100), 1)
+	if err != nil {
+		t.Fatalf("failed to schedule transaction: %v", err)
+	}
+	list := api.ScheduledTransactions()
+	if len(list) != 2 || list[0].Hash != sooner || list[1].Hash != later {
+		t.Fatalf("scheduled transactions mismatch: %v", list)
+	}
+	if list[0].Nonce != 1 || list[1].Nonce != 0 {
+		t.Fatalf("scheduled nonces mismatch: have %d and %d, want 1 and 0", list[0].Nonce, list[1].Nonce)
+	}
+	// Announce the head until the scheduler, subscribing in the background,
+	// releases the due transactions
+	head := func(number int64, scheduled int) {
+		block := types.NewBlock(&types.Header{Number: big.NewInt(number)}, nil, nil, nil)
+		for i := 0; i < 100 && len(api.ScheduledTransactions()) != scheduled; i++ {
+			b.headFeed.Send(core.ChainHeadEvent{Block: block})
+			time.Sleep(10 * time.Millisecond)
+		}
+		if list := api.ScheduledTransactions(); len(list) != scheduled {
+			t.Fatalf("scheduled transactions mismatch at block %d: have %d, want %d", number, len(list), scheduled)
+		}
+	}
+	head(1, 1)
+	for i := 0; i < 100 && b.pool.Get(sooner) == nil; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if b.pool.Get(sooner) == nil {
+		t.Fatalf("due transaction not submitted")
+	}
+	if b.pool.Get(later) != nil {
+		t.Fatalf("transaction submitted before its target block")
+	}
+	// The later transaction fills the nonce gap, both becoming executable
+	head(2, 0)
+	for i := 0; i < 100 && b.pool.Get(later) == nil; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if pending, queued := b.pool.Stats(); pending != 2 || queued != 0 {
+		t.Fatalf("pool content mismatch: have %d pending and %d queued, want 2 and 0", pending, queued)
+	}
+}
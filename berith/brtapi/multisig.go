@@ -0,0 +1,139 @@
+package brtapi
+
+import (
+	"context"
+	"errors"
+
+	"github.com/BerithFoundation/berith-chain/accounts"
+	"github.com/BerithFoundation/berith-chain/common"
+	"github.com/BerithFoundation/berith-chain/common/hexutil"
+	"github.com/BerithFoundation/berith-chain/core"
+	"github.com/BerithFoundation/berith-chain/core/types"
+	"github.com/BerithFoundation/berith-chain/rlp"
+	"github.com/BerithFoundation/berith-chain/rpc"
+)
+
+/*
+[BERITH]
+RegisterMultisig sends a transaction registering a multisig account owned by the
+given owners, of which threshold have to sign every transaction of the account.
+The value of the transaction funds the new account, whose address is returned
+as the contract address of the receipt. The registering account must be unlocked.
+*/
+func (s *PrivateBerithAPI) RegisterMultisig(ctx context.Context, wallet WalletTxArgs, owners []common.Address, threshold hexutil.Uint64) (common.Hash, error) {
+	config := &types.MultisigConfig{Owners: owners, Threshold: uint64(threshold)}
+	if err := config.Validate(); err != nil {
+		return common.Hash{}, err
+	}
+	payload, err := rlp.EncodeToBytes(config)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	if wallet.Gas == nil {
+		head := s.backend.CurrentBlock().Number()
+		gas, err := core.IntrinsicGas(payload, false, true, s.backend.ChainConfig().IsBIP5(head))
+		if err != nil {
+			return common.Hash{}, err
+		}
+		gas += core.MultisigRegistrationGas(config)
+		wallet.Gas = (*hexutil.Uint64)(&gas)
+	}
+	var (
+		data     = hexutil.Bytes(payload)
+		registry = types.MultisigRegistry
+	)
+	return s.sendTransaction(ctx, SendTxArgs{
+		From:     wallet.From,
+		To:       &registry,
+		Value:    wallet.Value,
+		Gas:      wallet.Gas,
+		GasPrice: wallet.GasPrice,
+		Nonce:    wallet.Nonce,
+		Data:     &data,
+		Base:     types.Main,
+		Target:   types.Main,
+	})
+}
+
+/*
+[BERITH]
+GetMultisig returns the owners and threshold of a multisig account.
+*/
+func (s *PrivateBerithAPI) GetMultisig(ctx context.Context, address common.Address, blockNr rpc.BlockNumber) (*types.MultisigConfig, error) {
+	state, _, err := s.backend.StateAndHeaderByNumber(ctx, blockNr)
+	if state == nil || err != nil {
+		return nil, err
+	}
+	config := core.ReadMultisig(state, address)
+	if config == nil {
+		return nil, core.ErrUnknownMultisig
+	}
+	return config, state.Error()
+}
+
+/*
+[BERITH]
+SignMultisigTransaction signs a transaction of the multisig account args.from as
+its owner. Transfers, stakes and unstakes are expressed through to, value, base
+and target as for any other account. The signed transaction is returned RLP
+encoded; the other owners add their signatures with CosignMultisigTransaction
+and the result is submitted with sendRawTransaction. The owner account must be
+unlocked.
+*/
+func (s *PrivateBerithAPI) SignMultisigTransaction(ctx context.Context, args SendTxArgs, owner common.Address) (hexutil.Bytes, error) {
+	if _, err := s.GetMultisig(ctx, args.From, rpc.LatestBlockNumber); err != nil {
+		return nil, err
+	}
+	account := accounts.Account{Address: owner}
+
+	wallet, err := s.accountManager.Find(account)
+	if err != nil {
+		return nil, err
+	}
+	s.nonceLock.LockAddr(args.From)
+	defer s.nonceLock.UnlockAddr(args.From)
+
+	if err := args.setDefaults(ctx, s.backend); err != nil {
+		return nil, err
+	}
+	signed, err := s.signWith(wallet, account, args.toTransaction().WithMultisig(args.From))
+	if err != nil {
+		return nil, err
+	}
+	return rlp.EncodeToBytes(signed)
+}
+
+/*
+[BERITH]
+CosignMultisigTransaction adds the signature of another owner to a multisig
+transaction created with SignMultisigTransaction and returns it RLP encoded.
+The owner account must be unlocked.
+*/
+func (s *PrivateBerithAPI) CosignMultisigTransaction(ctx context.Context, encodedTx hexutil.Bytes, owner common.Address) (hexutil.Bytes, error) {
+	tx := new(types.Transaction)
+	if err := rlp.DecodeBytes(encodedTx, tx); err != nil {
+		return nil, err
+	}
+	if !tx.IsMultisig() {
+		return nil, errors.New("transaction is not sent from a multisig account")
+	}
+	signer := types.MakeSigner(s.backend.ChainConfig(), s.backend.CurrentBlock().Number())
+	account := accounts.Account{Address: owner}
+
+	wallet, err := s.accountManager.Find(account)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := wallet.SignHash(account, signer.Hash(tx).Bytes())
+	if err != nil {
+		return nil, err
+	}
+	cosigned, err := tx.WithCosignature(signer, sig)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := types.MultisigSigners(signer, cosigned); err != nil {
+		return nil, err
+	}
+	return rlp.EncodeToBytes(cosigned)
+}
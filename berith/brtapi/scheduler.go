@@ -0,0 +1,140 @@
+package brtapi
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/BerithFoundation/berith-chain/common"
+	"github.com/BerithFoundation/berith-chain/common/hexutil"
+	"github.com/BerithFoundation/berith-chain/core"
+	"github.com/BerithFoundation/berith-chain/core/types"
+	"github.com/BerithFoundation/berith-chain/log"
+)
+
+// chainHeadChanSize is the size of channel listening to ChainHeadEvent.
+const chainHeadChanSize = 10
+
+// [BERITH]
+// ScheduledTx is a signed transaction held by the node until its target block.
+type ScheduledTx struct {
+	Hash    common.Hash    `json:"hash"`
+	From    common.Address `json:"from"`
+	Nonce   hexutil.Uint64 `json:"nonce"`
+	AtBlock hexutil.Uint64 `json:"atBlock"`
+}
+
+type scheduledTx struct {
+	tx      *types.Transaction
+	from    common.Address
+	atBlock uint64
+}
+
+// [BERITH]
+// txScheduler keeps signed transactions in memory and submits them to the
+// transaction pool once the chain reaches their target block. Scheduled
+// transactions are not persisted and are lost when the node stops.
+type txScheduler struct {
+	backend Backend
+
+	once sync.Once
+	mu   sync.Mutex
+	txs  map[common.Hash]*scheduledTx
+}
+
+func newTxScheduler(b Backend) *txScheduler {
+	return &txScheduler{
+		backend: b,
+		txs:     make(map[common.Hash]*scheduledTx),
+	}
+}
+
+// schedule holds the transaction until the head reaches atBlock. The event loop
+// is started with the first scheduled transaction.
+func (s *txScheduler) schedule(tx *types.Transaction, from common.Address, atBlock uint64) {
+	s.once.Do(func() { go s.loop() })
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.txs[tx.Hash()] = &scheduledTx{tx: tx, from: from, atBlock: atBlock}
+}
+
+// nextNonce returns the nonce following those of the transactions of from that
+// are still held, or false if none is.
+func (s *txScheduler) nextNonce(from common.Address) (uint64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var (
+		next  uint64
+		found bool
+	)
+	for _, stx := range s.txs {
+		if stx.from == from && (!found || stx.tx.Nonce() >= next) {
+			next, found = stx.tx.Nonce()+1, true
+		}
+	}
+	return next, found
+}
+
+// list returns the transactions that are still held, ordered by target block.
+func (s *txScheduler) list() []*ScheduledTx {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]*ScheduledTx, 0, len(s.txs))
+	for hash, stx := range s.txs {
+		list = append(list, &ScheduledTx{
+			Hash:    hash,
+			From:    stx.from,
+			Nonce:   hexutil.Uint64(stx.tx.Nonce()),
+			AtBlock: hexutil.Uint64(stx.atBlock),
+		})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].AtBlock != list[j].AtBlock {
+			return list[i].AtBlock < list[j].AtBlock
+		}
+		return list[i].Nonce < list[j].Nonce
+	})
+	return list
+}
+
+// loop submits the due transactions on every new chain head. It terminates
+// when the chain head subscription is closed on shutdown.
+func (s *txScheduler) loop() {
+	headCh := make(chan core.ChainHeadEvent, chainHeadChanSize)
+	sub := s.backend.SubscribeChainHeadEvent(headCh)
+	defer sub.Unsubscribe()
+
+	for {
+		select {
+		case ev := <-headCh:
+			s.release(ev.Block.NumberU64())
+		case <-sub.Err():
+			return
+		}
+	}
+}
+
+// release submits every transaction whose target block is at or below head.
+func (s *txScheduler) release(head uint64) {
+	s.mu.Lock()
+	var due []*scheduledTx
+	for hash, stx := range s.txs {
+		if stx.atBlock <= head {
+			due = append(due, stx)
+			delete(s.txs, hash)
+		}
+	}
+	s.mu.Unlock()
+
+	// Submit in nonce order so that transactions of one account are not queued
+	sort.Slice(due, func(i, j int) bool { return due[i].tx.Nonce() < due[j].tx.Nonce() })
+	for _, stx := range due {
+		if _, err := submitTransaction(context.Background(), s.backend, stx.tx); err != nil {
+			log.Warn("Failed to submit scheduled transaction", "hash", stx.tx.Hash(), "block", stx.atBlock, "err", err)
+		}
+	}
+}
@@ -29,6 +29,8 @@ type SendTxArgs struct {
 	Input  *hexutil.Bytes  `json:"input"`
 	Base   types.JobWallet `json:"base"`
 	Target types.JobWallet `json:"target"`
+	// Sponsor paying the gas of the transaction, if any
+	Sponsor *common.Address `json:"sponsor"`
 }
 
 // setDefaults is a helper function that fills in default values for unspecified tx fields.
@@ -79,8 +81,14 @@ func (args *SendTxArgs) toTransaction() *types.Transaction {
 	} else if args.Input != nil {
 		input = *args.Input
 	}
+	var tx *types.Transaction
 	if args.To == nil {
-		return types.NewContractCreation(uint64(*args.Nonce), (*big.Int)(args.Value), uint64(*args.Gas), (*big.Int)(args.GasPrice), input, args.Base, args.Target, false)
+		tx = types.NewContractCreation(uint64(*args.Nonce), (*big.Int)(args.Value), uint64(*args.Gas), (*big.Int)(args.GasPrice), input, args.Base, args.Target, false)
+	} else {
+		tx = types.NewTransaction(uint64(*args.Nonce), *args.To, (*big.Int)(args.Value), uint64(*args.Gas), (*big.Int)(args.GasPrice), input, args.Base, args.Target, false)
 	}
-	return types.NewTransaction(uint64(*args.Nonce), *args.To, (*big.Int)(args.Value), uint64(*args.Gas), (*big.Int)(args.GasPrice), input, args.Base, args.Target, false)
+	if args.Sponsor != nil {
+		tx = tx.WithSponsor(*args.Sponsor)
+	}
+	return tx
 }
@@ -7,6 +7,7 @@ import (
 
 type WalletTxArgs struct {
 	From     common.Address  `json:"from"`
+	To       *common.Address `json:"to"` // Recipient of batch and scheduled transfers, ignored by staking
 	Value    *hexutil.Big    `json:"value"`
 	Gas      *hexutil.Uint64 `json:"gas"`
 	GasPrice *hexutil.Big    `json:"gasPrice"`
@@ -27,6 +27,7 @@ import (
 	"github.com/BerithFoundation/berith-chain/common"
 	"github.com/BerithFoundation/berith-chain/common/hexutil"
 	"github.com/BerithFoundation/berith-chain/core"
+	"github.com/BerithFoundation/berith-chain/p2p/enode"
 	"github.com/BerithFoundation/berith-chain/params"
 )
 
@@ -38,6 +39,8 @@ var DefaultConfig = Config{
 	TrieCleanCache: 256,
 	TrieDirtyCache: 256,
 	TrieTimeout:    60 * time.Minute,
+	SnapshotCache:  102,
+	StateReexec:    128,
 	MinerGasFloor:  8000000,
 	MinerGasCeil:   8000000,
 	MinerGasPrice:  big.NewInt(params.Gmin),
@@ -58,6 +61,8 @@ var DefaultTestnetConfig = Config{
 	TrieCleanCache: 256,
 	TrieDirtyCache: 256,
 	TrieTimeout:    60 * time.Minute,
+	SnapshotCache:  102,
+	StateReexec:    128,
 	MinerGasFloor:  8000000,
 	MinerGasCeil:   8000000,
 	MinerGasPrice:  big.NewInt(params.Gmin),
@@ -91,6 +96,15 @@ type Config struct {
 	SyncMode  downloader.SyncMode
 	NoPruning bool
 
+	// [BERITH] enrtree:// URLs of the DNS node lists queried for peers to connect to
+	DiscoveryURLs []string `toml:",omitempty"`
+
+	// Number of recent blocks to maintain transaction lookups for, 0 indexes all
+	TxLookupLimit uint64 `toml:",omitempty"`
+
+	// [BERITH] Maintain the supply and issuance index served by berith_getSupply
+	SupplyIndex bool `toml:",omitempty"`
+
 	// Whitelist of required block number -> hash values to accept
 	Whitelist map[uint64]common.Hash `toml:"-"`
 
@@ -102,9 +116,12 @@ type Config struct {
 	SkipBcVersionCheck bool `toml:"-"`
 	DatabaseHandles    int  `toml:"-"`
 	DatabaseCache      int
+	DatabaseFreezer    string
 	TrieCleanCache     int
 	TrieDirtyCache     int
 	TrieTimeout        time.Duration
+	SnapshotCache      int
+	StateReexec        uint64 // Maximum number of blocks to re-execute when regenerating a pruned state
 
 	// Mining-related options
 	Berithbase     common.Address `toml:",omitempty"`
@@ -117,7 +134,8 @@ type Config struct {
 	MinerNoverify  bool
 
 	// Transaction pool options
-	TxPool core.TxPoolConfig
+	TxPool         core.TxPoolConfig
+	PrivateTxPeers []*enode.Node `toml:",omitempty"` // Trusted peers allowed to receive private transactions
 
 	// Gas Price Oracle options
 	GPO gasprice.Config
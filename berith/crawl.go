@@ -0,0 +1,110 @@
+package berith
+
+import (
+	"math/big"
+	"time"
+
+	"github.com/BerithFoundation/berith-chain/common"
+	"github.com/BerithFoundation/berith-chain/core/forkid"
+	"github.com/BerithFoundation/berith-chain/core/types"
+	"github.com/BerithFoundation/berith-chain/p2p"
+)
+
+// PeerStatus is the chain status a remote peer announced in the berith
+// handshake, as recorded by network crawlers.
+type PeerStatus struct {
+	ProtocolVersion uint32
+	NetworkID       uint64
+	TD              *big.Int
+	Head            common.Hash
+	HeadNumber      *uint64 // Number of the head block, nil if the peer didn't serve it
+	Genesis         common.Hash
+	ForkID          *forkid.ID // Fork identifier, nil before berith/64
+}
+
+// QueryStatus runs the handshake of a network crawler on a fresh berith
+// connection of the given protocol version. It reads the status of the remote
+// peer, mirrors it back so that the remote accepts the connection and asks for
+// the header of the announced head block.
+func QueryStatus(rw p2p.MsgReadWriter, version uint) (*PeerStatus, error) {
+	var (
+		status = new(PeerStatus) // safe to read after a nil error has been received from errc
+		errc   = make(chan error, 1)
+	)
+	go func() {
+		errc <- queryStatus(rw, version, status)
+	}()
+	timeout := time.NewTimer(2 * handshakeTimeout)
+	defer timeout.Stop()
+
+	select {
+	case err := <-errc:
+		if err != nil {
+			return nil, err
+		}
+		return status, nil
+	case <-timeout.C:
+		return nil, p2p.DiscReadTimeout
+	}
+}
+
+func queryStatus(rw p2p.MsgReadWriter, version uint, status *PeerStatus) error {
+	msg, err := rw.ReadMsg()
+	if err != nil {
+		return err
+	}
+	if msg.Code != StatusMsg {
+		return errResp(ErrNoStatusMsg, "first msg has code %x (!= %x)", msg.Code, StatusMsg)
+	}
+	if msg.Size > ProtocolMaxMsgSize {
+		return errResp(ErrMsgTooLarge, "%v > %v", msg.Size, ProtocolMaxMsgSize)
+	}
+	// Record the status of the remote and mirror it back
+	if version >= ber64 {
+		var remote statusData64
+		if err := msg.Decode(&remote); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		status.ProtocolVersion, status.NetworkID, status.TD = remote.ProtocolVersion, remote.NetworkID, remote.TD
+		status.Head, status.Genesis, status.ForkID = remote.Head, remote.Genesis, &remote.ForkID
+
+		if err := p2p.Send(rw, StatusMsg, &remote); err != nil {
+			return err
+		}
+	} else {
+		var remote statusData
+		if err := msg.Decode(&remote); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		status.ProtocolVersion, status.NetworkID, status.TD = remote.ProtocolVersion, remote.NetworkId, remote.TD
+		status.Head, status.Genesis = remote.CurrentBlock, remote.GenesisBlock
+
+		if err := p2p.Send(rw, StatusMsg, &remote); err != nil {
+			return err
+		}
+	}
+	// Retrieve the number of the head block, skipping any announcement
+	query := &getBlockHeadersData{Origin: hashOrNumber{Hash: status.Head}, Amount: 1}
+	if err := sendPacket(rw, int(version), GetBlockHeadersMsg, 1, query); err != nil {
+		return err
+	}
+	for {
+		msg, err := rw.ReadMsg()
+		if err != nil {
+			return err
+		}
+		if msg.Code != BlockHeadersMsg {
+			msg.Discard()
+			continue
+		}
+		var headers []*types.Header
+		if _, err := decodePacket(msg, int(version), &headers); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		if len(headers) == 1 && headers[0].Hash() == status.Head {
+			number := headers[0].Number.Uint64()
+			status.HeadNumber = &number
+		}
+		return nil
+	}
+}
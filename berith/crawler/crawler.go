@@ -0,0 +1,226 @@
+// Package crawler walks the berith network to record the nodes which are
+// online, together with their client versions and the chain they follow.
+// The resulting node sets can be signed into DNS discovery trees.
+package crawler
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/BerithFoundation/berith-chain/log"
+	"github.com/BerithFoundation/berith-chain/p2p"
+	"github.com/BerithFoundation/berith-chain/p2p/enode"
+)
+
+const (
+	defaultWorkers            = 16
+	defaultRevalidateInterval = 10 * time.Minute
+	defaultProbeTimeout       = 10 * time.Second
+)
+
+// Resolver retrieves the latest record of a node, e.g. from the discovery
+// network.
+type Resolver interface {
+	RequestENR(*enode.Node) (*enode.Node, error)
+}
+
+// Config holds the settings of a Crawler.
+type Config struct {
+	Resolver   Resolver          // source of the node records, required
+	Dialer     p2p.NodeDialer    // dialer of the RLPx probes, TCP if nil
+	PrivateKey *ecdsa.PrivateKey // node key of the probes, random if nil
+
+	Workers            int           // number of nodes checked concurrently
+	RevalidateInterval time.Duration // minimum time between two checks of a node
+	ProbeTimeout       time.Duration // time allowed for the handshakes of a probe
+}
+
+// Crawler checks the nodes of an input set and of the given iterators. Nodes
+// which answer their record request are added to the output set along with
+// the result of an RLPx probe.
+type Crawler struct {
+	input     NodeSet
+	output    NodeSet
+	outputMu  sync.Mutex
+	disc      Resolver
+	prober    *prober
+	iters     []enode.Iterator
+	inputIter enode.Iterator
+	ch        chan *enode.Node
+	closed    chan struct{}
+
+	workers            int
+	revalidateInterval time.Duration
+}
+
+// New creates a crawler starting out from the nodes of the input set.
+func New(input NodeSet, config Config, iters ...enode.Iterator) (*Crawler, error) {
+	if config.Resolver == nil {
+		return nil, errors.New("crawler: no resolver configured")
+	}
+	if config.Dialer == nil {
+		config.Dialer = p2p.TCPDialer{Dialer: &net.Dialer{Timeout: defaultProbeTimeout}}
+	}
+	if config.Workers <= 0 {
+		config.Workers = defaultWorkers
+	}
+	if config.RevalidateInterval == 0 {
+		config.RevalidateInterval = defaultRevalidateInterval
+	}
+	if config.ProbeTimeout == 0 {
+		config.ProbeTimeout = defaultProbeTimeout
+	}
+	prober, err := newProber(config.PrivateKey, config.Dialer, config.ProbeTimeout)
+	if err != nil {
+		return nil, err
+	}
+	c := &Crawler{
+		input:              input,
+		output:             make(NodeSet, len(input)),
+		disc:               config.Resolver,
+		prober:             prober,
+		iters:              iters,
+		inputIter:          enode.IterNodes(input.Nodes()),
+		ch:                 make(chan *enode.Node),
+		closed:             make(chan struct{}),
+		workers:            config.Workers,
+		revalidateInterval: config.RevalidateInterval,
+	}
+	c.iters = append(c.iters, c.inputIter)
+	// Copy input to output initially. Any nodes that fail validation
+	// will be dropped from output during the run.
+	for id, n := range input {
+		c.output[id] = n
+	}
+	return c, nil
+}
+
+// Run crawls until all iterators have ended or, once the input set has been
+// revalidated, until the timeout expires. A zero timeout disables it. The
+// crawler can't be reused after Run has returned.
+func (c *Crawler) Run(timeout time.Duration) NodeSet {
+	var (
+		timeoutTimer = time.NewTimer(timeout)
+		timeoutCh    <-chan time.Time
+		doneCh       = make(chan enode.Iterator, len(c.iters))
+		liveIters    = len(c.iters)
+		workers      sync.WaitGroup
+	)
+	defer timeoutTimer.Stop()
+	defer c.prober.close()
+
+	for _, it := range c.iters {
+		go c.runIterator(doneCh, it)
+	}
+	workers.Add(c.workers)
+	for i := 0; i < c.workers; i++ {
+		go func() {
+			defer workers.Done()
+			for n := range c.ch {
+				c.updateNode(n)
+			}
+		}()
+	}
+
+loop:
+	for {
+		select {
+		case it := <-doneCh:
+			if it == c.inputIter {
+				// Enable timeout when we're done revalidating the input nodes.
+				log.Info("Revalidation of input set is done", "len", len(c.input))
+				if timeout > 0 {
+					timeoutCh = timeoutTimer.C
+				}
+			}
+			if liveIters--; liveIters == 0 {
+				break loop
+			}
+		case <-timeoutCh:
+			break loop
+		}
+	}
+
+	close(c.closed)
+	for _, it := range c.iters {
+		it.Close()
+	}
+	for ; liveIters > 0; liveIters-- {
+		<-doneCh
+	}
+	close(c.ch)
+	workers.Wait()
+
+	return c.output
+}
+
+func (c *Crawler) runIterator(done chan<- enode.Iterator, it enode.Iterator) {
+	defer func() { done <- it }()
+	for it.Next() {
+		select {
+		case c.ch <- it.Node():
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+func (c *Crawler) updateNode(n *enode.Node) {
+	c.outputMu.Lock()
+	node, ok := c.output[n.ID()]
+	c.outputMu.Unlock()
+
+	// Skip validation of recently-seen nodes.
+	if ok && time.Since(node.LastCheck) < c.revalidateInterval {
+		return
+	}
+
+	// Request the node record.
+	nn, err := c.disc.RequestENR(n)
+	node.LastCheck = truncNow()
+	if err != nil {
+		if node.Score == 0 {
+			// Node doesn't implement EIP-868.
+			log.Debug("Skipping node", "id", n.ID())
+			return
+		}
+		node.Score /= 2
+	} else {
+		node.N = Record{nn}
+		node.Seq = nn.Seq()
+		node.Score++
+		if node.FirstResponse.IsZero() {
+			node.FirstResponse = node.LastCheck
+		}
+		node.LastResponse = node.LastCheck
+
+		// Find out what the node runs. A failed probe keeps the results
+		// of the previous one, the node is alive on the discovery network.
+		if res, err := c.prober.probe(nn); err != nil {
+			log.Debug("Node probe failed", "id", n.ID(), "err", err)
+		} else {
+			node.ClientVersion = res.name
+			node.Caps = capStrings(res.caps)
+			node.Berith = statusJSON(res.status)
+		}
+	}
+
+	// Store/update node in output set.
+	c.outputMu.Lock()
+	defer c.outputMu.Unlock()
+
+	if node.Score <= 0 {
+		log.Info("Removing node", "id", n.ID())
+		delete(c.output, n.ID())
+	} else {
+		log.Info("Updating node", "id", n.ID(), "seq", n.Seq(), "score", node.Score, "client", node.ClientVersion)
+		c.output[n.ID()] = node
+	}
+}
+
+func truncNow() time.Time {
+	return time.Now().UTC().Truncate(1 * time.Second)
+}
@@ -0,0 +1,212 @@
+package crawler
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/BerithFoundation/berith-chain/common"
+	"github.com/BerithFoundation/berith-chain/core/forkid"
+	"github.com/BerithFoundation/berith-chain/core/types"
+	"github.com/BerithFoundation/berith-chain/crypto"
+	"github.com/BerithFoundation/berith-chain/node"
+	"github.com/BerithFoundation/berith-chain/p2p"
+	"github.com/BerithFoundation/berith-chain/p2p/enode"
+	"github.com/BerithFoundation/berith-chain/p2p/enr"
+	"github.com/BerithFoundation/berith-chain/p2p/simulations"
+	"github.com/BerithFoundation/berith-chain/p2p/simulations/adapters"
+	"github.com/BerithFoundation/berith-chain/rpc"
+)
+
+var (
+	testGenesis = common.HexToHash("0x1111111111111111111111111111111111111111111111111111111111111111")
+	testHead    = &types.Header{Number: big.NewInt(42), Difficulty: big.NewInt(1)}
+	testForkID  = forkid.ID{Hash: [4]byte{0xde, 0xad, 0xbe, 0xef}, Next: 100}
+)
+
+// testStatus63 and testStatus64 mirror the status messages of berith/63
+// and berith/64.
+type testStatus63 struct {
+	ProtocolVersion uint32
+	NetworkID       uint64
+	TD              *big.Int
+	Head            common.Hash
+	Genesis         common.Hash
+}
+
+type testStatus64 struct {
+	ProtocolVersion uint32
+	NetworkID       uint64
+	TD              *big.Int
+	Head            common.Hash
+	Genesis         common.Hash
+	ForkID          forkid.ID
+}
+
+// testService runs a minimal berith protocol of a single version: it sends
+// its status and serves the header of its head block.
+type testService struct {
+	version uint
+}
+
+func (s *testService) Protocols() []p2p.Protocol {
+	return []p2p.Protocol{{
+		Name:    "berith",
+		Version: s.version,
+		Length:  17,
+		Run: func(peer *p2p.Peer, rw p2p.MsgReadWriter) error {
+			var status interface{}
+			switch s.version {
+			case 63:
+				status = &testStatus63{63, 1, big.NewInt(100), testHead.Hash(), testGenesis}
+			default:
+				status = &testStatus64{64, 1, big.NewInt(100), testHead.Hash(), testGenesis, testForkID}
+			}
+			if err := p2p.Send(rw, 0x00, status); err != nil {
+				return err
+			}
+			for {
+				msg, err := rw.ReadMsg()
+				if err != nil {
+					return err
+				}
+				msg.Discard()
+				if msg.Code == 0x03 { // GetBlockHeadersMsg
+					if err := p2p.Send(rw, 0x04, []*types.Header{testHead}); err != nil {
+						return err
+					}
+				}
+			}
+		},
+	}}
+}
+
+func (s *testService) APIs() []rpc.API                { return nil }
+func (s *testService) Start(server *p2p.Server) error { return nil }
+func (s *testService) Stop() error                    { return nil }
+
+// testResolver serves the signed records of the simulation nodes.
+type testResolver map[enode.ID]*enode.Node
+
+func (r testResolver) RequestENR(n *enode.Node) (*enode.Node, error) {
+	if nn, ok := r[n.ID()]; ok {
+		return nn, nil
+	}
+	return nil, errors.New("timeout")
+}
+
+func signedNode(t *testing.T, key *ecdsa.PrivateKey, port uint16) *enode.Node {
+	var r enr.Record
+	r.Set(enr.IP(net.IP{127, 0, 0, 1}))
+	r.Set(enr.TCP(port))
+	if err := enode.SignV4(&r, key); err != nil {
+		t.Fatal(err)
+	}
+	n, err := enode.New(enode.ValidSchemes, &r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestCrawler(t *testing.T) {
+	adapter := adapters.NewSimAdapter(adapters.Services{
+		"ber63": func(*adapters.ServiceContext) (node.Service, error) { return &testService{63}, nil },
+		"ber64": func(*adapters.ServiceContext) (node.Service, error) { return &testService{64}, nil },
+	})
+	network := simulations.NewNetwork(adapter, &simulations.NetworkConfig{DefaultService: "ber64"})
+	defer network.Shutdown()
+
+	resolver := make(testResolver)
+	var nodes []*enode.Node
+	for _, service := range []string{"ber63", "ber64"} {
+		conf := adapters.RandomNodeConfig()
+		conf.Services = []string{service}
+		if _, err := network.NewNodeWithConfig(conf); err != nil {
+			t.Fatalf("error creating node: %v", err)
+		}
+		if err := network.Start(conf.ID); err != nil {
+			t.Fatalf("error starting node: %v", err)
+		}
+		n := signedNode(t, conf.PrivateKey, conf.Port)
+		resolver[n.ID()] = n
+		nodes = append(nodes, n)
+	}
+	// A node of the input set which went offline
+	key, _ := crypto.GenerateKey()
+	offline := signedNode(t, key, 30303)
+	input := make(NodeSet)
+	input.Add(offline)
+	input[offline.ID()] = NodeJSON{Seq: offline.Seq(), N: Record{offline}, Score: 1}
+
+	c, err := New(input, Config{Resolver: resolver, Dialer: adapter, ProbeTimeout: 5 * time.Second}, enode.IterNodes(nodes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := c.Run(0)
+
+	if _, ok := output[offline.ID()]; ok {
+		t.Errorf("offline node still in output set")
+	}
+	if len(output) != len(nodes) {
+		t.Fatalf("output set has %d nodes, want %d", len(output), len(nodes))
+	}
+	for i, version := range []uint32{63, 64} {
+		entry, ok := output[nodes[i].ID()]
+		if !ok {
+			t.Fatalf("node %d missing in output set", i)
+		}
+		if entry.Score != 1 || entry.LastResponse.IsZero() {
+			t.Errorf("node %d: wrong liveness: score %d, last response %v", i, entry.Score, entry.LastResponse)
+		}
+		if entry.ClientVersion == "" {
+			t.Errorf("node %d: missing client version", i)
+		}
+		if len(entry.Caps) != 1 || entry.Caps[0] != (p2p.Cap{Name: "berith", Version: uint(version)}).String() {
+			t.Errorf("node %d: wrong caps %v", i, entry.Caps)
+		}
+		status := entry.Berith
+		if status == nil {
+			t.Fatalf("node %d: missing berith status", i)
+		}
+		if status.Version != version || status.NetworkID != 1 || status.Genesis != testGenesis || status.Head != testHead.Hash() {
+			t.Errorf("node %d: wrong status %+v", i, status)
+		}
+		if status.HeadNumber == nil || *status.HeadNumber != testHead.Number.Uint64() {
+			t.Errorf("node %d: wrong head number %v", i, status.HeadNumber)
+		}
+		switch {
+		case version < 64 && status.ForkID != nil:
+			t.Errorf("node %d: unexpected fork ID %v", i, status.ForkID)
+		case version >= 64 && (status.ForkID == nil || status.ForkID.Next != testForkID.Next || common.Bytes2Hex(status.ForkID.Hash) != "deadbeef"):
+			t.Errorf("node %d: wrong fork ID %+v", i, status.ForkID)
+		}
+	}
+
+	// The output must be usable as the node list of a DNS tree
+	dir, err := ioutil.TempDir("", "crawler-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "nodes.json")
+	if err := WriteNodesJSON(file, output); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadNodesJSON(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Nodes()) != len(nodes) {
+		t.Errorf("loaded %d nodes, want %d", len(loaded.Nodes()), len(nodes))
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package crawler
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/BerithFoundation/berith-chain/common"
+	"github.com/BerithFoundation/berith-chain/common/hexutil"
+	"github.com/BerithFoundation/berith-chain/p2p/enode"
+	"github.com/BerithFoundation/berith-chain/rlp"
+)
+
+const jsonIndent = "    "
+
+// NodeSet is the nodes.json file format. It holds a set of node records
+// as a JSON object.
+type NodeSet map[enode.ID]NodeJSON
+
+// NodeJSON is the entry of a node in the set.
+type NodeJSON struct {
+	Seq uint64 `json:"seq"`
+	N   Record `json:"record"`
+
+	// The score tracks how many liveness checks were performed. It is incremented by one
+	// every time the node passes a check, and halved every time it doesn't.
+	Score int `json:"score,omitempty"`
+	// These two track the time of last successful contact.
+	FirstResponse time.Time `json:"firstResponse,omitempty"`
+	LastResponse  time.Time `json:"lastResponse,omitempty"`
+	// This one tracks the time of our last attempt to contact the node.
+	LastCheck time.Time `json:"lastCheck,omitempty"`
+
+	// [BERITH] These are reported by the node during its last successful probe.
+	ClientVersion string      `json:"clientVersion,omitempty"` // name sent in the devp2p handshake
+	Caps          []string    `json:"caps,omitempty"`          // protocols advertised by the node, e.g. "berith/64"
+	Berith        *StatusJSON `json:"berith,omitempty"`        // chain status announced in the berith handshake
+}
+
+// StatusJSON is the chain status reported by a node in the berith handshake.
+type StatusJSON struct {
+	Version    uint32      `json:"version"`
+	NetworkID  uint64      `json:"networkId"`
+	Genesis    common.Hash `json:"genesis"`
+	Head       common.Hash `json:"head"`
+	HeadNumber *uint64     `json:"headNumber,omitempty"`
+	TD         *big.Int    `json:"td"`
+	ForkID     *ForkIDJSON `json:"forkId,omitempty"`
+}
+
+// ForkIDJSON is a fork identifier (EIP-2124) in its JSON form.
+type ForkIDJSON struct {
+	Hash hexutil.Bytes `json:"hash"`
+	Next uint64        `json:"next"`
+}
+
+// Record is a node marshalled as its signed "enr:" record. Unlike the
+// enode:// URL form of the node, it keeps all the entries of the record.
+type Record struct {
+	*enode.Node
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (n Record) MarshalText() ([]byte, error) {
+	enc, err := rlp.EncodeToBytes(n.Record())
+	if err != nil {
+		return nil, err
+	}
+	return []byte("enr:" + base64.RawURLEncoding.EncodeToString(enc)), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (n *Record) UnmarshalText(text []byte) error {
+	dec, err := enode.Parse(enode.ValidSchemes, string(text))
+	if err != nil {
+		return err
+	}
+	n.Node = dec
+	return nil
+}
+
+// LoadNodesJSON reads a node set from the given file.
+func LoadNodesJSON(file string) (NodeSet, error) {
+	var nodes NodeSet
+	if err := common.LoadJSON(file, &nodes); err != nil {
+		return nil, err
+	}
+	return nodes, nil
+}
+
+// WriteNodesJSON stores a node set in the given file, or prints it to stdout
+// if the file is "-".
+func WriteNodesJSON(file string, nodes NodeSet) error {
+	nodesJSON, err := json.MarshalIndent(nodes, "", jsonIndent)
+	if err != nil {
+		return err
+	}
+	if file == "-" {
+		os.Stdout.Write(nodesJSON)
+		fmt.Println()
+		return nil
+	}
+	return ioutil.WriteFile(file, nodesJSON, 0644)
+}
+
+// Nodes returns the nodes of the set, sorted by ID.
+func (ns NodeSet) Nodes() []*enode.Node {
+	result := make([]*enode.Node, 0, len(ns))
+	for _, n := range ns {
+		result = append(result, n.N.Node)
+	}
+	// Sort by ID.
+	sort.Slice(result, func(i, j int) bool {
+		return bytes.Compare(result[i].ID().Bytes(), result[j].ID().Bytes()) < 0
+	})
+	return result
+}
+
+// Add inserts the given nodes into the set, replacing existing entries.
+func (ns NodeSet) Add(nodes ...*enode.Node) {
+	for _, n := range nodes {
+		ns[n.ID()] = NodeJSON{Seq: n.Seq(), N: Record{n}}
+	}
+}
+
+// Verify checks that all the records of the set are complete and consistent
+// with their keys.
+func (ns NodeSet) Verify() error {
+	for id, n := range ns {
+		if n.N.Node == nil {
+			return fmt.Errorf("missing record for node %v", id)
+		}
+		if n.N.ID() != id {
+			return fmt.Errorf("invalid node %v: ID does not match ID %v in record", id, n.N.ID())
+		}
+		if n.N.Seq() != n.Seq {
+			return fmt.Errorf("invalid node %v: 'seq' does not match seq %d from record", id, n.N.Seq())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package crawler
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/BerithFoundation/berith-chain/berith"
+	"github.com/BerithFoundation/berith-chain/crypto"
+	"github.com/BerithFoundation/berith-chain/p2p"
+	"github.com/BerithFoundation/berith-chain/p2p/enode"
+)
+
+var (
+	errProbeRunning = errors.New("node is already being probed")
+	errProbeTimeout = errors.New("probe timed out")
+)
+
+// probeResult is what a node reveals about itself over RLPx.
+type probeResult struct {
+	name   string
+	caps   []p2p.Cap
+	status *berith.PeerStatus
+	err    error
+}
+
+// prober connects to berith nodes over RLPx to read their devp2p handshake and
+// their chain status. The connections are closed as soon as the status is known.
+type prober struct {
+	srv     *p2p.Server
+	dialer  p2p.NodeDialer
+	timeout time.Duration
+
+	lock    sync.Mutex
+	pending map[enode.ID]chan *probeResult
+}
+
+func newProber(key *ecdsa.PrivateKey, dialer p2p.NodeDialer, timeout time.Duration) (*prober, error) {
+	if key == nil {
+		var err error
+		if key, err = crypto.GenerateKey(); err != nil {
+			return nil, err
+		}
+	}
+	p := &prober{
+		dialer:  dialer,
+		timeout: timeout,
+		pending: make(map[enode.ID]chan *probeResult),
+	}
+	protocols := make([]p2p.Protocol, 0, len(berith.ProtocolVersions))
+	for i, version := range berith.ProtocolVersions {
+		version := version // Closure for Run
+		protocols = append(protocols, p2p.Protocol{
+			Name:    berith.ProtocolName,
+			Version: version,
+			Length:  berith.ProtocolLengths[i],
+			Run: func(peer *p2p.Peer, rw p2p.MsgReadWriter) error {
+				status, err := berith.QueryStatus(rw, version)
+				p.deliver(peer.ID(), &probeResult{name: peer.Name(), caps: peer.Caps(), status: status, err: err})
+				return err
+			},
+		})
+	}
+	p.srv = &p2p.Server{Config: p2p.Config{
+		PrivateKey:  key,
+		MaxPeers:    0, // only trusted peers, i.e. the probed nodes are accepted
+		Name:        "berith-crawler",
+		Protocols:   protocols,
+		NoDiscovery: true,
+		NoDial:      true,
+		Dialer:      dialer,
+	}}
+	if err := p.srv.Start(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// probe connects to the given node and reports its client version, protocols
+// and chain status.
+func (p *prober) probe(n *enode.Node) (*probeResult, error) {
+	ch := make(chan *probeResult, 1)
+	p.lock.Lock()
+	if _, ok := p.pending[n.ID()]; ok {
+		p.lock.Unlock()
+		return nil, errProbeRunning
+	}
+	p.pending[n.ID()] = ch
+	p.lock.Unlock()
+
+	defer func() {
+		p.lock.Lock()
+		delete(p.pending, n.ID())
+		p.lock.Unlock()
+	}()
+
+	// Trust the node for the duration of the probe, otherwise it would be
+	// rejected by the peer limit.
+	p.srv.AddTrustedPeer(n)
+	defer p.srv.RemoveTrustedPeer(n)
+
+	conn, err := p.dialer.Dial(n)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.srv.SetupConn(conn, 0, n); err != nil {
+		return nil, err
+	}
+	timeout := time.NewTimer(p.timeout)
+	defer timeout.Stop()
+
+	select {
+	case res := <-ch:
+		if res.err != nil {
+			return nil, res.err
+		}
+		return res, nil
+	case <-timeout.C:
+		if peer := p.peer(n.ID()); peer != nil {
+			peer.Disconnect(p2p.DiscReadTimeout)
+		}
+		return nil, errProbeTimeout
+	}
+}
+
+// deliver hands over the result of a berith handshake to the pending probe.
+func (p *prober) deliver(id enode.ID, res *probeResult) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if ch, ok := p.pending[id]; ok {
+		select {
+		case ch <- res:
+		default:
+		}
+	}
+}
+
+func (p *prober) peer(id enode.ID) *p2p.Peer {
+	for _, peer := range p.srv.Peers() {
+		if peer.ID() == id {
+			return peer
+		}
+	}
+	return nil
+}
+
+func (p *prober) close() {
+	p.srv.Stop()
+}
+
+// statusJSON converts a berith status to its JSON form.
+func statusJSON(s *berith.PeerStatus) *StatusJSON {
+	if s == nil {
+		return nil
+	}
+	res := &StatusJSON{
+		Version:    s.ProtocolVersion,
+		NetworkID:  s.NetworkID,
+		Genesis:    s.Genesis,
+		Head:       s.Head,
+		HeadNumber: s.HeadNumber,
+		TD:         s.TD,
+	}
+	if s.ForkID != nil {
+		res.ForkID = &ForkIDJSON{Hash: s.ForkID.Hash[:], Next: s.ForkID.Next}
+	}
+	return res
+}
+
+// capStrings formats capabilities as "name/version".
+func capStrings(caps []p2p.Cap) []string {
+	res := make([]string, len(caps))
+	for i, c := range caps {
+		res[i] = c.String()
+	}
+	return res
+}
@@ -26,6 +26,7 @@ import (
 	"time"
 
 	"github.com/BerithFoundation/berith-chain"
+	"github.com/BerithFoundation/berith-chain/berith/snap"
 	"github.com/BerithFoundation/berith-chain/berithdb"
 	"github.com/BerithFoundation/berith-chain/common"
 	"github.com/BerithFoundation/berith-chain/core/rawdb"
@@ -116,6 +117,8 @@ type Downloader struct {
 	lightchain LightChain
 	blockchain BlockChain
 
+	SnapSyncer *snap.Syncer // [BERITH] Snapshot state syncer, used in snap sync mode
+
 	// Callbacks
 	dropPeer peerDropFn // Drops a peer for misbehaving
 
@@ -197,6 +200,11 @@ type BlockChain interface {
 	// FastSyncCommitHead directly commits the head block to a certain entity.
 	FastSyncCommitHead(common.Hash) error
 
+	// [BERITH] RebuildStakers reconstructs the staker set at a block whose state
+	// was synced without executing the chain, checking it against the claimed
+	// set if not nil.
+	RebuildStakers(common.Hash, []common.Address) error
+
 	// InsertChain inserts a batch of blocks into the local chain.
 	InsertChain(types.Blocks) (int, error)
 
@@ -234,6 +242,7 @@ func New(mode SyncMode, stateDb berithdb.Database, mux *event.TypeMux, chain Blo
 			processed: rawdb.ReadFastTrieProgress(stateDb),
 		},
 		trackStateReq: make(chan *stateReq),
+		SnapSyncer:    snap.NewSyncer(stateDb),
 	}
 	go dl.qosTuner()
 	go dl.stateFetcher()
@@ -256,7 +265,7 @@ func (d *Downloader) Progress() berith_chain.SyncProgress {
 	switch d.mode {
 	case FullSync:
 		current = d.blockchain.CurrentBlock().NumberU64()
-	case FastSync:
+	case FastSync, SnapSync:
 		current = d.blockchain.CurrentFastBlock().NumberU64()
 	case LightSync:
 		current = d.lightchain.CurrentHeader().Number.Uint64()
@@ -318,6 +327,21 @@ func (d *Downloader) UnregisterPeer(id string) error {
 	return nil
 }
 
+// [BERITH] PeerLatency returns the request round trip time measured for a peer,
+// along with the median of the registered peers it is compared against. The
+// boolean is false if the peer is not registered.
+func (d *Downloader) PeerLatency(id string) (rtt time.Duration, median time.Duration, ok bool) {
+	p := d.peers.Peer(id)
+	if p == nil {
+		return 0, 0, false
+	}
+	p.lock.RLock()
+	rtt = p.rtt
+	p.lock.RUnlock()
+
+	return rtt, d.peers.medianRTT(), true
+}
+
 // Synchronise tries to sync up our local block chain with a remote peer, both
 // adding various sanity checks as well as wrapping it with various log entries.
 func (d *Downloader) Synchronise(id string, head common.Hash, td *big.Int, mode SyncMode) error {
@@ -447,7 +471,7 @@ func (d *Downloader) syncWithPeer(p *peerConnection, hash common.Hash, td *big.I
 
 	// Ensure our origin point is below any fast sync pivot point
 	pivot := uint64(0)
-	if d.mode == FastSync {
+	if d.mode == FastSync || d.mode == SnapSync {
 		if height <= uint64(fsMinFullBlocks) {
 			origin = 0
 		} else {
@@ -458,7 +482,7 @@ func (d *Downloader) syncWithPeer(p *peerConnection, hash common.Hash, td *big.I
 		}
 	}
 	d.committed = 1
-	if d.mode == FastSync && pivot != 0 {
+	if (d.mode == FastSync || d.mode == SnapSync) && pivot != 0 {
 		d.committed = 0
 	}
 	// Initiate the sync using a concurrent header and content retrieval algorithm
@@ -473,7 +497,7 @@ func (d *Downloader) syncWithPeer(p *peerConnection, hash common.Hash, td *big.I
 		func() error { return d.fetchReceipts(origin + 1) },        // Receipts are retrieved during fast sync
 		func() error { return d.processHeaders(origin+1, pivot, td) },
 	}
-	if d.mode == FastSync {
+	if d.mode == FastSync || d.mode == SnapSync {
 		fetchers = append(fetchers, func() error { return d.processFastSyncContent(latest) })
 	} else if d.mode == FullSync {
 		fetchers = append(fetchers, d.processFullSyncContent)
@@ -555,7 +579,7 @@ func (d *Downloader) fetchHeight(p *peerConnection) (*types.Header, error) {
 
 	// Request the advertised remote head block and wait for the response
 	head, _ := p.peer.Head()
-	go p.peer.RequestHeadersByHash(head, 1, 0, false)
+	p.requestHeadersByHash(head, 1, 0, false)
 
 	ttl := d.requestTTL()
 	timeout := time.After(ttl)
@@ -658,7 +682,7 @@ func (d *Downloader) findAncestor(p *peerConnection, remoteHeader *types.Header)
 	switch d.mode {
 	case FullSync:
 		localHeight = d.blockchain.CurrentBlock().NumberU64()
-	case FastSync:
+	case FastSync, SnapSync:
 		localHeight = d.blockchain.CurrentFastBlock().NumberU64()
 	default:
 		localHeight = d.lightchain.CurrentHeader().Number.Uint64()
@@ -691,7 +715,7 @@ func (d *Downloader) findAncestor(p *peerConnection, remoteHeader *types.Header)
 	from, count, skip, max := calculateRequestSpan(remoteHeight, localHeight)
 
 	p.log.Trace("Span searching for common ancestor", "count", count, "from", from, "skip", skip)
-	go p.peer.RequestHeadersByNumber(uint64(from), count, skip, false)
+	p.requestHeadersByNumber(uint64(from), count, skip, false)
 
 	// Wait for the remote response to the head fetch
 	number, hash := uint64(0), common.Hash{}
@@ -739,7 +763,7 @@ func (d *Downloader) findAncestor(p *peerConnection, remoteHeader *types.Header)
 				switch d.mode {
 				case FullSync:
 					known = d.blockchain.HasBlock(h, n)
-				case FastSync:
+				case FastSync, SnapSync:
 					known = d.blockchain.HasFastBlock(h, n)
 				default:
 					known = d.lightchain.HasHeader(h, n)
@@ -782,7 +806,7 @@ func (d *Downloader) findAncestor(p *peerConnection, remoteHeader *types.Header)
 		ttl := d.requestTTL()
 		timeout := time.After(ttl)
 
-		go p.peer.RequestHeadersByNumber(check, 1, 0, false)
+		p.requestHeadersByNumber(check, 1, 0, false)
 
 		// Wait until a reply arrives to this request
 		for arrived := false; !arrived; {
@@ -812,7 +836,7 @@ func (d *Downloader) findAncestor(p *peerConnection, remoteHeader *types.Header)
 				switch d.mode {
 				case FullSync:
 					known = d.blockchain.HasBlock(h, n)
-				case FastSync:
+				case FastSync, SnapSync:
 					known = d.blockchain.HasFastBlock(h, n)
 				default:
 					known = d.lightchain.HasHeader(h, n)
@@ -876,10 +900,10 @@ func (d *Downloader) fetchHeaders(p *peerConnection, from uint64, pivot uint64)
 
 		if skeleton {
 			p.log.Trace("Fetching skeleton headers", "count", MaxHeaderFetch, "from", from)
-			go p.peer.RequestHeadersByNumber(from+uint64(MaxHeaderFetch)-1, MaxSkeletonSize, MaxHeaderFetch-1, false)
+			p.requestHeadersByNumber(from+uint64(MaxHeaderFetch)-1, MaxSkeletonSize, MaxHeaderFetch-1, false)
 		} else {
 			p.log.Trace("Fetching full headers", "count", MaxHeaderFetch, "from", from)
-			go p.peer.RequestHeadersByNumber(from, MaxHeaderFetch, 0, false)
+			p.requestHeadersByNumber(from, MaxHeaderFetch, 0, false)
 		}
 	}
 	// Start pulling the header chain skeleton until all is done
@@ -1357,7 +1381,7 @@ func (d *Downloader) processHeaders(origin uint64, pivot uint64, td *big.Int) er
 				// This check cannot be executed "as is" for full imports, since blocks may still be
 				// queued for processing when the header download completes. However, as long as the
 				// peer gave us something useful, we're already happy/progressed (above check).
-				if d.mode == FastSync || d.mode == LightSync {
+				if d.mode == FastSync || d.mode == SnapSync || d.mode == LightSync {
 					head := d.lightchain.CurrentHeader()
 					if td.Cmp(d.lightchain.GetTd(head.Hash(), head.Number.Uint64())) > 0 {
 						return errStallingPeer
@@ -1385,7 +1409,7 @@ func (d *Downloader) processHeaders(origin uint64, pivot uint64, td *big.Int) er
 				chunk := headers[:limit]
 
 				// In case of header only syncing, validate the chunk immediately
-				if d.mode == FastSync || d.mode == LightSync {
+				if d.mode == FastSync || d.mode == SnapSync || d.mode == LightSync {
 					// Collect the yet unknown headers to mark them as uncertain
 					unknown := make([]*types.Header, 0, len(headers))
 					for _, header := range chunk {
@@ -1413,7 +1437,7 @@ func (d *Downloader) processHeaders(origin uint64, pivot uint64, td *big.Int) er
 					}
 				}
 				// Unless we're doing light chains, schedule the headers for associated content retrieval
-				if d.mode == FullSync || d.mode == FastSync {
+				if d.mode == FullSync || d.mode == FastSync || d.mode == SnapSync {
 					// If we've reached the allowed number of pending headers, stall a bit
 					for d.queue.PendingBlocks() >= maxQueuedHeaders || d.queue.PendingReceipts() >= maxQueuedHeaders {
 						select {
@@ -1643,6 +1667,11 @@ func (d *Downloader) commitPivotBlock(result *fetchResult) error {
 	if _, err := d.blockchain.InsertReceiptChain([]*types.Block{block}, []types.Receipts{result.Receipts}); err != nil {
 		return err
 	}
+	// [BERITH] The staker set is kept outside of the state, so it is missing
+	// after a state sync and has to be retrieved separately
+	if err := d.syncStakers(block); err != nil {
+		return err
+	}
 	if err := d.blockchain.FastSyncCommitHead(block.Hash()); err != nil {
 		return err
 	}
@@ -1650,6 +1679,30 @@ func (d *Downloader) commitPivotBlock(result *fetchResult) error {
 	return nil
 }
 
+// [BERITH] syncStakers retrieves the staker set at the pivot block from the snap
+// peers, verifying it against the stake transactions of the downloaded bodies. If
+// no peer serves a valid set, it is derived from the bodies and the pivot state.
+func (d *Downloader) syncStakers(block *types.Block) error {
+	d.cancelLock.RLock()
+	cancel := d.cancelCh
+	d.cancelLock.RUnlock()
+
+	verify := func(stakers []common.Address) error {
+		return d.blockchain.RebuildStakers(block.Hash(), stakers)
+	}
+	switch err := d.SnapSyncer.FetchStakers(block.Hash(), verify, cancel); err {
+	case nil:
+		return nil
+	case snap.ErrCancelled:
+		return errCancelContentProcessing
+	case snap.ErrNoStakers:
+		log.Warn("No peer served the pivot staker set, deriving it locally", "number", block.Number(), "hash", block.Hash())
+		return d.blockchain.RebuildStakers(block.Hash(), nil)
+	default:
+		return err
+	}
+}
+
 // DeliverHeaders injects a new batch of block headers received from a remote
 // node into the download schedule.
 func (d *Downloader) DeliverHeaders(id string, headers []*types.Header) (err error) {
@@ -1671,6 +1724,59 @@ func (d *Downloader) DeliverNodeData(id string, data [][]byte) (err error) {
 	return d.deliver(id, d.stateCh, &statePack{id, data}, stateInMeter, stateDropMeter)
 }
 
+// DeliverHeadersWithID injects a new batch of block headers received from a
+// remote node, answering the tagged request with the given identifier. Late
+// responses to abandoned requests are dropped.
+func (d *Downloader) DeliverHeadersWithID(id string, reqID uint64, headers []*types.Header) error {
+	if err := d.claimResponse(id, headerRequest, reqID, len(headers), headerInMeter, headerDropMeter); err != nil {
+		return err
+	}
+	return d.DeliverHeaders(id, headers)
+}
+
+// DeliverBodiesWithID injects a new batch of block bodies received from a remote
+// node, answering the tagged request with the given identifier.
+func (d *Downloader) DeliverBodiesWithID(id string, reqID uint64, transactions [][]*types.Transaction, uncles [][]*types.Header) error {
+	if err := d.claimResponse(id, bodyRequest, reqID, len(transactions), bodyInMeter, bodyDropMeter); err != nil {
+		return err
+	}
+	return d.DeliverBodies(id, transactions, uncles)
+}
+
+// DeliverReceiptsWithID injects a new batch of receipts received from a remote
+// node, answering the tagged request with the given identifier.
+func (d *Downloader) DeliverReceiptsWithID(id string, reqID uint64, receipts [][]*types.Receipt) error {
+	if err := d.claimResponse(id, receiptRequest, reqID, len(receipts), receiptInMeter, receiptDropMeter); err != nil {
+		return err
+	}
+	return d.DeliverReceipts(id, receipts)
+}
+
+// DeliverNodeDataWithID injects a new batch of node state data received from a
+// remote node, answering the tagged request with the given identifier.
+func (d *Downloader) DeliverNodeDataWithID(id string, reqID uint64, data [][]byte) error {
+	if err := d.claimResponse(id, stateRequest, reqID, len(data), stateInMeter, stateDropMeter); err != nil {
+		return err
+	}
+	return d.DeliverNodeData(id, data)
+}
+
+// claimResponse checks that a tagged response of a peer answers its latest
+// request of the given kind, metering the dropped items otherwise.
+func (d *Downloader) claimResponse(id string, kind requestKind, reqID uint64, items int, inMeter, dropMeter metrics.Meter) error {
+	var err error
+	if p := d.peers.Peer(id); p == nil {
+		err = errNotRegistered
+	} else if !p.claimRequestID(kind, reqID) {
+		err = errStaleResponse
+	}
+	if err != nil {
+		inMeter.Mark(int64(items))
+		dropMeter.Mark(int64(items))
+	}
+	return err
+}
+
 // deliver injects a new batch of data received from a remote node.
 func (d *Downloader) deliver(id string, destCh chan dataPack, packet dataPack, inMeter, dropMeter metrics.Meter) (err error) {
 	// Update the delivery metrics for both good and failed deliveries
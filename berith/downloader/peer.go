@@ -43,8 +43,24 @@ var (
 	errAlreadyFetching   = errors.New("already fetching blocks from peer")
 	errAlreadyRegistered = errors.New("peer is already registered")
 	errNotRegistered     = errors.New("peer is not registered")
+	errStaleResponse     = errors.New("response to an abandoned request")
 )
 
+// requestKind identifies the type of the requests whose identifiers a peer
+// connection keeps track of.
+type requestKind int
+
+const (
+	headerRequest requestKind = iota
+	bodyRequest
+	receiptRequest
+	stateRequest
+	numRequestKinds
+)
+
+// lastRequestID is the identifier of the last tagged request sent to any peer.
+var lastRequestID uint64
+
 // peerConnection represents an active peer from which hashes and blocks are retrieved.
 type peerConnection struct {
 	id string // Unique identifier of the peer
@@ -68,6 +84,8 @@ type peerConnection struct {
 
 	lacking map[common.Hash]struct{} // Set of hashes not to request (didn't have previously)
 
+	reqIDs [numRequestKinds]uint64 // [BERITH] Identifiers of the latest tagged requests, until answered
+
 	peer Peer
 
 	version int        // Eth protocol version number to switch strategies
@@ -90,6 +108,19 @@ type Peer interface {
 	RequestNodeData([]common.Hash) error
 }
 
+// [BERITH] IDPeer is implemented by the full peers whose protocol tags every
+// request with an identifier echoed back in the response. The downloader picks
+// the identifiers itself, so that a late response to an abandoned request can't
+// be mistaken for the answer to a newer one. Such responses are delivered with
+// the Deliver*WithID methods.
+type IDPeer interface {
+	RequestHeadersByHashWithID(uint64, common.Hash, int, int, bool) error
+	RequestHeadersByNumberWithID(uint64, uint64, int, int, bool) error
+	RequestBodiesWithID(uint64, []common.Hash) error
+	RequestReceiptsWithID(uint64, []common.Hash) error
+	RequestNodeDataWithID(uint64, []common.Hash) error
+}
+
 // lightPeerWrapper wraps a LightPeer struct, stubbing out the Peer-only methods.
 type lightPeerWrapper struct {
 	peer LightPeer
@@ -141,6 +172,47 @@ func (p *peerConnection) Reset() {
 	p.stateThroughput = 0
 
 	p.lacking = make(map[common.Hash]struct{})
+	for kind := range p.reqIDs {
+		atomic.StoreUint64(&p.reqIDs[kind], 0)
+	}
+}
+
+// newRequestID tags a new request of the given kind, superseding the previous
+// one. It returns false if the peer doesn't support request identifiers.
+func (p *peerConnection) newRequestID(kind requestKind) (IDPeer, uint64, bool) {
+	tagged, ok := p.peer.(IDPeer)
+	if !ok {
+		return nil, 0, false
+	}
+	reqID := atomic.AddUint64(&lastRequestID, 1)
+	atomic.StoreUint64(&p.reqIDs[kind], reqID)
+	return tagged, reqID, true
+}
+
+// claimRequestID reports whether a response tagged with the given identifier
+// answers the latest request of its kind, which can only be answered once.
+func (p *peerConnection) claimRequestID(kind requestKind, reqID uint64) bool {
+	return reqID != 0 && atomic.CompareAndSwapUint64(&p.reqIDs[kind], reqID, 0)
+}
+
+// requestHeadersByHash issues a header retrieval based on the hash of an origin
+// block, tagged if the peer supports it.
+func (p *peerConnection) requestHeadersByHash(origin common.Hash, amount int, skip int, reverse bool) {
+	if tagged, reqID, ok := p.newRequestID(headerRequest); ok {
+		go tagged.RequestHeadersByHashWithID(reqID, origin, amount, skip, reverse)
+		return
+	}
+	go p.peer.RequestHeadersByHash(origin, amount, skip, reverse)
+}
+
+// requestHeadersByNumber issues a header retrieval based on the number of an
+// origin block, tagged if the peer supports it.
+func (p *peerConnection) requestHeadersByNumber(origin uint64, amount int, skip int, reverse bool) {
+	if tagged, reqID, ok := p.newRequestID(headerRequest); ok {
+		go tagged.RequestHeadersByNumberWithID(reqID, origin, amount, skip, reverse)
+		return
+	}
+	go p.peer.RequestHeadersByNumber(origin, amount, skip, reverse)
 }
 
 // FetchHeaders sends a header retrieval request to the remote peer.
@@ -156,7 +228,7 @@ func (p *peerConnection) FetchHeaders(from uint64, count int) error {
 	p.headerStarted = time.Now()
 
 	// Issue the header retrieval request (absolut upwards without gaps)
-	go p.peer.RequestHeadersByNumber(from, count, 0, false)
+	p.requestHeadersByNumber(from, count, 0, false)
 
 	return nil
 }
@@ -178,7 +250,11 @@ func (p *peerConnection) FetchBodies(request *fetchRequest) error {
 	for _, header := range request.Headers {
 		hashes = append(hashes, header.Hash())
 	}
-	go p.peer.RequestBodies(hashes)
+	if tagged, reqID, ok := p.newRequestID(bodyRequest); ok {
+		go tagged.RequestBodiesWithID(reqID, hashes)
+	} else {
+		go p.peer.RequestBodies(hashes)
+	}
 
 	return nil
 }
@@ -200,7 +276,11 @@ func (p *peerConnection) FetchReceipts(request *fetchRequest) error {
 	for _, header := range request.Headers {
 		hashes = append(hashes, header.Hash())
 	}
-	go p.peer.RequestReceipts(hashes)
+	if tagged, reqID, ok := p.newRequestID(receiptRequest); ok {
+		go tagged.RequestReceiptsWithID(reqID, hashes)
+	} else {
+		go p.peer.RequestReceipts(hashes)
+	}
 
 	return nil
 }
@@ -217,7 +297,11 @@ func (p *peerConnection) FetchNodeData(hashes []common.Hash) error {
 	}
 	p.stateStarted = time.Now()
 
-	go p.peer.RequestNodeData(hashes)
+	if tagged, reqID, ok := p.newRequestID(stateRequest); ok {
+		go tagged.RequestNodeDataWithID(reqID, hashes)
+	} else {
+		go p.peer.RequestNodeData(hashes)
+	}
 
 	return nil
 }
@@ -0,0 +1,101 @@
+package downloader
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/BerithFoundation/berith-chain/common"
+	"github.com/BerithFoundation/berith-chain/core/types"
+	"github.com/BerithFoundation/berith-chain/log"
+)
+
+// taggedPeer is a peer recording the identifiers of its tagged requests.
+type taggedPeer struct {
+	reqIDs chan uint64
+}
+
+func (p *taggedPeer) Head() (common.Hash, *big.Int) { return common.Hash{}, new(big.Int) }
+func (p *taggedPeer) RequestHeadersByHash(common.Hash, int, int, bool) error {
+	panic("untagged request")
+}
+func (p *taggedPeer) RequestHeadersByNumber(uint64, int, int, bool) error {
+	panic("untagged request")
+}
+func (p *taggedPeer) RequestBodies([]common.Hash) error   { panic("untagged request") }
+func (p *taggedPeer) RequestReceipts([]common.Hash) error { panic("untagged request") }
+func (p *taggedPeer) RequestNodeData([]common.Hash) error { panic("untagged request") }
+
+func (p *taggedPeer) RequestHeadersByHashWithID(reqID uint64, origin common.Hash, amount int, skip int, reverse bool) error {
+	p.reqIDs <- reqID
+	return nil
+}
+func (p *taggedPeer) RequestHeadersByNumberWithID(reqID uint64, origin uint64, amount int, skip int, reverse bool) error {
+	p.reqIDs <- reqID
+	return nil
+}
+func (p *taggedPeer) RequestBodiesWithID(reqID uint64, hashes []common.Hash) error {
+	p.reqIDs <- reqID
+	return nil
+}
+func (p *taggedPeer) RequestReceiptsWithID(reqID uint64, hashes []common.Hash) error {
+	p.reqIDs <- reqID
+	return nil
+}
+func (p *taggedPeer) RequestNodeDataWithID(reqID uint64, hashes []common.Hash) error {
+	p.reqIDs <- reqID
+	return nil
+}
+
+func (p *taggedPeer) nextID(t *testing.T) uint64 {
+	select {
+	case reqID := <-p.reqIDs:
+		return reqID
+	case <-time.After(time.Second):
+		t.Fatal("request not issued")
+		return 0
+	}
+}
+
+// Tests that tagged responses are only accepted for the latest request of their
+// kind, and only once.
+func TestTaggedResponses(t *testing.T) {
+	var (
+		d    = &Downloader{peers: newPeerSet()}
+		peer = &taggedPeer{reqIDs: make(chan uint64, 1)}
+		conn = newPeerConnection("tagged", 66, peer, log.New())
+	)
+	if err := d.peers.Register(conn); err != nil {
+		t.Fatal(err)
+	}
+	conn.requestHeadersByNumber(1, 1, 0, false)
+	abandoned := peer.nextID(t)
+	conn.requestHeadersByHash(common.Hash{1}, 1, 0, false)
+	latest := peer.nextID(t)
+
+	if err := d.DeliverHeadersWithID("tagged", abandoned, nil); err != errStaleResponse {
+		t.Errorf("response to abandoned request: have %v, want %v", err, errStaleResponse)
+	}
+	if err := d.DeliverBodiesWithID("tagged", latest, nil, nil); err != errStaleResponse {
+		t.Errorf("response of the wrong kind: have %v, want %v", err, errStaleResponse)
+	}
+	// Accepted responses are only refused for the lack of an active sync
+	if err := d.DeliverHeadersWithID("tagged", latest, []*types.Header{}); err != errNoSyncActive {
+		t.Errorf("response to latest request: have %v, want %v", err, errNoSyncActive)
+	}
+	if err := d.DeliverHeadersWithID("tagged", latest, nil); err != errStaleResponse {
+		t.Errorf("repeated response: have %v, want %v", err, errStaleResponse)
+	}
+	if err := d.DeliverHeadersWithID("unknown", latest, nil); err != errNotRegistered {
+		t.Errorf("response of unknown peer: have %v, want %v", err, errNotRegistered)
+	}
+	// Resetting the peer forgets its requests
+	if err := conn.FetchNodeData(nil); err != nil {
+		t.Fatal(err)
+	}
+	pending := peer.nextID(t)
+	conn.Reset()
+	if err := d.DeliverNodeDataWithID("tagged", pending, nil); err != errStaleResponse {
+		t.Errorf("response to request before reset: have %v, want %v", err, errStaleResponse)
+	}
+}
@@ -0,0 +1,267 @@
+package downloader
+
+import (
+	"crypto/ecdsa"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/BerithFoundation/berith-chain/berith/snap"
+	"github.com/BerithFoundation/berith-chain/berith/staking"
+	"github.com/BerithFoundation/berith-chain/berithdb"
+	"github.com/BerithFoundation/berith-chain/common"
+	"github.com/BerithFoundation/berith-chain/consensus/bsrr"
+	"github.com/BerithFoundation/berith-chain/core"
+	"github.com/BerithFoundation/berith-chain/core/types"
+	"github.com/BerithFoundation/berith-chain/core/vm"
+	"github.com/BerithFoundation/berith-chain/crypto"
+	"github.com/BerithFoundation/berith-chain/event"
+	"github.com/BerithFoundation/berith-chain/log"
+	"github.com/BerithFoundation/berith-chain/params"
+)
+
+// Reduce the header continuation checks to make the tests faster.
+func init() {
+	fsHeaderContCheck = 500 * time.Millisecond
+}
+
+// snapPeer is a snap peer serving the state and the staker sets of a local chain,
+// optionally tampering with the staker sets.
+type snapPeer struct {
+	id     string
+	chain  *core.BlockChain
+	syncer *snap.Syncer
+	tamper func([]common.Address) []common.Address
+}
+
+func (p *snapPeer) ID() string      { return p.id }
+func (p *snapPeer) Log() log.Logger { return log.New("peer", p.id) }
+
+func (p *snapPeer) RequestAccountRange(id uint64, root, origin, limit common.Hash, bytes uint64) error {
+	go func() {
+		accounts, proof := snap.ServiceGetAccountRangeQuery(p.chain.StateCache(), &snap.GetAccountRangePacket{ID: id, Root: root, Origin: origin, Limit: limit, Bytes: bytes})
+		hashes, blobs := (&snap.AccountRangePacket{Accounts: accounts}).Unpack()
+		p.syncer.OnAccounts(p, id, hashes, blobs, proof)
+	}()
+	return nil
+}
+
+func (p *snapPeer) RequestStorageRanges(id uint64, root common.Hash, accounts []common.Hash, origin, limit []byte, bytes uint64) error {
+	go func() {
+		slots, proof := snap.ServiceGetStorageRangesQuery(p.chain.StateCache(), &snap.GetStorageRangesPacket{ID: id, Root: root, Accounts: accounts, Origin: origin, Limit: limit, Bytes: bytes})
+		hashes, values := (&snap.StorageRangesPacket{Slots: slots}).Unpack()
+		p.syncer.OnStorage(p, id, hashes, values, proof)
+	}()
+	return nil
+}
+
+func (p *snapPeer) RequestByteCodes(id uint64, hashes []common.Hash, bytes uint64) error {
+	go p.syncer.OnByteCodes(p, id, snap.ServiceGetByteCodesQuery(p.chain.StateCache(), &snap.GetByteCodesPacket{ID: id, Hashes: hashes, Bytes: bytes}))
+	return nil
+}
+
+func (p *snapPeer) RequestTrieNodes(id uint64, root common.Hash, hashes []common.Hash, bytes uint64) error {
+	go p.syncer.OnTrieNodes(p, id, snap.ServiceGetTrieNodesQuery(p.chain.StateCache(), &snap.GetTrieNodesPacket{ID: id, Root: root, Hashes: hashes, Bytes: bytes}))
+	return nil
+}
+
+func (p *snapPeer) RequestStakers(id uint64, block common.Hash) error {
+	go func() {
+		stakers := snap.ServiceGetStakersQuery(p.chain, &snap.GetStakersPacket{ID: id, Block: block})
+		if p.tamper != nil {
+			stakers = p.tamper(stakers)
+		}
+		p.syncer.OnStakers(p, id, stakers)
+	}()
+	return nil
+}
+
+// stakingTester is a BSRR chain along with the databases backing it.
+type stakingTester struct {
+	db        berithdb.Database
+	stakingDB *staking.StakingDB
+	chain     *core.BlockChain
+}
+
+func newStakingTester(t *testing.T, genesis *core.Genesis) *stakingTester {
+	dir, err := ioutil.TempDir("", "stakingdb")
+	if err != nil {
+		t.Fatalf("failed to create staking db dir: %v", err)
+	}
+	stakingDB := new(staking.StakingDB)
+	if err := stakingDB.CreateDB(dir, staking.NewStakers); err != nil {
+		t.Fatalf("failed to create staking db: %v", err)
+	}
+	t.Cleanup(func() {
+		stakingDB.Close()
+		os.RemoveAll(dir)
+	})
+	db := berithdb.NewMemDatabase()
+	genesis.MustCommit(db)
+
+	// Keep the trie nodes on disk, they are served from there
+	engine := bsrr.NewCliqueWithStakingDB(stakingDB, genesis.Config.Bsrr, db)
+	chain, err := core.NewBlockChain(stakingDB, db, &core.CacheConfig{Disabled: true}, genesis.Config, engine, vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	t.Cleanup(chain.Stop)
+
+	return &stakingTester{db: db, stakingDB: stakingDB, chain: chain}
+}
+
+// extend appends a block with the given transactions to the chain.
+func (st *stakingTester) extend(t *testing.T, txs []*types.Transaction) {
+	parent := st.chain.CurrentBlock()
+	header := &types.Header{
+		ParentHash: parent.Hash(),
+		Number:     new(big.Int).Add(parent.Number(), common.Big1),
+		GasLimit:   parent.GasLimit(),
+		Time:       new(big.Int).Add(parent.Time(), big.NewInt(10)),
+		Extra:      make([]byte, 32+65),
+		Nonce:      types.EncodeNonce(1),
+		Difficulty: big.NewInt(1),
+	}
+	statedb, err := st.chain.StateAt(parent.Root())
+	if err != nil {
+		t.Fatalf("failed to retrieve parent state: %v", err)
+	}
+	var (
+		gp       = new(core.GasPool).AddGas(header.GasLimit)
+		receipts []*types.Receipt
+	)
+	for i, tx := range txs {
+		statedb.Prepare(tx.Hash(), common.Hash{}, i)
+		receipt, err := core.ApplyTransaction(st.chain.Config(), st.chain, nil, gp, statedb, header, tx, &header.GasUsed, vm.Config{})
+		if err != nil {
+			t.Fatalf("failed to apply transaction: %v", err)
+		}
+		receipts = append(receipts, receipt)
+	}
+	block, err := st.chain.Engine().Finalize(st.chain, header, statedb, txs, nil, receipts)
+	if err != nil {
+		t.Fatalf("failed to finalize block: %v", err)
+	}
+	if _, err := st.chain.InsertChain(types.Blocks{block}); err != nil {
+		t.Fatalf("failed to insert block %d: %v", block.NumberU64(), err)
+	}
+}
+
+// stakers retrieves the sorted staker set after the given block.
+func (st *stakingTester) stakers(t *testing.T, hash common.Hash) []common.Address {
+	stakers, err := st.chain.Stakers(hash)
+	if err != nil {
+		t.Fatalf("failed to retrieve stakers: %v", err)
+	}
+	sort.Slice(stakers, func(i, j int) bool { return stakers[i].Big().Cmp(stakers[j].Big()) < 0 })
+	return stakers
+}
+
+// makeChurningChain creates a chain with the stakers joining and leaving all
+// along it.
+func makeChurningChain(t *testing.T, blocks int) (*core.Genesis, *stakingTester) {
+	config := *params.TestnetChainConfig
+	var (
+		keys   = make([]*ecdsa.PrivateKey, 8)
+		alloc  = make(core.GenesisAlloc)
+		funds  = new(big.Int).Mul(big.NewInt(1000), common.UnitForBer)
+		signer = types.NewEIP155Signer(config.ChainID)
+	)
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+		alloc[crypto.PubkeyToAddress(keys[i].PublicKey)] = core.GenesisAccount{Balance: funds}
+	}
+	genesis := &core.Genesis{Config: &config, GasLimit: 8000000, Difficulty: big.NewInt(1), Alloc: alloc}
+	source := newStakingTester(t, genesis)
+
+	nonces := make([]uint64, len(keys))
+	for i := 1; i <= blocks; i++ {
+		// Every block stakes for some accounts and unstakes for another
+		var txs []*types.Transaction
+		for j, key := range keys {
+			addr := crypto.PubkeyToAddress(key.PublicKey)
+
+			var tx *types.Transaction
+			switch {
+			case (i+j)%7 == 0:
+				tx = types.NewTransaction(nonces[j], addr, common.Big0, 21000, common.Big1, nil, types.Stake, types.Main, false)
+			case (i*j)%5 == 1:
+				tx = types.NewTransaction(nonces[j], addr, new(big.Int).Mul(big.NewInt(2), common.UnitForBer), 21000, common.Big1, nil, types.Main, types.Stake, false)
+			default:
+				continue
+			}
+			tx, err := types.SignTx(tx, signer, key)
+			if err != nil {
+				t.Fatalf("failed to sign transaction: %v", err)
+			}
+			txs = append(txs, tx)
+			nonces[j]++
+		}
+		source.extend(t, txs)
+	}
+	return genesis, source
+}
+
+// Tests that fast and snap sync retrieve the staker set of the pivot block, the
+// sets contradicting the stake transactions being rejected.
+func TestSyncStakers(t *testing.T) {
+	genesis, source := makeChurningChain(t, 2*fsMinFullBlocks+32)
+
+	head := source.chain.CurrentBlock()
+	pivot := source.chain.GetBlockByNumber(head.NumberU64() - uint64(fsMinFullBlocks))
+	if len(source.stakers(t, pivot.Hash())) == 0 {
+		t.Fatalf("no stakers at the pivot block")
+	}
+	drop := func(stakers []common.Address) []common.Address { return stakers[1:] }
+	add := func(stakers []common.Address) []common.Address {
+		return append(stakers, common.HexToAddress("0xdeadbeef"))
+	}
+
+	tests := []struct {
+		name    string
+		tampers []func([]common.Address) []common.Address // Staker set tampering of each snap peer
+	}{
+		{"no peers", nil},
+		{"honest", []func([]common.Address) []common.Address{nil}},
+		{"dropping", []func([]common.Address) []common.Address{drop}},
+		{"adding", []func([]common.Address) []common.Address{add}},
+		{"mixed", []func([]common.Address) []common.Address{drop, add, nil}},
+	}
+	for _, tt := range tests {
+		for _, mode := range []SyncMode{FastSync, SnapSync} {
+			if mode == SnapSync && len(tt.tampers) == 0 {
+				continue // snap sync needs a peer for the state
+			}
+			local := newStakingTester(t, genesis)
+			dl := New(mode, local.db, new(event.TypeMux), local.chain, nil, func(string) {})
+
+			hc, err := core.NewHeaderChain(source.db, source.chain.Config(), source.chain.Engine(), func() bool { return false })
+			if err != nil {
+				t.Fatalf("failed to create header chain: %v", err)
+			}
+			if err := dl.RegisterPeer("source", 63, NewFakePeer("source", source.db, hc, dl)); err != nil {
+				t.Fatalf("failed to register peer: %v", err)
+			}
+			for i, tamper := range tt.tampers {
+				dl.SnapSyncer.Register(&snapPeer{id: string(rune('a' + i)), chain: source.chain, syncer: dl.SnapSyncer, tamper: tamper})
+			}
+			td := source.chain.GetTd(head.Hash(), head.NumberU64())
+			if err := dl.Synchronise("source", head.Hash(), td, mode); err != nil {
+				t.Fatalf("%s/%v: sync failed: %v", tt.name, mode, err)
+			}
+			if have := local.chain.CurrentBlock().Hash(); have != head.Hash() {
+				t.Fatalf("%s/%v: head mismatch: have %x, want %x", tt.name, mode, have, head.Hash())
+			}
+			for _, block := range []*types.Block{pivot, head} {
+				if have, want := local.stakers(t, block.Hash()), source.stakers(t, block.Hash()); !reflect.DeepEqual(have, want) {
+					t.Errorf("%s/%v: stakers mismatch at block %d: have %x, want %x", tt.name, mode, block.NumberU64(), have, want)
+				}
+			}
+			dl.Terminate()
+		}
+	}
+}
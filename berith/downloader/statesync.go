@@ -22,6 +22,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/BerithFoundation/berith-chain/berith/snap"
 	"github.com/BerithFoundation/berith-chain/berithdb"
 	"github.com/BerithFoundation/berith-chain/common"
 	"github.com/BerithFoundation/berith-chain/core/rawdb"
@@ -101,6 +102,11 @@ func (d *Downloader) runStateSync(s *stateSync) *stateSync {
 			req.timer.Stop()
 			req.peer.SetNodeDataIdle(len(req.items))
 		}
+		// [BERITH] The requests finished but not yet delivered to the sync are
+		// dropped too, their peers must not be left busy forever.
+		for _, req := range finished {
+			req.peer.SetNodeDataIdle(len(req.response))
+		}
 	}()
 	// Run the state sync.
 	go s.run()
@@ -212,7 +218,8 @@ func (d *Downloader) runStateSync(s *stateSync) *stateSync {
 // stateSync schedules requests for downloading a particular state trie defined
 // by a given state root.
 type stateSync struct {
-	d *Downloader // Downloader instance to access and manage current peerset
+	d    *Downloader // Downloader instance to access and manage current peerset
+	root common.Hash // State root currently being synced
 
 	sched  *trie.Sync                 // State trie sync scheduler defining the tasks
 	keccak hash.Hash                  // Keccak256 hasher to verify deliveries with
@@ -239,6 +246,7 @@ type stateTask struct {
 func newStateSync(d *Downloader, root common.Hash) *stateSync {
 	return &stateSync{
 		d:       d,
+		root:    root,
 		sched:   state.NewStateSync(root, d.stateDB),
 		keccak:  sha3.NewKeccak256(),
 		tasks:   make(map[common.Hash]*stateTask),
@@ -252,7 +260,13 @@ func newStateSync(d *Downloader, root common.Hash) *stateSync {
 // it finishes, and finally notifying any goroutines waiting for the loop to
 // finish.
 func (s *stateSync) run() {
-	s.err = s.loop()
+	if s.d.mode == SnapSync {
+		if s.err = s.d.SnapSyncer.Sync(s.root, s.cancel); s.err == snap.ErrCancelled {
+			s.err = errCancelStateFetch
+		}
+	} else {
+		s.err = s.loop()
+	}
 	close(s.done)
 }
 
@@ -0,0 +1,104 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package berith
+
+import (
+	"github.com/BerithFoundation/berith-chain/core"
+	"github.com/BerithFoundation/berith-chain/core/forkid"
+	"github.com/BerithFoundation/berith-chain/p2p/dnsdisc"
+	"github.com/BerithFoundation/berith-chain/p2p/enode"
+	"github.com/BerithFoundation/berith-chain/p2p/enr"
+	"github.com/BerithFoundation/berith-chain/rlp"
+)
+
+// enrEntry is the ENR entry which advertises the berith protocol on the
+// discovery network.
+type enrEntry struct {
+	ForkID forkid.ID // Fork identifier per EIP-2124
+
+	// Ignore additional fields (for forward compatibility).
+	Rest []rlp.RawValue `rlp:"tail"`
+}
+
+// ENRKey implements enr.Entry.
+func (e enrEntry) ENRKey() string {
+	return "berith"
+}
+
+// currentENREntry constructs an `berith` ENR entry based on the current state
+// of the chain.
+func currentENREntry(chain forkid.Blockchain) *enrEntry {
+	return &enrEntry{
+		ForkID: forkid.NewID(chain),
+	}
+}
+
+// NewNodeFilter returns a discovery filter dropping the nodes whose `berith`
+// entry announces an incompatible fork ID. Nodes without the entry are kept,
+// they are checked during the protocol handshake instead.
+func NewNodeFilter(chain forkid.Blockchain) func(*enode.Node) bool {
+	filter := forkid.NewFilter(chain)
+	return func(n *enode.Node) bool {
+		var entry enrEntry
+		if err := n.Load(&entry); err != nil {
+			return enr.IsNotFound(err)
+		}
+		return filter(entry.ForkID) == nil
+	}
+}
+
+// setupDiscovery creates the DNS discovery source of berith peers, dropping the
+// nodes incompatible with the local chain. It returns nil if no node lists are
+// configured.
+func setupDiscovery(urls []string, chain forkid.Blockchain) (enode.Iterator, error) {
+	if len(urls) == 0 {
+		return nil, nil
+	}
+	client := dnsdisc.NewClient(dnsdisc.Config{})
+	it, err := client.NewIterator(urls...)
+	if err != nil {
+		return nil, err
+	}
+	return enode.Filter(it, NewNodeFilter(chain)), nil
+}
+
+// startBerithEntryUpdate keeps the `berith` entry of the local node record in
+// sync with the fork ID of the chain head.
+func (s *Berith) startBerithEntryUpdate(ln *enode.LocalNode) {
+	var newHead = make(chan core.ChainHeadEvent, 10)
+	sub := s.blockchain.SubscribeChainHeadEvent(newHead)
+
+	go func() {
+		defer sub.Unsubscribe()
+
+		current := currentENREntry(s.blockchain)
+		ln.Set(current)
+		for {
+			select {
+			case <-newHead:
+				if next := currentENREntry(s.blockchain); next.ForkID != current.ForkID {
+					current = next
+					ln.Set(current)
+				}
+			case <-sub.Err():
+				// Would be nice to sync with s.Stop, but there is no
+				// good way to do that.
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,268 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package fetcher
+
+import (
+	"math/big"
+	mrand "math/rand"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/BerithFoundation/berith-chain/common"
+	"github.com/BerithFoundation/berith-chain/common/mclock"
+	"github.com/BerithFoundation/berith-chain/core"
+	"github.com/BerithFoundation/berith-chain/core/types"
+)
+
+var testTxs = []*types.Transaction{
+	types.NewTransaction(1, common.Address{}, big.NewInt(1), 21000, big.NewInt(1), nil, types.Main, types.Main, false),
+	types.NewTransaction(2, common.Address{}, big.NewInt(1), 21000, big.NewInt(1), nil, types.Main, types.Main, false),
+	types.NewTransaction(3, common.Address{}, big.NewInt(1), 21000, big.NewInt(1), nil, types.Main, types.Main, false),
+}
+
+// txFetchRequest is a retrieval issued by the fetcher under test.
+type txFetchRequest struct {
+	peer   string
+	hashes []common.Hash
+}
+
+// txFetcherTester runs a transaction fetcher on a simulated clock, recording
+// its retrievals and answering its pool insertions.
+type txFetcherTester struct {
+	fetcher  *TxFetcher
+	clock    *mclock.Simulated
+	requests chan txFetchRequest
+	addErr   error // Error returned by the pool for every transaction
+}
+
+func newTxFetcherTester() *txFetcherTester {
+	tester := &txFetcherTester{
+		clock:    new(mclock.Simulated),
+		requests: make(chan txFetchRequest, 16),
+	}
+	tester.fetcher = newTxFetcher(
+		func(common.Hash) bool { return false },
+		func(peer string, txs []*types.Transaction) []error {
+			errs := make([]error, len(txs))
+			for i := range errs {
+				errs[i] = tester.addErr
+			}
+			return errs
+		},
+		func(peer string, hashes []common.Hash) error {
+			tester.requests <- txFetchRequest{peer, hashes}
+			return nil
+		},
+		tester.clock, mrand.New(mrand.NewSource(0)),
+	)
+	tester.fetcher.step = make(chan struct{})
+	tester.fetcher.Start()
+	return tester
+}
+
+func (t *txFetcherTester) notify(peer string, hashes ...common.Hash) {
+	t.fetcher.Notify(peer, hashes)
+	<-t.fetcher.step
+}
+
+func (t *txFetcherTester) enqueue(peer string, direct bool, txs ...*types.Transaction) {
+	t.fetcher.Enqueue(peer, txs, direct)
+	<-t.fetcher.step
+}
+
+func (t *txFetcherTester) drop(peer string) {
+	t.fetcher.Drop(peer)
+	<-t.fetcher.step
+}
+
+// run advances the simulated clock and waits for the fetcher to handle the
+// fired timer.
+func (t *txFetcherTester) run(d time.Duration) {
+	t.clock.Run(d)
+	<-t.fetcher.step
+}
+
+func (t *txFetcherTester) expectRequest(tb testing.TB, peer string, hashes ...common.Hash) {
+	select {
+	case req := <-t.requests:
+		if req.peer != peer || !reflect.DeepEqual(req.hashes, hashes) {
+			tb.Fatalf("request mismatch: have %s %x, want %s %x", req.peer, req.hashes, peer, hashes)
+		}
+	case <-time.After(time.Second):
+		tb.Fatalf("no request to %s", peer)
+	}
+}
+
+func (t *txFetcherTester) expectNoRequest(tb testing.TB) {
+	select {
+	case req := <-t.requests:
+		tb.Fatalf("unexpected request to %s: %x", req.peer, req.hashes)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// Tests that announced transactions are requested from the announcer once
+// they weren't broadcast in the allotted time.
+func TestTxFetcherWaitAndFetch(t *testing.T) {
+	tester := newTxFetcherTester()
+	defer tester.fetcher.Stop()
+
+	tester.notify("A", testTxs[0].Hash())
+	if len(tester.fetcher.waitlist) != 1 || len(tester.fetcher.requests) != 0 {
+		t.Fatalf("announce not waiting: %d waiting, %d requests", len(tester.fetcher.waitlist), len(tester.fetcher.requests))
+	}
+	tester.run(txArriveTimeout)
+	tester.expectRequest(t, "A", testTxs[0].Hash())
+
+	tester.enqueue("A", true, testTxs[0])
+	if len(tester.fetcher.requests) != 0 || len(tester.fetcher.fetching) != 0 || len(tester.fetcher.announces) != 0 {
+		t.Fatalf("delivered transaction still tracked")
+	}
+}
+
+// Tests that transactions broadcast while waiting are never requested.
+func TestTxFetcherBroadcastCancelsFetch(t *testing.T) {
+	tester := newTxFetcherTester()
+	defer tester.fetcher.Stop()
+
+	tester.notify("A", testTxs[0].Hash())
+	tester.enqueue("B", false, testTxs[0])
+	if len(tester.fetcher.waitlist) != 0 || len(tester.fetcher.waitslots) != 0 {
+		t.Fatalf("broadcast transaction still waiting")
+	}
+	tester.clock.Run(txArriveTimeout)
+	tester.expectNoRequest(t)
+}
+
+// Tests that hashes announced by several peers are requested from only one of
+// them, and from an alternate once the request times out.
+func TestTxFetcherTimeoutReschedules(t *testing.T) {
+	tester := newTxFetcherTester()
+	defer tester.fetcher.Stop()
+
+	tester.notify("A", testTxs[0].Hash())
+	tester.notify("B", testTxs[0].Hash())
+	tester.run(txArriveTimeout)
+
+	var first string
+	select {
+	case req := <-tester.requests:
+		first = req.peer
+	case <-time.After(time.Second):
+		t.Fatal("no request issued")
+	}
+	tester.expectNoRequest(t)
+
+	tester.run(txFetchTimeout)
+	second := "A"
+	if first == "A" {
+		second = "B"
+	}
+	tester.expectRequest(t, second, testTxs[0].Hash())
+
+	// The timed out peer stays busy until it answers
+	if req := tester.fetcher.requests[first]; req == nil || req.hashes != nil {
+		t.Fatalf("timed out request not kept dangling: %v", req)
+	}
+}
+
+// Tests that only the transactions missing from a reply are requested again.
+func TestTxFetcherPartialDelivery(t *testing.T) {
+	tester := newTxFetcherTester()
+	defer tester.fetcher.Stop()
+
+	tester.notify("A", testTxs[0].Hash(), testTxs[1].Hash())
+	tester.run(txArriveTimeout)
+	select {
+	case req := <-tester.requests:
+		if req.peer != "A" || len(req.hashes) != 2 {
+			t.Fatalf("wrong request: %s %x", req.peer, req.hashes)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no request issued")
+	}
+	tester.notify("B", testTxs[0].Hash(), testTxs[1].Hash())
+	tester.expectNoRequest(t)
+
+	tester.enqueue("A", true, testTxs[0])
+	select {
+	case req := <-tester.requests:
+		if len(req.hashes) != 1 || req.hashes[0] != testTxs[1].Hash() {
+			t.Fatalf("wrong rescheduled request: %s %x", req.peer, req.hashes)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("missing transaction not rescheduled")
+	}
+}
+
+// Tests that underpriced transactions are not requested again.
+func TestTxFetcherUnderpriced(t *testing.T) {
+	tester := newTxFetcherTester()
+	defer tester.fetcher.Stop()
+
+	tester.addErr = core.ErrUnderpriced
+	tester.enqueue("A", false, testTxs[0])
+	if !tester.fetcher.underpriced.Contains(testTxs[0].Hash()) {
+		t.Fatalf("underpriced transaction not tracked")
+	}
+	// Known cheap transactions are filtered before reaching the loop
+	tester.fetcher.Notify("B", []common.Hash{testTxs[0].Hash()})
+	if len(tester.fetcher.waitlist) != 0 {
+		t.Fatalf("underpriced transaction scheduled")
+	}
+}
+
+// Tests that announcements beyond the DoS limit are dropped.
+func TestTxFetcherAnnounceLimit(t *testing.T) {
+	tester := newTxFetcherTester()
+	defer tester.fetcher.Stop()
+
+	hashes := make([]common.Hash, maxTxAnnounces+10)
+	for i := range hashes {
+		hashes[i] = common.BigToHash(big.NewInt(int64(i + 1)))
+	}
+	tester.notify("A", hashes...)
+	if len(tester.fetcher.waitslots["A"]) != maxTxAnnounces {
+		t.Fatalf("waiting announces mismatch: have %d, want %d", len(tester.fetcher.waitslots["A"]), maxTxAnnounces)
+	}
+	if _, ok := tester.fetcher.waitlist[hashes[maxTxAnnounces]]; ok {
+		t.Fatalf("announce beyond the limit accepted")
+	}
+}
+
+// Tests that dropping a peer cleans up its announcements and moves its
+// in-flight retrievals to alternate peers.
+func TestTxFetcherDrop(t *testing.T) {
+	tester := newTxFetcherTester()
+	defer tester.fetcher.Stop()
+
+	tester.notify("A", testTxs[0].Hash())
+	tester.run(txArriveTimeout)
+	tester.expectRequest(t, "A", testTxs[0].Hash())
+	tester.notify("B", testTxs[0].Hash())
+	tester.notify("B", testTxs[2].Hash())
+
+	tester.drop("A")
+	tester.expectRequest(t, "B", testTxs[0].Hash())
+
+	tester.drop("B")
+	f := tester.fetcher
+	if len(f.waitlist)+len(f.waittime)+len(f.waitslots)+len(f.announces)+len(f.announced)+len(f.fetching)+len(f.requests)+len(f.alternates) != 0 {
+		t.Fatalf("dropped peers still tracked")
+	}
+}
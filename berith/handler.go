@@ -28,16 +28,19 @@ import (
 
 	"github.com/BerithFoundation/berith-chain/berith/downloader"
 	"github.com/BerithFoundation/berith-chain/berith/fetcher"
+	"github.com/BerithFoundation/berith-chain/berith/snap"
 	"github.com/BerithFoundation/berith-chain/berithdb"
 	"github.com/BerithFoundation/berith-chain/common"
 	"github.com/BerithFoundation/berith-chain/consensus"
 	"github.com/BerithFoundation/berith-chain/consensus/misc"
 	"github.com/BerithFoundation/berith-chain/core"
+	"github.com/BerithFoundation/berith-chain/core/forkid"
 	"github.com/BerithFoundation/berith-chain/core/types"
 	"github.com/BerithFoundation/berith-chain/event"
 	"github.com/BerithFoundation/berith-chain/log"
 	"github.com/BerithFoundation/berith-chain/p2p"
 	"github.com/BerithFoundation/berith-chain/p2p/enode"
+	"github.com/BerithFoundation/berith-chain/p2p/enr"
 	"github.com/BerithFoundation/berith-chain/params"
 	"github.com/BerithFoundation/berith-chain/rlp"
 )
@@ -67,9 +70,11 @@ func errResp(code errCode, format string, v ...interface{}) error {
 }
 
 type ProtocolManager struct {
-	networkID uint64
+	networkID  uint64
+	forkFilter forkid.Filter // [BERITH] Fork ID filter, constant across the lifetime of the node
 
 	fastSync  uint32 // Flag whether fast sync is enabled (gets disabled if we already have blocks)
+	snapSync  uint32 // Flag whether fast sync should retrieve the state over the snap protocol
 	acceptTxs uint32 // Flag whether we're considered synchronised (enables transaction processing)
 
 	txpool      txPool
@@ -79,7 +84,12 @@ type ProtocolManager struct {
 
 	downloader *downloader.Downloader
 	fetcher    *fetcher.Fetcher
+	txFetcher  *fetcher.TxFetcher
 	peers      *peerSet
+	scores     *scoreBoard // [BERITH] Reputation of the remote nodes, outliving their connections
+
+	snapPeers map[string]*snap.Peer // [BERITH] Peers connected over the snap protocol
+	snapLock  sync.RWMutex          // Lock protecting the snap peers
 
 	SubProtocols []p2p.Protocol
 
@@ -88,7 +98,8 @@ type ProtocolManager struct {
 	txsSub        event.Subscription
 	minedBlockSub *event.TypeMuxSubscription
 
-	whitelist map[uint64]common.Hash
+	whitelist    map[uint64]common.Hash
+	privatePeers map[enode.ID]struct{} // Trusted peers allowed to receive private transactions
 
 	// channels for fetcher, syncer, txsyncLoop
 	newPeerCh   chan *peer
@@ -101,34 +112,45 @@ type ProtocolManager struct {
 	wg sync.WaitGroup
 }
 
-func NewProtocolManager(config *params.ChainConfig, mode downloader.SyncMode, networkID uint64, mux *event.TypeMux, txpool txPool, engine consensus.Engine, blockchain *core.BlockChain, chaindb berithdb.Database, whitelist map[uint64]common.Hash) (*ProtocolManager, error) {
+func NewProtocolManager(config *params.ChainConfig, mode downloader.SyncMode, networkID uint64, mux *event.TypeMux, txpool txPool, engine consensus.Engine, blockchain *core.BlockChain, chaindb berithdb.Database, whitelist map[uint64]common.Hash, privatePeers []*enode.Node) (*ProtocolManager, error) {
 	// Create the protocol manager with the base fields
 	manager := &ProtocolManager{
-		networkID:   networkID,
-		eventMux:    mux,
-		txpool:      txpool,
-		blockchain:  blockchain,
-		chainconfig: config,
-		peers:       newPeerSet(),
-		whitelist:   whitelist,
-		newPeerCh:   make(chan *peer),
-		noMorePeers: make(chan struct{}),
-		txsyncCh:    make(chan *txsync),
-		quitSync:    make(chan struct{}),
+		networkID:    networkID,
+		forkFilter:   forkid.NewFilter(blockchain),
+		eventMux:     mux,
+		txpool:       txpool,
+		blockchain:   blockchain,
+		chainconfig:  config,
+		peers:        newPeerSet(),
+		scores:       newScoreBoard(),
+		snapPeers:    make(map[string]*snap.Peer),
+		whitelist:    whitelist,
+		privatePeers: make(map[enode.ID]struct{}),
+		newPeerCh:    make(chan *peer),
+		noMorePeers:  make(chan struct{}),
+		txsyncCh:     make(chan *txsync),
+		quitSync:     make(chan struct{}),
+	}
+	for _, node := range privatePeers {
+		manager.privatePeers[node.ID()] = struct{}{}
 	}
 	// Figure out whether to allow fast sync or not
-	if mode == downloader.FastSync && blockchain.CurrentBlock().NumberU64() > 0 {
+	if (mode == downloader.FastSync || mode == downloader.SnapSync) && blockchain.CurrentBlock().NumberU64() > 0 {
 		log.Warn("Blockchain not empty, fast sync disabled")
 		mode = downloader.FullSync
 	}
-	if mode == downloader.FastSync {
+	if mode == downloader.FastSync || mode == downloader.SnapSync {
 		manager.fastSync = uint32(1)
 	}
+	if mode == downloader.SnapSync {
+		manager.snapSync = uint32(1)
+	}
 	// Initiate a sub-protocol for every implemented version we can handle
 	manager.SubProtocols = make([]p2p.Protocol, 0, len(ProtocolVersions))
+	nodeFilter := NewNodeFilter(blockchain)
 	for i, version := range ProtocolVersions {
 		// Skip protocol version if incompatible with the mode of operation
-		if mode == downloader.FastSync && version < ber63 {
+		if (mode == downloader.FastSync || mode == downloader.SnapSync) && version < ber63 {
 			continue
 		}
 		// Compatible; initialise the sub-protocol
@@ -157,11 +179,16 @@ func NewProtocolManager(config *params.ChainConfig, mode downloader.SyncMode, ne
 				}
 				return nil
 			},
+			Attributes: []enr.Entry{currentENREntry(blockchain)},
+			NodeFilter: nodeFilter,
 		})
 	}
 	if len(manager.SubProtocols) == 0 {
 		return nil, errIncompatibleConfig
 	}
+	// [BERITH] Serve the state ranges for snap syncing peers
+	manager.SubProtocols = append(manager.SubProtocols, snap.MakeProtocols((*snapHandler)(manager))...)
+
 	// Construct the different synchronisation mechanisms
 	manager.downloader = downloader.New(mode, chaindb, manager.eventMux, blockchain, nil, manager.removePeer)
 
@@ -178,9 +205,35 @@ func NewProtocolManager(config *params.ChainConfig, mode downloader.SyncMode, ne
 			return 0, nil
 		}
 		atomic.StoreUint32(&manager.acceptTxs, 1) // Mark initial sync done on any fetcher import
-		return manager.blockchain.InsertChain(blocks)
+		n, err := manager.blockchain.InsertChain(blocks)
+		if err != nil && n < len(blocks) {
+			manager.penalizeInvalidBlock(blocks[n], validator)
+		}
+		return n, err
+	}
+	manager.fetcher = fetcher.New(blockchain.GetBlockByHash, validator, manager.BroadcastBlock, heighter, inserter, manager.dropInvalidBlockPeer)
+
+	hasTx := func(hash common.Hash) bool {
+		return manager.txpool.Get(hash) != nil
 	}
-	manager.fetcher = fetcher.New(blockchain.GetBlockByHash, validator, manager.BroadcastBlock, heighter, inserter, manager.removePeer)
+	addTxs := func(id string, txs []*types.Transaction) []error {
+		// Private transactions never go through the fetcher, see PrivateTxMsg
+		errs := manager.txpool.AddRemotes(txs)
+
+		// [BERITH] Score the peer by the share of transactions new to the pool
+		if p := manager.peers.Peer(id); p != nil {
+			manager.scoreTransactions(p, errs)
+		}
+		return errs
+	}
+	fetchTx := func(id string, hashes []common.Hash) error {
+		p := manager.peers.Peer(id)
+		if p == nil {
+			return errNotRegistered
+		}
+		return p.RequestTxs(hashes)
+	}
+	manager.txFetcher = fetcher.NewTxFetcher(hasTx, addTxs, fetchTx)
 
 	return manager, nil
 }
@@ -195,6 +248,7 @@ func (pm *ProtocolManager) removePeer(id string) {
 
 	// Unregister the peer from the downloader and Berith peer set
 	pm.downloader.UnregisterPeer(id)
+	pm.txFetcher.Drop(id)
 	if err := pm.peers.Unregister(id); err != nil {
 		log.Error("Peer removal failed", "peer", id, "err", err)
 	}
@@ -219,6 +273,10 @@ func (pm *ProtocolManager) Start(maxPeers int) {
 	// start sync handlers
 	go pm.syncer()
 	go pm.txsyncLoop()
+	pm.txFetcher.Start()
+
+	// [BERITH] evaluate the reputation of the peers
+	go pm.scoreLoop()
 }
 
 func (pm *ProtocolManager) Stop() {
@@ -233,6 +291,7 @@ func (pm *ProtocolManager) Stop() {
 
 	// Quit fetcher, txsyncLoop.
 	close(pm.quitSync)
+	pm.txFetcher.Stop()
 
 	// Disconnect existing sessions.
 	// This also closes the gate for any new registrations on the peer set.
@@ -257,6 +316,10 @@ func (pm *ProtocolManager) handle(p *peer) error {
 	if pm.peers.Len() >= pm.maxPeers && !p.Peer.Info().Network.Trusted {
 		return p2p.DiscTooManyPeers
 	}
+	// [BERITH] Refuse the peers banned for a low score
+	if pm.scores.banned(p.ID()) && !p.Peer.Info().Network.Trusted {
+		return p2p.DiscUselessPeer
+	}
 	p.Log().Debug("Berith peer connected", "name", p.Name())
 
 	var (
@@ -266,7 +329,8 @@ func (pm *ProtocolManager) handle(p *peer) error {
 		number  = head.Number.Uint64()
 		td      = pm.blockchain.GetTd(hash, number)
 	)
-	if err := p.Handshake(pm.networkID, td, hash, genesis.Hash()); err != nil {
+	forkID := forkid.NewID(pm.blockchain)
+	if err := p.Handshake(pm.networkID, td, hash, genesis.Hash(), forkID, pm.forkFilter); err != nil {
 		p.Log().Debug("Berith handshake failed", "err", err)
 		return err
 	}
@@ -345,7 +409,8 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 	case msg.Code == GetBlockHeadersMsg:
 		// Decode the complex header query
 		var query getBlockHeadersData
-		if err := msg.Decode(&query); err != nil {
+		reqID, err := decodePacket(msg, p.version, &query)
+		if err != nil {
 			return errResp(ErrDecode, "%v: %v", msg, err)
 		}
 		hashMode := query.Origin.Hash != (common.Hash{})
@@ -427,14 +492,25 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 				query.Origin.Number += query.Skip + 1
 			}
 		}
-		return p.SendBlockHeaders(headers)
+		return p.SendBlockHeaders(reqID, headers)
 
 	case msg.Code == BlockHeadersMsg:
 		// A batch of headers arrived to one of our previous requests
 		var headers []*types.Header
-		if err := msg.Decode(&headers); err != nil {
+		reqID, err := decodePacket(msg, p.version, &headers)
+		if err != nil {
 			return errResp(ErrDecode, "msg %v: %v", msg, err)
 		}
+		// [BERITH] On berith/66, the responses to the requests of the downloader
+		// go straight to it, which drops the late ones to abandoned requests.
+		// The others answer the fetcher or the fork checks.
+		tagged := p.version >= ber66
+		if tagged && !p.claimRequest(reqID) {
+			if err := pm.downloader.DeliverHeadersWithID(p.id, reqID, headers); err != nil {
+				log.Debug("Failed to deliver headers", "err", err)
+			}
+			break
+		}
 		// If no headers were received, but we're expending a DAO fork check, maybe it's that
 		if len(headers) == 0 && p.forkDrop != nil {
 			// Possibly an empty reply to the fork header checks, sanity check TDs
@@ -483,7 +559,7 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 			// Irrelevant of the fork checks, send the header to the fetcher just in case
 			headers = pm.fetcher.FilterHeaders(p.id, headers, time.Now())
 		}
-		if len(headers) > 0 || !filter {
+		if !tagged && (len(headers) > 0 || !filter) {
 			err := pm.downloader.DeliverHeaders(p.id, headers)
 			if err != nil {
 				log.Debug("Failed to deliver headers", "err", err)
@@ -492,8 +568,8 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 
 	case msg.Code == GetBlockBodiesMsg:
 		// Decode the retrieval message
-		msgStream := rlp.NewStream(msg.Payload, uint64(msg.Size))
-		if _, err := msgStream.List(); err != nil {
+		msgStream, reqID, err := openHashList(msg, p.version)
+		if err != nil {
 			return err
 		}
 		// Gather blocks until the fetch or network limits is reached
@@ -515,12 +591,13 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 				bytes += len(data)
 			}
 		}
-		return p.SendBlockBodiesRLP(bodies)
+		return p.SendBlockBodiesRLP(reqID, bodies)
 
 	case msg.Code == BlockBodiesMsg:
 		// A batch of block bodies arrived to one of our previous requests
 		var request blockBodiesData
-		if err := msg.Decode(&request); err != nil {
+		reqID, err := decodePacket(msg, p.version, &request)
+		if err != nil {
 			return errResp(ErrDecode, "msg %v: %v", msg, err)
 		}
 		// Deliver them all to the downloader for queuing
@@ -531,6 +608,15 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 			transactions[i] = body.Transactions
 			uncles[i] = body.Uncles
 		}
+		// [BERITH] On berith/66, the responses answer either the downloader or the fetcher
+		if p.version >= ber66 {
+			if p.claimRequest(reqID) {
+				pm.fetcher.FilterBodies(p.id, transactions, uncles, time.Now())
+			} else if err := pm.downloader.DeliverBodiesWithID(p.id, reqID, transactions, uncles); err != nil {
+				log.Debug("Failed to deliver bodies", "err", err)
+			}
+			break
+		}
 		// Filter out any explicitly requested bodies, deliver the rest to the downloader
 		filter := len(transactions) > 0 || len(uncles) > 0
 		if filter {
@@ -545,8 +631,8 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 
 	case p.version >= ber63 && msg.Code == GetNodeDataMsg:
 		// Decode the retrieval message
-		msgStream := rlp.NewStream(msg.Payload, uint64(msg.Size))
-		if _, err := msgStream.List(); err != nil {
+		msgStream, reqID, err := openHashList(msg, p.version)
+		if err != nil {
 			return err
 		}
 		// Gather state data until the fetch or network limits is reached
@@ -568,23 +654,29 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 				bytes += len(entry)
 			}
 		}
-		return p.SendNodeData(data)
+		return p.SendNodeData(reqID, data)
 
 	case p.version >= ber63 && msg.Code == NodeDataMsg:
 		// A batch of node state data arrived to one of our previous requests
 		var data [][]byte
-		if err := msg.Decode(&data); err != nil {
+		reqID, err := decodePacket(msg, p.version, &data)
+		if err != nil {
 			return errResp(ErrDecode, "msg %v: %v", msg, err)
 		}
 		// Deliver all to the downloader
-		if err := pm.downloader.DeliverNodeData(p.id, data); err != nil {
+		if p.version >= ber66 {
+			err = pm.downloader.DeliverNodeDataWithID(p.id, reqID, data)
+		} else {
+			err = pm.downloader.DeliverNodeData(p.id, data)
+		}
+		if err != nil {
 			log.Debug("Failed to deliver node state data", "err", err)
 		}
 
 	case p.version >= ber63 && msg.Code == GetReceiptsMsg:
 		// Decode the retrieval message
-		msgStream := rlp.NewStream(msg.Payload, uint64(msg.Size))
-		if _, err := msgStream.List(); err != nil {
+		msgStream, reqID, err := openHashList(msg, p.version)
+		if err != nil {
 			return err
 		}
 		// Gather state data until the fetch or network limits is reached
@@ -615,16 +707,22 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 				bytes += len(encoded)
 			}
 		}
-		return p.SendReceiptsRLP(receipts)
+		return p.SendReceiptsRLP(reqID, receipts)
 
 	case p.version >= ber63 && msg.Code == ReceiptsMsg:
 		// A batch of receipts arrived to one of our previous requests
 		var receipts [][]*types.Receipt
-		if err := msg.Decode(&receipts); err != nil {
+		reqID, err := decodePacket(msg, p.version, &receipts)
+		if err != nil {
 			return errResp(ErrDecode, "msg %v: %v", msg, err)
 		}
 		// Deliver all to the downloader
-		if err := pm.downloader.DeliverReceipts(p.id, receipts); err != nil {
+		if p.version >= ber66 {
+			err = pm.downloader.DeliverReceiptsWithID(p.id, reqID, receipts)
+		} else {
+			err = pm.downloader.DeliverReceipts(p.id, receipts)
+		}
+		if err != nil {
 			log.Debug("Failed to deliver receipts", "err", err)
 		}
 
@@ -680,24 +778,114 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 			}
 		}
 
-	case msg.Code == TxMsg:
+	case p.version >= ber65 && msg.Code == NewPooledTransactionHashesMsg:
+		// New transaction announcement arrived, make sure we have
+		// a valid and fresh chain to handle them
+		if atomic.LoadUint32(&pm.acceptTxs) == 0 {
+			break
+		}
+		var hashes []common.Hash
+		if err := msg.Decode(&hashes); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		// Schedule all the unknown hashes for retrieval
+		for _, hash := range hashes {
+			p.MarkTransaction(hash)
+		}
+		pm.txFetcher.Notify(p.id, hashes)
+
+	case p.version >= ber65 && msg.Code == GetPooledTransactionsMsg:
+		// Decode the retrieval message
+		msgStream, reqID, err := openHashList(msg, p.version)
+		if err != nil {
+			return err
+		}
+		// Gather transactions until the fetch or network limits is reached
+		var (
+			hash   common.Hash
+			bytes  int
+			hashes []common.Hash
+			txs    []rlp.RawValue
+		)
+		for bytes < softResponseLimit {
+			// Retrieve the hash of the next transaction
+			if err := msgStream.Decode(&hash); err == rlp.EOL {
+				break
+			} else if err != nil {
+				return errResp(ErrDecode, "msg %v: %v", msg, err)
+			}
+			// Retrieve the requested transaction, skipping if unknown to us.
+			// Private transactions are only sent over PrivateTxMsg, as they would
+			// be taken for public ones here.
+			tx := pm.txpool.Get(hash)
+			if tx == nil || pm.txpool.IsPrivate(hash) {
+				continue
+			}
+			// If known, encode and queue for response packet
+			if encoded, err := rlp.EncodeToBytes(tx); err != nil {
+				log.Error("Failed to encode transaction", "err", err)
+			} else {
+				hashes = append(hashes, hash)
+				txs = append(txs, encoded)
+				bytes += len(encoded)
+			}
+		}
+		return p.SendPooledTransactionsRLP(reqID, hashes, txs)
+
+	case msg.Code == TxMsg || (p.version >= ber65 && msg.Code == PooledTransactionsMsg):
 		// Transactions arrived, make sure we have a valid and fresh chain to handle them
 		if atomic.LoadUint32(&pm.acceptTxs) == 0 {
 			break
 		}
 		// Transactions can be processed, parse all of them and deliver to the pool
+		var (
+			txs    []*types.Transaction
+			direct = msg.Code == PooledTransactionsMsg
+		)
+		if direct {
+			reqID, err := decodePacket(msg, p.version, &txs)
+			if err != nil {
+				return errResp(ErrDecode, "msg %v: %v", msg, err)
+			}
+			// [BERITH] On berith/66, transactions not answering a pending request
+			// of the fetcher are taken as a broadcast.
+			if p.version >= ber66 {
+				direct = p.claimRequest(reqID)
+			}
+		} else if err := msg.Decode(&txs); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		for i, tx := range txs {
+			// Validate and mark the remote transaction
+			if tx == nil {
+				return errResp(ErrDecode, "transaction %d is nil", i)
+			}
+			p.MarkTransaction(tx.Hash())
+		}
+		pm.txFetcher.Enqueue(p.id, txs, direct)
+
+	case p.version >= ber66 && msg.Code == PrivateTxMsg:
+		// [BERITH] Private transactions arrived, which are only kept private if
+		// the peer is a trusted one. Otherwise they are dropped rather than
+		// spread to the public peers.
+		if atomic.LoadUint32(&pm.acceptTxs) == 0 {
+			break
+		}
 		var txs []*types.Transaction
 		if err := msg.Decode(&txs); err != nil {
 			return errResp(ErrDecode, "msg %v: %v", msg, err)
 		}
 		for i, tx := range txs {
-			// Validate and mark the remote transaction
 			if tx == nil {
 				return errResp(ErrDecode, "transaction %d is nil", i)
 			}
 			p.MarkTransaction(tx.Hash())
 		}
-		pm.txpool.AddRemotes(txs)
+		if !pm.isPrivatePeer(p) {
+			p.Log().Debug("Discarded private transactions of untrusted peer", "count", len(txs))
+			break
+		}
+		pm.scoreTransactions(p, pm.txpool.AddPrivateRemotes(txs))
 
 	default:
 		return errResp(ErrInvalidMsgCode, "%v", msg.Code)
@@ -721,7 +909,8 @@ func (pm *ProtocolManager) BroadcastBlock(block *types.Block, propagate bool) {
 			log.Error("Propagating dangling block", "number", block.Number(), "hash", hash)
 			return
 		}
-		// Send the block to a subset of our peers
+		// [BERITH] Send the block to the sentry links first, then to a subset of our peers
+		links, peers := splitSentryLinks(peers)
 		transferLen := int(math.Sqrt(float64(len(peers))))
 		if transferLen < minBroadcastPeers {
 			transferLen = minBroadcastPeers
@@ -729,7 +918,7 @@ func (pm *ProtocolManager) BroadcastBlock(block *types.Block, propagate bool) {
 		if transferLen > len(peers) {
 			transferLen = len(peers)
 		}
-		transfer := peers[:transferLen]
+		transfer := append(links, peers[:transferLen]...)
 		for _, peer := range transfer {
 			peer.AsyncSendNewBlock(block, td)
 		}
@@ -746,22 +935,81 @@ func (pm *ProtocolManager) BroadcastBlock(block *types.Block, propagate bool) {
 }
 
 // BroadcastTxs will propagate a batch of transactions to all peers which are not known to
-// already have the given transaction.
+// already have the given transaction. The full transactions are only sent to the square
+// root of the peers, the remaining berith/65 peers get the hashes announced and fetch
+// what they miss. Sentry links always get the full transactions first. Private
+// transactions are only propagated to the configured private peers, in full.
 func (pm *ProtocolManager) BroadcastTxs(txs types.Transactions) {
-	var txset = make(map[*peer]types.Transactions)
-
+	var (
+		txset = make(map[*peer]types.Transactions)
+		annos = make(map[*peer][]common.Hash)
+		privs = make(map[*peer]types.Transactions)
+	)
 	// Broadcast transactions to a batch of peers not knowing about it
 	for _, tx := range txs {
-		peers := pm.peers.PeersWithoutTx(tx.Hash())
-		for _, peer := range peers {
-			txset[peer] = append(txset[peer], tx)
+		// Private transactions are sent in full to every private peer
+		if pm.txpool.IsPrivate(tx.Hash()) {
+			peers := pm.filterPrivatePeers(pm.peers.PeersWithoutTx(tx.Hash()))
+			for _, peer := range peers {
+				privs[peer] = append(privs[peer], tx)
+			}
+			log.Trace("Broadcast private transaction", "hash", tx.Hash(), "recipients", len(peers))
+			continue
+		}
+		links, peers := splitSentryLinks(pm.peers.PeersWithoutTx(tx.Hash()))
+		transferLen := len(links) + int(math.Sqrt(float64(len(peers))))
+		peers = append(links, peers...)
+		for i, peer := range peers {
+			// Peers predating berith/65 can't fetch announced transactions
+			if i < transferLen || peer.version < ber65 {
+				txset[peer] = append(txset[peer], tx)
+			} else {
+				annos[peer] = append(annos[peer], tx.Hash())
+			}
 		}
 		log.Trace("Broadcast transaction", "hash", tx.Hash(), "recipients", len(peers))
 	}
-	// FIXME include this again: peers = peers[:int(math.Sqrt(float64(len(peers))))]
 	for peer, txs := range txset {
 		peer.AsyncSendTransactions(txs)
 	}
+	for peer, hashes := range annos {
+		peer.AsyncSendPooledTransactionHashes(hashes)
+	}
+	for peer, txs := range privs {
+		peer.AsyncSendPrivateTransactions(txs)
+	}
+}
+
+// splitSentryLinks separates the links between a validator and its sentries,
+// relaying blocks and transactions with priority, from the rest of the peers.
+func splitSentryLinks(peers []*peer) (links []*peer, others []*peer) {
+	others = make([]*peer, 0, len(peers))
+	for _, p := range peers {
+		if p.SentryLink() {
+			links = append(links, p)
+		} else {
+			others = append(others, p)
+		}
+	}
+	return links, others
+}
+
+// isPrivatePeer reports whether the peer is allowed to exchange private
+// transactions, which takes the PrivateTxMsg of berith/66.
+func (pm *ProtocolManager) isPrivatePeer(p *peer) bool {
+	_, ok := pm.privatePeers[p.ID()]
+	return ok && p.version >= ber66
+}
+
+// filterPrivatePeers retains the peers allowed to receive private transactions.
+func (pm *ProtocolManager) filterPrivatePeers(peers []*peer) []*peer {
+	list := make([]*peer, 0, len(peers))
+	for _, p := range peers {
+		if pm.isPrivatePeer(p) {
+			list = append(list, p)
+		}
+	}
+	return list
 }
 
 // Mined broadcast loop
@@ -0,0 +1,88 @@
+package berith
+
+import (
+	"fmt"
+
+	"github.com/BerithFoundation/berith-chain/berith/snap"
+	"github.com/BerithFoundation/berith-chain/core"
+	"github.com/BerithFoundation/berith-chain/p2p/enode"
+)
+
+// snapHandler implements the snap.Backend interface to handle the various network
+// packets that are sent as replies or broadcasts.
+type snapHandler ProtocolManager
+
+// snapPeerInfo represents a short summary of the snap protocol metadata known
+// about a connected peer.
+type snapPeerInfo struct {
+	Version uint `json:"version"` // Snapshot protocol version negotiated
+}
+
+// Chain retrieves the chain from which the state ranges are served.
+func (h *snapHandler) Chain() *core.BlockChain {
+	return h.blockchain
+}
+
+// RunPeer is invoked when a peer joins on the snap protocol, registering it as
+// a state source of the snap syncer for its lifetime.
+func (h *snapHandler) RunPeer(peer *snap.Peer, handler snap.Handler) error {
+	h.wg.Add(1)
+	defer h.wg.Done()
+
+	h.snapLock.Lock()
+	if _, ok := h.snapPeers[peer.ID()]; ok {
+		h.snapLock.Unlock()
+		return errAlreadyRegistered
+	}
+	h.snapPeers[peer.ID()] = peer
+	h.snapLock.Unlock()
+
+	defer func() {
+		h.snapLock.Lock()
+		delete(h.snapPeers, peer.ID())
+		h.snapLock.Unlock()
+	}()
+	if err := h.downloader.SnapSyncer.Register(peer); err != nil {
+		return err
+	}
+	defer h.downloader.SnapSyncer.Unregister(peer.ID())
+
+	return handler(peer)
+}
+
+// PeerInfo retrieves all known snap information about a peer.
+func (h *snapHandler) PeerInfo(id enode.ID) interface{} {
+	h.snapLock.RLock()
+	defer h.snapLock.RUnlock()
+
+	if peer := h.snapPeers[fmt.Sprintf("%x", id[:8])]; peer != nil {
+		return &snapPeerInfo{Version: peer.Version()}
+	}
+	return nil
+}
+
+// Handle is invoked from a peer's message handler when it receives a new remote
+// message that the handler couldn't consume and serve itself.
+func (h *snapHandler) Handle(peer *snap.Peer, packet snap.Packet) error {
+	switch packet := packet.(type) {
+	case *snap.AccountRangePacket:
+		hashes, accounts := packet.Unpack()
+		return h.downloader.SnapSyncer.OnAccounts(peer, packet.ID, hashes, accounts, packet.Proof)
+
+	case *snap.StorageRangesPacket:
+		hashset, slotset := packet.Unpack()
+		return h.downloader.SnapSyncer.OnStorage(peer, packet.ID, hashset, slotset, packet.Proof)
+
+	case *snap.ByteCodesPacket:
+		return h.downloader.SnapSyncer.OnByteCodes(peer, packet.ID, packet.Codes)
+
+	case *snap.TrieNodesPacket:
+		return h.downloader.SnapSyncer.OnTrieNodes(peer, packet.ID, packet.Nodes)
+
+	case *snap.StakersPacket:
+		return h.downloader.SnapSyncer.OnStakers(peer, packet.ID, packet.Stakers)
+
+	default:
+		return fmt.Errorf("unexpected snap packet type: %T", packet)
+	}
+}
@@ -0,0 +1,216 @@
+package berith
+
+import (
+	"math/big"
+	"sync"
+	"testing"
+
+	"github.com/BerithFoundation/berith-chain/berith/fetcher"
+	"github.com/BerithFoundation/berith-chain/common"
+	"github.com/BerithFoundation/berith-chain/core"
+	"github.com/BerithFoundation/berith-chain/core/types"
+	"github.com/BerithFoundation/berith-chain/event"
+	"github.com/BerithFoundation/berith-chain/p2p"
+	"github.com/BerithFoundation/berith-chain/p2p/enode"
+)
+
+// testTxPool is a transaction pool holding a fixed set of pending transactions,
+// some of them private, and recording the transactions added to it.
+type testTxPool struct {
+	pending map[common.Address]types.Transactions
+	private map[common.Hash]bool
+	added   map[common.Hash]bool // Added transactions, mapped to whether they were private
+	lock    sync.Mutex
+	txFeed  event.Feed
+}
+
+func newTestTxPool(public, private types.Transactions) *testTxPool {
+	pool := &testTxPool{
+		pending: map[common.Address]types.Transactions{common.Address{1}: public, common.Address{2}: private},
+		private: make(map[common.Hash]bool),
+		added:   make(map[common.Hash]bool),
+	}
+	for _, tx := range private {
+		pool.private[tx.Hash()] = true
+	}
+	return pool
+}
+
+func (p *testTxPool) add(txs []*types.Transaction, private bool) []error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for _, tx := range txs {
+		p.added[tx.Hash()] = private
+	}
+	return make([]error, len(txs))
+}
+func (p *testTxPool) AddRemotes(txs []*types.Transaction) []error { return p.add(txs, false) }
+func (p *testTxPool) AddPrivateRemotes(txs []*types.Transaction) []error {
+	return p.add(txs, true)
+}
+func (p *testTxPool) Get(hash common.Hash) *types.Transaction {
+	for _, txs := range p.pending {
+		for _, tx := range txs {
+			if tx.Hash() == hash {
+				return tx
+			}
+		}
+	}
+	return nil
+}
+func (p *testTxPool) Pending() (map[common.Address]types.Transactions, error) {
+	pending := make(map[common.Address]types.Transactions)
+	for addr, txs := range p.pending {
+		pending[addr] = append(types.Transactions{}, txs...)
+	}
+	return pending, nil
+}
+func (p *testTxPool) SubscribeNewTxsEvent(ch chan<- core.NewTxsEvent) event.Subscription {
+	return p.txFeed.Subscribe(ch)
+}
+func (p *testTxPool) IsPrivate(hash common.Hash) bool { return p.private[hash] }
+
+func newTestTxs(n int, nonce uint64) types.Transactions {
+	txs := make(types.Transactions, n)
+	for i := range txs {
+		txs[i] = types.NewTransaction(nonce+uint64(i), common.Address{}, big.NewInt(1), 21000, big.NewInt(1), nil, types.Main, types.Main, false)
+	}
+	return txs
+}
+
+// newTxTestManager creates a protocol manager over the given pool, connected to
+// peers whose broadcast queues are left for the test to inspect. The first
+// private peers are the configured private peers.
+func newTxTestManager(pool txPool, versions []int, private int) (*ProtocolManager, []*peer) {
+	pm := &ProtocolManager{
+		txpool:       pool,
+		peers:        newPeerSet(),
+		privatePeers: make(map[enode.ID]struct{}),
+	}
+	peers := make([]*peer, len(versions))
+	for i, version := range versions {
+		id := enode.ID{byte(i + 1)}
+		peers[i] = newPeer(version, p2p.NewPeer(id, "test", nil), nil)
+		pm.peers.peers[peers[i].id] = peers[i]
+		if i < private {
+			pm.privatePeers[id] = struct{}{}
+		}
+	}
+	return pm, peers
+}
+
+// queuedHashes drains the broadcast queues of a peer, returning the hashes of
+// the transactions sent in full, of those announced and of the private ones.
+func queuedHashes(p *peer) (sent, announced, private map[common.Hash]bool) {
+	sent, announced, private = make(map[common.Hash]bool), make(map[common.Hash]bool), make(map[common.Hash]bool)
+	for {
+		select {
+		case txs := <-p.queuedTxs:
+			for _, tx := range txs {
+				sent[tx.Hash()] = true
+			}
+		case txs := <-p.queuedPrivs:
+			for _, tx := range txs {
+				private[tx.Hash()] = true
+			}
+		case hashes := <-p.queuedTxAnns:
+			for _, hash := range hashes {
+				announced[hash] = true
+			}
+		default:
+			return sent, announced, private
+		}
+	}
+}
+
+// Tests that private transactions are only sent to the private peers, in full
+// over the private transaction messages, while the public ones reach every peer.
+func TestBroadcastPrivateTxs(t *testing.T) {
+	public, private := newTestTxs(2, 0), newTestTxs(2, 10)
+	pm, peers := newTxTestManager(newTestTxPool(public, private), []int{ber66, ber66, ber65, ber65, ber65, ber66, ber64}, 3)
+
+	pm.BroadcastTxs(append(append(types.Transactions{}, public...), private...))
+
+	for i, p := range peers {
+		sent, announced, privs := queuedHashes(p)
+		for _, tx := range public {
+			if !sent[tx.Hash()] && !announced[tx.Hash()] {
+				t.Errorf("peer %d: public transaction %x not propagated", i, tx.Hash())
+			}
+			if privs[tx.Hash()] {
+				t.Errorf("peer %d: public transaction %x sent as private", i, tx.Hash())
+			}
+		}
+		for _, tx := range private {
+			if sent[tx.Hash()] || announced[tx.Hash()] {
+				t.Errorf("peer %d: private transaction %x propagated as public", i, tx.Hash())
+			}
+			// The third private peer predates the private transaction messages
+			switch {
+			case i < 2 && !privs[tx.Hash()]:
+				t.Errorf("peer %d: private transaction %x not sent to private peer", i, tx.Hash())
+			case i >= 2 && privs[tx.Hash()]:
+				t.Errorf("peer %d: private transaction %x sent to public peer", i, tx.Hash())
+			}
+		}
+	}
+	// Peers knowing a private transaction already are skipped
+	pm.BroadcastTxs(private)
+	for i, p := range peers {
+		if sent, announced, privs := queuedHashes(p); len(sent)+len(announced)+len(privs) > 0 {
+			t.Errorf("peer %d: known transactions propagated again", i)
+		}
+	}
+}
+
+// Tests that transactions are only kept private if a private peer sends them
+// over the private transaction messages.
+func TestHandlePrivateTxs(t *testing.T) {
+	pool := newTestTxPool(nil, nil)
+	pm, _ := newTxTestManager(pool, nil, 0)
+	pm.scores = newScoreBoard()
+	pm.acceptTxs = 1
+	pm.txFetcher = fetcher.NewTxFetcher(func(common.Hash) bool { return false }, func(id string, txs []*types.Transaction) []error {
+		return pool.AddRemotes(txs)
+	}, func(string, []common.Hash) error { return nil })
+	pm.txFetcher.Start()
+	defer pm.txFetcher.Stop()
+
+	tests := []struct {
+		private bool // Whether the sending peer is a private one
+		code    uint64
+		added   bool
+	}{
+		{true, TxMsg, false},
+		{true, PrivateTxMsg, true},
+		{false, TxMsg, false},
+		{false, PrivateTxMsg, false},
+	}
+	for i, tt := range tests {
+		app, net := p2p.MsgPipe()
+		id := enode.ID{byte(i + 1)}
+		p := newPeer(ber66, p2p.NewPeer(id, "test", nil), net)
+		if tt.private {
+			pm.privatePeers[id] = struct{}{}
+		}
+		txs := newTestTxs(1, uint64(10*i))
+		go p2p.Send(app, tt.code, txs)
+		if err := pm.handleMsg(p); err != nil {
+			t.Fatalf("test #%d: failed to handle message: %v", i, err)
+		}
+		app.Close()
+
+		pool.lock.Lock()
+		private, ok := pool.added[txs[0].Hash()]
+		pool.lock.Unlock()
+		switch {
+		case tt.private || tt.code == TxMsg:
+			if !ok || private != tt.added {
+				t.Errorf("test #%d: transaction added: %v, private: %v, want private: %v", i, ok, private, tt.added)
+			}
+		case ok:
+			t.Errorf("test #%d: private transaction of public peer added", i)
+		}
+	}
+}
@@ -20,10 +20,12 @@ import (
 	"errors"
 	"fmt"
 	"math/big"
+	"math/rand"
 	"sync"
 	"time"
 
 	"github.com/BerithFoundation/berith-chain/common"
+	"github.com/BerithFoundation/berith-chain/core/forkid"
 	"github.com/BerithFoundation/berith-chain/core/types"
 	"github.com/BerithFoundation/berith-chain/p2p"
 	"github.com/BerithFoundation/berith-chain/rlp"
@@ -45,6 +47,11 @@ const (
 	// contain a single transaction, or thousands.
 	maxQueuedTxs = 128
 
+	// maxQueuedTxAnns is the maximum number of transaction announcement lists to
+	// queue up before dropping them. Announcements are cheap, the remote side
+	// decides what it actually needs.
+	maxQueuedTxAnns = 128
+
 	// maxQueuedProps is the maximum number of block propagations to queue up before
 	// dropping broadcasts. There's not much point in queueing stale blocks, so a few
 	// that might cover uncles should be enough.
@@ -56,6 +63,10 @@ const (
 	maxQueuedAnns = 4
 
 	handshakeTimeout = 5 * time.Second
+
+	// pendingRequestTTL is the time after which an unanswered berith/66 request
+	// of the protocol manager is forgotten.
+	pendingRequestTTL = time.Minute
 )
 
 // PeerInfo represents a short summary of the Berith sub-protocol metadata known
@@ -64,6 +75,7 @@ type PeerInfo struct {
 	Version    int      `json:"version"`    // Berith protocol version negotiated
 	Difficulty *big.Int `json:"difficulty"` // Total difficulty of the peer's blockchain
 	Head       string   `json:"head"`       // SHA3 hash of the peer's best owned block
+	Score      float64  `json:"score"`      // [BERITH] Reputation of the peer as last evaluated
 }
 
 // propEvent is a block propagation, waiting for its turn in the broadcast queue.
@@ -81,30 +93,39 @@ type peer struct {
 	version  int         // Protocol version negotiated
 	forkDrop *time.Timer // Timed connection dropper if forks aren't validated in time
 
-	head common.Hash
-	td   *big.Int
-	lock sync.RWMutex
+	head  common.Hash
+	td    *big.Int
+	score float64 // [BERITH] Reputation score, refreshed by the protocol manager
+	lock  sync.RWMutex
+
+	knownTxs     mapset.Set                // Set of transaction hashes known to be known by this peer
+	knownBlocks  mapset.Set                // Set of block hashes known to be known by this peer
+	queuedTxs    chan []*types.Transaction // Queue of transactions to broadcast to the peer
+	queuedTxAnns chan []common.Hash        // Queue of transaction hashes to announce to the peer
+	queuedPrivs  chan []*types.Transaction // Queue of private transactions to send to the peer
+	queuedProps  chan *propEvent           // Queue of blocks to broadcast to the peer
+	queuedAnns   chan *types.Block         // Queue of blocks to announce to the peer
+	term         chan struct{}             // Termination channel to stop the broadcaster
 
-	knownTxs    mapset.Set                // Set of transaction hashes known to be known by this peer
-	knownBlocks mapset.Set                // Set of block hashes known to be known by this peer
-	queuedTxs   chan []*types.Transaction // Queue of transactions to broadcast to the peer
-	queuedProps chan *propEvent           // Queue of blocks to broadcast to the peer
-	queuedAnns  chan *types.Block         // Queue of blocks to announce to the peer
-	term        chan struct{}             // Termination channel to stop the broadcaster
+	requests map[uint64]time.Time // [BERITH] Pending berith/66 requests of the protocol manager
+	reqLock  sync.Mutex           // Lock protecting the pending requests
 }
 
 func newPeer(version int, p *p2p.Peer, rw p2p.MsgReadWriter) *peer {
 	return &peer{
-		Peer:        p,
-		rw:          rw,
-		version:     version,
-		id:          fmt.Sprintf("%x", p.ID().Bytes()[:8]),
-		knownTxs:    mapset.NewSet(),
-		knownBlocks: mapset.NewSet(),
-		queuedTxs:   make(chan []*types.Transaction, maxQueuedTxs),
-		queuedProps: make(chan *propEvent, maxQueuedProps),
-		queuedAnns:  make(chan *types.Block, maxQueuedAnns),
-		term:        make(chan struct{}),
+		Peer:         p,
+		rw:           rw,
+		version:      version,
+		id:           fmt.Sprintf("%x", p.ID().Bytes()[:8]),
+		knownTxs:     mapset.NewSet(),
+		knownBlocks:  mapset.NewSet(),
+		queuedTxs:    make(chan []*types.Transaction, maxQueuedTxs),
+		queuedTxAnns: make(chan []common.Hash, maxQueuedTxAnns),
+		queuedPrivs:  make(chan []*types.Transaction, maxQueuedTxs),
+		queuedProps:  make(chan *propEvent, maxQueuedProps),
+		queuedAnns:   make(chan *types.Block, maxQueuedAnns),
+		term:         make(chan struct{}),
+		requests:     make(map[uint64]time.Time),
 	}
 }
 
@@ -120,6 +141,18 @@ func (p *peer) broadcast() {
 			}
 			p.Log().Trace("Broadcast transactions", "count", len(txs))
 
+		case hashes := <-p.queuedTxAnns:
+			if err := p.SendPooledTransactionHashes(hashes); err != nil {
+				return
+			}
+			p.Log().Trace("Announced transactions", "count", len(hashes))
+
+		case txs := <-p.queuedPrivs:
+			if err := p.SendPrivateTransactions(txs); err != nil {
+				return
+			}
+			p.Log().Trace("Sent private transactions", "count", len(txs))
+
 		case prop := <-p.queuedProps:
 			if err := p.SendNewBlock(prop.block, prop.td); err != nil {
 				return
@@ -151,6 +184,7 @@ func (p *peer) Info() *PeerInfo {
 		Version:    p.version,
 		Difficulty: td,
 		Head:       hash.Hex(),
+		Score:      p.Score(),
 	}
 }
 
@@ -173,6 +207,22 @@ func (p *peer) SetHead(hash common.Hash, td *big.Int) {
 	p.td.Set(td)
 }
 
+// Score retrieves the reputation score of the peer.
+func (p *peer) Score() float64 {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return p.score
+}
+
+// SetScore updates the reputation score of the peer.
+func (p *peer) SetScore(score float64) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.score = score
+}
+
 // MarkBlock marks a block as known for the peer, ensuring that the block will
 // never be propagated to this particular peer.
 func (p *peer) MarkBlock(hash common.Hash) {
@@ -215,6 +265,64 @@ func (p *peer) AsyncSendTransactions(txs []*types.Transaction) {
 	}
 }
 
+// [BERITH] SendPrivateTransactions sends private transactions to the peer, over
+// the message keeping them private on its side, and includes the hashes in its
+// transaction hash set for future reference.
+func (p *peer) SendPrivateTransactions(txs types.Transactions) error {
+	for _, tx := range txs {
+		p.MarkTransaction(tx.Hash())
+	}
+	return p2p.Send(p.rw, PrivateTxMsg, txs)
+}
+
+// [BERITH] AsyncSendPrivateTransactions queues a list of private transactions to
+// send to a remote peer. If the peer's queue is full, the event is silently
+// dropped.
+func (p *peer) AsyncSendPrivateTransactions(txs []*types.Transaction) {
+	select {
+	case p.queuedPrivs <- txs:
+		for _, tx := range txs {
+			p.MarkTransaction(tx.Hash())
+		}
+	default:
+		p.Log().Debug("Dropping private transaction propagation", "count", len(txs))
+	}
+}
+
+// SendPooledTransactionHashes announces the availability of a batch of pooled
+// transactions to the peer and includes the hashes in its transaction hash set
+// for future reference.
+func (p *peer) SendPooledTransactionHashes(hashes []common.Hash) error {
+	for _, hash := range hashes {
+		p.MarkTransaction(hash)
+	}
+	return p2p.Send(p.rw, NewPooledTransactionHashesMsg, hashes)
+}
+
+// AsyncSendPooledTransactionHashes queues a list of transaction hashes to
+// announce to a remote peer. If the peer's announcement queue is full, the
+// event is silently dropped.
+func (p *peer) AsyncSendPooledTransactionHashes(hashes []common.Hash) {
+	select {
+	case p.queuedTxAnns <- hashes:
+		for _, hash := range hashes {
+			p.MarkTransaction(hash)
+		}
+	default:
+		p.Log().Debug("Dropping transaction announcement", "count", len(hashes))
+	}
+}
+
+// SendPooledTransactionsRLP sends requested transactions to the peer from an
+// already RLP encoded format, answering the request with the given identifier,
+// and adds the hashes in its transaction hash set for future reference.
+func (p *peer) SendPooledTransactionsRLP(reqID uint64, hashes []common.Hash, txs []rlp.RawValue) error {
+	for _, hash := range hashes {
+		p.MarkTransaction(hash)
+	}
+	return sendPacket(p.rw, p.version, PooledTransactionsMsg, reqID, txs)
+}
+
 // SendNewBlockHashes announces the availability of a number of blocks through
 // a hash notification.
 func (p *peer) SendNewBlockHashes(hashes []common.Hash, numbers []uint64) error {
@@ -258,93 +366,189 @@ func (p *peer) AsyncSendNewBlock(block *types.Block, td *big.Int) {
 	}
 }
 
-// SendBlockHeaders sends a batch of block headers to the remote peer.
-func (p *peer) SendBlockHeaders(headers []*types.Header) error {
-	return p2p.Send(p.rw, BlockHeadersMsg, headers)
+// SendBlockHeaders sends a batch of block headers to the remote peer, answering
+// the request with the given identifier.
+func (p *peer) SendBlockHeaders(reqID uint64, headers []*types.Header) error {
+	return sendPacket(p.rw, p.version, BlockHeadersMsg, reqID, headers)
 }
 
-// SendBlockBodies sends a batch of block contents to the remote peer.
-func (p *peer) SendBlockBodies(bodies []*blockBody) error {
-	return p2p.Send(p.rw, BlockBodiesMsg, blockBodiesData(bodies))
+// SendBlockBodies sends a batch of block contents to the remote peer, answering
+// the request with the given identifier.
+func (p *peer) SendBlockBodies(reqID uint64, bodies []*blockBody) error {
+	return sendPacket(p.rw, p.version, BlockBodiesMsg, reqID, blockBodiesData(bodies))
 }
 
 // SendBlockBodiesRLP sends a batch of block contents to the remote peer from
-// an already RLP encoded format.
-func (p *peer) SendBlockBodiesRLP(bodies []rlp.RawValue) error {
-	return p2p.Send(p.rw, BlockBodiesMsg, bodies)
+// an already RLP encoded format, answering the request with the given identifier.
+func (p *peer) SendBlockBodiesRLP(reqID uint64, bodies []rlp.RawValue) error {
+	return sendPacket(p.rw, p.version, BlockBodiesMsg, reqID, bodies)
 }
 
-// SendNodeDataRLP sends a batch of arbitrary internals data, corresponding to the
+// SendNodeData sends a batch of arbitrary internals data, corresponding to the
 // hashes requested.
-func (p *peer) SendNodeData(data [][]byte) error {
-	return p2p.Send(p.rw, NodeDataMsg, data)
+func (p *peer) SendNodeData(reqID uint64, data [][]byte) error {
+	return sendPacket(p.rw, p.version, NodeDataMsg, reqID, data)
 }
 
 // SendReceiptsRLP sends a batch of transaction receipts, corresponding to the
 // ones requested from an already RLP encoded format.
-func (p *peer) SendReceiptsRLP(receipts []rlp.RawValue) error {
-	return p2p.Send(p.rw, ReceiptsMsg, receipts)
+func (p *peer) SendReceiptsRLP(reqID uint64, receipts []rlp.RawValue) error {
+	return sendPacket(p.rw, p.version, ReceiptsMsg, reqID, receipts)
 }
 
 // RequestOneHeader is a wrapper around the header query functions to fetch a
 // single header. It is used solely by the fetcher.
 func (p *peer) RequestOneHeader(hash common.Hash) error {
 	p.Log().Debug("Fetching single header", "hash", hash)
-	return p2p.Send(p.rw, GetBlockHeadersMsg, &getBlockHeadersData{Origin: hashOrNumber{Hash: hash}, Amount: uint64(1), Skip: uint64(0), Reverse: false})
+	return p.RequestHeadersByHashWithID(p.newRequestID(), hash, 1, 0, false)
 }
 
 // RequestHeadersByHash fetches a batch of blocks' headers corresponding to the
 // specified header query, based on the hash of an origin block.
 func (p *peer) RequestHeadersByHash(origin common.Hash, amount int, skip int, reverse bool) error {
+	return p.RequestHeadersByHashWithID(p.newRequestID(), origin, amount, skip, reverse)
+}
+
+// RequestHeadersByHashWithID is RequestHeadersByHash, tagged with a request
+// identifier picked by the downloader.
+func (p *peer) RequestHeadersByHashWithID(reqID uint64, origin common.Hash, amount int, skip int, reverse bool) error {
 	p.Log().Debug("Fetching batch of headers", "count", amount, "fromhash", origin, "skip", skip, "reverse", reverse)
-	return p2p.Send(p.rw, GetBlockHeadersMsg, &getBlockHeadersData{Origin: hashOrNumber{Hash: origin}, Amount: uint64(amount), Skip: uint64(skip), Reverse: reverse})
+	return sendPacket(p.rw, p.version, GetBlockHeadersMsg, reqID, &getBlockHeadersData{Origin: hashOrNumber{Hash: origin}, Amount: uint64(amount), Skip: uint64(skip), Reverse: reverse})
 }
 
 // RequestHeadersByNumber fetches a batch of blocks' headers corresponding to the
 // specified header query, based on the number of an origin block.
 func (p *peer) RequestHeadersByNumber(origin uint64, amount int, skip int, reverse bool) error {
+	return p.RequestHeadersByNumberWithID(p.newRequestID(), origin, amount, skip, reverse)
+}
+
+// RequestHeadersByNumberWithID is RequestHeadersByNumber, tagged with a request
+// identifier picked by the downloader.
+func (p *peer) RequestHeadersByNumberWithID(reqID uint64, origin uint64, amount int, skip int, reverse bool) error {
 	p.Log().Debug("Fetching batch of headers", "count", amount, "fromnum", origin, "skip", skip, "reverse", reverse)
-	return p2p.Send(p.rw, GetBlockHeadersMsg, &getBlockHeadersData{Origin: hashOrNumber{Number: origin}, Amount: uint64(amount), Skip: uint64(skip), Reverse: reverse})
+	return sendPacket(p.rw, p.version, GetBlockHeadersMsg, reqID, &getBlockHeadersData{Origin: hashOrNumber{Number: origin}, Amount: uint64(amount), Skip: uint64(skip), Reverse: reverse})
 }
 
 // RequestBodies fetches a batch of blocks' bodies corresponding to the hashes
 // specified.
 func (p *peer) RequestBodies(hashes []common.Hash) error {
+	return p.RequestBodiesWithID(p.newRequestID(), hashes)
+}
+
+// RequestBodiesWithID is RequestBodies, tagged with a request identifier picked
+// by the downloader.
+func (p *peer) RequestBodiesWithID(reqID uint64, hashes []common.Hash) error {
 	p.Log().Debug("Fetching batch of block bodies", "count", len(hashes))
-	return p2p.Send(p.rw, GetBlockBodiesMsg, hashes)
+	return sendPacket(p.rw, p.version, GetBlockBodiesMsg, reqID, hashes)
 }
 
 // RequestNodeData fetches a batch of arbitrary data from a node's known state
 // data, corresponding to the specified hashes.
 func (p *peer) RequestNodeData(hashes []common.Hash) error {
+	return p.RequestNodeDataWithID(p.newRequestID(), hashes)
+}
+
+// RequestNodeDataWithID is RequestNodeData, tagged with a request identifier
+// picked by the downloader.
+func (p *peer) RequestNodeDataWithID(reqID uint64, hashes []common.Hash) error {
 	p.Log().Debug("Fetching batch of state data", "count", len(hashes))
-	return p2p.Send(p.rw, GetNodeDataMsg, hashes)
+	return sendPacket(p.rw, p.version, GetNodeDataMsg, reqID, hashes)
 }
 
 // RequestReceipts fetches a batch of transaction receipts from a remote node.
 func (p *peer) RequestReceipts(hashes []common.Hash) error {
+	return p.RequestReceiptsWithID(p.newRequestID(), hashes)
+}
+
+// RequestReceiptsWithID is RequestReceipts, tagged with a request identifier
+// picked by the downloader.
+func (p *peer) RequestReceiptsWithID(reqID uint64, hashes []common.Hash) error {
 	p.Log().Debug("Fetching batch of receipts", "count", len(hashes))
-	return p2p.Send(p.rw, GetReceiptsMsg, hashes)
+	return sendPacket(p.rw, p.version, GetReceiptsMsg, reqID, hashes)
+}
+
+// RequestTxs fetches a batch of transactions from a remote node.
+func (p *peer) RequestTxs(hashes []common.Hash) error {
+	p.Log().Debug("Fetching batch of transactions", "count", len(hashes))
+	return sendPacket(p.rw, p.version, GetPooledTransactionsMsg, p.newRequestID(), hashes)
+}
+
+// newRequestID tags a berith/66 request issued by the protocol manager itself,
+// e.g. for the fetchers, so that its response can be told apart from the ones
+// the downloader waits for. Older versions carry no identifiers, 0 is returned.
+func (p *peer) newRequestID() uint64 {
+	if p.version < ber66 {
+		return 0
+	}
+	p.reqLock.Lock()
+	defer p.reqLock.Unlock()
+
+	// Forget the requests which were never answered
+	now := time.Now()
+	for id, issued := range p.requests {
+		if now.Sub(issued) > pendingRequestTTL {
+			delete(p.requests, id)
+		}
+	}
+	id := rand.Uint64()
+	p.requests[id] = now
+	return id
+}
+
+// claimRequest reports whether the response tagged with the given identifier
+// answers a pending request issued by the protocol manager, forgetting it.
+func (p *peer) claimRequest(reqID uint64) bool {
+	p.reqLock.Lock()
+	defer p.reqLock.Unlock()
+
+	if _, ok := p.requests[reqID]; !ok {
+		return false
+	}
+	delete(p.requests, reqID)
+	return true
 }
 
 // Handshake executes the berith protocol handshake, negotiating version number,
 // network IDs, difficulties, head and genesis blocks.
-func (p *peer) Handshake(network uint64, td *big.Int, head common.Hash, genesis common.Hash) error {
+func (p *peer) Handshake(network uint64, td *big.Int, head common.Hash, genesis common.Hash, forkID forkid.ID, forkFilter forkid.Filter) error {
 	// Send out own handshake in a new thread
 	errc := make(chan error, 2)
+
 	var status statusData // safe to read after two values have been received from errc
+	var status64 statusData64
 
 	go func() {
-		errc <- p2p.Send(p.rw, StatusMsg, &statusData{
-			ProtocolVersion: uint32(p.version),
-			NetworkId:       network,
-			TD:              td,
-			CurrentBlock:    head,
-			GenesisBlock:    genesis,
-		})
+		switch {
+		case p.version == ber62 || p.version == ber63:
+			errc <- p2p.Send(p.rw, StatusMsg, &statusData{
+				ProtocolVersion: uint32(p.version),
+				NetworkId:       network,
+				TD:              td,
+				CurrentBlock:    head,
+				GenesisBlock:    genesis,
+			})
+		case p.version >= ber64:
+			errc <- p2p.Send(p.rw, StatusMsg, &statusData64{
+				ProtocolVersion: uint32(p.version),
+				NetworkID:       network,
+				TD:              td,
+				Head:            head,
+				Genesis:         genesis,
+				ForkID:          forkID,
+			})
+		default:
+			panic(fmt.Sprintf("unsupported berith protocol version: %d", p.version))
+		}
 	}()
 	go func() {
-		errc <- p.readStatus(network, &status, genesis)
+		switch {
+		case p.version == ber62 || p.version == ber63:
+			errc <- p.readStatusLegacy(network, &status, genesis)
+		case p.version >= ber64:
+			errc <- p.readStatus(network, &status64, genesis, forkFilter)
+		default:
+			panic(fmt.Sprintf("unsupported berith protocol version: %d", p.version))
+		}
 	}()
 	timeout := time.NewTimer(handshakeTimeout)
 	defer timeout.Stop()
@@ -358,11 +562,18 @@ func (p *peer) Handshake(network uint64, td *big.Int, head common.Hash, genesis
 			return p2p.DiscReadTimeout
 		}
 	}
-	p.td, p.head = status.TD, status.CurrentBlock
+	switch {
+	case p.version == ber62 || p.version == ber63:
+		p.td, p.head = status.TD, status.CurrentBlock
+	case p.version >= ber64:
+		p.td, p.head = status64.TD, status64.Head
+	default:
+		panic(fmt.Sprintf("unsupported berith protocol version: %d", p.version))
+	}
 	return nil
 }
 
-func (p *peer) readStatus(network uint64, status *statusData, genesis common.Hash) (err error) {
+func (p *peer) readStatusLegacy(network uint64, status *statusData, genesis common.Hash) (err error) {
 	msg, err := p.rw.ReadMsg()
 	if err != nil {
 		return err
@@ -389,6 +600,38 @@ func (p *peer) readStatus(network uint64, status *statusData, genesis common.Has
 	return nil
 }
 
+// [BERITH] readStatus reads the berith/64 status message, rejecting the peers
+// whose fork identifier is incompatible with the local chain.
+func (p *peer) readStatus(network uint64, status *statusData64, genesis common.Hash, forkFilter forkid.Filter) error {
+	msg, err := p.rw.ReadMsg()
+	if err != nil {
+		return err
+	}
+	if msg.Code != StatusMsg {
+		return errResp(ErrNoStatusMsg, "first msg has code %x (!= %x)", msg.Code, StatusMsg)
+	}
+	if msg.Size > ProtocolMaxMsgSize {
+		return errResp(ErrMsgTooLarge, "%v > %v", msg.Size, ProtocolMaxMsgSize)
+	}
+	// Decode the handshake and make sure everything matches
+	if err := msg.Decode(&status); err != nil {
+		return errResp(ErrDecode, "msg %v: %v", msg, err)
+	}
+	if status.NetworkID != network {
+		return errResp(ErrNetworkIdMismatch, "%d (!= %d)", status.NetworkID, network)
+	}
+	if int(status.ProtocolVersion) != p.version {
+		return errResp(ErrProtocolVersionMismatch, "%d (!= %d)", status.ProtocolVersion, p.version)
+	}
+	if status.Genesis != genesis {
+		return errResp(ErrGenesisBlockMismatch, "%x (!= %x)", status.Genesis, genesis)
+	}
+	if err := forkFilter(status.ForkID); err != nil {
+		return errResp(ErrForkIDRejected, "%v", err)
+	}
+	return nil
+}
+
 // String implements fmt.Stringer.
 func (p *peer) String() string {
 	return fmt.Sprintf("Peer %s [%s]", p.id,
@@ -462,6 +705,18 @@ func (ps *peerSet) Len() int {
 	return len(ps.peers)
 }
 
+// AllPeers retrieves a flat list of all the peers within the set.
+func (ps *peerSet) AllPeers() []*peer {
+	ps.lock.RLock()
+	defer ps.lock.RUnlock()
+
+	list := make([]*peer, 0, len(ps.peers))
+	for _, p := range ps.peers {
+		list = append(list, p)
+	}
+	return list
+}
+
 // PeersWithoutBlock retrieves a list of peers that do not have a given block in
 // their set of known hashes.
 func (ps *peerSet) PeersWithoutBlock(hash common.Hash) []*peer {
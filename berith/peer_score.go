@@ -0,0 +1,238 @@
+package berith
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/BerithFoundation/berith-chain/consensus"
+	"github.com/BerithFoundation/berith-chain/core"
+	"github.com/BerithFoundation/berith-chain/core/types"
+	"github.com/BerithFoundation/berith-chain/p2p/enode"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+const (
+	scoreCheckInterval = 30 * time.Second // Interval between two evaluations of the connected peers
+	scoreHalfLife      = 10 * time.Minute // Time for the recorded behaviour of a peer to lose half its weight
+	scoreBanThreshold  = -50              // Score under which a peer gets banned
+	scoreBanDuration   = 30 * time.Minute // Time during which a banned peer is refused
+
+	invalidBlockPenalty = 30 // Penalty of each propagated block failing header verification
+	maxTxScore          = 10 // Bound of the reward (or penalty) for the usefulness of transactions
+	minTxSamples        = 64 // Number of recent transactions needed to judge their usefulness
+	maxLatencyPenalty   = 20 // Penalty of a peer responding four times slower than the median
+
+	maxTrackedScores = 4096 // Number of remote nodes whose scores are remembered
+)
+
+// peerScore is the reputation of a remote node. The recorded behaviour decays
+// over time, so a peer recovers from occasional failures.
+type peerScore struct {
+	invalidBlocks float64   // Decayed number of invalid blocks propagated
+	usefulTxs     float64   // Decayed number of transactions accepted by the pool
+	uselessTxs    float64   // Decayed number of known or rejected transactions
+	latency       float64   // Penalty for the last measured response latency
+	updated       time.Time // Time of the last decay
+}
+
+// decay ages the recorded behaviour up to the given time.
+func (s *peerScore) decay(now time.Time) {
+	if !s.updated.IsZero() {
+		factor := math.Exp2(-float64(now.Sub(s.updated)) / float64(scoreHalfLife))
+		s.invalidBlocks *= factor
+		s.usefulTxs *= factor
+		s.uselessTxs *= factor
+	}
+	s.updated = now
+}
+
+// value computes the score of the node. Transactions are judged against the
+// fair share of a peer among the given number: peers relaying at least their
+// share of new transactions get the full reward, peers relaying nothing new get
+// the full penalty.
+func (s *peerScore) value(peers int) float64 {
+	score := -invalidBlockPenalty*s.invalidBlocks - s.latency
+	if total := s.usefulTxs + s.uselessTxs; total >= minTxSamples && peers > 0 {
+		share := math.Min(s.usefulTxs/total*float64(peers), 1)
+		score += maxTxScore * (2*share - 1)
+	}
+	return score
+}
+
+// latencyPenalty converts the round trip time of a peer into a penalty growing
+// linearly from nothing at the median up to the maximum at four times it.
+func latencyPenalty(rtt, median time.Duration) float64 {
+	if median <= 0 {
+		return 0
+	}
+	excess := (float64(rtt)/float64(median) - 1) / 3
+	return maxLatencyPenalty * math.Max(0, math.Min(excess, 1))
+}
+
+// scoreBoard keeps the scores and the bans of remote nodes across their
+// connections.
+type scoreBoard struct {
+	scores *lru.Cache             // Scores of the recently seen nodes
+	bans   map[enode.ID]time.Time // Expiry of the current bans
+	lock   sync.Mutex
+}
+
+func newScoreBoard() *scoreBoard {
+	scores, _ := lru.New(maxTrackedScores)
+	return &scoreBoard{
+		scores: scores,
+		bans:   make(map[enode.ID]time.Time),
+	}
+}
+
+// update applies a change to the decayed score of a node.
+func (b *scoreBoard) update(id enode.ID, fn func(*peerScore)) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	var score *peerScore
+	if cached, ok := b.scores.Get(id); ok {
+		score = cached.(*peerScore)
+	} else {
+		score = new(peerScore)
+		b.scores.Add(id, score)
+	}
+	score.decay(time.Now())
+	fn(score)
+}
+
+// invalidBlock records a block of the node failing header verification.
+func (b *scoreBoard) invalidBlock(id enode.ID) {
+	b.update(id, func(s *peerScore) { s.invalidBlocks++ })
+}
+
+// transactions records a batch of transactions relayed by the node.
+func (b *scoreBoard) transactions(id enode.ID, useful, useless int) {
+	b.update(id, func(s *peerScore) {
+		s.usefulTxs += float64(useful)
+		s.uselessTxs += float64(useless)
+	})
+}
+
+// setLatency records the response latency of the node measured by the downloader.
+func (b *scoreBoard) setLatency(id enode.ID, rtt, median time.Duration) {
+	b.update(id, func(s *peerScore) { s.latency = latencyPenalty(rtt, median) })
+}
+
+// score returns the current score of a node among the given number of peers.
+func (b *scoreBoard) score(id enode.ID, peers int) (score float64) {
+	b.update(id, func(s *peerScore) { score = s.value(peers) })
+	return score
+}
+
+// ban refuses the node for scoreBanDuration. Its score is reset, so it starts
+// over once the ban expires.
+func (b *scoreBoard) ban(id enode.ID) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.bans[id] = time.Now().Add(scoreBanDuration)
+	b.scores.Remove(id)
+}
+
+// banned reports whether the node is currently banned.
+func (b *scoreBoard) banned(id enode.ID) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	expiry, ok := b.bans[id]
+	return ok && time.Now().Before(expiry)
+}
+
+// expireBans forgets the bans which are over.
+func (b *scoreBoard) expireBans() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	now := time.Now()
+	for id, expiry := range b.bans {
+		if !now.Before(expiry) {
+			delete(b.bans, id)
+		}
+	}
+}
+
+// scoreLoop periodically evaluates the connected peers, taking their response
+// latency into account, and bans the ones which dropped below the threshold.
+func (pm *ProtocolManager) scoreLoop() {
+	ticker := time.NewTicker(scoreCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			pm.scores.expireBans()
+			for _, p := range pm.peers.AllPeers() {
+				if rtt, median, ok := pm.downloader.PeerLatency(p.id); ok {
+					pm.scores.setLatency(p.ID(), rtt, median)
+				}
+				pm.checkScore(p)
+			}
+
+		case <-pm.quitSync:
+			return
+		}
+	}
+}
+
+// checkScore refreshes the score of a peer and bans it if it's too low. Trusted
+// peers are never banned.
+func (pm *ProtocolManager) checkScore(p *peer) {
+	score := pm.scores.score(p.ID(), pm.peers.Len())
+	p.SetScore(score)
+
+	if score < scoreBanThreshold && !p.Peer.Info().Network.Trusted {
+		p.Log().Debug("Banning low scoring peer", "score", score, "duration", scoreBanDuration)
+		pm.scores.ban(p.ID())
+		pm.removePeer(p.id)
+	}
+}
+
+// penalizeInvalidBlock lowers the score of the peer which propagated a block
+// failing its import, if the header of the block fails verification. Blocks are
+// relayed as soon as their header verifies, before their import, so honest peers
+// relay blocks failing on their body or state (e.g. in Finalize) as well: such
+// failures, like the errors which aren't the fault of the peer, are ignored.
+func (pm *ProtocolManager) penalizeInvalidBlock(block *types.Block, verify func(*types.Header) error) {
+	err := verify(block.Header())
+	switch err {
+	case nil, consensus.ErrFutureBlock, consensus.ErrUnknownAncestor, consensus.ErrPrunedAncestor, core.ErrKnownBlock:
+		return
+	}
+	p, ok := block.ReceivedFrom.(*peer)
+	if !ok {
+		return
+	}
+	p.Log().Debug("Penalizing peer for invalid block", "number", block.Number(), "hash", block.Hash(), "err", err)
+	pm.scores.invalidBlock(p.ID())
+	pm.checkScore(p)
+}
+
+// dropInvalidBlockPeer lowers the score of a peer whose propagated block failed
+// its header verification before disconnecting it. The lowered score outlives
+// the connection.
+func (pm *ProtocolManager) dropInvalidBlockPeer(id string) {
+	if p := pm.peers.Peer(id); p != nil {
+		pm.scores.invalidBlock(p.ID())
+		pm.checkScore(p)
+	}
+	pm.removePeer(id)
+}
+
+// scoreTransactions records how many transactions of a batch relayed by a peer
+// were new to the pool.
+func (pm *ProtocolManager) scoreTransactions(p *peer, errs []error) {
+	useful := 0
+	for _, err := range errs {
+		if err == nil {
+			useful++
+		}
+	}
+	pm.scores.transactions(p.ID(), useful, len(errs)-useful)
+}
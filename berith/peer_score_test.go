@@ -0,0 +1,121 @@
+package berith
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/BerithFoundation/berith-chain/core/types"
+	"github.com/BerithFoundation/berith-chain/p2p"
+	"github.com/BerithFoundation/berith-chain/p2p/enode"
+)
+
+func TestPeerScoreValue(t *testing.T) {
+	tests := []struct {
+		score peerScore
+		peers int
+		want  float64
+	}{
+		{peerScore{}, 10, 0},
+		{peerScore{invalidBlocks: 1}, 10, -invalidBlockPenalty},
+		{peerScore{latency: 5}, 10, -5},
+		// Too few transactions to judge
+		{peerScore{uselessTxs: minTxSamples - 1}, 10, 0},
+		// Only duplicates
+		{peerScore{uselessTxs: minTxSamples}, 10, -maxTxScore},
+		// Fair share of new transactions among 10 peers, and more
+		{peerScore{usefulTxs: 10, uselessTxs: 90}, 10, maxTxScore},
+		{peerScore{usefulTxs: 50, uselessTxs: 50}, 10, maxTxScore},
+		// Half the fair share
+		{peerScore{usefulTxs: 5, uselessTxs: 95}, 10, 0},
+	}
+	for i, tt := range tests {
+		if have := tt.score.value(tt.peers); math.Abs(have-tt.want) > 1e-9 {
+			t.Errorf("test %d: score mismatch: have %v, want %v", i, have, tt.want)
+		}
+	}
+}
+
+func TestPeerScoreDecay(t *testing.T) {
+	now := time.Now()
+	s := peerScore{invalidBlocks: 2, usefulTxs: 100, updated: now}
+	s.decay(now.Add(scoreHalfLife))
+
+	if math.Abs(s.invalidBlocks-1) > 1e-9 || math.Abs(s.usefulTxs-50) > 1e-9 {
+		t.Errorf("decay mismatch: have %v invalid blocks and %v useful txs, want 1 and 50", s.invalidBlocks, s.usefulTxs)
+	}
+}
+
+func TestLatencyPenalty(t *testing.T) {
+	median := 2 * time.Second
+	tests := []struct {
+		rtt  time.Duration
+		want float64
+	}{
+		{time.Second, 0},
+		{median, 0},
+		{4 * time.Second, maxLatencyPenalty / 3.0},
+		{8 * time.Second, maxLatencyPenalty},
+		{time.Minute, maxLatencyPenalty},
+	}
+	for i, tt := range tests {
+		if have := latencyPenalty(tt.rtt, median); math.Abs(have-tt.want) > 1e-9 {
+			t.Errorf("test %d: penalty mismatch: have %v, want %v", i, have, tt.want)
+		}
+	}
+}
+
+func TestScoreBoardBan(t *testing.T) {
+	var (
+		board = newScoreBoard()
+		id    = enode.ID{1}
+	)
+	board.invalidBlock(id)
+	board.invalidBlock(id)
+	if score := board.score(id, 1); score >= scoreBanThreshold {
+		t.Fatalf("score %v not below the ban threshold after two invalid blocks", score)
+	}
+	board.ban(id)
+	if !board.banned(id) {
+		t.Fatalf("node not banned")
+	}
+	if score := board.score(id, 1); score != 0 {
+		t.Errorf("score not reset by the ban: %v", score)
+	}
+	// Expire the ban
+	board.bans[id] = time.Now().Add(-time.Second)
+	board.expireBans()
+	if board.banned(id) || len(board.bans) != 0 {
+		t.Errorf("expired ban still in place")
+	}
+}
+
+// Tests that the peers relaying blocks failing on their body or state are not
+// penalized, unlike the ones relaying blocks with invalid headers.
+func TestPenalizeInvalidBlock(t *testing.T) {
+	pm, _ := newTxTestManager(newTestTxPool(nil, nil), nil, 0)
+	pm.scores = newScoreBoard()
+
+	p := newPeer(ber66, p2p.NewPeer(enode.ID{1}, "test", nil), nil)
+	block := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(1)})
+	block.ReceivedFrom = p
+
+	// The header verifies, so the block failed in Finalize: the relayer is kept
+	valid := func(*types.Header) error { return nil }
+	for i := 0; i < 3; i++ {
+		pm.penalizeInvalidBlock(block, valid)
+	}
+	if score := pm.scores.score(p.ID(), 1); score != 0 || pm.scores.banned(p.ID()) {
+		t.Fatalf("relayer of block failing in Finalize penalized: score %v, banned %v", score, pm.scores.banned(p.ID()))
+	}
+	// Invalid headers are the fault of the peer
+	invalid := func(*types.Header) error { return errors.New("invalid header") }
+	for i := 0; i < 2; i++ {
+		pm.penalizeInvalidBlock(block, invalid)
+	}
+	if !pm.scores.banned(p.ID()) {
+		t.Fatalf("peer relaying invalid headers not banned")
+	}
+}
@@ -23,8 +23,10 @@ import (
 
 	"github.com/BerithFoundation/berith-chain/common"
 	"github.com/BerithFoundation/berith-chain/core"
+	"github.com/BerithFoundation/berith-chain/core/forkid"
 	"github.com/BerithFoundation/berith-chain/core/types"
 	"github.com/BerithFoundation/berith-chain/event"
+	"github.com/BerithFoundation/berith-chain/p2p"
 	"github.com/BerithFoundation/berith-chain/rlp"
 )
 
@@ -32,16 +34,19 @@ import (
 const (
 	ber62 = 62
 	ber63 = 63
+	ber64 = 64
+	ber65 = 65
+	ber66 = 66
 )
 
 // ProtocolName is the official short name of the protocol used during capability negotiation.
 var ProtocolName = "berith"
 
 // ProtocolVersions are the supported versions of the berith protocol (first is primary).
-var ProtocolVersions = []uint{ber63, ber62}
+var ProtocolVersions = []uint{ber66, ber65, ber64, ber63, ber62}
 
 // ProtocolLengths are the number of implemented message corresponding to different protocol versions.
-var ProtocolLengths = []uint64{17, 8}
+var ProtocolLengths = []uint64{18, 17, 17, 17, 8}
 
 const ProtocolMaxMsgSize = 10 * 1024 * 1024 // Maximum cap on the size of a protocol message
 
@@ -62,6 +67,14 @@ const (
 	NodeDataMsg    = 0x0e
 	GetReceiptsMsg = 0x0f
 	ReceiptsMsg    = 0x10
+
+	// Protocol messages belonging to berith/65
+	NewPooledTransactionHashesMsg = 0x08
+	GetPooledTransactionsMsg      = 0x09
+	PooledTransactionsMsg         = 0x0a
+
+	// [BERITH] Protocol messages belonging to berith/66
+	PrivateTxMsg = 0x11
 )
 
 type errCode int
@@ -76,6 +89,7 @@ const (
 	ErrNoStatusMsg
 	ErrExtraStatusMsg
 	ErrSuspendedPeer
+	ErrForkIDRejected
 )
 
 func (e errCode) String() string {
@@ -93,12 +107,21 @@ var errorToString = map[int]string{
 	ErrNoStatusMsg:             "No status message",
 	ErrExtraStatusMsg:          "Extra status message",
 	ErrSuspendedPeer:           "Suspended peer",
+	ErrForkIDRejected:          "Fork ID rejected",
 }
 
 type txPool interface {
 	// AddRemotes should add the given transactions to the pool.
 	AddRemotes([]*types.Transaction) []error
 
+	// AddPrivateRemotes should add the given transactions to the pool, keeping
+	// them away from the public broadcast.
+	AddPrivateRemotes([]*types.Transaction) []error
+
+	// Get should retrieve the transaction with the given hash from the pool,
+	// or nil if it's unknown.
+	Get(hash common.Hash) *types.Transaction
+
 	// Pending should return pending transactions.
 	// The slice should be modifiable by the caller.
 	Pending() (map[common.Address]types.Transactions, error)
@@ -106,6 +129,10 @@ type txPool interface {
 	// SubscribeNewTxsEvent should return an event subscription of
 	// NewTxsEvent and send events to the given channel.
 	SubscribeNewTxsEvent(chan<- core.NewTxsEvent) event.Subscription
+
+	// IsPrivate should report whether the transaction is withheld from the
+	// public broadcast.
+	IsPrivate(hash common.Hash) bool
 }
 
 // statusData is the network packet for the status message.
@@ -117,6 +144,75 @@ type statusData struct {
 	GenesisBlock    common.Hash
 }
 
+// [BERITH] statusData64 is the network packet for the status message for berith/64
+// and later, announcing the fork identifier of the chain.
+type statusData64 struct {
+	ProtocolVersion uint32
+	NetworkID       uint64
+	TD              *big.Int
+	Head            common.Hash
+	Genesis         common.Hash
+	ForkID          forkid.ID
+}
+
+// packet66 is the envelope of the berith/66 retrieval requests and responses,
+// tagging them with the identifier of the request. The messages keep the codes
+// of the earlier versions.
+type packet66 struct {
+	RequestID uint64
+	Data      interface{}
+}
+
+// rawPacket66 is the decoding counterpart of packet66, leaving the wrapped data
+// for the specific message type.
+type rawPacket66 struct {
+	RequestID uint64
+	Data      rlp.RawValue
+}
+
+// sendPacket sends a retrieval request or response, wrapping it with the request
+// identifier on berith/66 and later. Older versions ignore the identifier.
+func sendPacket(w p2p.MsgWriter, version int, code uint64, reqID uint64, data interface{}) error {
+	if version >= ber66 {
+		return p2p.Send(w, code, &packet66{RequestID: reqID, Data: data})
+	}
+	return p2p.Send(w, code, data)
+}
+
+// decodePacket decodes a retrieval request or response into data, returning the
+// request identifier it was wrapped with on berith/66 and later. The identifier
+// is always 0 on older versions.
+func decodePacket(msg p2p.Msg, version int, data interface{}) (uint64, error) {
+	if version < ber66 {
+		return 0, msg.Decode(data)
+	}
+	var packet rawPacket66
+	if err := msg.Decode(&packet); err != nil {
+		return 0, err
+	}
+	return packet.RequestID, rlp.DecodeBytes(packet.Data, data)
+}
+
+// openHashList opens the stream of hashes of a retrieval request, returning the
+// request identifier preceding the list on berith/66 and later.
+func openHashList(msg p2p.Msg, version int) (*rlp.Stream, uint64, error) {
+	stream := rlp.NewStream(msg.Payload, uint64(msg.Size))
+	if _, err := stream.List(); err != nil {
+		return nil, 0, err
+	}
+	if version < ber66 {
+		return stream, 0, nil
+	}
+	var reqID uint64
+	if err := stream.Decode(&reqID); err != nil {
+		return nil, 0, err
+	}
+	if _, err := stream.List(); err != nil {
+		return nil, 0, err
+	}
+	return stream, reqID, nil
+}
+
 // newBlockHashesData is the network packet for the block announcements.
 type newBlockHashesData []struct {
 	Hash   common.Hash // Hash of one particular block being announced
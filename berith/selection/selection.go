@@ -13,10 +13,15 @@ import (
 
 	"github.com/BerithFoundation/berith-chain/berith/staking"
 	"github.com/BerithFoundation/berith-chain/core/state"
+	"github.com/BerithFoundation/berith-chain/core/types"
 
 	"github.com/BerithFoundation/berith-chain/common"
+	"github.com/BerithFoundation/berith-chain/crypto"
 )
 
+// emptyCodeHash is the code hash of the accounts without code.
+var emptyCodeHash = crypto.Keccak256Hash(nil)
+
 /*
 [BERITH]
 Entry function to elect Block Creator
@@ -41,6 +46,9 @@ func SelectBlockCreator(config *params.ChainConfig, number uint64, hash common.H
 		In accordance with the addition of the Stake Balance limit, targets with a Stake Balance limit or higher are recalculated.
 	*/
 	for _, stk := range list {
+		if !canSign(state, stk) {
+			continue
+		}
 		stakeBalance := state.GetStakeBalance(stk)
 		var point uint64
 
@@ -70,6 +78,19 @@ func SelectBlockCreator(config *params.ChainConfig, number uint64, hash common.H
 	return result
 }
 
+/*
+[Berith]
+Reports whether a staker can sign blocks, and so be elected. Contracts may
+stake through the staking contract and multisig accounts by their owners, but
+neither has a key to sign with.
+*/
+func canSign(state *state.StateDB, stk common.Address) bool {
+	if hash := state.GetCodeHash(stk); hash != (common.Hash{}) && hash != emptyCodeHash {
+		return false
+	}
+	return state.GetState(stk, types.MultisigThresholdSlot) == (common.Hash{})
+}
+
 /*
 	[Berith]
 	A function that newly calculates the elected point advantage for holders who have exceeded the Stake Balance limit
@@ -108,6 +129,9 @@ func GetCandidates(number uint64, hash common.Hash, stks staking.Stakers, state
 
 	cddts := NewCandidates()
 	for _, stk := range list {
+		if !canSign(state, stk) {
+			continue
+		}
 		point := state.GetPoint(stk).Uint64()
 		cddts.Add(Candidate{
 			point:   point,
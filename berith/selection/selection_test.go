@@ -14,6 +14,7 @@ import (
 	"github.com/BerithFoundation/berith-chain/berithdb"
 	"github.com/BerithFoundation/berith-chain/common"
 	"github.com/BerithFoundation/berith-chain/core/state"
+	"github.com/BerithFoundation/berith-chain/core/types"
 )
 
 /*
@@ -88,6 +89,42 @@ func TestSelectBlockCreator(t *testing.T) {
 	}
 }
 
+/*
+[BERITH]
+Test that the stakers unable to sign blocks are not elected
+*/
+func TestSelectSigners(t *testing.T) {
+	st, _ := state.New(common.Hash{}, state.NewDatabase(berithdb.NewMemDatabase()))
+	stks := staking.NewStakers()
+
+	contract, multisig := common.BigToAddress(big.NewInt(3)), common.BigToAddress(big.NewInt(4))
+	for i := 1; i <= 4; i++ {
+		addr := common.BigToAddress(big.NewInt(int64(i)))
+		st.AddStakeBalance(addr, new(big.Int).Mul(big.NewInt(100000), common.UnitForBer), big.NewInt(1))
+		st.SetPoint(addr, big.NewInt(100000))
+		stks.Put(addr)
+	}
+	st.SetCode(contract, []byte{0x00})
+	st.SetState(multisig, types.MultisigThresholdSlot, common.BigToHash(big.NewInt(2)))
+
+	config := &params.ChainConfig{
+		BIP2Block: big.NewInt(0),
+	}
+	results := SelectBlockCreator(config, 100, common.Hash{}, stks, st)
+	if len(results) != 2 {
+		t.Errorf("%d stakers elected [expected : 2]", len(results))
+	}
+	if _, ok := results[contract]; ok {
+		t.Errorf("contract %s elected", contract.Hex())
+	}
+	if _, ok := results[multisig]; ok {
+		t.Errorf("multisig account %s elected", multisig.Hex())
+	}
+	if err := st.Error(); err != nil {
+		t.Errorf("state error: %v", err)
+	}
+}
+
 func TestSeed(t *testing.T) {
 
 	configs := []*params.ChainConfig{
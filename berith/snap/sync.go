@@ -0,0 +1,1359 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package snap
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/BerithFoundation/berith-chain/berithdb"
+	"github.com/BerithFoundation/berith-chain/common"
+	"github.com/BerithFoundation/berith-chain/core/state"
+	"github.com/BerithFoundation/berith-chain/crypto"
+	"github.com/BerithFoundation/berith-chain/log"
+	"github.com/BerithFoundation/berith-chain/rlp"
+	"github.com/BerithFoundation/berith-chain/trie"
+)
+
+var (
+	// emptyRoot is the known root hash of an empty trie.
+	emptyRoot = common.HexToHash("56e81f171bcc55a6ff8345e692c0f86e5b48e01b996cadc001622fb5e363b421")
+
+	// emptyCode is the known hash of the empty EVM bytecode.
+	emptyCode = crypto.Keccak256Hash(nil)
+)
+
+const (
+	// maxRequestSize is the maximum number of bytes to request from a remote peer.
+	maxRequestSize = 512 * 1024
+
+	// maxStorageSetRequestCount is the maximum number of contracts to request the
+	// storage of in a single query. If this number is too low, we're not filling
+	// responses fully and waste round trip times. If it's too high, we're capping
+	// responses and waste bandwidth.
+	maxStorageSetRequestCount = maxRequestSize / 1024
+
+	// maxCodeRequestCount is the maximum number of bytecode blobs to request in a
+	// single query. If this number is too low, we're not filling responses fully
+	// and waste round trip times. If it's too high, we're capping responses and
+	// waste bandwidth.
+	maxCodeRequestCount = maxRequestSize / (24 * 1024) * 16
+
+	// maxTrieRequestCount is the maximum number of trie node blobs to request in
+	// a single query. If this number is too low, we're not filling responses fully
+	// and waste round trip times. If it's too high, we're capping responses and
+	// waste bandwidth.
+	maxTrieRequestCount = 384
+
+	// accountConcurrency is the number of chunks to split the account trie into
+	// to allow concurrent retrievals.
+	accountConcurrency = 16
+
+	// requestTimeout is the maximum time a peer is allowed to spend on serving a
+	// single network request.
+	requestTimeout = 10 * time.Second
+
+	// trieCommitThreshold is the size of the dirty trie nodes accumulated in
+	// memory before they are flushed into the database.
+	trieCommitThreshold = 64 * 1024 * 1024
+)
+
+// ErrCancelled is returned from snap syncing if the operation was prematurely
+// terminated.
+var ErrCancelled = errors.New("sync cancelled")
+
+// ErrNoStakers is returned from a staker set retrieval if none of the peers
+// served a staker set passing the verification.
+var ErrNoStakers = errors.New("no valid staker set served")
+
+// SyncPeer abstracts out the methods required for a peer to be synced against
+// with the goal of allowing the construction of mock peers without the full
+// blown networking.
+type SyncPeer interface {
+	// ID retrieves the peer's unique identifier.
+	ID() string
+
+	// RequestAccountRange fetches a batch of accounts rooted in a specific account
+	// trie, starting with the origin.
+	RequestAccountRange(id uint64, root, origin, limit common.Hash, bytes uint64) error
+
+	// RequestStorageRanges fetches a batch of storage slots belonging to one or
+	// more accounts. If slots from only one accout is requested, an origin marker
+	// may also be used to retrieve from there.
+	RequestStorageRanges(id uint64, root common.Hash, accounts []common.Hash, origin, limit []byte, bytes uint64) error
+
+	// RequestByteCodes fetches a batch of bytecodes by hash.
+	RequestByteCodes(id uint64, hashes []common.Hash, bytes uint64) error
+
+	// RequestTrieNodes fetches a batch of account or storage trie nodes by hash.
+	RequestTrieNodes(id uint64, root common.Hash, hashes []common.Hash, bytes uint64) error
+
+	// RequestStakers fetches the staker set after a block.
+	RequestStakers(id uint64, block common.Hash) error
+
+	// Log retrieves the peer's own contextual logger.
+	Log() log.Logger
+}
+
+// accountRequest tracks a pending account range request to ensure responses are
+// to actual requests and to validate any security constraints.
+type accountRequest struct {
+	peer string      // Peer to which this request is assigned
+	id   uint64      // Request ID of this request
+	root common.Hash // State root the range is requested from
+
+	origin common.Hash // First account requested to allow continuation checks
+	limit  common.Hash // Last account requested to allow non-overlapping chunking
+
+	task    *accountTask  // Task which this request is filling
+	timeout *time.Timer   // Timer to track delivery timeout
+	stale   chan struct{} // Channel to signal the request was dropped
+}
+
+// accountResponse is an already Merkle-verified remote response to an account
+// range request.
+type accountResponse struct {
+	req *accountRequest // Original request to match up with
+
+	hashes   []common.Hash    // Account hashes in the returned range
+	blobs    [][]byte         // Account bodies in the state trie encoding
+	accounts []*state.Account // Expanded accounts in the returned range
+
+	cont bool // Whether the account range has a continuation
+}
+
+// storageRequest tracks a pending storage ranges request to ensure responses are
+// to actual requests and to validate any security constraints.
+type storageRequest struct {
+	peer string      // Peer to which this request is assigned
+	id   uint64      // Request ID of this request
+	root common.Hash // State root the ranges are requested from
+
+	tasks  []*storageTask // Tasks of the accounts whose storage is requested
+	origin common.Hash    // First storage slot requested to allow continuation checks
+
+	timeout *time.Timer   // Timer to track delivery timeout
+	stale   chan struct{} // Channel to signal the request was dropped
+}
+
+// storageResponse is an already Merkle-verified remote response to a storage
+// ranges request.
+type storageResponse struct {
+	req *storageRequest // Original request to match up with
+
+	hashes [][]common.Hash // Storage slot hashes in the returned ranges
+	slots  [][][]byte      // Storage slot values in the returned ranges
+
+	cont bool // Whether the last storage range has a continuation
+}
+
+// bytecodeRequest tracks a pending bytecode request to ensure responses are to
+// actual requests and to validate any security constraints.
+type bytecodeRequest struct {
+	peer string // Peer to which this request is assigned
+	id   uint64 // Request ID of this request
+
+	hashes []common.Hash // Bytecode hashes to validate responses
+
+	timeout *time.Timer   // Timer to track delivery timeout
+	stale   chan struct{} // Channel to signal the request was dropped
+}
+
+// bytecodeResponse is an already verified remote response to a bytecode request.
+type bytecodeResponse struct {
+	req *bytecodeRequest // Original request to match up with
+
+	hashes []common.Hash // Hashes of the delivered bytecodes
+	codes  [][]byte      // Actual bytecodes to store into the database
+}
+
+// trienodeRequest tracks a pending trie node request to ensure responses are to
+// actual requests and to validate any security constraints.
+type trienodeRequest struct {
+	peer string      // Peer to which this request is assigned
+	id   uint64      // Request ID of this request
+	root common.Hash // State root the nodes are requested from
+
+	hashes []common.Hash // Trie node hashes to validate responses
+
+	timeout *time.Timer   // Timer to track delivery timeout
+	stale   chan struct{} // Channel to signal the request was dropped
+}
+
+// trienodeResponse is an already verified remote response to a trie node request.
+type trienodeResponse struct {
+	req *trienodeRequest // Original request to match up with
+
+	hashes []common.Hash // Hashes of the delivered trie nodes
+	nodes  [][]byte      // Actual trie nodes to store into the database
+}
+
+// stakersRequest tracks a pending staker set request.
+type stakersRequest struct {
+	peer  string      // Peer to which this request is assigned
+	id    uint64      // Request ID of this request
+	block common.Hash // Block to retrieve the staker set after
+
+	deliver chan []common.Address // Channel to deliver the staker set on
+}
+
+// accountTask represents the sync task for a chunk of the account snapshot.
+type accountTask struct {
+	Next common.Hash // Next account to sync in this interval
+	Last common.Hash // Last account to sync in this interval
+
+	req  *accountRequest // Pending request to fill this task
+	done bool            // Flag whether the task has been completed
+}
+
+// storageTask represents the sync task of the storage trie of an account.
+type storageTask struct {
+	account common.Hash // Hash of the account owning the storage
+	root    common.Hash // Storage root the slots are verified against
+
+	next  common.Hash // Next storage slot to sync (large contract mode)
+	large bool        // Whether the storage is too large to be served at once
+	trie  *trie.Trie  // Storage trie being assembled from the ranges
+
+	req *storageRequest // Pending request to fill this task
+}
+
+// Syncer is a Berith account and storage trie syncer based on the snap protocol.
+// Its purpose is to download all the accounts, storage slots and bytecodes of
+// a state as Merkle proven ranges, heal the parts retrieved from older roots
+// trie node by trie node, and write everything into the database.
+//
+// The progress is kept across root changes, ranges already retrieved against
+// an older root are fixed up by the healing phase.
+type Syncer struct {
+	db     berithdb.Database // Database to store the trie nodes into (and dedup)
+	triedb *trie.Database    // Trie database to assemble the tries in
+
+	root    common.Hash    // Current state trie root being synced
+	tasks   []*accountTask // Current account task set being synced
+	accTrie *trie.Trie     // Account trie assembled from the ranges
+	batch   berithdb.Batch // Batch of the bytecodes and healed trie nodes
+	update  chan struct{}  // Notification channel for possible sync progression
+	quit    chan struct{}  // Channel closed when the running sync terminates
+
+	peers     map[string]SyncPeer // Currently active peers to download from
+	idlers    map[string]struct{} // Peers that aren't serving requests
+	stateless map[string]bool     // Peers that failed to deliver state data of the root
+
+	reqID        uint64                      // Last request ID issued
+	accountReqs  map[uint64]*accountRequest  // Account requests currently running
+	storageReqs  map[uint64]*storageRequest  // Storage requests currently running
+	bytecodeReqs map[uint64]*bytecodeRequest // Bytecode requests currently running
+	trienodeReqs map[uint64]*trienodeRequest // Trie node requests currently running
+	stakersReqs  map[uint64]*stakersRequest  // Staker set requests currently running
+
+	accountResps  chan *accountResponse  // Verified account ranges waiting to be processed
+	storageResps  chan *storageResponse  // Verified storage ranges waiting to be processed
+	bytecodeResps chan *bytecodeResponse // Verified bytecodes waiting to be processed
+	trienodeResps chan *trienodeResponse // Verified trie nodes waiting to be processed
+
+	storageTasks map[common.Hash]*storageTask // Storage tries still to be retrieved
+	codeTasks    map[common.Hash]struct{}     // Bytecodes still to be retrieved
+
+	healer    *trie.Sync               // State trie sync scheduler healing the ranges
+	healTasks map[common.Hash]struct{} // Trie nodes still to be retrieved
+
+	accountSynced  uint64             // Number of accounts downloaded
+	accountBytes   common.StorageSize // Number of account trie bytes persisted to disk
+	storageSynced  uint64             // Number of storage slots downloaded
+	bytecodeSynced uint64             // Number of bytecodes downloaded
+	trienodeHealed uint64             // Number of state trie nodes downloaded
+	startTime      time.Time          // Time instance when snapshot sync started
+	logTime        time.Time          // Time instance when status was last reported
+
+	lock sync.RWMutex // Protects fields that can change outside of sync (peers, reqs, root)
+}
+
+// NewSyncer creates a new snapshot syncer to download the Berith state over the
+// snap protocol.
+func NewSyncer(db berithdb.Database) *Syncer {
+	return &Syncer{
+		db:     db,
+		triedb: trie.NewDatabase(db),
+		batch:  db.NewBatch(),
+		update: make(chan struct{}, 1),
+
+		peers:     make(map[string]SyncPeer),
+		idlers:    make(map[string]struct{}),
+		stateless: make(map[string]bool),
+
+		accountReqs:  make(map[uint64]*accountRequest),
+		storageReqs:  make(map[uint64]*storageRequest),
+		bytecodeReqs: make(map[uint64]*bytecodeRequest),
+		trienodeReqs: make(map[uint64]*trienodeRequest),
+		stakersReqs:  make(map[uint64]*stakersRequest),
+
+		accountResps:  make(chan *accountResponse),
+		storageResps:  make(chan *storageResponse),
+		bytecodeResps: make(chan *bytecodeResponse),
+		trienodeResps: make(chan *trienodeResponse),
+
+		storageTasks: make(map[common.Hash]*storageTask),
+		codeTasks:    make(map[common.Hash]struct{}),
+	}
+}
+
+// Register injects a new data source into the syncer's peerset.
+func (s *Syncer) Register(peer SyncPeer) error {
+	// Make sure the peer is not registered yet
+	id := peer.ID()
+
+	s.lock.Lock()
+	if _, ok := s.peers[id]; ok {
+		log.Error("Snap peer already registered", "id", id)
+
+		s.lock.Unlock()
+		return errors.New("already registered")
+	}
+	s.peers[id] = peer
+	s.idlers[id] = struct{}{}
+	s.lock.Unlock()
+
+	// Notify any active syncs that a new peer can be assigned data
+	s.nudge()
+	return nil
+}
+
+// Unregister removes a data source from the syncer's peerset, rescheduling the
+// requests it was serving.
+func (s *Syncer) Unregister(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.peers[id]; !ok {
+		log.Error("Snap peer not registered", "id", id)
+		return errors.New("not registered")
+	}
+	delete(s.peers, id)
+	delete(s.idlers, id)
+	delete(s.stateless, id)
+
+	for _, req := range s.accountReqs {
+		if req.peer == id {
+			s.revertAccountRequest(req)
+		}
+	}
+	for _, req := range s.storageReqs {
+		if req.peer == id {
+			s.revertStorageRequest(req)
+		}
+	}
+	for _, req := range s.bytecodeReqs {
+		if req.peer == id {
+			s.revertBytecodeRequest(req)
+		}
+	}
+	for _, req := range s.trienodeReqs {
+		if req.peer == id {
+			s.revertTrienodeRequest(req)
+		}
+	}
+	s.nudge()
+	return nil
+}
+
+// nudge notifies the running sync of a possible progression.
+func (s *Syncer) nudge() {
+	select {
+	case s.update <- struct{}{}:
+	default:
+	}
+}
+
+// Sync starts (or resumes a previous) sync cycle to iterate over a state trie
+// with the given root and reconstruct the nodes based on the snapshot leaves.
+// Previously downloaded segments will not be redownloaded of fixed, rather any
+// errors will be healed after the leaves are fully accumulated.
+func (s *Syncer) Sync(root common.Hash, cancel chan struct{}) error {
+	s.lock.Lock()
+	if err := s.setRoot(root); err != nil {
+		s.lock.Unlock()
+		return err
+	}
+	s.quit = make(chan struct{})
+	s.lock.Unlock()
+
+	defer func() {
+		// Drop all the pending requests, their tasks will be retried by the next
+		// cycle and the late responses will be ignored
+		s.lock.Lock()
+		for _, req := range s.accountReqs {
+			s.revertAccountRequest(req)
+		}
+		for _, req := range s.storageReqs {
+			s.revertStorageRequest(req)
+		}
+		for _, req := range s.bytecodeReqs {
+			s.revertBytecodeRequest(req)
+		}
+		for _, req := range s.trienodeReqs {
+			s.revertTrienodeRequest(req)
+		}
+		close(s.quit)
+		s.lock.Unlock()
+	}()
+	log.Debug("Starting snapshot sync cycle", "root", root)
+
+	for {
+		// Schedule the next requests if the sync is not done yet
+		s.lock.Lock()
+		done, err := s.schedule()
+		s.lock.Unlock()
+
+		if err != nil {
+			return err
+		}
+		if done {
+			if err := s.commit(true); err != nil {
+				return err
+			}
+			log.Info("Snapshot sync completed", "root", root, "accounts", s.accountSynced, "slots", s.storageSynced,
+				"codes", s.bytecodeSynced, "healed", s.trienodeHealed, "elapsed", common.PrettyDuration(time.Since(s.startTime)))
+			return nil
+		}
+		s.report()
+
+		// Wait for something to happen
+		select {
+		case <-s.update:
+			// Something happened (new peer, delivery, timeout), recheck tasks
+		case <-cancel:
+			return ErrCancelled
+
+		case res := <-s.accountResps:
+			err = s.processAccountResponse(res)
+		case res := <-s.storageResps:
+			err = s.processStorageResponse(res)
+		case res := <-s.bytecodeResps:
+			err = s.processBytecodeResponse(res)
+		case res := <-s.trienodeResps:
+			err = s.processTrienodeResponse(res)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// setRoot switches the sync over to a new state root. The retrieved accounts,
+// storage and bytecodes are kept, but the storage tries in progress may belong
+// to accounts that changed since, so their accounts are retrieved anew.
+func (s *Syncer) setRoot(root common.Hash) error {
+	if s.tasks == nil {
+		accTrie, err := trie.New(common.Hash{}, s.triedb)
+		if err != nil {
+			return err
+		}
+		s.accTrie = accTrie
+		s.tasks = newAccountTasks()
+		s.startTime = time.Now()
+	}
+	if root == s.root {
+		return nil
+	}
+	if s.root != (common.Hash{}) {
+		log.Info("Snapshot sync root changed", "old", s.root, "new", root)
+	}
+	s.root = root
+	s.stateless = make(map[string]bool)
+	s.healer, s.healTasks = nil, nil
+
+	for hash, task := range s.storageTasks {
+		s.tasks = append(s.tasks, &accountTask{Next: hash, Last: hash})
+		delete(s.storageTasks, task.account)
+	}
+	return nil
+}
+
+// newAccountTasks splits the account hash space into equal chunks to retrieve
+// concurrently.
+func newAccountTasks() []*accountTask {
+	var (
+		tasks []*accountTask
+		next  common.Hash
+		step  = new(big.Int).Sub(
+			new(big.Int).Div(
+				new(big.Int).Exp(common.Big2, common.Big256, nil),
+				big.NewInt(accountConcurrency),
+			), common.Big1,
+		)
+	)
+	for i := 0; i < accountConcurrency; i++ {
+		last := common.BigToHash(new(big.Int).Add(next.Big(), step))
+		if i == accountConcurrency-1 {
+			// Make sure we don't overflow if the step is not a proper divisor
+			last = common.HexToHash("0xffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff")
+		}
+		tasks = append(tasks, &accountTask{Next: next, Last: last})
+		next = common.BigToHash(new(big.Int).Add(last.Big(), common.Big1))
+	}
+	return tasks
+}
+
+// schedule assigns the pending tasks to the idle peers, returning whether the
+// sync is complete. The healing phase starts once all the accounts, storage
+// slots and bytecodes have been retrieved.
+func (s *Syncer) schedule() (bool, error) {
+	snapped := len(s.storageTasks) == 0 && len(s.codeTasks) == 0 &&
+		len(s.accountReqs) == 0 && len(s.storageReqs) == 0 && len(s.bytecodeReqs) == 0
+	for _, task := range s.tasks {
+		if !task.done {
+			snapped = false
+			break
+		}
+	}
+	if snapped {
+		if s.healer == nil {
+			// Everything must be on disk for the healer to skip it
+			if err := s.commit(true); err != nil {
+				return false, err
+			}
+			log.Info("Snapshot ranges retrieved, healing state", "root", s.root, "accounts", s.accountSynced, "slots", s.storageSynced, "codes", s.bytecodeSynced)
+			s.healer = state.NewStateSync(s.root, s.db)
+			s.healTasks = make(map[common.Hash]struct{})
+		}
+		for _, hash := range s.healer.Missing(0) {
+			s.healTasks[hash] = struct{}{}
+		}
+		if s.healer.Pending() == 0 && len(s.trienodeReqs) == 0 {
+			return true, nil
+		}
+		s.assignTrienodeTasks()
+		return false, nil
+	}
+	s.assignAccountTasks()
+	s.assignBytecodeTasks()
+	s.assignStorageTasks()
+	return false, nil
+}
+
+// idlePeer returns an idle peer having the state of the current root, or nil
+// if there is none.
+func (s *Syncer) idlePeer() SyncPeer {
+	for id := range s.idlers {
+		if !s.stateless[id] {
+			return s.peers[id]
+		}
+	}
+	return nil
+}
+
+// assignAccountTasks attempts to match idle peers to pending account range
+// retrievals.
+func (s *Syncer) assignAccountTasks() {
+	for _, task := range s.tasks {
+		if task.done || task.req != nil {
+			continue
+		}
+		peer := s.idlePeer()
+		if peer == nil {
+			return
+		}
+		s.reqID++
+		req := &accountRequest{
+			peer:   peer.ID(),
+			id:     s.reqID,
+			root:   s.root,
+			origin: task.Next,
+			limit:  task.Last,
+			task:   task,
+			stale:  s.quit,
+		}
+		req.timeout = time.AfterFunc(requestTimeout, func() {
+			peer.Log().Debug("Account range request timed out", "reqid", req.id)
+			s.lock.Lock()
+			if s.accountReqs[req.id] == req {
+				s.revertAccountRequest(req)
+			}
+			s.lock.Unlock()
+			s.nudge()
+		})
+		s.accountReqs[req.id] = req
+		delete(s.idlers, req.peer)
+		task.req = req
+
+		if err := peer.RequestAccountRange(req.id, req.root, req.origin, req.limit, maxRequestSize); err != nil {
+			peer.Log().Debug("Failed to request account range", "err", err)
+			s.revertAccountRequest(req)
+		}
+	}
+}
+
+// assignStorageTasks attempts to match idle peers to pending storage range
+// retrievals.
+func (s *Syncer) assignStorageTasks() {
+	var small []*storageTask
+	for _, task := range s.storageTasks {
+		if task.req != nil {
+			continue
+		}
+		// Large contracts are continued one at a time
+		if task.large {
+			peer := s.idlePeer()
+			if peer == nil {
+				return
+			}
+			s.requestStorage(peer, []*storageTask{task}, task.next)
+			continue
+		}
+		if small = append(small, task); len(small) == maxStorageSetRequestCount {
+			peer := s.idlePeer()
+			if peer == nil {
+				return
+			}
+			s.requestStorage(peer, small, common.Hash{})
+			small = nil
+		}
+	}
+	if len(small) > 0 {
+		if peer := s.idlePeer(); peer != nil {
+			s.requestStorage(peer, small, common.Hash{})
+		}
+	}
+}
+
+// requestStorage sends a storage ranges request for the given tasks.
+func (s *Syncer) requestStorage(peer SyncPeer, tasks []*storageTask, origin common.Hash) {
+	s.reqID++
+	req := &storageRequest{
+		peer:   peer.ID(),
+		id:     s.reqID,
+		root:   s.root,
+		tasks:  tasks,
+		origin: origin,
+		stale:  s.quit,
+	}
+	req.timeout = time.AfterFunc(requestTimeout, func() {
+		peer.Log().Debug("Storage request timed out", "reqid", req.id)
+		s.lock.Lock()
+		if s.storageReqs[req.id] == req {
+			s.revertStorageRequest(req)
+		}
+		s.lock.Unlock()
+		s.nudge()
+	})
+	s.storageReqs[req.id] = req
+	delete(s.idlers, req.peer)
+
+	accounts := make([]common.Hash, len(tasks))
+	for i, task := range tasks {
+		task.req = req
+		accounts[i] = task.account
+	}
+	var start []byte
+	if origin != (common.Hash{}) {
+		start = origin[:]
+	}
+	if err := peer.RequestStorageRanges(req.id, req.root, accounts, start, nil, maxRequestSize); err != nil {
+		peer.Log().Debug("Failed to request storage", "err", err)
+		s.revertStorageRequest(req)
+	}
+}
+
+// assignBytecodeTasks attempts to match idle peers to pending code retrievals.
+func (s *Syncer) assignBytecodeTasks() {
+	for len(s.codeTasks) > 0 {
+		peer := s.idlePeer()
+		if peer == nil {
+			return
+		}
+		hashes := make([]common.Hash, 0, maxCodeRequestCount)
+		for hash := range s.codeTasks {
+			delete(s.codeTasks, hash)
+			if hashes = append(hashes, hash); len(hashes) >= maxCodeRequestCount {
+				break
+			}
+		}
+		s.reqID++
+		req := &bytecodeRequest{
+			peer:   peer.ID(),
+			id:     s.reqID,
+			hashes: hashes,
+			stale:  s.quit,
+		}
+		req.timeout = time.AfterFunc(requestTimeout, func() {
+			peer.Log().Debug("Bytecode request timed out", "reqid", req.id)
+			s.lock.Lock()
+			if s.bytecodeReqs[req.id] == req {
+				s.revertBytecodeRequest(req)
+			}
+			s.lock.Unlock()
+			s.nudge()
+		})
+		s.bytecodeReqs[req.id] = req
+		delete(s.idlers, req.peer)
+
+		if err := peer.RequestByteCodes(req.id, hashes, maxRequestSize); err != nil {
+			peer.Log().Debug("Failed to request bytecodes", "err", err)
+			s.revertBytecodeRequest(req)
+		}
+	}
+}
+
+// assignTrienodeTasks attempts to match idle peers to trie node requests to
+// heal the state.
+func (s *Syncer) assignTrienodeTasks() {
+	for len(s.healTasks) > 0 {
+		peer := s.idlePeer()
+		if peer == nil {
+			return
+		}
+		hashes := make([]common.Hash, 0, maxTrieRequestCount)
+		for hash := range s.healTasks {
+			delete(s.healTasks, hash)
+			if hashes = append(hashes, hash); len(hashes) >= maxTrieRequestCount {
+				break
+			}
+		}
+		s.reqID++
+		req := &trienodeRequest{
+			peer:   peer.ID(),
+			id:     s.reqID,
+			root:   s.root,
+			hashes: hashes,
+			stale:  s.quit,
+		}
+		req.timeout = time.AfterFunc(requestTimeout, func() {
+			peer.Log().Debug("Trienode heal request timed out", "reqid", req.id)
+			s.lock.Lock()
+			if s.trienodeReqs[req.id] == req {
+				s.revertTrienodeRequest(req)
+			}
+			s.lock.Unlock()
+			s.nudge()
+		})
+		s.trienodeReqs[req.id] = req
+		delete(s.idlers, req.peer)
+
+		if err := peer.RequestTrieNodes(req.id, req.root, hashes, maxRequestSize); err != nil {
+			peer.Log().Debug("Failed to request trienode healers", "err", err)
+			s.revertTrienodeRequest(req)
+		}
+	}
+}
+
+// revertAccountRequest cleans up an account range request and returns all
+// failed retrieval tasks to the scheduler for reassignment. The lock must be
+// held by the caller.
+func (s *Syncer) revertAccountRequest(req *accountRequest) {
+	req.timeout.Stop()
+	delete(s.accountReqs, req.id)
+	if req.task.req == req {
+		req.task.req = nil
+	}
+}
+
+// revertStorageRequest cleans up a storage range request and returns all failed
+// retrieval tasks to the scheduler for reassignment. The lock must be held by
+// the caller.
+func (s *Syncer) revertStorageRequest(req *storageRequest) {
+	req.timeout.Stop()
+	delete(s.storageReqs, req.id)
+	for _, task := range req.tasks {
+		if task.req == req {
+			task.req = nil
+		}
+	}
+}
+
+// revertBytecodeRequest cleans up a bytecode request and returns all failed
+// retrieval tasks to the scheduler for reassignment. The lock must be held by
+// the caller.
+func (s *Syncer) revertBytecodeRequest(req *bytecodeRequest) {
+	req.timeout.Stop()
+	delete(s.bytecodeReqs, req.id)
+	for _, hash := range req.hashes {
+		s.codeTasks[hash] = struct{}{}
+	}
+}
+
+// revertTrienodeRequest cleans up a trie node request and returns all failed
+// retrieval tasks to the scheduler for reassignment. The lock must be held by
+// the caller.
+func (s *Syncer) revertTrienodeRequest(req *trienodeRequest) {
+	req.timeout.Stop()
+	delete(s.trienodeReqs, req.id)
+	if req.root == s.root && s.healTasks != nil {
+		for _, hash := range req.hashes {
+			s.healTasks[hash] = struct{}{}
+		}
+	}
+}
+
+// processAccountResponse integrates an already validated account range response
+// into the account trie, and schedules the storage and bytecode retrievals of
+// the delivered accounts.
+func (s *Syncer) processAccountResponse(res *accountResponse) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	task := res.req.task
+	if task.req == res.req {
+		task.req = nil
+	}
+	for i, hash := range res.hashes {
+		// Accounts beyond the chunk belong to the next task
+		if bytes.Compare(hash[:], task.Last[:]) > 0 {
+			res.cont = false
+			break
+		}
+		if err := s.accTrie.TryUpdate(hash[:], res.blobs[i]); err != nil {
+			return err
+		}
+		s.accountSynced++
+		s.accountBytes += common.StorageSize(common.HashLength + len(res.blobs[i]))
+
+		account := res.accounts[i]
+		if codeHash := common.BytesToHash(account.CodeHash); codeHash != emptyCode {
+			if ok, _ := s.db.Has(codeHash[:]); !ok {
+				s.codeTasks[codeHash] = struct{}{}
+			}
+		}
+		if account.Root != emptyRoot {
+			if ok, _ := s.db.Has(account.Root[:]); !ok {
+				s.storageTasks[hash] = &storageTask{account: hash, root: account.Root}
+			}
+		}
+	}
+	if res.cont {
+		task.Next = incHash(res.hashes[len(res.hashes)-1])
+	} else {
+		task.done = true
+	}
+	return s.commit(false)
+}
+
+// processStorageResponse integrates an already validated storage ranges response
+// into the storage tries, and persists the tries which are complete.
+func (s *Syncer) processStorageResponse(res *storageResponse) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for i, task := range res.req.tasks {
+		if task.req == res.req {
+			task.req = nil
+		}
+		// Skip the tasks superseded by a root change or not delivered
+		if s.storageTasks[task.account] != task || i >= len(res.hashes) {
+			continue
+		}
+		if task.trie == nil {
+			tr, err := trie.New(common.Hash{}, s.triedb)
+			if err != nil {
+				return err
+			}
+			task.trie = tr
+		}
+		for j, hash := range res.hashes[i] {
+			if err := task.trie.TryUpdate(hash[:], res.slots[i][j]); err != nil {
+				return err
+			}
+		}
+		s.storageSynced += uint64(len(res.hashes[i]))
+
+		// The last range may be capped, continue it in large contract mode
+		if i == len(res.hashes)-1 && res.cont {
+			task.large, task.next = true, incHash(res.hashes[i][len(res.hashes[i])-1])
+			continue
+		}
+		root, err := task.trie.Commit(nil)
+		if err != nil {
+			return err
+		}
+		if root != task.root {
+			log.Warn("Storage trie root mismatch", "account", task.account, "have", root, "want", task.root)
+		}
+		if err := s.triedb.Commit(root, false); err != nil {
+			return err
+		}
+		delete(s.storageTasks, task.account)
+	}
+	return s.commit(false)
+}
+
+// processBytecodeResponse stores the already validated bytecodes.
+func (s *Syncer) processBytecodeResponse(res *bytecodeResponse) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for i, hash := range res.hashes {
+		if err := s.batch.Put(hash[:], res.codes[i]); err != nil {
+			return err
+		}
+		s.bytecodeSynced++
+	}
+	return s.commit(false)
+}
+
+// processTrienodeResponse feeds the already validated trie nodes to the healer.
+func (s *Syncer) processTrienodeResponse(res *trienodeResponse) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// Nodes of an older root were already rescheduled by the new healer
+	if res.req.root != s.root || s.healer == nil {
+		return nil
+	}
+	for i, hash := range res.hashes {
+		_, _, err := s.healer.Process([]trie.SyncResult{{Hash: hash, Data: res.nodes[i]}})
+		switch err {
+		case nil:
+			s.trienodeHealed++
+		case trie.ErrAlreadyProcessed, trie.ErrNotRequested:
+		default:
+			return fmt.Errorf("invalid state node %x: %v", hash[:4], err)
+		}
+	}
+	if _, err := s.healer.Commit(s.batch); err != nil {
+		return err
+	}
+	return s.commit(false)
+}
+
+// commit flushes the tries being assembled and the batched data into the
+// database if enough data accumulated, or if forced.
+func (s *Syncer) commit(force bool) error {
+	if nodes, _ := s.triedb.Size(); force || nodes > trieCommitThreshold {
+		root, err := s.accTrie.Commit(nil)
+		if err != nil {
+			return err
+		}
+		if err := s.triedb.Commit(root, false); err != nil {
+			return err
+		}
+		if s.accTrie, err = trie.New(root, s.triedb); err != nil {
+			return err
+		}
+		for _, task := range s.storageTasks {
+			if task.trie == nil {
+				continue
+			}
+			root, err := task.trie.Commit(nil)
+			if err != nil {
+				return err
+			}
+			if err := s.triedb.Commit(root, false); err != nil {
+				return err
+			}
+			if task.trie, err = trie.New(root, s.triedb); err != nil {
+				return err
+			}
+		}
+	}
+	if force || s.batch.ValueSize() > berithdb.IdealBatchSize {
+		if err := s.batch.Write(); err != nil {
+			return err
+		}
+		s.batch.Reset()
+	}
+	return nil
+}
+
+// report calculates various status reports and provides it to the user.
+func (s *Syncer) report() {
+	if time.Since(s.logTime) < 8*time.Second {
+		return
+	}
+	s.logTime = time.Now()
+
+	s.lock.RLock()
+	var (
+		done    = 0
+		pending = len(s.storageTasks)
+		codes   = len(s.codeTasks)
+	)
+	for _, task := range s.tasks {
+		if task.done {
+			done++
+		}
+	}
+	s.lock.RUnlock()
+
+	if s.healer != nil {
+		log.Info("State heal in progress", "nodes", s.trienodeHealed, "pending", s.healer.Pending())
+		return
+	}
+	log.Info("State sync in progress", "chunks", fmt.Sprintf("%d/%d", done, len(s.tasks)), "accounts", s.accountSynced,
+		"accountsize", s.accountBytes, "slots", s.storageSynced, "codes", s.bytecodeSynced, "pendingstorage", pending, "pendingcodes", codes,
+		"elapsed", common.PrettyDuration(time.Since(s.startTime)))
+}
+
+// OnAccounts is a callback method to invoke when a range of accounts are
+// received from a remote peer.
+func (s *Syncer) OnAccounts(peer SyncPeer, id uint64, hashes []common.Hash, accounts [][]byte, proof [][]byte) error {
+	s.lock.Lock()
+	// Whether or not the response is valid, we can mark the peer as idle and
+	// notify the scheduler to assign a new task
+	s.markIdle(peer)
+
+	req, ok := s.accountReqs[id]
+	if !ok {
+		s.lock.Unlock()
+		peer.Log().Warn("Unexpected account range packet", "reqid", id)
+		return nil
+	}
+	delete(s.accountReqs, id)
+	req.timeout.Stop()
+
+	// An empty response means the peer does not have the requested state
+	if len(hashes) == 0 && len(proof) == 0 {
+		peer.Log().Debug("Peer rejected account range request", "root", req.root)
+		s.stateless[peer.ID()] = true
+		s.revertAccountRequest(req)
+		s.lock.Unlock()
+		return nil
+	}
+	s.lock.Unlock()
+
+	// Reconstruct the partial trie from the response and verify it
+	keys := make([][]byte, len(hashes))
+	for i, hash := range hashes {
+		keys[i] = common.CopyBytes(hash[:])
+	}
+	end := req.origin
+	if len(hashes) > 0 {
+		end = hashes[len(hashes)-1]
+	}
+	cont, err := trie.VerifyRangeProof(req.root, req.origin[:], end[:], keys, accounts, proofDatabase(proof))
+	if err == nil && len(hashes) > 0 && bytes.Compare(hashes[0][:], req.origin[:]) < 0 {
+		err = errors.New("account before the origin")
+	}
+	res := &accountResponse{req: req, hashes: hashes, blobs: accounts, cont: cont}
+	for i := 0; err == nil && i < len(accounts); i++ {
+		account := new(state.Account)
+		if err = rlp.DecodeBytes(accounts[i], account); err == nil {
+			res.accounts = append(res.accounts, account)
+		}
+	}
+	if err != nil {
+		peer.Log().Warn("Account range failed proof", "err", err)
+		s.lock.Lock()
+		s.revertAccountRequest(req)
+		s.lock.Unlock()
+		return err
+	}
+	select {
+	case s.accountResps <- res:
+	case <-req.stale:
+		s.lock.Lock()
+		s.revertAccountRequest(req)
+		s.lock.Unlock()
+	}
+	return nil
+}
+
+// OnStorage is a callback method to invoke when ranges of storage slots are
+// received from a remote peer.
+func (s *Syncer) OnStorage(peer SyncPeer, id uint64, hashes [][]common.Hash, slots [][][]byte, proof [][]byte) error {
+	s.lock.Lock()
+	s.markIdle(peer)
+
+	req, ok := s.storageReqs[id]
+	if !ok {
+		s.lock.Unlock()
+		peer.Log().Warn("Unexpected storage ranges packet", "reqid", id)
+		return nil
+	}
+	delete(s.storageReqs, id)
+	req.timeout.Stop()
+
+	// An empty response means the peer does not have the requested state
+	if len(hashes) == 0 {
+		peer.Log().Debug("Peer rejected storage request", "root", req.root)
+		s.stateless[peer.ID()] = true
+		s.revertStorageRequest(req)
+		s.lock.Unlock()
+		return nil
+	}
+	s.lock.Unlock()
+
+	var (
+		cont bool
+		err  error
+	)
+	if len(hashes) > len(req.tasks) || len(hashes) != len(slots) {
+		err = fmt.Errorf("storage ranges mismatch: %d ranges for %d accounts", len(hashes), len(req.tasks))
+	}
+	// Every range but the last one must be a complete storage trie, the last
+	// one is proven by the edge proofs if capped
+	for i := 0; err == nil && i < len(hashes); i++ {
+		keys := make([][]byte, len(hashes[i]))
+		for j, hash := range hashes[i] {
+			keys[j] = common.CopyBytes(hash[:])
+		}
+		if i < len(hashes)-1 || len(proof) == 0 {
+			if req.origin != (common.Hash{}) {
+				err = errors.New("continued storage range without proof")
+				break
+			}
+			_, err = trie.VerifyRangeProof(req.tasks[i].root, nil, nil, keys, slots[i], nil)
+			continue
+		}
+		end := req.origin
+		if len(keys) > 0 {
+			end = hashes[i][len(keys)-1]
+		}
+		cont, err = trie.VerifyRangeProof(req.tasks[i].root, req.origin[:], end[:], keys, slots[i], proofDatabase(proof))
+	}
+	if err != nil {
+		peer.Log().Warn("Storage slots failed proof", "err", err)
+		s.lock.Lock()
+		s.revertStorageRequest(req)
+		s.lock.Unlock()
+		return err
+	}
+	select {
+	case s.storageResps <- &storageResponse{req: req, hashes: hashes, slots: slots, cont: cont}:
+	case <-req.stale:
+		s.lock.Lock()
+		s.revertStorageRequest(req)
+		s.lock.Unlock()
+	}
+	return nil
+}
+
+// OnByteCodes is a callback method to invoke when a batch of contract bytecodes
+// are received from a remote peer.
+func (s *Syncer) OnByteCodes(peer SyncPeer, id uint64, codes [][]byte) error {
+	s.lock.Lock()
+	s.markIdle(peer)
+
+	req, ok := s.bytecodeReqs[id]
+	if !ok {
+		s.lock.Unlock()
+		peer.Log().Warn("Unexpected bytecode packet", "reqid", id)
+		return nil
+	}
+	delete(s.bytecodeReqs, id)
+	req.timeout.Stop()
+
+	if len(codes) == 0 {
+		peer.Log().Debug("Peer rejected bytecode request")
+		s.stateless[peer.ID()] = true
+	}
+	s.lock.Unlock()
+
+	// Cross reference the requested bytecodes with the response to find gaps
+	// that the serving node is missing
+	res := &bytecodeResponse{req: req}
+	requested := make(map[common.Hash]struct{}, len(req.hashes))
+	for _, hash := range req.hashes {
+		requested[hash] = struct{}{}
+	}
+	for _, code := range codes {
+		hash := crypto.Keccak256Hash(code)
+		if _, ok := requested[hash]; !ok {
+			s.lock.Lock()
+			s.revertBytecodeRequest(req)
+			s.lock.Unlock()
+			return errors.New("unexpected bytecode")
+		}
+		delete(requested, hash)
+		res.hashes, res.codes = append(res.hashes, hash), append(res.codes, code)
+	}
+	// Reschedule the bytecodes not delivered
+	s.lock.Lock()
+	for hash := range requested {
+		s.codeTasks[hash] = struct{}{}
+	}
+	s.lock.Unlock()
+
+	select {
+	case s.bytecodeResps <- res:
+	case <-req.stale:
+		s.lock.Lock()
+		req.hashes = res.hashes
+		s.revertBytecodeRequest(req)
+		s.lock.Unlock()
+	}
+	return nil
+}
+
+// OnTrieNodes is a callback method to invoke when a batch of trie nodes are
+// received from a remote peer.
+func (s *Syncer) OnTrieNodes(peer SyncPeer, id uint64, nodes [][]byte) error {
+	s.lock.Lock()
+	s.markIdle(peer)
+
+	req, ok := s.trienodeReqs[id]
+	if !ok {
+		s.lock.Unlock()
+		peer.Log().Warn("Unexpected trienode heal packet", "reqid", id)
+		return nil
+	}
+	delete(s.trienodeReqs, id)
+	req.timeout.Stop()
+
+	if len(nodes) == 0 {
+		peer.Log().Debug("Peer rejected trienode heal request", "root", req.root)
+		s.stateless[peer.ID()] = true
+	}
+	s.lock.Unlock()
+
+	// Cross reference the requested trie nodes with the response to find gaps
+	// that the serving node is missing
+	res := &trienodeResponse{req: req}
+	requested := make(map[common.Hash]struct{}, len(req.hashes))
+	for _, hash := range req.hashes {
+		requested[hash] = struct{}{}
+	}
+	for _, node := range nodes {
+		hash := crypto.Keccak256Hash(node)
+		if _, ok := requested[hash]; !ok {
+			s.lock.Lock()
+			s.revertTrienodeRequest(req)
+			s.lock.Unlock()
+			return errors.New("unexpected healing trienode")
+		}
+		delete(requested, hash)
+		res.hashes, res.nodes = append(res.hashes, hash), append(res.nodes, node)
+	}
+	// Reschedule the trie nodes not delivered
+	s.lock.Lock()
+	if req.root == s.root && s.healTasks != nil {
+		for hash := range requested {
+			s.healTasks[hash] = struct{}{}
+		}
+	}
+	s.lock.Unlock()
+
+	select {
+	case s.trienodeResps <- res:
+	case <-req.stale:
+		s.lock.Lock()
+		req.hashes = res.hashes
+		s.revertTrienodeRequest(req)
+		s.lock.Unlock()
+	}
+	return nil
+}
+
+// FetchStakers retrieves the staker set after a block, which is kept outside of
+// the state and isn't retrieved by a sync cycle. The peers are asked one by one
+// until one serves a set accepted by the verification callback.
+func (s *Syncer) FetchStakers(block common.Hash, verify func([]common.Address) error, cancel chan struct{}) error {
+	s.lock.RLock()
+	peers := make([]SyncPeer, 0, len(s.peers))
+	for _, peer := range s.peers {
+		peers = append(peers, peer)
+	}
+	s.lock.RUnlock()
+
+	for _, peer := range peers {
+		s.lock.Lock()
+		s.reqID++
+		req := &stakersRequest{
+			peer:    peer.ID(),
+			id:      s.reqID,
+			block:   block,
+			deliver: make(chan []common.Address, 1),
+		}
+		s.stakersReqs[req.id] = req
+		s.lock.Unlock()
+
+		var stakers []common.Address
+		if err := peer.RequestStakers(req.id, block); err != nil {
+			peer.Log().Debug("Failed to request staker set", "err", err)
+		} else {
+			select {
+			case stakers = <-req.deliver:
+			case <-time.After(requestTimeout):
+				peer.Log().Debug("Staker set request timed out", "reqid", req.id)
+			case <-cancel:
+				s.lock.Lock()
+				delete(s.stakersReqs, req.id)
+				s.lock.Unlock()
+				return ErrCancelled
+			}
+		}
+		s.lock.Lock()
+		delete(s.stakersReqs, req.id)
+		s.lock.Unlock()
+
+		if len(stakers) == 0 {
+			continue
+		}
+		if err := verify(stakers); err != nil {
+			peer.Log().Warn("Rejected served staker set", "block", block, "stakers", len(stakers), "err", err)
+			continue
+		}
+		return nil
+	}
+	return ErrNoStakers
+}
+
+// OnStakers is a callback method to invoke when a staker set is received from a
+// remote peer.
+func (s *Syncer) OnStakers(peer SyncPeer, id uint64, stakers []common.Address) error {
+	s.lock.Lock()
+	req, ok := s.stakersReqs[id]
+	if !ok || req.peer != peer.ID() {
+		s.lock.Unlock()
+		peer.Log().Warn("Unexpected staker set packet", "reqid", id)
+		return nil
+	}
+	delete(s.stakersReqs, id)
+	s.lock.Unlock()
+
+	if len(stakers) == 0 {
+		peer.Log().Debug("Peer rejected staker set request", "block", req.block)
+	}
+	req.deliver <- stakers
+	return nil
+}
+
+// markIdle marks a registered peer as ready to serve a new request and notifies
+// the scheduler. The lock must be held by the caller.
+func (s *Syncer) markIdle(peer SyncPeer) {
+	if _, ok := s.peers[peer.ID()]; ok {
+		s.idlers[peer.ID()] = struct{}{}
+	}
+	s.nudge()
+}
+
+// proofDatabase converts the nodes of a Merkle proof into a database keyed by
+// node hash, or nil if there is no proof.
+func proofDatabase(proof [][]byte) trie.DatabaseReader {
+	if len(proof) == 0 {
+		return nil
+	}
+	db := berithdb.NewMemDatabase()
+	for _, node := range proof {
+		db.Put(crypto.Keccak256(node), node)
+	}
+	return db
+}
+
+// incHash returns the next hash, in lexicographical order (a.k.a plus one).
+func incHash(h common.Hash) common.Hash {
+	for i := len(h) - 1; i >= 0; i-- {
+		h[i]++
+		if h[i] != 0 {
+			break
+		}
+	}
+	return h
+}
@@ -0,0 +1,231 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package snap
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/BerithFoundation/berith-chain/berithdb"
+	"github.com/BerithFoundation/berith-chain/common"
+	"github.com/BerithFoundation/berith-chain/core/state"
+	"github.com/BerithFoundation/berith-chain/log"
+)
+
+// testPeer is a snap peer serving the requests from a local state database.
+type testPeer struct {
+	id      string
+	db      state.Database
+	syncer  *Syncer
+	test    *testing.T
+	storage bool // Whether the peer serves storage slots
+}
+
+func newTestPeer(id string, t *testing.T, db state.Database, syncer *Syncer) *testPeer {
+	return &testPeer{id: id, db: db, syncer: syncer, test: t, storage: true}
+}
+
+func (p *testPeer) ID() string      { return p.id }
+func (p *testPeer) Log() log.Logger { return log.New("peer", p.id) }
+
+func (p *testPeer) RequestAccountRange(id uint64, root, origin, limit common.Hash, bytes uint64) error {
+	go func() {
+		accounts, proof := ServiceGetAccountRangeQuery(p.db, &GetAccountRangePacket{ID: id, Root: root, Origin: origin, Limit: limit, Bytes: bytes})
+		hashes, blobs := (&AccountRangePacket{Accounts: accounts}).Unpack()
+		if err := p.syncer.OnAccounts(p, id, hashes, blobs, proof); err != nil {
+			p.test.Errorf("account range rejected: %v", err)
+		}
+	}()
+	return nil
+}
+
+func (p *testPeer) RequestStorageRanges(id uint64, root common.Hash, accounts []common.Hash, origin, limit []byte, bytes uint64) error {
+	go func() {
+		var (
+			slots [][]*StorageData
+			proof [][]byte
+		)
+		if p.storage {
+			slots, proof = ServiceGetStorageRangesQuery(p.db, &GetStorageRangesPacket{ID: id, Root: root, Accounts: accounts, Origin: origin, Limit: limit, Bytes: bytes})
+		}
+		hashes, values := (&StorageRangesPacket{Slots: slots}).Unpack()
+		if err := p.syncer.OnStorage(p, id, hashes, values, proof); err != nil {
+			p.test.Errorf("storage ranges rejected: %v", err)
+		}
+	}()
+	return nil
+}
+
+func (p *testPeer) RequestByteCodes(id uint64, hashes []common.Hash, bytes uint64) error {
+	go func() {
+		codes := ServiceGetByteCodesQuery(p.db, &GetByteCodesPacket{ID: id, Hashes: hashes, Bytes: bytes})
+		if err := p.syncer.OnByteCodes(p, id, codes); err != nil {
+			p.test.Errorf("bytecodes rejected: %v", err)
+		}
+	}()
+	return nil
+}
+
+func (p *testPeer) RequestTrieNodes(id uint64, root common.Hash, hashes []common.Hash, bytes uint64) error {
+	go func() {
+		nodes := ServiceGetTrieNodesQuery(p.db, &GetTrieNodesPacket{ID: id, Root: root, Hashes: hashes, Bytes: bytes})
+		if err := p.syncer.OnTrieNodes(p, id, nodes); err != nil {
+			p.test.Errorf("trie nodes rejected: %v", err)
+		}
+	}()
+	return nil
+}
+
+func (p *testPeer) RequestStakers(id uint64, block common.Hash) error {
+	go p.syncer.OnStakers(p, id, nil)
+	return nil
+}
+
+// makeTestState creates a state with plain accounts, stakers, small contracts
+// and a contract whose storage is too large to be served at once.
+func makeTestState(t *testing.T, db state.Database) common.Hash {
+	statedb, _ := state.New(common.Hash{}, db)
+	for i := 0; i < 500; i++ {
+		addr := common.BigToAddress(big.NewInt(int64(i + 1)))
+		statedb.AddBalance(addr, big.NewInt(int64(i+1)))
+		switch {
+		case i%10 == 0:
+			statedb.AddStakeBalance(addr, big.NewInt(int64(1000*(i+1))), big.NewInt(1))
+		case i%25 == 1:
+			statedb.SetCode(addr, []byte{byte(i), 0x60, 0x00})
+			for j := 0; j < 20; j++ {
+				statedb.SetState(addr, common.BigToHash(big.NewInt(int64(j))), common.BigToHash(big.NewInt(int64(i*j+1))))
+			}
+		}
+	}
+	large := common.HexToAddress("0xdeadbeef")
+	statedb.SetCode(large, []byte{0x60, 0x01})
+	for j := 0; j < 20000; j++ {
+		statedb.SetState(large, common.BigToHash(big.NewInt(int64(j))), common.BigToHash(big.NewInt(int64(j+1))))
+	}
+	return commitTestState(t, db, statedb)
+}
+
+// commitTestState writes the state into the database, returning its root.
+func commitTestState(t *testing.T, db state.Database, statedb *state.StateDB) common.Hash {
+	root, err := statedb.Commit(false)
+	if err != nil {
+		t.Fatalf("failed to commit state: %v", err)
+	}
+	if err := db.TrieDB().Commit(root, false); err != nil {
+		t.Fatalf("failed to write state: %v", err)
+	}
+	return root
+}
+
+// checkTestState verifies that the complete state of the root is available in
+// the database.
+func checkTestState(t *testing.T, db berithdb.Database, root common.Hash) {
+	statedb, err := state.New(root, state.NewDatabase(db))
+	if err != nil {
+		t.Fatalf("state root not available: %v", err)
+	}
+	it := state.NewNodeIterator(statedb)
+	for it.Next() {
+	}
+	if it.Error != nil {
+		t.Fatalf("state incomplete: %v", it.Error)
+	}
+}
+
+// runSync runs a sync cycle, failing the test if it does not finish in time.
+func runSync(t *testing.T, syncer *Syncer, root common.Hash, cancel chan struct{}) error {
+	done := make(chan error)
+	go func() { done <- syncer.Sync(root, cancel) }()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(time.Minute):
+		t.Fatalf("sync timed out")
+	}
+	return nil
+}
+
+// Tests that a state including large contract storage can be retrieved from
+// multiple peers.
+func TestSync(t *testing.T) {
+	source := state.NewDatabase(berithdb.NewMemDatabase())
+	root := makeTestState(t, source)
+
+	db := berithdb.NewMemDatabase()
+	syncer := NewSyncer(db)
+	for _, id := range []string{"a", "b", "c"} {
+		syncer.Register(newTestPeer(id, t, source, syncer))
+	}
+	if err := runSync(t, syncer, root, make(chan struct{})); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	checkTestState(t, db, root)
+
+	if syncer.trienodeHealed != 0 {
+		t.Errorf("healed %d trie nodes of an unchanged root", syncer.trienodeHealed)
+	}
+}
+
+// Tests that a sync interrupted and resumed on a newer root completes, healing
+// the accounts which changed in between.
+func TestSyncRootChange(t *testing.T) {
+	source := state.NewDatabase(berithdb.NewMemDatabase())
+	oldRoot := makeTestState(t, source)
+
+	statedb, _ := state.New(oldRoot, source)
+	for i := 0; i < 500; i += 7 {
+		addr := common.BigToAddress(big.NewInt(int64(i + 1)))
+		statedb.AddBalance(addr, big.NewInt(1))
+		statedb.SetState(addr, common.Hash{0x01}, common.Hash{0x02})
+	}
+	statedb.SetState(common.HexToAddress("0xdeadbeef"), common.Hash{}, common.Hash{0xff})
+	statedb.Suicide(common.BigToAddress(big.NewInt(27)))
+	newRoot := commitTestState(t, source, statedb)
+
+	// Retrieve the accounts of the old root from a peer not serving storage
+	db := berithdb.NewMemDatabase()
+	syncer := NewSyncer(db)
+	peer := newTestPeer("a", t, source, syncer)
+	peer.storage = false
+	syncer.Register(peer)
+
+	cancel := make(chan struct{})
+	go func() {
+		for {
+			syncer.lock.RLock()
+			done := len(syncer.stateless) > 0
+			syncer.lock.RUnlock()
+			if done {
+				close(cancel)
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	if err := runSync(t, syncer, oldRoot, cancel); err != ErrCancelled {
+		t.Fatalf("interrupted sync: have %v, want %v", err, ErrCancelled)
+	}
+	// Resume on the new root with peers serving everything
+	syncer.Register(newTestPeer("b", t, source, syncer))
+	if err := runSync(t, syncer, newRoot, make(chan struct{})); err != nil {
+		t.Fatalf("resumed sync failed: %v", err)
+	}
+	checkTestState(t, db, newRoot)
+}
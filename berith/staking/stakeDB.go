@@ -1,9 +1,8 @@
 package staking
 
 import (
-	"github.com/syndtr/goleveldb/leveldb/util"
-
 	"github.com/BerithFoundation/berith-chain/common"
+	"github.com/BerithFoundation/berith-chain/common/hexutil"
 	"github.com/BerithFoundation/berith-chain/rlp"
 
 	"github.com/BerithFoundation/berith-chain/berithdb"
@@ -17,8 +16,9 @@ Database that stores staker information
 */
 type StakingDB struct {
 	creator   createFunc
-	stakeDB   *berithdb.LDBDatabase
-	NoPruning bool // When gc mode is archive, this value is true or false.
+	stakeDB   berithdb.Store
+	NoPruning bool   // When gc mode is archive, this value is true or false.
+	Engine    string // Key-value store engine of the database, empty picks the existing one.
 }
 
 // staker type creation function
@@ -29,7 +29,7 @@ func (s *StakingDB) CreateDB(filename string, creator createFunc) error {
 		return nil
 	}
 
-	db, err := berithdb.NewLDBDatabase(filename, 128, 1024)
+	db, err := berithdb.Open(s.Engine, filename, 128, 1024)
 	if err != nil {
 		return err
 	}
@@ -136,7 +136,8 @@ func (s *StakingDB) Clean(chain consensus.ChainReader, header *types.Header) err
 		}
 		header = chain.GetHeader(header.ParentHash, header.Number.Uint64()-1)
 	}
-	return s.stakeDB.LDB().CompactRange(util.Range{})
+	s.stakeDB.Compact()
+	return nil
 }
 
 func (s *StakingDB) isExist(key []byte) (bool, error) {
@@ -146,3 +147,54 @@ func (s *StakingDB) isExist(key []byte) (bool, error) {
 func (s *StakingDB) delete(key []byte) error {
 	return s.stakeDB.Delete(key)
 }
+
+/*
+[Berith]
+Prune deletes every staker entry whose block hash is rejected by the keep
+callback, compacting the database afterwards. It returns the number of the
+deleted entries. Deleting is idempotent, so an interrupted pruning can simply
+be run again.
+*/
+func (s *StakingDB) Prune(keep func(hash common.Hash) bool) (int, error) {
+	var (
+		batch   = s.stakeDB.NewBatch()
+		it      = s.stakeDB.NewIteratorWithPrefix(nil)
+		deleted int
+	)
+	defer it.Release()
+
+	for it.Next() {
+		key := it.Key()
+		if !isStakersKey(key) || keep(common.HexToHash(string(key))) {
+			continue
+		}
+		if err := batch.Delete(common.CopyBytes(key)); err != nil {
+			return deleted, err
+		}
+		deleted++
+
+		if batch.ValueSize() >= berithdb.IdealBatchSize {
+			if err := batch.Write(); err != nil {
+				return deleted, err
+			}
+			batch.Reset()
+		}
+	}
+	if err := it.Error(); err != nil {
+		return deleted, err
+	}
+	if err := batch.Write(); err != nil {
+		return deleted, err
+	}
+	if deleted > 0 {
+		s.stakeDB.Compact()
+	}
+	return deleted, nil
+}
+
+// isStakersKey reports whether the key is a hex encoded block hash, the only
+// kind of key the staker entries are stored with.
+func isStakersKey(key []byte) bool {
+	blob, err := hexutil.Decode(string(key))
+	return err == nil && len(blob) == common.HashLength
+}
@@ -0,0 +1,309 @@
+package berith
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/BerithFoundation/berith-chain/berithdb"
+	"github.com/BerithFoundation/berith-chain/common"
+	"github.com/BerithFoundation/berith-chain/consensus/bsrr"
+	"github.com/BerithFoundation/berith-chain/core"
+	"github.com/BerithFoundation/berith-chain/core/rawdb"
+	"github.com/BerithFoundation/berith-chain/core/state"
+	"github.com/BerithFoundation/berith-chain/core/types"
+	"github.com/BerithFoundation/berith-chain/log"
+	"github.com/BerithFoundation/berith-chain/params"
+	"github.com/BerithFoundation/berith-chain/rlp"
+	"github.com/BerithFoundation/berith-chain/trie"
+)
+
+const (
+	// supplySectionSize is the number of blocks indexed at once by the supply
+	// indexer. The records of a section are only available once it is complete.
+	supplySectionSize = 64
+
+	// supplyConfirms is the number of confirmations needed before a section is
+	// indexed, so that the index does not need to be rolled back on mini reorgs.
+	supplyConfirms = 16
+
+	// supplyThrottling is the time to wait between processing two consecutive
+	// index sections.
+	supplyThrottling = 100 * time.Millisecond
+)
+
+// Supply is the supply and issuance record of a block. The balances are summed
+// over every account of the state of the block, the flows are the changes made
+// by the block itself.
+type Supply struct {
+	Circulating *big.Int // Sum of the main balances
+	Staked      *big.Int // Sum of the stake balances
+	Behind      *big.Int // Sum of the rewards pending in behind balances
+
+	Issuance *big.Int // Reward credited to the behind balance of the block creator
+	Matured  *big.Int // Behind balances released to main balances
+	Burnt    *big.Int // Balances destroyed by the block
+	Fees     *big.Int // Transaction fees, paid to the block creator
+	StakeIn  *big.Int // Main balances moved to stake balances
+	StakeOut *big.Int // Stake balances moved back to main balances
+
+	// Inconsistent is set if the balances changed by more than the issuance
+	// accounts for, in which case Matured and Burnt are left zero.
+	Inconsistent bool
+}
+
+// Total returns the total supply, locked or not.
+func (s *Supply) Total() *big.Int {
+	total := new(big.Int).Add(s.Circulating, s.Staked)
+	return total.Add(total, s.Behind)
+}
+
+// ReadSupply retrieves the supply record of a block from the database.
+func ReadSupply(db rawdb.DatabaseReader, hash common.Hash, number uint64) *Supply {
+	data := rawdb.ReadSupplyRLP(db, hash, number)
+	if len(data) == 0 {
+		return nil
+	}
+	supply := new(Supply)
+	if err := rlp.DecodeBytes(data, supply); err != nil {
+		return nil
+	}
+	return supply
+}
+
+// SupplyIndexer implements a core.ChainIndexer, recording the supply of every
+// canonical block along with the issuance, the burnt balances, the fees and the
+// staking flows of the block.
+//
+// The changes are computed by walking the accounts that differ between the state
+// of a block and its parent, so the states of the indexed blocks must not have
+// been pruned.
+type SupplyIndexer struct {
+	db     berithdb.Database // Database instance to write the records into
+	states state.Database    // State database to diff the blocks with
+	config *params.ChainConfig
+
+	batch berithdb.Batch
+	last  *Supply     // Record of the last processed block
+	root  common.Hash // State root of the last processed block
+}
+
+// NewSupplyIndexer returns a chain indexer that maintains the supply and issuance
+// records of the canonical chain.
+func NewSupplyIndexer(db berithdb.Database, states state.Database, config *params.ChainConfig) *core.ChainIndexer {
+	backend := &SupplyIndexer{
+		db:     db,
+		states: states,
+		config: config,
+	}
+	table := berithdb.NewTable(db, string(rawdb.SupplyIndexPrefix))
+
+	return core.NewChainIndexer(db, table, backend, supplySectionSize, supplyConfirms, supplyThrottling, "supply")
+}
+
+// Reset implements core.ChainIndexerBackend, starting a new section from the
+// record of the last block of the previous one.
+func (s *SupplyIndexer) Reset(ctx context.Context, section uint64, lastSectionHead common.Hash) error {
+	s.batch, s.last, s.root = s.db.NewBatch(), nil, common.Hash{}
+	if section == 0 {
+		return nil
+	}
+	number := section*supplySectionSize - 1
+	header := rawdb.ReadHeader(s.db, lastSectionHead, number)
+	if header == nil {
+		return fmt.Errorf("section head #%d [%x…] not found", number, lastSectionHead[:4])
+	}
+	if s.last = ReadSupply(s.db, lastSectionHead, number); s.last == nil {
+		return fmt.Errorf("supply of block #%d [%x…] not found", number, lastSectionHead[:4])
+	}
+	s.root = header.Root
+	return nil
+}
+
+// Process implements core.ChainIndexerBackend, recording the supply of a block.
+func (s *SupplyIndexer) Process(ctx context.Context, header *types.Header) error {
+	delta, err := diffSupply(s.states, s.root, header.Root)
+	if err != nil {
+		return fmt.Errorf("block #%d: %v", header.Number, err)
+	}
+	supply := &Supply{
+		Circulating: delta.balance,
+		Staked:      delta.stake,
+		Behind:      delta.behind,
+		Issuance:    new(big.Int),
+		Matured:     new(big.Int),
+		Burnt:       new(big.Int),
+		Fees:        new(big.Int),
+		StakeIn:     delta.stakeIn,
+		StakeOut:    delta.stakeOut,
+	}
+	// The genesis allocation is the starting point, not a change
+	if s.last != nil {
+		// Rewards are only issued into behind balances, and leave them by maturing
+		supply.Issuance = bsrr.BlockReward(s.config, header)
+		supply.Matured.Sub(supply.Issuance, delta.behind)
+
+		supply.Circulating.Add(supply.Circulating, s.last.Circulating)
+		supply.Staked.Add(supply.Staked, s.last.Staked)
+		supply.Behind.Add(supply.Behind, s.last.Behind)
+		supply.Burnt.Sub(supply.Issuance, supply.Total())
+		supply.Burnt.Add(supply.Burnt, s.last.Total())
+
+		// A block can't be reprocessed any differently, so an inconsistency is
+		// recorded rather than failing, which would stall the whole index
+		if supply.Matured.Sign() < 0 || supply.Burnt.Sign() < 0 {
+			log.Error("Inconsistent supply change", "number", header.Number, "hash", header.Hash(), "matured", supply.Matured, "burnt", supply.Burnt)
+			supply.Matured, supply.Burnt, supply.Inconsistent = new(big.Int), new(big.Int), true
+		}
+		supply.Fees = blockFees(s.db, header)
+	}
+	data, err := rlp.EncodeToBytes(supply)
+	if err != nil {
+		return err
+	}
+	rawdb.WriteSupplyRLP(s.batch, header.Hash(), header.Number.Uint64(), data)
+	if s.batch.ValueSize() > berithdb.IdealBatchSize {
+		if err := s.batch.Write(); err != nil {
+			return err
+		}
+		s.batch.Reset()
+	}
+	s.last, s.root = supply, header.Root
+	return nil
+}
+
+// Commit implements core.ChainIndexerBackend, writing out the records of the
+// section.
+func (s *SupplyIndexer) Commit() error {
+	return s.batch.Write()
+}
+
+// blockFees sums up the fees paid by the transactions of a block.
+func blockFees(db rawdb.DatabaseReader, header *types.Header) *big.Int {
+	fees := new(big.Int)
+
+	hash, number := header.Hash(), header.Number.Uint64()
+	body := rawdb.ReadBody(db, hash, number)
+	receipts := rawdb.ReadReceipts(db, hash, number)
+	if body == nil || len(body.Transactions) != len(receipts) {
+		return fees
+	}
+	for i, tx := range body.Transactions {
+		fee := new(big.Int).SetUint64(receipts[i].GasUsed)
+		fees.Add(fees, fee.Mul(fee, tx.GasPrice()))
+	}
+	return fees
+}
+
+// supplyDelta accumulates the balance changes of the accounts between states.
+type supplyDelta struct {
+	balance  *big.Int // Net change of the main balances
+	stake    *big.Int // Net change of the stake balances
+	behind   *big.Int // Net change of the behind balances
+	stakeIn  *big.Int // Sum of the stake balance increases
+	stakeOut *big.Int // Sum of the stake balance decreases
+}
+
+// add accounts for the change of an account from prev to next.
+func (d *supplyDelta) add(prev, next *state.Account) {
+	d.balance.Add(d.balance, next.Balance)
+	d.balance.Sub(d.balance, prev.Balance)
+	d.behind.Add(d.behind, behindSum(next))
+	d.behind.Sub(d.behind, behindSum(prev))
+
+	change := new(big.Int).Sub(next.StakeBalance, prev.StakeBalance)
+	d.stake.Add(d.stake, change)
+	if change.Sign() > 0 {
+		d.stakeIn.Add(d.stakeIn, change)
+	} else {
+		d.stakeOut.Sub(d.stakeOut, change)
+	}
+}
+
+// behindSum returns the sum of the pending rewards of an account.
+func behindSum(account *state.Account) *big.Int {
+	sum := new(big.Int)
+	for _, behind := range account.BehindBalance {
+		sum.Add(sum, behind.Balance)
+	}
+	return sum
+}
+
+// decodeAccount decodes an account of the state trie, a missing account being
+// an empty one.
+func decodeAccount(blob []byte) (*state.Account, error) {
+	account := new(state.Account)
+	if len(blob) > 0 {
+		if err := rlp.DecodeBytes(blob, account); err != nil {
+			return nil, err
+		}
+	}
+	for _, n := range []**big.Int{&account.Balance, &account.StakeBalance} {
+		if *n == nil {
+			*n = new(big.Int)
+		}
+	}
+	return account, nil
+}
+
+// diffSupply walks the accounts which differ between the two state roots and
+// sums up their balance changes. An empty parent root diffs against the empty
+// state.
+func diffSupply(db state.Database, parentRoot, root common.Hash) (*supplyDelta, error) {
+	parent, err := trie.New(parentRoot, db.TrieDB())
+	if err != nil {
+		return nil, err
+	}
+	child, err := trie.New(root, db.TrieDB())
+	if err != nil {
+		return nil, err
+	}
+	delta := &supplyDelta{
+		balance:  new(big.Int),
+		stake:    new(big.Int),
+		behind:   new(big.Int),
+		stakeIn:  new(big.Int),
+		stakeOut: new(big.Int),
+	}
+	// Accounts created or modified by the block
+	diff, _ := trie.NewDifferenceIterator(parent.NodeIterator(nil), child.NodeIterator(nil))
+	it := trie.NewIterator(diff)
+	for it.Next() {
+		blob, err := parent.TryGet(it.Key)
+		if err != nil {
+			return nil, err
+		}
+		prev, err := decodeAccount(blob)
+		if err != nil {
+			return nil, err
+		}
+		next, err := decodeAccount(it.Value)
+		if err != nil {
+			return nil, err
+		}
+		delta.add(prev, next)
+	}
+	if it.Err != nil {
+		return nil, it.Err
+	}
+	// Accounts deleted by the block
+	diff, _ = trie.NewDifferenceIterator(child.NodeIterator(nil), parent.NodeIterator(nil))
+	it = trie.NewIterator(diff)
+	for it.Next() {
+		blob, err := child.TryGet(it.Key)
+		if err != nil {
+			return nil, err
+		}
+		if len(blob) > 0 {
+			continue
+		}
+		prev, err := decodeAccount(it.Value)
+		if err != nil {
+			return nil, err
+		}
+		next, _ := decodeAccount(nil)
+		delta.add(prev, next)
+	}
+	return delta, it.Err
+}
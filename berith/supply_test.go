@@ -0,0 +1,218 @@
+package berith
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/BerithFoundation/berith-chain/berithdb"
+	"github.com/BerithFoundation/berith-chain/common"
+	"github.com/BerithFoundation/berith-chain/consensus/bsrr"
+	"github.com/BerithFoundation/berith-chain/core/state"
+	"github.com/BerithFoundation/berith-chain/core/types"
+	"github.com/BerithFoundation/berith-chain/params"
+)
+
+var (
+	supplyCreator = common.Address{1}
+	supplyStaker  = common.Address{2}
+)
+
+// supplyChain commits a chain of states into a state database, each block
+// modifying the state of its parent.
+type supplyChain struct {
+	t      *testing.T
+	states state.Database
+	roots  []common.Hash
+}
+
+func newSupplyChain(t *testing.T) *supplyChain {
+	return &supplyChain{t: t, states: state.NewDatabase(berithdb.NewMemDatabase())}
+}
+
+// add commits the next state of the chain, returning its root.
+func (c *supplyChain) add(modify func(*state.StateDB)) common.Hash {
+	var parent common.Hash
+	if len(c.roots) > 0 {
+		parent = c.roots[len(c.roots)-1]
+	}
+	statedb, err := state.New(parent, c.states)
+	if err != nil {
+		c.t.Fatalf("failed to open state: %v", err)
+	}
+	modify(statedb)
+	root, err := statedb.Commit(false)
+	if err != nil {
+		c.t.Fatalf("failed to commit state: %v", err)
+	}
+	c.roots = append(c.roots, root)
+	return root
+}
+
+func checkBig(t *testing.T, name string, have, want *big.Int) {
+	t.Helper()
+	if have.Cmp(want) != 0 {
+		t.Errorf("%s mismatch: have %v, want %v", name, have, want)
+	}
+}
+
+// Tests that the balance changes between two states are summed up, including
+// the ones of created and deleted accounts.
+func TestDiffSupply(t *testing.T) {
+	chain := newSupplyChain(t)
+	parent := chain.add(func(statedb *state.StateDB) {
+		statedb.AddBalance(supplyStaker, big.NewInt(1000))
+		statedb.AddBalance(common.Address{3}, big.NewInt(50))
+	})
+	root := chain.add(func(statedb *state.StateDB) {
+		statedb.SubBalance(supplyStaker, big.NewInt(300))
+		statedb.AddStakeBalance(supplyStaker, big.NewInt(300), big.NewInt(1))
+		statedb.AddBehindBalance(supplyCreator, big.NewInt(1), big.NewInt(20))
+		statedb.Suicide(common.Address{3})
+	})
+	delta, err := diffSupply(chain.states, parent, root)
+	if err != nil {
+		t.Fatalf("failed to diff states: %v", err)
+	}
+	checkBig(t, "balance", delta.balance, big.NewInt(-350))
+	checkBig(t, "stake", delta.stake, big.NewInt(300))
+	checkBig(t, "behind", delta.behind, big.NewInt(20))
+	checkBig(t, "stake in", delta.stakeIn, big.NewInt(300))
+	checkBig(t, "stake out", delta.stakeOut, new(big.Int))
+
+	// Diffing the other way round reverts every change
+	delta, err = diffSupply(chain.states, root, parent)
+	if err != nil {
+		t.Fatalf("failed to diff states: %v", err)
+	}
+	checkBig(t, "reverted balance", delta.balance, big.NewInt(350))
+	checkBig(t, "reverted stake out", delta.stakeOut, big.NewInt(300))
+
+	// The empty root diffs against the empty state
+	delta, err = diffSupply(chain.states, common.Hash{}, parent)
+	if err != nil {
+		t.Fatalf("failed to diff states: %v", err)
+	}
+	checkBig(t, "allocated balance", delta.balance, big.NewInt(1050))
+}
+
+// Tests that the supply records follow the issuance, the maturation and the
+// staking flows of the blocks, and that an inconsistent block is recorded
+// without failing the section.
+func TestSupplyProcess(t *testing.T) {
+	config := *params.TestnetChainConfig
+	bsrrConfig := *config.Bsrr
+	bsrrConfig.Rewards = common.Big0
+	config.Bsrr = &bsrrConfig
+
+	var (
+		db      = berithdb.NewMemDatabase()
+		chain   = newSupplyChain(t)
+		indexer = &SupplyIndexer{db: db, states: chain.states, config: &config}
+		headers []*types.Header
+	)
+	reward := func(number int64) *big.Int {
+		return bsrr.BlockReward(&config, &types.Header{Number: big.NewInt(number)})
+	}
+	block := func(modify func(*state.StateDB)) {
+		number := int64(len(headers))
+		header := &types.Header{Number: big.NewInt(number), Coinbase: supplyCreator, Root: chain.add(modify)}
+		if len(headers) > 0 {
+			header.ParentHash = headers[len(headers)-1].Hash()
+		}
+		headers = append(headers, header)
+	}
+	issue := func(statedb *state.StateDB) {
+		number := big.NewInt(int64(len(headers)))
+		statedb.AddBehindBalance(supplyCreator, number, reward(number.Int64()))
+	}
+	// Genesis allocation
+	block(func(statedb *state.StateDB) {
+		statedb.AddBalance(supplyStaker, big.NewInt(1000))
+	})
+	// Issuance, the reward staying behind
+	block(issue)
+	// Maturation of the first reward, with the burning of some balance
+	block(func(statedb *state.StateDB) {
+		statedb.RemoveFirstBehindBalance(supplyCreator)
+		statedb.AddBalance(supplyCreator, reward(1))
+		statedb.SubBalance(supplyStaker, big.NewInt(100))
+		issue(statedb)
+	})
+	// Stake
+	block(func(statedb *state.StateDB) {
+		statedb.SubBalance(supplyStaker, big.NewInt(600))
+		statedb.AddStakeBalance(supplyStaker, big.NewInt(600), big.NewInt(3))
+		issue(statedb)
+	})
+	// Unstake
+	block(func(statedb *state.StateDB) {
+		statedb.RemoveStakeBalance(supplyStaker)
+		issue(statedb)
+	})
+	// Balance created out of nothing
+	block(func(statedb *state.StateDB) {
+		statedb.AddBalance(supplyStaker, big.NewInt(10))
+		issue(statedb)
+	})
+	// Indexing carries on after the inconsistency
+	block(issue)
+
+	if err := indexer.Reset(context.Background(), 0, common.Hash{}); err != nil {
+		t.Fatalf("failed to reset indexer: %v", err)
+	}
+	for _, header := range headers {
+		if err := indexer.Process(context.Background(), header); err != nil {
+			t.Fatalf("failed to process block #%d: %v", header.Number, err)
+		}
+	}
+	if err := indexer.Commit(); err != nil {
+		t.Fatalf("failed to commit section: %v", err)
+	}
+	r := make([]*big.Int, len(headers))
+	for i := range r {
+		r[i] = reward(int64(i))
+	}
+	sum := func(values ...*big.Int) *big.Int {
+		total := new(big.Int)
+		for _, value := range values {
+			total.Add(total, value)
+		}
+		return total
+	}
+	n := big.NewInt
+	zero := new(big.Int)
+
+	tests := []struct {
+		circulating, staked, behind                 *big.Int
+		issuance, matured, burnt, stakeIn, stakeOut *big.Int
+		inconsistent                                bool
+	}{
+		{n(1000), zero, zero, zero, zero, zero, zero, zero, false},
+		{n(1000), zero, r[1], r[1], zero, zero, zero, zero, false},
+		{sum(n(900), r[1]), zero, r[2], r[2], r[1], n(100), zero, zero, false},
+		{sum(n(300), r[1]), n(600), sum(r[2], r[3]), r[3], zero, zero, n(600), zero, false},
+		{sum(n(900), r[1]), zero, sum(r[2], r[3], r[4]), r[4], zero, zero, zero, n(600), false},
+		{sum(n(910), r[1]), zero, sum(r[2], r[3], r[4], r[5]), r[5], zero, zero, zero, zero, true},
+		{sum(n(910), r[1]), zero, sum(r[2], r[3], r[4], r[5], r[6]), r[6], zero, zero, zero, zero, false},
+	}
+	for i, tt := range tests {
+		header := headers[i]
+		supply := ReadSupply(db, header.Hash(), header.Number.Uint64())
+		if supply == nil {
+			t.Fatalf("block #%d: supply not recorded", i)
+		}
+		checkBig(t, fmt.Sprintf("block #%d circulating", i), supply.Circulating, tt.circulating)
+		checkBig(t, fmt.Sprintf("block #%d staked", i), supply.Staked, tt.staked)
+		checkBig(t, fmt.Sprintf("block #%d behind", i), supply.Behind, tt.behind)
+		checkBig(t, fmt.Sprintf("block #%d issuance", i), supply.Issuance, tt.issuance)
+		checkBig(t, fmt.Sprintf("block #%d matured", i), supply.Matured, tt.matured)
+		checkBig(t, fmt.Sprintf("block #%d burnt", i), supply.Burnt, tt.burnt)
+		checkBig(t, fmt.Sprintf("block #%d stake in", i), supply.StakeIn, tt.stakeIn)
+		checkBig(t, fmt.Sprintf("block #%d stake out", i), supply.StakeOut, tt.stakeOut)
+		if supply.Inconsistent != tt.inconsistent {
+			t.Errorf("block #%d: inconsistency mismatch: have %v, want %v", i, supply.Inconsistent, tt.inconsistent)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package berith
+
+import (
+	"testing"
+
+	"github.com/BerithFoundation/berith-chain/core/types"
+)
+
+// Tests that the pending private transactions are only synced to the private
+// peers on connection, in full, while the public ones are announced.
+func TestSyncPrivateTxs(t *testing.T) {
+	public, private := newTestTxs(3, 0), newTestTxs(2, 10)
+	pm, peers := newTxTestManager(newTestTxPool(public, private), []int{ber66, ber66}, 1)
+
+	for i, want := range []types.Transactions{private, nil} {
+		pm.syncTransactions(peers[i])
+
+		sent, announced, privs := queuedHashes(peers[i])
+		if len(sent) > 0 || len(announced) != len(public) {
+			t.Errorf("peer %d: synced public transactions mismatch: have %d sent and %d announced, want %d announced", i, len(sent), len(announced), len(public))
+		}
+		for _, tx := range public {
+			if !announced[tx.Hash()] {
+				t.Errorf("peer %d: transaction %x not synced", i, tx.Hash())
+			}
+		}
+		if len(privs) != len(want) {
+			t.Errorf("peer %d: synced private transactions mismatch: have %d, want %d", i, len(privs), len(want))
+		}
+		for _, tx := range want {
+			if !privs[tx.Hash()] {
+				t.Errorf("peer %d: private transaction %x not synced", i, tx.Hash())
+			}
+		}
+	}
+	// Public peers get nothing if only private transactions are pending
+	pm, peers = newTxTestManager(newTestTxPool(nil, private), []int{ber66}, 0)
+	pm.syncTransactions(peers[0])
+	if sent, announced, privs := queuedHashes(peers[0]); len(sent)+len(announced)+len(privs) > 0 {
+		t.Errorf("private transactions synced to public peer")
+	}
+}
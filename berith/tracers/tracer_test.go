@@ -145,3 +145,38 @@ func TestHaltBetweenSteps(t *testing.T) {
 		t.Errorf("Expected timeout error, got %v", err)
 	}
 }
+
+func TestIsPrecompiled(t *testing.T) {
+	staking := vm.StakingContractAddress.Hex()
+	code := "{res: [], step: function() { if (this.res.length == 0) { this.res.push(isPrecompiled(toAddress('0x01')), isPrecompiled(toAddress('" + staking + "'))); } }, fault: function() {}, result: function() { return this.res; }}"
+
+	for _, tt := range []struct {
+		fork *big.Int
+		want string
+	}{
+		{nil, "[true,false]"},
+		{big.NewInt(2), "[true,false]"},
+		{big.NewInt(1), "[true,true]"},
+	} {
+		tracer, err := New(code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config := *params.TestnetChainConfig
+		config.BIP8Block = tt.fork
+
+		env := vm.NewEVM(vm.Context{BlockNumber: big.NewInt(1)}, &dummyStatedb{}, &config, vm.Config{Debug: true, Tracer: tracer})
+		contract := vm.NewContract(account{}, account{}, big.NewInt(0), 10000)
+		contract.Code = []byte{byte(vm.STOP)}
+		if _, err := env.Interpreter().Run(contract, []byte{}, false); err != nil {
+			t.Fatal(err)
+		}
+		ret, err := tracer.GetResult()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(ret) != tt.want {
+			t.Errorf("BIP8 block %v: have %s, want %s", tt.fork, ret, tt.want)
+		}
+	}
+}
@@ -155,6 +155,11 @@ func (db *LDBDatabase) Close() {
 	}
 }
 
+// Stat returns a particular internal stat of the database.
+func (db *LDBDatabase) Stat(property string) (string, error) {
+	return db.db.GetProperty(property)
+}
+
 func (db *LDBDatabase) LDB() *leveldb.DB {
 	return db.db
 }
@@ -20,6 +20,8 @@ package berithdb
 
 import (
 	"errors"
+
+	"github.com/syndtr/goleveldb/leveldb/iterator"
 )
 
 var errNotSupported = errors.New("berithdb: not supported")
@@ -63,6 +65,16 @@ func (db *LDBDatabase) Close() {
 func (db *LDBDatabase) Meter(prefix string) {
 }
 
+// NewIteratorWithPrefix returns a iterator to iterate over subset of database content with a particular prefix.
+func (db *LDBDatabase) NewIteratorWithPrefix(prefix []byte) iterator.Iterator {
+	return iterator.NewEmptyIterator(errNotSupported)
+}
+
+// Stat returns a particular internal stat of the database.
+func (db *LDBDatabase) Stat(property string) (string, error) {
+	return "", errNotSupported
+}
+
 func (db *LDBDatabase) NewBatch() Batch {
 	return nil
 }
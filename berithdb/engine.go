@@ -0,0 +1,86 @@
+// Copyright 2023 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package berithdb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// EngineLevelDB is the name of the goleveldb backed key-value store.
+	EngineLevelDB = "leveldb"
+
+	// EnginePebble is the name of the Pebble backed key-value store.
+	EnginePebble = "pebble"
+)
+
+// Store is a persistent key-value store backed by one of the supported engines.
+type Store interface {
+	Database
+	Iteratee
+
+	// Path returns the path to the database directory.
+	Path() string
+
+	// Meter configures the database metrics collectors under the given prefix.
+	Meter(prefix string)
+
+	// Stat returns a particular internal stat of the database.
+	Stat(property string) (string, error)
+}
+
+// DetectEngine returns the engine of the database in the given directory, or an
+// empty string if there is no database yet.
+func DetectEngine(file string) string {
+	// Pebble keeps its options in a dedicated file, LevelDB only has a manifest
+	if matches, _ := filepath.Glob(filepath.Join(file, "OPTIONS-*")); len(matches) > 0 {
+		return EnginePebble
+	}
+	if _, err := os.Stat(filepath.Join(file, "CURRENT")); err == nil {
+		return EngineLevelDB
+	}
+	return ""
+}
+
+// Open opens the key-value store of the given engine in the given directory. An
+// empty engine picks the one of the existing database, defaulting to LevelDB. A
+// database is never opened with another engine than it was created with.
+func Open(engine string, file string, cache int, handles int) (Store, error) {
+	existing := DetectEngine(file)
+	switch {
+	case engine == "" && existing == "":
+		engine = EngineLevelDB
+	case engine == "":
+		engine = existing
+	case existing != "" && engine != existing:
+		return nil, fmt.Errorf("database %s is %s, not %s (convert it with 'berith db convert')", file, existing, engine)
+	}
+	switch engine {
+	case EngineLevelDB:
+		db, err := NewLDBDatabase(file, cache, handles)
+		if err != nil {
+			return nil, err
+		}
+		return db, nil
+	case EnginePebble:
+		return newPebbleDatabase(file, cache, handles)
+	default:
+		return nil, fmt.Errorf("unknown database engine %q", engine)
+	}
+}
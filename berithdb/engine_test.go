@@ -0,0 +1,111 @@
+// Copyright 2023 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package berithdb
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Tests the basic operations, batches and prefix iteration of the engines.
+func TestEngines(t *testing.T) {
+	for _, engine := range []string{EngineLevelDB, EnginePebble} {
+		t.Run(engine, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "berithdb")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			db, err := Open(engine, dir, 0, 0)
+			if err != nil {
+				t.Fatalf("failed to open database: %v", err)
+			}
+			defer db.Close()
+
+			if err := db.Put([]byte("a1"), []byte("v1")); err != nil {
+				t.Fatalf("failed to put: %v", err)
+			}
+			if blob, err := db.Get([]byte("a1")); err != nil || !bytes.Equal(blob, []byte("v1")) {
+				t.Fatalf("get mismatch: have %x (%v), want %x", blob, err, []byte("v1"))
+			}
+			if _, err := db.Get([]byte("missing")); err == nil {
+				t.Fatalf("missing key retrieved")
+			}
+			batch := db.NewBatch()
+			batch.Put([]byte("a2"), []byte("v2"))
+			batch.Put([]byte("a3"), []byte("v3"))
+			batch.Put([]byte("b1"), []byte("v4"))
+			batch.Delete([]byte("a1"))
+			if err := batch.Write(); err != nil {
+				t.Fatalf("failed to write batch: %v", err)
+			}
+			if ok, _ := db.Has([]byte("a1")); ok {
+				t.Fatalf("deleted key still present")
+			}
+			var keys []string
+			it := db.NewIteratorWithPrefix([]byte("a"))
+			for it.Next() {
+				keys = append(keys, string(it.Key()))
+			}
+			it.Release()
+			if len(keys) != 2 || keys[0] != "a2" || keys[1] != "a3" {
+				t.Fatalf("iteration mismatch: have %v, want [a2 a3]", keys)
+			}
+			db.Compact()
+		})
+	}
+}
+
+// Tests that the engine of an existing database is detected and a database is
+// never opened with another engine.
+func TestEngineDetection(t *testing.T) {
+	dir, err := ioutil.TempDir("", "berithdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, engine := range []string{EngineLevelDB, EnginePebble} {
+		path := filepath.Join(dir, engine)
+		db, err := Open(engine, path, 0, 0)
+		if err != nil {
+			t.Fatalf("%s: failed to open database: %v", engine, err)
+		}
+		db.Close()
+
+		if have := DetectEngine(path); have != engine {
+			t.Fatalf("%s: detected engine mismatch: have %s", engine, have)
+		}
+		other := EnginePebble
+		if engine == EnginePebble {
+			other = EngineLevelDB
+		}
+		if db, err := Open(other, path, 0, 0); err == nil {
+			db.Close()
+			t.Fatalf("%s: database opened with %s", engine, other)
+		}
+		db, err = Open("", path, 0, 0)
+		if err != nil {
+			t.Fatalf("%s: failed to reopen database: %v", engine, err)
+		}
+		db.Close()
+	}
+}
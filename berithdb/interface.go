@@ -16,6 +16,8 @@
 
 package berithdb
 
+import "github.com/syndtr/goleveldb/leveldb/iterator"
+
 // Code using batches should try to add this much data to the batch.
 // The value was determined empirically.
 const IdealBatchSize = 100 * 1024
@@ -41,6 +43,14 @@ type Database interface {
 	Compact()
 }
 
+// Iteratee wraps the NewIteratorWithPrefix method of a backing data store. It is
+// implemented by the databases able to iterate over their content.
+type Iteratee interface {
+	// NewIteratorWithPrefix creates a binary-alphabetical iterator over a subset
+	// of database content with a particular key prefix.
+	NewIteratorWithPrefix(prefix []byte) iterator.Iterator
+}
+
 // Batch is a write-only database that commits changes to its host database
 // when Write is called. Batch cannot be used concurrently.
 type Batch interface {
@@ -18,9 +18,13 @@ package berithdb
 
 import (
 	"errors"
+	"strings"
 	"sync"
 
 	"github.com/BerithFoundation/berith-chain/common"
+	"github.com/syndtr/goleveldb/leveldb/comparer"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/memdb"
 )
 
 /*
@@ -80,6 +84,21 @@ func (db *MemDatabase) Keys() [][]byte {
 	return keys
 }
 
+// NewIteratorWithPrefix returns an iterator over a copy of the database content
+// with a particular prefix, taken when the iterator is created.
+func (db *MemDatabase) NewIteratorWithPrefix(prefix []byte) iterator.Iterator {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	content := memdb.New(comparer.DefaultComparer, 0)
+	for key, value := range db.db {
+		if strings.HasPrefix(key, string(prefix)) {
+			content.Put([]byte(key), common.CopyBytes(value))
+		}
+	}
+	return content.NewIterator(nil)
+}
+
 func (db *MemDatabase) Delete(key []byte) error {
 	db.lock.Lock()
 	defer db.lock.Unlock()
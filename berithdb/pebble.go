@@ -0,0 +1,499 @@
+// Copyright 2023 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+//go:build !js
+// +build !js
+
+package berithdb
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/BerithFoundation/berith-chain/log"
+	"github.com/BerithFoundation/berith-chain/metrics"
+	"github.com/cockroachdb/pebble"
+	"github.com/cockroachdb/pebble/bloom"
+	"github.com/syndtr/goleveldb/leveldb/errors"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+const (
+	// minCache is the minimum amount of memory in megabytes to allocate to pebble
+	// read and write caching, split half and half.
+	minCache = 16
+
+	// minHandles is the minimum number of files handles to allocate to the open
+	// database files.
+	minHandles = 16
+)
+
+// PebbleDatabase is a persistent key-value store based on the Pebble storage
+// engine. Apart from basic data storage functionality it also supports batch
+// writes and iterating over the keyspace in binary-alphabetical order.
+type PebbleDatabase struct {
+	fn string     // filename for reporting
+	db *pebble.DB // Underlying pebble storage engine
+
+	compTimeMeter    metrics.Meter // Meter for measuring the total time spent in database compaction
+	compReadMeter    metrics.Meter // Meter for measuring the data read during compaction
+	compWriteMeter   metrics.Meter // Meter for measuring the data written during compaction
+	writeDelayNMeter metrics.Meter // Meter for measuring the write delay number due to database compaction
+	writeDelayMeter  metrics.Meter // Meter for measuring the write delay duration due to database compaction
+	diskReadMeter    metrics.Meter // Meter for measuring the effective amount of data read
+	diskWriteMeter   metrics.Meter // Meter for measuring the effective amount of data written
+
+	quitLock sync.Mutex      // Mutex protecting the quit channel access
+	quitChan chan chan error // Quit channel to stop the metrics collection before closing the database
+
+	log log.Logger // Contextual logger tracking the database path
+
+	activeComp    int           // Current number of active compactions
+	compStartTime time.Time     // The start time of the earliest currently-active compaction
+	compTime      atomic.Int64  // Total time spent in compaction in ns
+	writeDelayNum atomic.Uint64 // Total number of write stalls
+	writeDelay    atomic.Int64  // Total time spent in write stalls in ns
+	writeStalled  atomic.Bool   // Flag whether the write is stalled
+	writeStallAt  time.Time     // The start time of the current write stall
+}
+
+func newPebbleDatabase(file string, cache int, handles int) (Store, error) {
+	db, err := NewPebbleDatabase(file, cache, handles)
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// NewPebbleDatabase returns a Pebble wrapped object.
+func NewPebbleDatabase(file string, cache int, handles int) (*PebbleDatabase, error) {
+	logger := log.New("database", file)
+
+	// Ensure we have some minimal caching and file guarantees
+	if cache < minCache {
+		cache = minCache
+	}
+	if handles < minHandles {
+		handles = minHandles
+	}
+	logger.Info("Allocated cache and file handles", "cache", cache, "handles", handles)
+
+	// The memory table size is the same fraction of the cache as the write
+	// buffer of LevelDB, two of these are used internally.
+	memTableSize := cache / 4 * 1024 * 1024
+
+	db := &PebbleDatabase{
+		fn:  file,
+		log: logger,
+	}
+	opt := &pebble.Options{
+		Cache:        pebble.NewCache(int64(cache / 2 * 1024 * 1024)),
+		MaxOpenFiles: handles,
+
+		MemTableSize:                memTableSize,
+		MemTableStopWritesThreshold: 2,
+
+		// The default compaction concurrency is one, use the available cores
+		MaxConcurrentCompactions: func() int { return runtime.NumCPU() },
+
+		Levels: []pebble.LevelOptions{
+			{TargetFileSize: 2 * 1024 * 1024, FilterPolicy: bloom.FilterPolicy(10)},
+			{TargetFileSize: 4 * 1024 * 1024, FilterPolicy: bloom.FilterPolicy(10)},
+			{TargetFileSize: 8 * 1024 * 1024, FilterPolicy: bloom.FilterPolicy(10)},
+			{TargetFileSize: 16 * 1024 * 1024, FilterPolicy: bloom.FilterPolicy(10)},
+			{TargetFileSize: 32 * 1024 * 1024, FilterPolicy: bloom.FilterPolicy(10)},
+			{TargetFileSize: 64 * 1024 * 1024, FilterPolicy: bloom.FilterPolicy(10)},
+			{TargetFileSize: 128 * 1024 * 1024, FilterPolicy: bloom.FilterPolicy(10)},
+		},
+		EventListener: &pebble.EventListener{
+			CompactionBegin: db.onCompactionBegin,
+			CompactionEnd:   db.onCompactionEnd,
+			WriteStallBegin: db.onWriteStallBegin,
+			WriteStallEnd:   db.onWriteStallEnd,
+		},
+	}
+	opt.EnsureDefaults()
+
+	inner, err := pebble.Open(file, opt)
+	if err != nil {
+		return nil, err
+	}
+	db.db = inner
+	return db, nil
+}
+
+func (db *PebbleDatabase) onCompactionBegin(info pebble.CompactionInfo) {
+	if db.activeComp == 0 {
+		db.compStartTime = time.Now()
+	}
+	db.activeComp++
+}
+
+func (db *PebbleDatabase) onCompactionEnd(info pebble.CompactionInfo) {
+	if db.activeComp == 1 {
+		db.compTime.Add(int64(time.Since(db.compStartTime)))
+	} else if db.activeComp == 0 {
+		panic("should not happen")
+	}
+	db.activeComp--
+}
+
+func (db *PebbleDatabase) onWriteStallBegin(b pebble.WriteStallBeginInfo) {
+	db.writeStallAt = time.Now()
+	db.writeStalled.Store(true)
+}
+
+func (db *PebbleDatabase) onWriteStallEnd() {
+	db.writeDelay.Add(int64(time.Since(db.writeStallAt)))
+	db.writeDelayNum.Add(1)
+	db.writeStalled.Store(false)
+}
+
+// Path returns the path to the database directory.
+func (db *PebbleDatabase) Path() string {
+	return db.fn
+}
+
+// Put puts the given key / value to the queue
+func (db *PebbleDatabase) Put(key []byte, value []byte) error {
+	return db.db.Set(key, value, pebble.NoSync)
+}
+
+func (db *PebbleDatabase) Has(key []byte) (bool, error) {
+	_, closer, err := db.db.Get(key)
+	if err == pebble.ErrNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	closer.Close()
+	return true, nil
+}
+
+// Get returns the given key if it's present.
+func (db *PebbleDatabase) Get(key []byte) ([]byte, error) {
+	dat, closer, err := db.db.Get(key)
+	if err == pebble.ErrNotFound {
+		// Report the LevelDB error, the callers don't distinguish between the engines
+		return nil, errors.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	ret := make([]byte, len(dat))
+	copy(ret, dat)
+	closer.Close()
+	return ret, nil
+}
+
+// Delete deletes the key from the queue and database
+func (db *PebbleDatabase) Delete(key []byte) error {
+	return db.db.Delete(key, pebble.NoSync)
+}
+
+// Compact flattens the entire key space of the database.
+func (db *PebbleDatabase) Compact() {
+	iter := db.db.NewIter(nil)
+	var start, limit []byte
+	if iter.First() {
+		start = append([]byte{}, iter.Key()...)
+	}
+	if iter.Last() {
+		limit = append(append([]byte{}, iter.Key()...), 0x00)
+	}
+	iter.Close()
+
+	if start == nil {
+		return
+	}
+	if err := db.db.Compact(start, limit, true); err != nil {
+		db.log.Error("Failed to compact database", "err", err)
+	}
+}
+
+func (db *PebbleDatabase) NewIterator() iterator.Iterator {
+	return db.NewIteratorWithPrefix(nil)
+}
+
+// NewIteratorWithPrefix returns a iterator to iterate over subset of database content with a particular prefix.
+func (db *PebbleDatabase) NewIteratorWithPrefix(prefix []byte) iterator.Iterator {
+	r := util.BytesPrefix(prefix)
+	iter := db.db.NewIter(&pebble.IterOptions{
+		LowerBound: r.Start,
+		UpperBound: r.Limit,
+	})
+	return &pebbleIterator{iter: iter}
+}
+
+func (db *PebbleDatabase) Close() {
+	// Stop the metrics collection to avoid internals database races
+	db.quitLock.Lock()
+	defer db.quitLock.Unlock()
+
+	if db.quitChan != nil {
+		errc := make(chan error)
+		db.quitChan <- errc
+		if err := <-errc; err != nil {
+			db.log.Error("Metrics collection failed", "err", err)
+		}
+		db.quitChan = nil
+	}
+	err := db.db.Close()
+	if err == nil {
+		db.log.Info("Database closed")
+	} else {
+		db.log.Error("Failed to close database", "err", err)
+	}
+}
+
+// Stat returns the internal metrics of the database. Pebble has no named
+// properties, so the property is ignored.
+func (db *PebbleDatabase) Stat(property string) (string, error) {
+	return db.db.Metrics().String(), nil
+}
+
+// Meter configures the database metrics collectors and
+func (db *PebbleDatabase) Meter(prefix string) {
+	// Initialize all the metrics collector at the requested prefix
+	db.compTimeMeter = metrics.NewRegisteredMeter(prefix+"compact/time", nil)
+	db.compReadMeter = metrics.NewRegisteredMeter(prefix+"compact/input", nil)
+	db.compWriteMeter = metrics.NewRegisteredMeter(prefix+"compact/output", nil)
+	db.diskReadMeter = metrics.NewRegisteredMeter(prefix+"disk/read", nil)
+	db.diskWriteMeter = metrics.NewRegisteredMeter(prefix+"disk/write", nil)
+	db.writeDelayMeter = metrics.NewRegisteredMeter(prefix+"compact/writedelay/duration", nil)
+	db.writeDelayNMeter = metrics.NewRegisteredMeter(prefix+"compact/writedelay/counter", nil)
+
+	// Create a quit channel for the periodic collector and run it
+	db.quitLock.Lock()
+	db.quitChan = make(chan chan error)
+	db.quitLock.Unlock()
+
+	go db.meter(3 * time.Second)
+}
+
+// meter periodically retrieves internal pebble counters and reports them to
+// the metrics subsystem. The meters are the same as the ones of LevelDB, so the
+// dashboards don't depend on the engine.
+func (db *PebbleDatabase) meter(refresh time.Duration) {
+	var (
+		errc  chan error
+		timer = time.NewTimer(refresh)
+
+		// Cumulative counters of the current and previous iteration
+		compTimes    [2]int64
+		compReads    [2]int64
+		compWrites   [2]int64
+		writeDelays  [2]int64
+		writeDelayNs [2]uint64
+		nReads       [2]int64
+		nWrites      [2]int64
+
+		lastWriteStall time.Time
+	)
+	defer timer.Stop()
+
+	// Iterate ad infinitum and collect the stats
+	for i := 1; errc == nil; i++ {
+		var (
+			compRead  int64
+			compWrite int64
+			nRead     int64
+			nWrite    int64
+
+			stats = db.db.Metrics()
+		)
+		compTimes[i%2] = db.compTime.Load()
+		writeDelays[i%2] = db.writeDelay.Load()
+		writeDelayNs[i%2] = db.writeDelayNum.Load()
+
+		for _, level := range stats.Levels {
+			compRead += int64(level.BytesRead)
+			compWrite += int64(level.BytesCompacted)
+			nWrite += int64(level.BytesCompacted) + int64(level.BytesFlushed)
+		}
+		nRead = compRead
+		nWrite += int64(stats.WAL.BytesWritten)
+
+		compReads[i%2] = compRead
+		compWrites[i%2] = compWrite
+		nReads[i%2] = nRead
+		nWrites[i%2] = nWrite
+
+		// Update all the requested meters
+		if db.compTimeMeter != nil {
+			db.compTimeMeter.Mark(compTimes[i%2] - compTimes[(i-1)%2])
+		}
+		if db.compReadMeter != nil {
+			db.compReadMeter.Mark(compReads[i%2] - compReads[(i-1)%2])
+		}
+		if db.compWriteMeter != nil {
+			db.compWriteMeter.Mark(compWrites[i%2] - compWrites[(i-1)%2])
+		}
+		if db.writeDelayNMeter != nil {
+			db.writeDelayNMeter.Mark(int64(writeDelayNs[i%2] - writeDelayNs[(i-1)%2]))
+		}
+		if db.writeDelayMeter != nil {
+			db.writeDelayMeter.Mark(writeDelays[i%2] - writeDelays[(i-1)%2])
+		}
+		// If a warning that db is performing compaction has been displayed, any subsequent
+		// warnings will be withheld for one minute not to overwhelm the user.
+		if db.writeStalled.Load() && time.Now().After(lastWriteStall.Add(writePauseWarningThrottler)) {
+			db.log.Warn("Database compacting, degraded performance")
+			lastWriteStall = time.Now()
+		}
+		if db.diskReadMeter != nil {
+			db.diskReadMeter.Mark(nReads[i%2] - nReads[(i-1)%2])
+		}
+		if db.diskWriteMeter != nil {
+			db.diskWriteMeter.Mark(nWrites[i%2] - nWrites[(i-1)%2])
+		}
+		// Sleep a bit, then repeat the stats collection
+		select {
+		case errc = <-db.quitChan:
+			// Quit requesting, stop hammering the database
+		case <-timer.C:
+			timer.Reset(refresh)
+			// Timeout, gather a new set of stats
+		}
+	}
+	errc <- nil
+}
+
+func (db *PebbleDatabase) NewBatch() Batch {
+	return &pebbleBatch{db: db.db, b: db.db.NewBatch()}
+}
+
+// pebbleBatch is a write-only batch that commits changes to its host database
+// when Write is called.
+type pebbleBatch struct {
+	db   *pebble.DB
+	b    *pebble.Batch
+	size int
+}
+
+func (b *pebbleBatch) Put(key, value []byte) error {
+	b.b.Set(key, value, nil)
+	b.size += len(value)
+	return nil
+}
+
+func (b *pebbleBatch) Delete(key []byte) error {
+	b.b.Delete(key, nil)
+	b.size++
+	return nil
+}
+
+func (b *pebbleBatch) Write() error {
+	return b.b.Commit(pebble.NoSync)
+}
+
+func (b *pebbleBatch) ValueSize() int {
+	return b.size
+}
+
+func (b *pebbleBatch) Reset() {
+	b.b.Reset()
+	b.size = 0
+}
+
+// pebbleIterator adapts a pebble iterator to the goleveldb iterator interface
+// used across the codebase. Like the goleveldb iterators, it's positioned before
+// the first entry until moved.
+type pebbleIterator struct {
+	iter     *pebble.Iterator
+	moved    bool
+	released bool
+	releaser util.Releaser
+}
+
+func (it *pebbleIterator) First() bool {
+	it.moved = true
+	return it.iter.First()
+}
+
+func (it *pebbleIterator) Last() bool {
+	it.moved = true
+	return it.iter.Last()
+}
+
+func (it *pebbleIterator) Seek(key []byte) bool {
+	it.moved = true
+	return it.iter.SeekGE(key)
+}
+
+func (it *pebbleIterator) Next() bool {
+	if !it.moved {
+		return it.First()
+	}
+	return it.iter.Next()
+}
+
+func (it *pebbleIterator) Prev() bool {
+	if !it.moved {
+		return false
+	}
+	return it.iter.Prev()
+}
+
+func (it *pebbleIterator) Valid() bool {
+	return it.moved && !it.released && it.iter.Valid()
+}
+
+// Key returns the key of the current entry. The caller must not retain it past
+// the next move of the iterator.
+func (it *pebbleIterator) Key() []byte {
+	if !it.Valid() {
+		return nil
+	}
+	return it.iter.Key()
+}
+
+// Value returns the value of the current entry. The caller must not retain it
+// past the next move of the iterator.
+func (it *pebbleIterator) Value() []byte {
+	if !it.Valid() {
+		return nil
+	}
+	return it.iter.Value()
+}
+
+func (it *pebbleIterator) Error() error {
+	return it.iter.Error()
+}
+
+func (it *pebbleIterator) Release() {
+	if it.released {
+		return
+	}
+	it.released = true
+	it.iter.Close()
+	if it.releaser != nil {
+		it.releaser.Release()
+		it.releaser = nil
+	}
+}
+
+func (it *pebbleIterator) SetReleaser(releaser util.Releaser) {
+	if it.released {
+		panic(util.ErrReleased)
+	}
+	if it.releaser != nil && releaser != nil {
+		panic(util.ErrHasReleaser)
+	}
+	it.releaser = releaser
+}
@@ -0,0 +1,157 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/BerithFoundation/berith-chain/berith"
+	"github.com/BerithFoundation/berith-chain/berith/crawler"
+	"github.com/BerithFoundation/berith-chain/cmd/utils"
+	"github.com/BerithFoundation/berith-chain/common"
+	"github.com/BerithFoundation/berith-chain/crypto"
+	"github.com/BerithFoundation/berith-chain/p2p/discover"
+	"github.com/BerithFoundation/berith-chain/p2p/enode"
+	"github.com/BerithFoundation/berith-chain/params"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var (
+	crawlTimeoutFlag = cli.DurationFlag{
+		Name:  "timeout",
+		Usage: "Time limit for the crawl",
+		Value: 30 * time.Minute,
+	}
+	crawlListenFlag = cli.StringFlag{
+		Name:  "addr",
+		Usage: "UDP listening address of the discovery",
+		Value: "0.0.0.0:0",
+	}
+
+	crawlCommand = cli.Command{
+		Name:      "crawl",
+		Usage:     "Update a nodes.json file with the live nodes of the network",
+		ArgsUsage: "<nodes.json>",
+		Category:  "MISCELLANEOUS COMMANDS",
+		Action:    utils.MigrateFlags(crawlNodes),
+		Flags: []cli.Flag{
+			crawlTimeoutFlag,
+			crawlListenFlag,
+			utils.BootnodesFlag,
+			utils.TestnetFlag,
+		},
+		Description: `
+berith crawl <nodes.json>
+
+walks the discovery network, starting out from the bootstrap nodes and the
+nodes of the file. Every node answering its record request is probed over RLPx
+to record its client version, protocols and, if it runs berith, the network,
+genesis, head block and fork ID it announces. Nodes which stop responding lose
+their score and are dropped once it reaches zero.
+
+The updated set is written back to the file, which can be used as the nodes.json
+of a 'berith dnsdisc sign' tree directory.`,
+	}
+)
+
+// crawlNodes revalidates the nodes of a nodes.json file and adds the nodes
+// found on the discovery network.
+func crawlNodes(ctx *cli.Context) error {
+	if ctx.NArg() < 1 {
+		return fmt.Errorf("need nodes file as argument")
+	}
+	nodesFile := ctx.Args().First()
+
+	inputSet := make(crawler.NodeSet)
+	if common.FileExist(nodesFile) {
+		var err error
+		if inputSet, err = crawler.LoadNodesJSON(nodesFile); err != nil {
+			return err
+		}
+	}
+	if ctx.Bool(utils.TestnetFlag.Name) {
+		berith.ProtocolName = "berith_testnet"
+	}
+	disc, err := startDiscovery(ctx)
+	if err != nil {
+		return err
+	}
+	defer disc.Close()
+
+	c, err := crawler.New(inputSet, crawler.Config{Resolver: disc}, disc.RandomNodes())
+	if err != nil {
+		return err
+	}
+	output := c.Run(ctx.Duration(crawlTimeoutFlag.Name))
+	return crawler.WriteNodesJSON(nodesFile, output)
+}
+
+// startDiscovery joins the discovery network with a throwaway node key.
+func startDiscovery(ctx *cli.Context) (*discover.Table, error) {
+	bootnodes, err := crawlBootnodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	addr, err := net.ResolveUDPAddr("udp", ctx.String(crawlListenFlag.Name))
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	db, _ := enode.OpenDB("")
+	ln := enode.NewLocalNode(db, key)
+	cfg := discover.Config{
+		PrivateKey: key,
+		Bootnodes:  bootnodes,
+	}
+	tab, err := discover.ListenUDP(conn, ln, cfg)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tab, nil
+}
+
+// crawlBootnodes returns the bootstrap nodes given on the command line, or the
+// default ones of the selected network.
+func crawlBootnodes(ctx *cli.Context) ([]*enode.Node, error) {
+	urls := params.MainnetBootnodes
+	switch {
+	case ctx.IsSet(utils.BootnodesFlag.Name):
+		urls = strings.Split(ctx.String(utils.BootnodesFlag.Name), ",")
+	case ctx.Bool(utils.TestnetFlag.Name):
+		urls = params.TestnetBootnodes
+	}
+	nodes := make([]*enode.Node, 0, len(urls))
+	for _, url := range urls {
+		n, err := enode.Parse(enode.ValidSchemes, url)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bootstrap node %q: %v", url, err)
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes, nil
+}
@@ -0,0 +1,469 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/BerithFoundation/berith-chain/berithdb"
+	"github.com/BerithFoundation/berith-chain/cmd/utils"
+	"github.com/BerithFoundation/berith-chain/common"
+	"github.com/BerithFoundation/berith-chain/core/rawdb"
+	"github.com/BerithFoundation/berith-chain/log"
+	"github.com/BerithFoundation/berith-chain/node"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var (
+	stakingDBFlag = cli.BoolFlag{
+		Name:  "staking",
+		Usage: "Operate on the staking database instead of the chain database",
+	}
+	dbFlags = []cli.Flag{
+		utils.DataDirFlag,
+		utils.AncientFlag,
+		utils.DBEngineFlag,
+		utils.CacheFlag,
+	}
+
+	dbCommand = cli.Command{
+		Name:      "db",
+		Usage:     "Low level database operations",
+		ArgsUsage: "",
+		Category:  "DATABASE COMMANDS",
+		Description: `
+The db command groups the low level operations on the key-value stores of the
+node. The node must be stopped while running them.`,
+		Subcommands: []cli.Command{
+			{
+				Name:      "inspect",
+				Usage:     "Inspect the storage size for each type of data in the databases",
+				ArgsUsage: " ",
+				Action:    utils.MigrateFlags(inspectDB),
+				Category:  "DATABASE COMMANDS",
+				Flags:     dbFlags,
+				Description: `
+berith db inspect
+
+iterates over the entire chain database and reports the number of entries and
+their total size for every category of data (headers, bodies, receipts, trie
+nodes, ...), followed by the ancient store and the staking database.`,
+			},
+			{
+				Name:      "stats",
+				Usage:     "Print the internal statistics of the databases",
+				ArgsUsage: " ",
+				Action:    utils.MigrateFlags(dbStats),
+				Category:  "DATABASE COMMANDS",
+				Flags:     dbFlags,
+			},
+			{
+				Name:      "compact",
+				Usage:     "Compact the chain and staking databases",
+				ArgsUsage: " ",
+				Action:    utils.MigrateFlags(dbCompact),
+				Category:  "DATABASE COMMANDS",
+				Flags:     dbFlags,
+			},
+			{
+				Name:      "get",
+				Usage:     "Show the value of a database key",
+				ArgsUsage: "<hex-encoded key>",
+				Action:    utils.MigrateFlags(dbGet),
+				Category:  "DATABASE COMMANDS",
+				Flags:     append(dbFlags, stakingDBFlag),
+			},
+			{
+				Name:      "put",
+				Usage:     "Set the value of a database key (WARNING: may corrupt your database)",
+				ArgsUsage: "<hex-encoded key> <hex-encoded value>",
+				Action:    utils.MigrateFlags(dbPut),
+				Category:  "DATABASE COMMANDS",
+				Flags:     append(dbFlags, stakingDBFlag),
+			},
+			{
+				Name:      "delete",
+				Usage:     "Delete a database key (WARNING: may corrupt your database)",
+				ArgsUsage: "<hex-encoded key>",
+				Action:    utils.MigrateFlags(dbDelete),
+				Category:  "DATABASE COMMANDS",
+				Flags:     append(dbFlags, stakingDBFlag),
+			},
+			{
+				Name:      "convert",
+				Usage:     "Convert the chain and staking databases to another engine",
+				ArgsUsage: " ",
+				Action:    utils.MigrateFlags(convertDB),
+				Category:  "DATABASE COMMANDS",
+				Flags:     dbFlags,
+				Description: `
+berith db convert --db.engine pebble
+
+copies the content of the chain database and the staking database into a new
+key-value store of the engine given by --db.engine and replaces the original
+databases with them. The ancient store is engine independent and is moved into
+the new chain database as is.
+
+The new databases are built next to the original ones, so the conversion needs
+as much free disk space as the databases take up. An interrupted conversion is
+restarted from scratch by running the command again.`,
+			},
+		},
+	}
+)
+
+// openStakingDB opens the key-value store of the staking database. Nil is
+// returned if the database doesn't exist.
+func openStakingDB(ctx *cli.Context, stack *node.Node) berithdb.Store {
+	path := stack.ResolvePath("stakingDB")
+	if berithdb.DetectEngine(path) == "" {
+		return nil
+	}
+	db, err := berithdb.Open(stack.Config().DBEngine, path, ctx.GlobalInt(utils.CacheFlag.Name)/2, 256)
+	if err != nil {
+		utils.Fatalf("Could not open staking database: %v", err)
+	}
+	return db
+}
+
+// openTargetDB opens the database the get, put and delete commands operate on.
+func openTargetDB(ctx *cli.Context) berithdb.Database {
+	stack, _ := makeConfigNode(ctx)
+	if !ctx.Bool(stakingDBFlag.Name) {
+		return utils.MakeChainDatabase(ctx, stack)
+	}
+	db := openStakingDB(ctx, stack)
+	if db == nil {
+		utils.Fatalf("Staking database doesn't exist")
+	}
+	return db
+}
+
+// parseHexArg decodes a hex command line argument, with or without 0x prefix.
+func parseHexArg(arg string) []byte {
+	blob, err := hex.DecodeString(strings.TrimPrefix(arg, "0x"))
+	if err != nil {
+		utils.Fatalf("Invalid hex argument %q: %v", arg, err)
+	}
+	return blob
+}
+
+// inspectDB reports the number of entries and their size per data category.
+func inspectDB(ctx *cli.Context) error {
+	stack, _ := makeConfigNode(ctx)
+	chainDb := utils.MakeChainDatabase(ctx, stack)
+	defer chainDb.Close()
+
+	stats, err := rawdb.InspectDatabase(chainDb)
+	if err != nil {
+		utils.Fatalf("Failed to inspect database: %v", err)
+	}
+	if stakingDB := openStakingDB(ctx, stack); stakingDB != nil {
+		stat := &rawdb.DatabaseStat{Category: "Staking database"}
+		it := stakingDB.NewIteratorWithPrefix(nil)
+		for it.Next() {
+			stat.Count++
+			stat.Size += common.StorageSize(len(it.Key()) + len(it.Value()))
+		}
+		it.Release()
+		stakingDB.Close()
+
+		stats = append(stats, stat)
+	}
+	var (
+		total common.StorageSize
+		w     = tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+	)
+	fmt.Fprintln(w, "Category\tEntries\tSize\t")
+	for _, stat := range stats {
+		fmt.Fprintf(w, "%s\t%d\t%v\t\n", stat.Category, stat.Count, stat.Size)
+		total += stat.Size
+	}
+	fmt.Fprintf(w, "Total\t\t%v\t\n", total)
+	return w.Flush()
+}
+
+// dbStats prints the internal statistics of the chain and staking databases.
+func dbStats(ctx *cli.Context) error {
+	stack, _ := makeConfigNode(ctx)
+	chainDb := utils.MakeChainDatabase(ctx, stack)
+	defer chainDb.Close()
+
+	printStoreStats("chaindata", rawdb.KeyValueStore(chainDb))
+	if stakingDB := openStakingDB(ctx, stack); stakingDB != nil {
+		printStoreStats("stakingDB", stakingDB)
+		stakingDB.Close()
+	}
+	return nil
+}
+
+// printStoreStats prints the internal statistics of a key-value store.
+func printStoreStats(name string, db berithdb.Database) {
+	store, ok := db.(berithdb.Store)
+	if !ok {
+		return
+	}
+	properties := []string{""}
+	if _, ok := store.(*berithdb.LDBDatabase); ok {
+		properties = []string{"leveldb.stats", "leveldb.iostats"}
+	}
+	fmt.Printf("%s (%s)\n", name, store.Path())
+	for _, property := range properties {
+		stats, err := store.Stat(property)
+		if err != nil {
+			log.Error("Failed to retrieve database stats", "database", name, "err", err)
+			continue
+		}
+		fmt.Println(stats)
+	}
+}
+
+// dbCompact compacts the entire chain and staking databases.
+func dbCompact(ctx *cli.Context) error {
+	stack, _ := makeConfigNode(ctx)
+	chainDb := utils.MakeChainDatabase(ctx, stack)
+	defer chainDb.Close()
+
+	start := time.Now()
+	fmt.Println("Compacting chain database...")
+	chainDb.Compact()
+	fmt.Printf("Compaction done in %v.\n\n", time.Since(start))
+
+	if stakingDB := openStakingDB(ctx, stack); stakingDB != nil {
+		start = time.Now()
+		fmt.Println("Compacting staking database...")
+		stakingDB.Compact()
+		stakingDB.Close()
+		fmt.Printf("Compaction done in %v.\n\n", time.Since(start))
+	}
+	return nil
+}
+
+// dbGet shows the value of a single database key.
+func dbGet(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		utils.Fatalf("This command requires the key as argument.")
+	}
+	key := parseHexArg(ctx.Args().First())
+
+	db := openTargetDB(ctx)
+	defer db.Close()
+
+	value, err := db.Get(key)
+	if err != nil {
+		utils.Fatalf("Failed to retrieve key %#x: %v", key, err)
+	}
+	fmt.Printf("key %#x: %#x\n", key, value)
+	return nil
+}
+
+// dbPut sets the value of a single database key.
+func dbPut(ctx *cli.Context) error {
+	if ctx.NArg() != 2 {
+		utils.Fatalf("This command requires the key and the value as arguments.")
+	}
+	var (
+		key   = parseHexArg(ctx.Args().Get(0))
+		value = parseHexArg(ctx.Args().Get(1))
+	)
+	db := openTargetDB(ctx)
+	defer db.Close()
+
+	if previous, err := db.Get(key); err == nil {
+		fmt.Printf("Previous value: %#x\n", previous)
+	}
+	if err := db.Put(key, value); err != nil {
+		utils.Fatalf("Failed to write key %#x: %v", key, err)
+	}
+	log.Info("Updated database key", "key", fmt.Sprintf("%#x", key), "size", len(value))
+	return nil
+}
+
+// dbDelete deletes a single database key.
+func dbDelete(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		utils.Fatalf("This command requires the key as argument.")
+	}
+	key := parseHexArg(ctx.Args().First())
+
+	db := openTargetDB(ctx)
+	defer db.Close()
+
+	if previous, err := db.Get(key); err == nil {
+		fmt.Printf("Previous value: %#x\n", previous)
+	}
+	if err := db.Delete(key); err != nil {
+		utils.Fatalf("Failed to delete key %#x: %v", key, err)
+	}
+	log.Info("Deleted database key", "key", fmt.Sprintf("%#x", key))
+	return nil
+}
+
+// convertDB copies the chain and staking databases into key-value stores of the
+// requested engine.
+func convertDB(ctx *cli.Context) error {
+	engine := ctx.GlobalString(utils.DBEngineFlag.Name)
+	if engine == "" {
+		utils.Fatalf("The target engine must be given with --%s", utils.DBEngineFlag.Name)
+	}
+	stack, cfg := makeConfigNode(ctx)
+
+	// The ancient store is resolved the same way as when opening the database
+	chaindata := stack.ResolvePath("chaindata")
+	freezer := cfg.Ber.DatabaseFreezer
+	switch {
+	case freezer == "":
+		freezer = filepath.Join(chaindata, "ancient")
+	case !filepath.IsAbs(freezer):
+		freezer = filepath.Join(chaindata, freezer)
+	}
+	cache := ctx.GlobalInt(utils.CacheFlag.Name) / 2
+
+	if err := convertDatabase(chaindata, freezer, engine, cache); err != nil {
+		utils.Fatalf("Failed to convert chain database: %v", err)
+	}
+	if err := convertDatabase(stack.ResolvePath("stakingDB"), "", engine, cache); err != nil {
+		utils.Fatalf("Failed to convert staking database: %v", err)
+	}
+	return nil
+}
+
+// convertDatabase copies the key-value store at path into a new one of the given
+// engine and swaps them. An ancient store located within the original database
+// directory is moved over to the new one.
+func convertDatabase(path string, freezer string, engine string, cache int) error {
+	var (
+		logger = log.New("database", path)
+		temp   = path + ".converting"
+		old    = path + ".old"
+	)
+	// The ancient store is only moved along if it's kept within the database
+	var ancient string
+	if freezer != "" {
+		if rel, err := filepath.Rel(path, freezer); err == nil && !strings.HasPrefix(rel, "..") {
+			ancient = rel
+		}
+	}
+	// Finish the swap if a previous conversion was interrupted in the middle of it
+	if common.FileExist(old) {
+		logger.Info("Finishing interrupted database conversion")
+		if err := swapDatabase(path, temp, old, ancient); err != nil {
+			return err
+		}
+	}
+	existing := berithdb.DetectEngine(path)
+	switch existing {
+	case "":
+		logger.Info("Database doesn't exist, skipping")
+		return nil
+	case engine:
+		logger.Info("Database already uses the requested engine", "engine", engine)
+		return nil
+	}
+	// Drop the leftovers of an interrupted copy and copy all the content over
+	if err := os.RemoveAll(temp); err != nil {
+		return err
+	}
+	src, err := berithdb.Open(existing, path, cache, 256)
+	if err != nil {
+		return err
+	}
+	dst, err := berithdb.Open(engine, temp, cache, 256)
+	if err != nil {
+		src.Close()
+		return err
+	}
+	logger.Info("Converting database", "from", existing, "to", engine)
+	err = copyDatabase(logger, src, dst)
+	src.Close()
+	dst.Close()
+	if err != nil {
+		return err
+	}
+	// Swap the databases, the original one is only deleted once replaced
+	if err := os.Rename(path, old); err != nil {
+		return err
+	}
+	return swapDatabase(path, temp, old, ancient)
+}
+
+// swapDatabase moves the converted database into place after the original one
+// was moved aside, carries the ancient store over and deletes the original. It
+// is safe to be called again if interrupted.
+func swapDatabase(path string, temp string, old string, ancient string) error {
+	if !common.FileExist(path) {
+		if err := os.Rename(temp, path); err != nil {
+			return err
+		}
+	}
+	if ancient != "" && common.FileExist(filepath.Join(old, ancient)) {
+		target := filepath.Join(path, ancient)
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		if err := os.Rename(filepath.Join(old, ancient), target); err != nil {
+			return err
+		}
+	}
+	return os.RemoveAll(old)
+}
+
+// copyDatabase copies all the entries of a key-value store into another one.
+func copyDatabase(logger log.Logger, src berithdb.Store, dst berithdb.Store) error {
+	var (
+		start  = time.Now()
+		logged = time.Now()
+		batch  = dst.NewBatch()
+		count  int
+		size   common.StorageSize
+	)
+	it := src.NewIteratorWithPrefix(nil)
+	defer it.Release()
+
+	for it.Next() {
+		if err := batch.Put(it.Key(), it.Value()); err != nil {
+			return err
+		}
+		count++
+		size += common.StorageSize(len(it.Key()) + len(it.Value()))
+
+		if batch.ValueSize() >= berithdb.IdealBatchSize {
+			if err := batch.Write(); err != nil {
+				return err
+			}
+			batch.Reset()
+		}
+		if time.Since(logged) > 8*time.Second {
+			logger.Info("Converting database", "entries", count, "size", size, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+	}
+	if err := it.Error(); err != nil {
+		return err
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	logger.Info("Converted database", "entries", count, "size", size, "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
@@ -0,0 +1,286 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/BerithFoundation/berith-chain/accounts/keystore"
+	"github.com/BerithFoundation/berith-chain/berith/crawler"
+	"github.com/BerithFoundation/berith-chain/cmd/utils"
+	"github.com/BerithFoundation/berith-chain/common"
+	"github.com/BerithFoundation/berith-chain/p2p/dnsdisc"
+	"github.com/BerithFoundation/berith-chain/p2p/enode"
+	"gopkg.in/urfave/cli.v1"
+)
+
+const jsonIndent = "    "
+
+var (
+	dnsDomainFlag = cli.StringFlag{
+		Name:  "domain",
+		Usage: "Domain name of the tree",
+	}
+	dnsSeqFlag = cli.UintFlag{
+		Name:  "seq",
+		Usage: "New sequence number of the tree",
+	}
+
+	dnsCommand = cli.Command{
+		Name:     "dnsdisc",
+		Usage:    "DNS node list operations (EIP-1459)",
+		Category: "MISCELLANEOUS COMMANDS",
+		Description: `
+The dnsdisc command builds the signed node trees published in DNS and queried by
+the nodes started with --discovery.dns.
+
+A tree is defined by a directory holding two files:
+
+    enrtree-info.json    -- sequence number, signature and links to other trees
+    nodes.json           -- the node records, e.g. as written by 'berith crawl'`,
+		Subcommands: []cli.Command{
+			{
+				Name:      "sign",
+				Usage:     "Sign a DNS discovery tree",
+				ArgsUsage: "<tree-directory> <key-file>",
+				Action:    utils.MigrateFlags(dnsSign),
+				Flags:     []cli.Flag{dnsDomainFlag, dnsSeqFlag, utils.PasswordFileFlag},
+				Description: `
+berith dnsdisc sign <tree-directory> <key-file>
+
+builds the tree out of the definition directory, signs its root with the key of
+the keystore file and stores the resulting enrtree:// URL, sequence number and
+signature in enrtree-info.json. The sequence number is bumped unless --seq is
+given.`,
+			},
+			{
+				Name:      "to-txt",
+				Usage:     "Create the DNS TXT records of a signed discovery tree",
+				ArgsUsage: "<tree-directory> [<output-file>]",
+				Action:    utils.MigrateFlags(dnsToTXT),
+				Description: `
+berith dnsdisc to-txt <tree-directory> [<output-file>]
+
+writes the TXT records of a signed tree as a JSON object from record names to
+record values, to stdout by default.`,
+			},
+		},
+	}
+)
+
+// dnsSign applies signatures to a tree directory.
+func dnsSign(ctx *cli.Context) error {
+	if ctx.NArg() < 2 {
+		return fmt.Errorf("need tree definition directory and key file as arguments")
+	}
+	var (
+		defdir  = ctx.Args().Get(0)
+		keyfile = ctx.Args().Get(1)
+		domain  = filepath.Base(defdir)
+	)
+	def, err := loadTreeDefinition(defdir)
+	if err != nil {
+		return err
+	}
+	if def.Meta.URL != "" {
+		d, _, err := dnsdisc.ParseURL(def.Meta.URL)
+		if err != nil {
+			return fmt.Errorf("invalid 'url' field: %v", err)
+		}
+		domain = d
+	}
+	if ctx.IsSet(dnsDomainFlag.Name) {
+		domain = ctx.String(dnsDomainFlag.Name)
+	}
+	if ctx.IsSet(dnsSeqFlag.Name) {
+		def.Meta.Seq = ctx.Uint(dnsSeqFlag.Name)
+	} else {
+		def.Meta.Seq++ // Auto-bump sequence number if not supplied via flag.
+	}
+	t, err := dnsdisc.MakeTree(def.Meta.Seq, def.Nodes, def.Meta.Links)
+	if err != nil {
+		return err
+	}
+	key, err := loadSigningKey(keyfile, utils.MakePasswordList(ctx))
+	if err != nil {
+		return err
+	}
+	url, err := t.Sign(key, domain)
+	if err != nil {
+		return fmt.Errorf("can't sign: %v", err)
+	}
+	def = treeToDefinition(url, t)
+	def.Meta.LastModified = time.Now()
+	if err := writeTreeMetadata(defdir, def); err != nil {
+		return err
+	}
+	fmt.Println(url)
+	return nil
+}
+
+// dnsToTXT writes the TXT records of a signed tree directory.
+func dnsToTXT(ctx *cli.Context) error {
+	if ctx.NArg() < 1 {
+		return fmt.Errorf("need tree definition directory as argument")
+	}
+	output := ctx.Args().Get(1)
+	if output == "" {
+		output = "-" // default to stdout
+	}
+	domain, t, err := loadTreeDefinitionForExport(ctx.Args().Get(0))
+	if err != nil {
+		return err
+	}
+	return writeTXTJSON(output, t.ToTXT(domain))
+}
+
+// loadSigningKey loads a private key in Berith keystore format.
+func loadSigningKey(keyfile string, passwords []string) (*ecdsa.PrivateKey, error) {
+	keyjson, err := ioutil.ReadFile(keyfile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the keyfile at '%s': %v", keyfile, err)
+	}
+	password := getPassPhrase("Please enter the password for '"+keyfile+"'.", false, 0, passwords)
+	key, err := keystore.DecryptKey(keyjson, password)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting key: %v", err)
+	}
+	return key.PrivateKey, nil
+}
+
+// dnsDefinition is the 'definition' format of a DNS node tree on disk: a
+// directory containing the tree metadata in enrtree-info.json and the nodes
+// in nodes.json. The format exists because it's convenient to edit.
+type dnsDefinition struct {
+	Meta  dnsMetaJSON
+	Nodes []*enode.Node
+}
+
+type dnsMetaJSON struct {
+	URL          string    `json:"url,omitempty"`
+	Seq          uint      `json:"seq"`
+	Sig          string    `json:"signature,omitempty"`
+	Links        []string  `json:"links"`
+	LastModified time.Time `json:"lastModified"`
+}
+
+func treeToDefinition(url string, t *dnsdisc.Tree) *dnsDefinition {
+	meta := dnsMetaJSON{
+		URL:   url,
+		Seq:   t.Seq(),
+		Sig:   t.Signature(),
+		Links: t.Links(),
+	}
+	if meta.Links == nil {
+		meta.Links = []string{}
+	}
+	return &dnsDefinition{Meta: meta, Nodes: t.Nodes()}
+}
+
+// loadTreeDefinition loads a directory in 'definition' format.
+func loadTreeDefinition(directory string) (*dnsDefinition, error) {
+	metaFile, nodesFile := treeDefinitionFiles(directory)
+	var def dnsDefinition
+	err := common.LoadJSON(metaFile, &def.Meta)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if def.Meta.Links == nil {
+		def.Meta.Links = []string{}
+	}
+	// Check link syntax.
+	for _, link := range def.Meta.Links {
+		if _, _, err := dnsdisc.ParseURL(link); err != nil {
+			return nil, fmt.Errorf("invalid link %q: %v", link, err)
+		}
+	}
+	// Check/convert nodes.
+	nodes, err := crawler.LoadNodesJSON(nodesFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := nodes.Verify(); err != nil {
+		return nil, err
+	}
+	def.Nodes = nodes.Nodes()
+	return &def, nil
+}
+
+// loadTreeDefinitionForExport loads a DNS tree and ensures it is signed.
+func loadTreeDefinitionForExport(dir string) (domain string, t *dnsdisc.Tree, err error) {
+	metaFile, _ := treeDefinitionFiles(dir)
+	def, err := loadTreeDefinition(dir)
+	if err != nil {
+		return "", nil, err
+	}
+	if def.Meta.URL == "" {
+		return "", nil, fmt.Errorf("missing 'url' field in %v", metaFile)
+	}
+	domain, pubkey, err := dnsdisc.ParseURL(def.Meta.URL)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid 'url' field in %v: %v", metaFile, err)
+	}
+	if t, err = dnsdisc.MakeTree(def.Meta.Seq, def.Nodes, def.Meta.Links); err != nil {
+		return "", nil, err
+	}
+	if def.Meta.Sig == "" {
+		return "", nil, fmt.Errorf("missing signature, run 'berith dnsdisc sign' first")
+	}
+	if err := t.SetSignature(pubkey, def.Meta.Sig); err != nil {
+		return "", nil, fmt.Errorf("invalid signature on tree, run 'berith dnsdisc sign' to update it")
+	}
+	return domain, t, nil
+}
+
+// writeTreeMetadata writes a DNS node tree metadata file to the given directory.
+func writeTreeMetadata(directory string, def *dnsDefinition) error {
+	metaJSON, err := json.MarshalIndent(&def.Meta, "", jsonIndent)
+	if err != nil {
+		return err
+	}
+	if err := os.Mkdir(directory, 0744); err != nil && !os.IsExist(err) {
+		return err
+	}
+	metaFile, _ := treeDefinitionFiles(directory)
+	return ioutil.WriteFile(metaFile, metaJSON, 0644)
+}
+
+func treeDefinitionFiles(directory string) (string, string) {
+	meta := filepath.Join(directory, "enrtree-info.json")
+	nodes := filepath.Join(directory, "nodes.json")
+	return meta, nodes
+}
+
+// writeTXTJSON writes TXT records in JSON format.
+func writeTXTJSON(file string, txt map[string]string) error {
+	txtJSON, err := json.MarshalIndent(txt, "", jsonIndent)
+	if err != nil {
+		return err
+	}
+	if file == "-" {
+		os.Stdout.Write(txtJSON)
+		fmt.Println()
+		return nil
+	}
+	return ioutil.WriteFile(file, txtJSON, 0644)
+}
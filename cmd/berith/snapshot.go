@@ -0,0 +1,90 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"github.com/BerithFoundation/berith-chain/berith/staking"
+	"github.com/BerithFoundation/berith-chain/cmd/utils"
+	"github.com/BerithFoundation/berith-chain/core/state/pruner"
+	"github.com/BerithFoundation/berith-chain/log"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var (
+	snapshotCommand = cli.Command{
+		Name:      "snapshot",
+		Usage:     "A set of commands based on the state of the chain",
+		ArgsUsage: "",
+		Category:  "BLOCKCHAIN COMMANDS",
+		Description: `
+The snapshot command groups the offline maintenance tools operating on the
+state of the chain.`,
+		Subcommands: []cli.Command{
+			{
+				Name:      "prune-state",
+				Usage:     "Prune stale state data and staker entries",
+				ArgsUsage: " ",
+				Action:    utils.MigrateFlags(pruneState),
+				Category:  "BLOCKCHAIN COMMANDS",
+				Flags: []cli.Flag{
+					utils.DataDirFlag,
+					utils.AncientFlag,
+					utils.DBEngineFlag,
+					utils.CacheFlag,
+					utils.BloomFilterSizeFlag,
+				},
+				Description: `
+berith snapshot prune-state
+
+will prune the historical state data with the help of a bloom filter. All the
+trie nodes and contract codes not reachable from the states of the recent 128
+blocks, the genesis or the state snapshot are deleted from the database, along
+with the staker entries of the blocks which are not canonical or older than two
+BSRR epochs.
+
+The bloom filter is persisted into the data directory before anything gets
+deleted, so an interrupted pruning is resumed by running the command again.
+The node must be stopped while pruning.`,
+			},
+		},
+	}
+)
+
+// pruneState deletes the stale state data and staker entries from the databases
+// of a stopped node.
+func pruneState(ctx *cli.Context) error {
+	stack := makeFullNode(ctx)
+	chainDb := utils.MakeChainDatabase(ctx, stack)
+	defer chainDb.Close()
+
+	stakingDB := &staking.StakingDB{Engine: stack.Config().DBEngine}
+	if err := stakingDB.CreateDB(stack.ResolvePath("stakingDB"), staking.NewStakers); err != nil {
+		utils.Fatalf("Failed to open staking database: %v", err)
+	}
+	defer stakingDB.Close()
+
+	pruner, err := pruner.NewPruner(chainDb, stakingDB, stack.InstanceDir(), ctx.GlobalUint64(utils.BloomFilterSizeFlag.Name))
+	if err != nil {
+		log.Error("Failed to open state pruner", "err", err)
+		return err
+	}
+	if err := pruner.Prune(); err != nil {
+		log.Error("Failed to prune state", "err", err)
+		return err
+	}
+	return nil
+}
@@ -69,12 +69,17 @@ var AppHelpFlagGroups = []flagGroup{
 		Flags: []cli.Flag{
 			configFileFlag,
 			utils.DataDirFlag,
+			utils.AncientFlag,
+			utils.DBEngineFlag,
 			utils.KeyStoreDirFlag,
 			utils.NoUSBFlag,
 			utils.NetworkIdFlag,
 			utils.TestnetFlag,
 			utils.SyncModeFlag,
 			utils.GCModeFlag,
+			utils.TxLookupLimitFlag,
+			utils.SupplyIndexFlag,
+			utils.StateReexecFlag,
 			utils.BerithStatsURLFlag,
 			utils.IdentityFlag,
 			utils.LightServFlag,
@@ -114,6 +119,8 @@ var AppHelpFlagGroups = []flagGroup{
 			utils.TxPoolAccountQueueFlag,
 			utils.TxPoolGlobalQueueFlag,
 			utils.TxPoolLifetimeFlag,
+			utils.TxPoolPrivateLifetimeFlag,
+			utils.TxPoolPrivatePeersFlag,
 		},
 	},
 	{
@@ -123,6 +130,7 @@ var AppHelpFlagGroups = []flagGroup{
 			utils.CacheDatabaseFlag,
 			utils.CacheTrieFlag,
 			utils.CacheGCFlag,
+			utils.CacheSnapshotFlag,
 			utils.TrieCacheGenFlag,
 		},
 	},
@@ -167,7 +175,10 @@ var AppHelpFlagGroups = []flagGroup{
 			utils.NATFlag,
 			utils.NoDiscoverFlag,
 			utils.DiscoveryV5Flag,
+			utils.DNSDiscoveryFlag,
 			utils.NetrestrictFlag,
+			utils.SentryNodesFlag,
+			utils.SentryGuardFlag,
 			utils.NodeKeyFileFlag,
 			utils.NodeKeyHexFlag,
 		},
@@ -129,6 +129,18 @@ func (w *wizard) makeGenesis() {
 	fmt.Println("Specify hard fork block number for BIP5 (default = 0)")
 	genesis.Config.BIP5Block = w.readDefaultBigInt(big.NewInt(0))
 
+	fmt.Println()
+	fmt.Println("Specify hard fork block number for BIP6 (default = 0)")
+	genesis.Config.BIP6Block = w.readDefaultBigInt(big.NewInt(0))
+
+	fmt.Println()
+	fmt.Println("Specify hard fork block number for BIP7 (default = 0)")
+	genesis.Config.BIP7Block = w.readDefaultBigInt(big.NewInt(0))
+
+	fmt.Println()
+	fmt.Println("Specify hard fork block number for BIP8 (default = 0)")
+	genesis.Config.BIP8Block = w.readDefaultBigInt(big.NewInt(0))
+
 	// All done.
 	log.Info("Configured new genesis block")
 	w.conf.Genesis = genesis
@@ -121,6 +121,14 @@ var (
 		Usage: "Data directory for the databases and keystore",
 		Value: DirectoryString{node.DefaultDataDir()},
 	}
+	AncientFlag = DirectoryFlag{
+		Name:  "datadir.ancient",
+		Usage: "Data directory for ancient chain segments (default = inside chaindata)",
+	}
+	DBEngineFlag = cli.StringFlag{
+		Name:  "db.engine",
+		Usage: "Backing database implementation to use ('leveldb' or 'pebble', default = engine of the existing database)",
+	}
 	KeyStoreDirFlag = DirectoryFlag{
 		Name:  "keystore",
 		Usage: "Directory for the keystore (default = inside the datadir)",
@@ -162,7 +170,7 @@ var (
 	defaultSyncMode = berith.DefaultConfig.SyncMode
 	SyncModeFlag    = TextMarshalerFlag{
 		Name:  "syncmode",
-		Usage: `Blockchain sync mode ("fast", "full", or "light")`,
+		Usage: `Blockchain sync mode ("fast", "full", "snap" or "light")`,
 		Value: &defaultSyncMode,
 	}
 	GCModeFlag = cli.StringFlag{
@@ -170,6 +178,36 @@ var (
 		Usage: `Blockchain garbage collection mode ("full", "archive")`,
 		Value: "archive",
 	}
+	TxLookupLimitFlag = cli.Uint64Flag{
+		Name:  "txlookuplimit",
+		Usage: "Number of recent blocks to maintain transaction indexes for (default = index all blocks)",
+		Value: 0,
+	}
+	SupplyIndexFlag = cli.BoolFlag{
+		Name:  "supplyindex",
+		Usage: "Maintain an index of the supply and issuance of every block (requires --gcmode=archive)",
+	}
+	StateReexecFlag = cli.Uint64Flag{
+		Name:  "state.reexec",
+		Usage: "Maximum number of blocks to re-execute when regenerating a pruned historical state (0 = disabled)",
+		Value: berith.DefaultConfig.StateReexec,
+	}
+	IterativeOutputFlag = cli.BoolFlag{
+		Name:  "iterative",
+		Usage: "Print streaming JSON iteratively, delimited by newlines",
+	}
+	ExcludeStorageFlag = cli.BoolFlag{
+		Name:  "nostorage",
+		Usage: "Exclude storage entries (save db lookups)",
+	}
+	ExcludeCodeFlag = cli.BoolFlag{
+		Name:  "nocode",
+		Usage: "Exclude contract code (save db lookups)",
+	}
+	StakersOnlyFlag = cli.BoolFlag{
+		Name:  "stakers-only",
+		Usage: "Only dump the accounts with a non-zero stake balance",
+	}
 	LightServFlag = cli.IntFlag{
 		Name:  "lightserv",
 		Usage: "Maximum percentage of time allowed for serving LES requests (0-90)",
@@ -242,6 +280,15 @@ var (
 		Usage: "Maximum amount of time non-executable transaction are queued",
 		Value: berith.DefaultConfig.TxPool.Lifetime,
 	}
+	TxPoolPrivateLifetimeFlag = cli.Uint64Flag{
+		Name:  "txpool.privatelifetime",
+		Usage: "Number of blocks a private transaction is withheld from public broadcast",
+		Value: berith.DefaultConfig.TxPool.PrivateLifetime,
+	}
+	TxPoolPrivatePeersFlag = cli.StringFlag{
+		Name:  "txpool.privatepeers",
+		Usage: "Comma separated enode URLs of trusted peers receiving private transactions",
+	}
 	// Performance tuning settings
 	CacheFlag = cli.IntFlag{
 		Name:  "cache",
@@ -263,6 +310,16 @@ var (
 		Usage: "Percentage of cache memory allowance to use for trie pruning",
 		Value: 25,
 	}
+	CacheSnapshotFlag = cli.IntFlag{
+		Name:  "cache.snapshot",
+		Usage: "Percentage of cache memory allowance to use for state snapshot caching (0 disables the snapshot)",
+		Value: 10,
+	}
+	BloomFilterSizeFlag = cli.Uint64Flag{
+		Name:  "bloomfilter.size",
+		Usage: "Megabytes of memory allocated to the bloom filter of the state pruning",
+		Value: 2048,
+	}
 	TrieCacheGenFlag = cli.IntFlag{
 		Name:  "trie-cache-gens",
 		Usage: "Number of trie node generations to keep in memory",
@@ -495,10 +552,22 @@ var (
 		Name:  "v5disc",
 		Usage: "Enables the experimental RLPx V5 (Topic Discovery) mechanism",
 	}
+	DNSDiscoveryFlag = cli.StringFlag{
+		Name:  "discovery.dns",
+		Usage: "Comma separated enrtree:// URLs of the DNS node lists used for peer discovery",
+	}
 	NetrestrictFlag = cli.StringFlag{
 		Name:  "netrestrict",
 		Usage: "Restricts network communication to the given IP networks (CIDR masks)",
 	}
+	SentryNodesFlag = cli.StringFlag{
+		Name:  "sentry.nodes",
+		Usage: "Comma separated enode URLs of the private sentries hiding this validator (disables discovery)",
+	}
+	SentryGuardFlag = cli.StringFlag{
+		Name:  "sentry.guard",
+		Usage: "Comma separated enode URLs of the validators relayed and hidden by this sentry",
+	}
 
 	// ATM the url is left to the user and deployment to
 	JSpathFlag = cli.StringFlag{
@@ -663,6 +732,32 @@ func setBootstrapNodes(ctx *cli.Context, cfg *p2p.Config) {
 	}
 }
 
+// setSentryNodes creates the sentry topology of a validator or a sentry from the
+// command line flags.
+func setSentryNodes(ctx *cli.Context, cfg *p2p.Config) {
+	checkExclusive(ctx, SentryNodesFlag, SentryGuardFlag)
+
+	if ctx.GlobalIsSet(SentryNodesFlag.Name) {
+		cfg.SentryNodes = parseEnodeList(ctx, SentryNodesFlag)
+	}
+	if ctx.GlobalIsSet(SentryGuardFlag.Name) {
+		cfg.GuardedNodes = parseEnodeList(ctx, SentryGuardFlag)
+	}
+}
+
+// parseEnodeList parses the comma separated enode URLs of the given flag.
+func parseEnodeList(ctx *cli.Context, flag cli.StringFlag) []*enode.Node {
+	var nodes []*enode.Node
+	for _, url := range strings.Split(ctx.GlobalString(flag.Name), ",") {
+		node, err := enode.ParseV4(strings.TrimSpace(url))
+		if err != nil {
+			Fatalf("Invalid enode in --%s: %s: %v", flag.Name, url, err)
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
 // setBootstrapNodesV5 creates a list of bootstrap nodes from the command line
 // flags, reverting to pre-configured ones if none have been specified.
 func setBootstrapNodesV5(ctx *cli.Context, cfg *p2p.Config) {
@@ -932,6 +1027,7 @@ func SetP2PConfig(ctx *cli.Context, cfg *p2p.Config) {
 		}
 		cfg.NetRestrict = list
 	}
+	setSentryNodes(ctx, cfg)
 
 	if ctx.GlobalBool(DeveloperFlag.Name) {
 		// --dev mode can't use p2p networking.
@@ -952,6 +1048,14 @@ func SetNodeConfig(ctx *cli.Context, cfg *node.Config) {
 
 	setDataDir(ctx, cfg)
 
+	if ctx.GlobalIsSet(DBEngineFlag.Name) {
+		engine := ctx.GlobalString(DBEngineFlag.Name)
+		if engine != berithdb.EngineLevelDB && engine != berithdb.EnginePebble {
+			Fatalf("--%s must be either '%s' or '%s'", DBEngineFlag.Name, berithdb.EngineLevelDB, berithdb.EnginePebble)
+		}
+		cfg.DBEngine = engine
+	}
+
 	if ctx.GlobalIsSet(KeyStoreDirFlag.Name) {
 		cfg.KeyStoreDir = ctx.GlobalString(KeyStoreDirFlag.Name)
 	}
@@ -1024,6 +1128,22 @@ func setTxPool(ctx *cli.Context, cfg *core.TxPoolConfig) {
 	if ctx.GlobalIsSet(TxPoolLifetimeFlag.Name) {
 		cfg.Lifetime = ctx.GlobalDuration(TxPoolLifetimeFlag.Name)
 	}
+	if ctx.GlobalIsSet(TxPoolPrivateLifetimeFlag.Name) {
+		cfg.PrivateLifetime = ctx.GlobalUint64(TxPoolPrivateLifetimeFlag.Name)
+	}
+}
+
+// [BERITH] setDNSDiscovery configures the DNS node lists queried for peers.
+func setDNSDiscovery(ctx *cli.Context, cfg *berith.Config) {
+	if !ctx.GlobalIsSet(DNSDiscoveryFlag.Name) {
+		return
+	}
+	cfg.DiscoveryURLs = nil
+	for _, url := range strings.Split(ctx.GlobalString(DNSDiscoveryFlag.Name), ",") {
+		if url = strings.TrimSpace(url); url != "" {
+			cfg.DiscoveryURLs = append(cfg.DiscoveryURLs, url)
+		}
+	}
 }
 
 func setWhitelist(ctx *cli.Context, cfg *berith.Config) {
@@ -1049,6 +1169,15 @@ func setWhitelist(ctx *cli.Context, cfg *berith.Config) {
 	}
 }
 
+// setPrivateTxPeers creates the list of trusted peers receiving private
+// transactions from the command line flags.
+func setPrivateTxPeers(ctx *cli.Context, cfg *berith.Config) {
+	if !ctx.GlobalIsSet(TxPoolPrivatePeersFlag.Name) {
+		return
+	}
+	cfg.PrivateTxPeers = parseEnodeList(ctx, TxPoolPrivatePeersFlag)
+}
+
 // checkExclusive verifies that only a single instance of the provided flags was
 // set by the user. Each flag might optionally be followed by a string type to
 // specialize it further.
@@ -1100,7 +1229,9 @@ func SetBerithConfig(ctx *cli.Context, stack *node.Node, cfg *berith.Config) {
 	setBerithbase(ctx, ks, cfg)
 	setGPO(ctx, &cfg.GPO)
 	setTxPool(ctx, &cfg.TxPool)
+	setPrivateTxPeers(ctx, cfg)
 	setWhitelist(ctx, cfg)
+	setDNSDiscovery(ctx, cfg)
 
 	if ctx.GlobalIsSet(SyncModeFlag.Name) {
 		cfg.SyncMode = *GlobalTextMarshaler(ctx, SyncModeFlag.Name).(*downloader.SyncMode)
@@ -1118,11 +1249,26 @@ func SetBerithConfig(ctx *cli.Context, stack *node.Node, cfg *berith.Config) {
 		cfg.DatabaseCache = ctx.GlobalInt(CacheFlag.Name) * ctx.GlobalInt(CacheDatabaseFlag.Name) / 100
 	}
 	cfg.DatabaseHandles = makeDatabaseHandles()
+	if ctx.GlobalIsSet(AncientFlag.Name) {
+		cfg.DatabaseFreezer = ctx.GlobalString(AncientFlag.Name)
+	}
 
 	if gcmode := ctx.GlobalString(GCModeFlag.Name); gcmode != "full" && gcmode != "archive" {
 		Fatalf("--%s must be either 'full' or 'archive'", GCModeFlag.Name)
 	}
 	cfg.NoPruning = ctx.GlobalString(GCModeFlag.Name) == "archive"
+	if ctx.GlobalIsSet(TxLookupLimitFlag.Name) {
+		cfg.TxLookupLimit = ctx.GlobalUint64(TxLookupLimitFlag.Name)
+	}
+	if ctx.GlobalBool(SupplyIndexFlag.Name) {
+		if !cfg.NoPruning {
+			Fatalf("--%s requires --%s=archive", SupplyIndexFlag.Name, GCModeFlag.Name)
+		}
+		cfg.SupplyIndex = true
+	}
+	if ctx.GlobalIsSet(StateReexecFlag.Name) {
+		cfg.StateReexec = ctx.GlobalUint64(StateReexecFlag.Name)
+	}
 
 	if ctx.GlobalIsSet(CacheFlag.Name) || ctx.GlobalIsSet(CacheTrieFlag.Name) {
 		cfg.TrieCleanCache = ctx.GlobalInt(CacheFlag.Name) * ctx.GlobalInt(CacheTrieFlag.Name) / 100
@@ -1130,6 +1276,9 @@ func SetBerithConfig(ctx *cli.Context, stack *node.Node, cfg *berith.Config) {
 	if ctx.GlobalIsSet(CacheFlag.Name) || ctx.GlobalIsSet(CacheGCFlag.Name) {
 		cfg.TrieDirtyCache = ctx.GlobalInt(CacheFlag.Name) * ctx.GlobalInt(CacheGCFlag.Name) / 100
 	}
+	if ctx.GlobalIsSet(CacheFlag.Name) || ctx.GlobalIsSet(CacheSnapshotFlag.Name) {
+		cfg.SnapshotCache = ctx.GlobalInt(CacheFlag.Name) * ctx.GlobalInt(CacheSnapshotFlag.Name) / 100
+	}
 	if ctx.GlobalIsSet(MinerNotifyFlag.Name) {
 		cfg.MinerNotify = strings.Split(ctx.GlobalString(MinerNotifyFlag.Name), ",")
 	}
@@ -1281,11 +1430,15 @@ func MakeChainDatabase(ctx *cli.Context, stack *node.Node) berithdb.Database {
 		cache   = ctx.GlobalInt(CacheFlag.Name) * ctx.GlobalInt(CacheDatabaseFlag.Name) / 100
 		handles = makeDatabaseHandles()
 	)
-	name := "chaindata"
+	var (
+		chainDb berithdb.Database
+		err     error
+	)
 	if ctx.GlobalString(SyncModeFlag.Name) == "light" {
-		name = "lightchaindata"
+		chainDb, err = stack.OpenDatabase("lightchaindata", cache, handles)
+	} else {
+		chainDb, err = stack.OpenDatabaseWithFreezer("chaindata", cache, handles, ctx.GlobalString(AncientFlag.Name), "")
 	}
-	chainDb, err := stack.OpenDatabase(name, cache, handles)
 	if err != nil {
 		Fatalf("Could not open database: %v", err)
 	}
@@ -1320,6 +1473,8 @@ func MakeChain(ctx *cli.Context, stack *node.Node) (chain *core.BlockChain, chai
 		TrieCleanLimit: berith.DefaultConfig.TrieCleanCache,
 		TrieDirtyLimit: berith.DefaultConfig.TrieDirtyCache,
 		TrieTimeLimit:  berith.DefaultConfig.TrieTimeout,
+		SnapshotLimit:  berith.DefaultConfig.SnapshotCache,
+		StateReexec:    berith.DefaultConfig.StateReexec,
 	}
 	if ctx.GlobalIsSet(CacheFlag.Name) || ctx.GlobalIsSet(CacheTrieFlag.Name) {
 		cache.TrieCleanLimit = ctx.GlobalInt(CacheFlag.Name) * ctx.GlobalInt(CacheTrieFlag.Name) / 100
@@ -1327,13 +1482,19 @@ func MakeChain(ctx *cli.Context, stack *node.Node) (chain *core.BlockChain, chai
 	if ctx.GlobalIsSet(CacheFlag.Name) || ctx.GlobalIsSet(CacheGCFlag.Name) {
 		cache.TrieDirtyLimit = ctx.GlobalInt(CacheFlag.Name) * ctx.GlobalInt(CacheGCFlag.Name) / 100
 	}
+	if ctx.GlobalIsSet(CacheFlag.Name) || ctx.GlobalIsSet(CacheSnapshotFlag.Name) {
+		cache.SnapshotLimit = ctx.GlobalInt(CacheFlag.Name) * ctx.GlobalInt(CacheSnapshotFlag.Name) / 100
+	}
+	if ctx.GlobalIsSet(StateReexecFlag.Name) {
+		cache.StateReexec = ctx.GlobalUint64(StateReexecFlag.Name)
+	}
 	vmcfg := vm.Config{EnablePreimageRecording: ctx.GlobalBool(VMEnableDebugFlag.Name)}
 
-	stakingDB := &staking.StakingDB{NoPruning: ctx.GlobalString(GCModeFlag.Name) == "archive"}
+	stakingDB := &staking.StakingDB{NoPruning: ctx.GlobalString(GCModeFlag.Name) == "archive", Engine: stack.Config().DBEngine}
 	stakingDBPath := stack.ResolvePath("stakingDB")
 	stakingDB.CreateDB(stakingDBPath, staking.NewStakers)
 
-	chain, err = core.NewBlockChain(stakingDB, chainDb, cache, config, engine, vmcfg, nil)
+	chain, err = core.NewBlockChain(stakingDB, chainDb, cache, config, engine, vmcfg, nil, nil)
 	if err != nil {
 		Fatalf("Can't create BlockChain: %v", err)
 	}
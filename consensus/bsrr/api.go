@@ -19,6 +19,7 @@ import (
 	"github.com/BerithFoundation/berith-chain/berith/selection"
 	"github.com/BerithFoundation/berith-chain/common"
 	"github.com/BerithFoundation/berith-chain/consensus"
+	"github.com/BerithFoundation/berith-chain/core/state"
 	"github.com/BerithFoundation/berith-chain/core/types"
 	"github.com/BerithFoundation/berith-chain/rpc"
 )
@@ -30,6 +31,21 @@ type API struct {
 	bsrr  *BSRR
 }
 
+// historicStateReader is implemented by the chains able to regenerate the
+// historical states which were already pruned.
+type historicStateReader interface {
+	StateAtHeader(header *types.Header) (*state.StateDB, error)
+}
+
+// [BERITH] stateAt returns the state of the given header, regenerating it if
+// it was pruned and the chain supports it.
+func (api *API) stateAt(header *types.Header) (*state.StateDB, error) {
+	if chain, ok := api.chain.(historicStateReader); ok {
+		return chain.StateAtHeader(header)
+	}
+	return api.chain.StateAt(header.Root)
+}
+
 func (api *API) GetCandidates(number *rpc.BlockNumber) (*selection.JSONCandidates, error) {
 	var header *types.Header
 	if number == nil || *number == rpc.LatestBlockNumber {
@@ -52,7 +68,7 @@ func (api *API) GetCandidates(number *rpc.BlockNumber) (*selection.JSONCandidate
 		return nil, consensus.ErrUnknownAncestor
 	}
 
-	stat, err := api.chain.StateAt(target.Root)
+	stat, err := api.stateAt(target)
 
 	if err != nil {
 		return nil, err
@@ -141,7 +157,7 @@ func (api *API) GetJoinRatio(address common.Address, number *rpc.BlockNumber) (f
 		return 0, consensus.ErrUnknownAncestor
 	}
 
-	states, err := api.chain.StateAt(target.Root)
+	states, err := api.stateAt(target)
 	if err != nil {
 		return 0, err
 	}
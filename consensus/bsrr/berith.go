@@ -38,8 +38,10 @@ import (
 	"github.com/BerithFoundation/berith-chain/common"
 	"github.com/BerithFoundation/berith-chain/consensus"
 	"github.com/BerithFoundation/berith-chain/consensus/misc"
+	"github.com/BerithFoundation/berith-chain/core/rawdb"
 	"github.com/BerithFoundation/berith-chain/core/state"
 	"github.com/BerithFoundation/berith-chain/core/types"
+	"github.com/BerithFoundation/berith-chain/core/vm"
 	"github.com/BerithFoundation/berith-chain/crypto"
 	"github.com/BerithFoundation/berith-chain/crypto/sha3"
 	"github.com/BerithFoundation/berith-chain/log"
@@ -52,6 +54,7 @@ const (
 	inmemorySnapshots  = 128     // Number of recent vote snapshots to keep in memory
 	inmemorySigners    = 128 * 3 // Number of recent vote snapshots to keep in memory
 	inmemorySignatures = 4096    // Number of recent block signatures to keep in memory
+	inmemoryReplays    = 4       // Number of staker sets replayed from the block bodies to keep in memory
 
 	termDelay  = 100 * time.Millisecond // Delay per signer in the same group
 	groupDelay = 1 * time.Second        // Delay per groups
@@ -130,9 +133,22 @@ var (
 
 	errMissingState = errors.New("state missing")
 
+	errMissingReceipts = errors.New("receipts missing")
+
 	errCleanStakingDB = errors.New("fail to clean stakingDB")
 
 	errBIP1 = errors.New("error when fork network to BIP1")
+
+	// errNonCanonicalBlock is returned if the staker set of a side chain block is rebuilt.
+	errNonCanonicalBlock = errors.New("block not canonical")
+
+	// errStakersMismatch is returned if a claimed staker set contradicts the one
+	// replayed from the stake transactions of the chain.
+	errStakersMismatch = errors.New("staker set mismatch")
+
+	// errInvalidStakerProof is returned if a proven staker set contains accounts
+	// holding no stake at the stake target block.
+	errInvalidStakerProof = errors.New("staker without stake in proof")
 )
 
 // SignerFn is a signer callback function to request a hash to be signed by a
@@ -201,6 +217,7 @@ type BSRR struct {
 	//[BERITH] add to stakingDB clique structure
 	stakingDB staking.DataBase // DB storing stakingList
 	cache     *lru.ARCCache    // cache to store stakingList
+	replays   *lru.ARCCache    // Staker sets replayed from the block bodies, checked against every claim
 
 	recents    *lru.ARCCache // Snapshots for recent block to speed up reorgs
 	signatures *lru.ARCCache // Signatures of recent blocks to speed up mining
@@ -257,6 +274,7 @@ func New(config *params.BSRRConfig, db berithdb.Database) *BSRR {
 	signatures, _ := lru.NewARC(inmemorySignatures)
 	//[BERITH] Cache instance creation and sizing
 	cache, _ := lru.NewARC(inmemorySigners)
+	replays, _ := lru.NewARC(inmemoryReplays)
 
 	return &BSRR{
 		config:     conf,
@@ -264,6 +282,7 @@ func New(config *params.BSRRConfig, db berithdb.Database) *BSRR {
 		recents:    recents,
 		signatures: signatures,
 		cache:      cache,
+		replays:    replays,
 		proposals:  make(map[common.Address]bool),
 		rankGroup:  &common.ArithmeticGroup{CommonDiff: commonDiff},
 	}
@@ -481,8 +500,6 @@ func (c *BSRR) Finalize(chain consensus.ChainReader, header *types.Header, state
 	}
 
 	if header.Coinbase != common.HexToAddress("0") {
-		var signers signers
-
 		parent := chain.GetHeader(header.ParentHash, header.Number.Uint64()-1)
 		if parent == nil {
 			log.Warn("unknown ancestor", "parent", "nil")
@@ -494,46 +511,17 @@ func (c *BSRR) Finalize(chain consensus.ChainReader, header *types.Header, state
 				return nil, errBIP1
 			}
 		}
-		target, exist := c.getStakeTargetBlock(chain, parent)
-		if !exist {
-			return nil, consensus.ErrUnknownAncestor
-		}
-
-		signers, err := c.getSigners(chain, target)
-		if err != nil {
-			return nil, errUnauthorizedSigner
-		}
-
-		signerMap := signers.signersMap()
-		if _, ok := signerMap[header.Coinbase]; !ok {
-			return nil, errUnauthorizedSigner
-		}
-
-		predicted, rank := c.calcDifficultyAndRank(header.Coinbase, chain, 0, target)
-		if rank < 1 {
-			return nil, errUnauthorizedSigner
-		}
-
-		if predicted.Cmp(header.Difficulty) != 0 {
-			return nil, errInvalidDifficulty
-		}
-		if header.Nonce.Uint64() != uint64(rank) {
-			return nil, errInvalidNonce
-		}
-
-		/*
-			[Berith]
-			To reduce disk usage, Staker information is periodically deleted.
-		*/
-		if new(big.Int).Mod(header.Number, big.NewInt(common.CleanCycle)).Cmp(common.Big0) == 0 {
-			if err = c.stakingDB.Clean(chain, target); err != nil {
-				return nil, errCleanStakingDB
+		// [BERITH] Replayed blocks were verified on import, and the state of their
+		// stake target block may have been pruned since.
+		if replayer, ok := chain.(consensus.ChainReplayer); !ok || !replayer.Replaying() {
+			if err := c.verifyCreator(chain, header, parent); err != nil {
+				return nil, err
 			}
 		}
 	}
 
 	// [BERITH] Modify the data of StateDB based on the transaction information of the received block.
-	if err = c.setStakersWithTxs(state, chain, stks, txs, header); err != nil {
+	if err = c.setStakersWithTxs(state, chain, stks, txs, receipts, header); err != nil {
 		return nil, errStakingList
 	}
 
@@ -548,6 +536,51 @@ func (c *BSRR) Finalize(chain consensus.ChainReader, header *types.Header, state
 	return types.NewBlock(header, txs, nil, receipts), nil
 }
 
+/*
+[BERITH]
+Method to check that the creator of the block is a selected signer with the
+expected difficulty and rank, periodically cleaning the staking database.
+*/
+func (c *BSRR) verifyCreator(chain consensus.ChainReader, header *types.Header, parent *types.Header) error {
+	target, exist := c.getStakeTargetBlock(chain, parent)
+	if !exist {
+		return consensus.ErrUnknownAncestor
+	}
+
+	signers, err := c.getSigners(chain, target)
+	if err != nil {
+		return errUnauthorizedSigner
+	}
+
+	signerMap := signers.signersMap()
+	if _, ok := signerMap[header.Coinbase]; !ok {
+		return errUnauthorizedSigner
+	}
+
+	predicted, rank := c.calcDifficultyAndRank(header.Coinbase, chain, 0, target)
+	if rank < 1 {
+		return errUnauthorizedSigner
+	}
+
+	if predicted.Cmp(header.Difficulty) != 0 {
+		return errInvalidDifficulty
+	}
+	if header.Nonce.Uint64() != uint64(rank) {
+		return errInvalidNonce
+	}
+
+	/*
+		[Berith]
+		To reduce disk usage, Staker information is periodically deleted.
+	*/
+	if new(big.Int).Mod(header.Number, big.NewInt(common.CleanCycle)).Cmp(common.Big0) == 0 {
+		if err = c.stakingDB.Clean(chain, target); err != nil {
+			return errCleanStakingDB
+		}
+	}
+	return nil
+}
+
 // Authorize injects a private key into the consensus engine to mint new blocks
 // with.
 func (c *BSRR) Authorize(signer common.Address, signFn SignerFn) {
@@ -722,7 +755,12 @@ func (c *BSRR) calcDifficultyAndRank(signer common.Address, chain consensus.Chai
 		return big.NewInt(0), -1
 	}
 
-	results := selection.SelectBlockCreator(chain.Config(), target.Number.Uint64(), target.Hash(), stks, stateDB)
+	return c.electedRank(chain.Config(), signer, target, stks, stateDB)
+}
+
+// [BERITH] Method to return the difficulty and rank of a signer elected from the staking list and the state of the target block
+func (c *BSRR) electedRank(config *params.ChainConfig, signer common.Address, target *types.Header, stks staking.Stakers, stateDB *state.StateDB) (*big.Int, int) {
+	results := selection.SelectBlockCreator(config, target.Number.Uint64(), target.Hash(), stks, stateDB)
 
 	max := c.getMaxMiningCandidates(len(results))
 
@@ -766,6 +804,12 @@ func (c *BSRR) Close() error {
 	return nil
 }
 
+// BlockReward returns the reward credited to the behind balance of the creator
+// of the block, the only source of issuance of the chain.
+func BlockReward(config *params.ChainConfig, header *types.Header) *big.Int {
+	return getReward(config, header)
+}
+
 func getReward(config *params.ChainConfig, header *types.Header) *big.Int {
 	const (
 		blockNumberAt1Year         = 3150000 // If a block is created every 10 seconds, this number of the block created at the time of 1 year.
@@ -948,7 +992,11 @@ func (c *BSRR) checkBlocks(chain consensus.ChainReader, stks staking.Stakers, bl
 	}
 
 	for _, block := range blocks {
-		if err := c.setStakersWithTxs(nil, chain, stks, block.Transactions(), block.Header()); err != nil {
+		receipts, err := c.blockReceipts(chain, block)
+		if err != nil {
+			return err
+		}
+		if err := c.setStakersWithTxs(nil, chain, stks, block.Transactions(), receipts, block.Header()); err != nil {
 			return err
 		}
 	}
@@ -957,7 +1005,7 @@ func (c *BSRR) checkBlocks(chain consensus.ChainReader, stks staking.Stakers, bl
 }
 
 // [BERITH] Method to examine transaction array and set value in stakingList
-func (c *BSRR) setStakersWithTxs(state *state.StateDB, chain consensus.ChainReader, stks staking.Stakers, txs []*types.Transaction, header *types.Header) error {
+func (c *BSRR) setStakersWithTxs(state *state.StateDB, chain consensus.ChainReader, stks staking.Stakers, txs []*types.Transaction, receipts []*types.Receipt, header *types.Header) error {
 	number := header.Number
 
 	parent := chain.GetHeader(header.ParentHash, header.Number.Uint64()-1)
@@ -972,27 +1020,9 @@ func (c *BSRR) setStakersWithTxs(state *state.StateDB, chain consensus.ChainRead
 		return errMissingState
 	}
 
-	stkChanged := make(map[common.Address]bool)
-
-	for _, tx := range txs {
-		msg, err := tx.AsMessage(types.MakeSigner(chain.Config(), number))
-		if err != nil {
-			return err
-		}
-
-		// General Transaction
-		if msg.Base() == types.Main && msg.Target() == types.Main {
-			continue
-		}
-
-		//[BERITH] 2019-09-03
-		// Fix to save the last staking block number
-		// Stake or Unstake in case of not normal Tx
-		if chain.Config().IsBIP1(number) && msg.Base() == types.Stake && msg.Target() == types.Main {
-			stkChanged[msg.From()] = false
-		} else if msg.Base() == types.Main && msg.Target() == types.Stake {
-			stkChanged[msg.From()] = true
-		}
+	stkChanged, err := stakeChanges(chain.Config(), txs, receipts, number)
+	if err != nil {
+		return err
 	}
 
 	for addr, isAdd := range stkChanged {
@@ -1021,6 +1051,185 @@ func (c *BSRR) setStakersWithTxs(state *state.StateDB, chain consensus.ChainRead
 	return nil
 }
 
+// [BERITH] Method to collect the accounts staking (true) or unstaking (false) in the transactions of a block,
+// either by staking transactions or, given the receipts of the transactions, through the staking contract
+func stakeChanges(config *params.ChainConfig, txs []*types.Transaction, receipts []*types.Receipt, number *big.Int) (map[common.Address]bool, error) {
+	stkChanged := make(map[common.Address]bool)
+
+	for i, tx := range txs {
+		msg, err := tx.AsMessage(types.MakeSigner(config, number))
+		if err != nil {
+			return nil, err
+		}
+
+		//[BERITH] 2019-09-03
+		// Fix to save the last staking block number
+		// Stake or Unstake in case of not normal Tx
+		if config.IsBIP1(number) && msg.Base() == types.Stake && msg.Target() == types.Main {
+			stkChanged[msg.From()] = false
+		} else if msg.Base() == types.Main && msg.Target() == types.Stake {
+			stkChanged[msg.From()] = true
+		}
+
+		// Stake or Unstake of contracts through the staking contract
+		if i >= len(receipts) {
+			continue
+		}
+		for _, l := range receipts[i].Logs {
+			if l.Address != vm.StakingContractAddress || len(l.Topics) != 2 {
+				continue
+			}
+			switch l.Topics[0] {
+			case vm.StakedTopic:
+				stkChanged[common.BytesToAddress(l.Topics[1].Bytes())] = true
+			case vm.UnstakedTopic:
+				stkChanged[common.BytesToAddress(l.Topics[1].Bytes())] = false
+			}
+		}
+	}
+	return stkChanged, nil
+}
+
+// [BERITH] Method to retrieve the receipts of a stored block, which are only needed to replay the stake changes
+// made through the staking contract
+func (c *BSRR) blockReceipts(chain consensus.ChainReader, block *types.Block) (types.Receipts, error) {
+	if !chain.Config().IsBIP8(block.Number()) || len(block.Transactions()) == 0 {
+		return nil, nil
+	}
+	receipts := rawdb.ReadReceipts(c.db, block.Hash(), block.NumberU64())
+	if len(receipts) != len(block.Transactions()) {
+		return nil, errMissingReceipts
+	}
+	return receipts, nil
+}
+
+// [BERITH] Stakers implements consensus.StakersSyncer, retrieving the staker list
+// after the given block to serve it to the syncing nodes.
+func (c *BSRR) Stakers(chain consensus.ChainReader, header *types.Header) ([]common.Address, error) {
+	stks, err := c.getStakers(chain, header.Number.Uint64(), header.Hash())
+	if err != nil {
+		return nil, err
+	}
+	return stks.AsList(), nil
+}
+
+// stakersReplay is the staker set rebuilt by replaying the block bodies, along
+// with the stakers left undecided by the replay.
+type stakersReplay struct {
+	stakers   []common.Address
+	undecided map[common.Address]struct{}
+}
+
+/*
+[BERITH]
+RebuildStakers implements consensus.StakersSyncer. The stake and unstake
+transactions are replayed from the block bodies. The BIP1 cleanup can't be
+redone once the state it ran against is gone, so the stakers it applied to stay
+undecided until a later stake change of theirs goes through a replayed block.
+
+The undecided stakers are settled by their stake balances in the given state,
+which can't have changed since the cleanup without a stake change. A claimed
+list must then match the rebuilt set exactly. The replay is cached, so the
+claims of several peers are checked against a single replay.
+*/
+func (c *BSRR) RebuildStakers(chain consensus.ChainReader, header *types.Header, state *state.StateDB, claimed []common.Address) error {
+	replay, err := c.replayStakers(chain, header)
+	if err != nil {
+		return err
+	}
+	stks := c.stakingDB.NewStakers()
+	for _, addr := range replay.stakers {
+		stks.Put(addr)
+	}
+	for addr := range replay.undecided {
+		if state.GetStakeBalance(addr).Cmp(c.config.StakeMinimum) >= 0 {
+			stks.Put(addr)
+		}
+	}
+	if err := state.Error(); err != nil {
+		return err
+	}
+	if claimed != nil {
+		claims := make(map[common.Address]struct{}, len(claimed))
+		for _, addr := range claimed {
+			if !stks.IsContain(addr) {
+				return errStakersMismatch
+			}
+			claims[addr] = struct{}{}
+		}
+		if len(claims) != len(stks.AsList()) {
+			return errStakersMismatch
+		}
+	}
+	bytes, err := json.Marshal(stks)
+	if err != nil {
+		return err
+	}
+	c.cache.Add(header.Hash(), bytes)
+	return c.stakingDB.Commit(header.Hash().Hex(), stks)
+}
+
+// replayStakers rebuilds the staker set at the given block from the stake and
+// unstake transactions of the canonical chain, and the stake changes logged by
+// the staking contract, or retrieves it from the cache.
+func (c *BSRR) replayStakers(chain consensus.ChainReader, header *types.Header) (*stakersReplay, error) {
+	// The replay walks the canonical chain, so it must end at the given block
+	if current := chain.GetHeaderByNumber(header.Number.Uint64()); current == nil || current.Hash() != header.Hash() {
+		return nil, errNonCanonicalBlock
+	}
+	if replay, ok := c.replays.Get(header.Hash()); ok {
+		return replay.(*stakersReplay), nil
+	}
+	var (
+		stks      = c.stakingDB.NewStakers()
+		undecided = make(map[common.Address]struct{})
+	)
+	for number := uint64(1); number <= header.Number.Uint64(); number++ {
+		current := chain.GetHeaderByNumber(number)
+		if current == nil {
+			return nil, consensus.ErrUnknownAncestor
+		}
+		block := chain.GetBlock(current.Hash(), number)
+		if block == nil {
+			return nil, consensus.ErrUnknownAncestor
+		}
+		if current.Coinbase != common.HexToAddress("0") && chain.Config().IsBIP1Block(current.Number) {
+			parent := chain.GetHeader(current.ParentHash, number-1)
+			if parent == nil {
+				return nil, consensus.ErrUnknownAncestor
+			}
+			prevState, err := chain.StateAt(parent.Root)
+			for _, addr := range stks.AsList() {
+				if err != nil {
+					undecided[addr] = struct{}{}
+					stks.Remove(addr)
+				} else if prevState.GetStakeBalance(addr).Cmp(c.config.StakeMinimum) < 0 {
+					stks.Remove(addr)
+				}
+			}
+		}
+		receipts, err := c.blockReceipts(chain, block)
+		if err != nil {
+			return nil, err
+		}
+		changes, err := stakeChanges(chain.Config(), block.Transactions(), receipts, current.Number)
+		if err != nil {
+			return nil, err
+		}
+		for addr, isAdd := range changes {
+			delete(undecided, addr)
+			if isAdd {
+				stks.Put(addr)
+			} else {
+				stks.Remove(addr)
+			}
+		}
+	}
+	replay := &stakersReplay{stakers: stks.AsList(), undecided: undecided}
+	c.replays.Add(header.Hash(), replay)
+	return replay, nil
+}
+
 type signers []common.Address
 
 func (s signers) signersMap() map[common.Address]struct{} {
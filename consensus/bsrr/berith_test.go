@@ -1,11 +1,22 @@
 package bsrr
 
 import (
+	"crypto/ecdsa"
+	"errors"
+	"math/big"
 	"testing"
 	"time"
 
 	"github.com/BerithFoundation/berith-chain/berith/selection"
+	"github.com/BerithFoundation/berith-chain/berith/staking"
+	"github.com/BerithFoundation/berith-chain/berithdb"
 	"github.com/BerithFoundation/berith-chain/common"
+	"github.com/BerithFoundation/berith-chain/consensus"
+	"github.com/BerithFoundation/berith-chain/core/rawdb"
+	"github.com/BerithFoundation/berith-chain/core/state"
+	"github.com/BerithFoundation/berith-chain/core/types"
+	"github.com/BerithFoundation/berith-chain/core/vm"
+	"github.com/BerithFoundation/berith-chain/crypto"
 	"github.com/BerithFoundation/berith-chain/params"
 )
 
@@ -79,3 +90,249 @@ func TestGetDelay(t *testing.T) {
 		}
 	}
 }
+
+// memStakingDB is a staking database kept in memory.
+type memStakingDB map[string][]common.Address
+
+func (db memStakingDB) GetStakers(key string) (staking.Stakers, error) {
+	list, ok := db[key]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	stks := staking.NewStakers()
+	stks.FetchFromList(list)
+	return stks, nil
+}
+func (db memStakingDB) Commit(key string, stks staking.Stakers) error {
+	db[key] = stks.AsList()
+	return nil
+}
+func (db memStakingDB) NewStakers() staking.Stakers { return staking.NewStakers() }
+func (db memStakingDB) Close()                      {}
+func (db memStakingDB) Clean(consensus.ChainReader, *types.Header) error {
+	return nil
+}
+
+// testBlockChain is a canonical chain of blocks counting the bodies read, whose
+// states are all missing.
+type testBlockChain struct {
+	*testHeaderChain
+	blocks map[common.Hash]*types.Block
+	reads  int
+}
+
+func newTestBlockChain(config *params.ChainConfig, bodies [][]*types.Transaction) *testBlockChain {
+	chain := &testBlockChain{testHeaderChain: &testHeaderChain{config: config}, blocks: make(map[common.Hash]*types.Block)}
+	var parent common.Hash
+	for i, txs := range bodies {
+		header := &types.Header{ParentHash: parent, Number: big.NewInt(int64(i)), Difficulty: big.NewInt(1)}
+		if i > 0 {
+			header.Coinbase = common.Address{1}
+		}
+		block := types.NewBlock(header, txs, nil, nil)
+		chain.headers = append(chain.headers, block.Header())
+		chain.blocks[block.Hash()] = block
+		parent = block.Hash()
+	}
+	return chain
+}
+
+func (bc *testBlockChain) GetBlock(hash common.Hash, number uint64) *types.Block {
+	bc.reads++
+	return bc.blocks[hash]
+}
+func (bc *testBlockChain) StateAt(common.Hash) (*state.StateDB, error) {
+	return nil, errors.New("no state")
+}
+func (bc *testBlockChain) HasBlockAndState(common.Hash, uint64) bool { return false }
+
+// Tests that the staker set is replayed once for all of the claims checked
+// against it, each claim being checked on its own.
+func TestRebuildStakers(t *testing.T) {
+	config := *params.TestnetChainConfig
+	signer := types.NewEIP155Signer(config.ChainID)
+
+	var (
+		stakers []common.Address
+		bodies  = make([][]*types.Transaction, 8)
+	)
+	for i := 1; i < len(bodies); i++ {
+		key, _ := crypto.GenerateKey()
+		addr := crypto.PubkeyToAddress(key.PublicKey)
+		tx, _ := types.SignTx(types.NewTransaction(0, addr, big.NewInt(1), 21000, big.NewInt(1), nil, types.Main, types.Stake, false), signer, key)
+		bodies[i] = []*types.Transaction{tx}
+		stakers = append(stakers, addr)
+	}
+	chain := newTestBlockChain(&config, bodies)
+	head := chain.CurrentHeader()
+
+	db := make(memStakingDB)
+	c := New(config.Bsrr, berithdb.NewMemDatabase())
+	c.stakingDB = db
+
+	tests := []struct {
+		claimed []common.Address
+		err     error
+	}{
+		{stakers[1:], errStakersMismatch},
+		{append(stakers, common.HexToAddress("0xdeadbeef")), errStakersMismatch},
+		{stakers, nil},
+		{nil, nil},
+	}
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(berithdb.NewMemDatabase()))
+	for i, tt := range tests {
+		if err := c.RebuildStakers(chain, head, statedb, tt.claimed); err != tt.err {
+			t.Errorf("test #%d: have error %v, want %v", i, err, tt.err)
+		}
+	}
+	if chain.reads != len(bodies)-1 {
+		t.Errorf("block bodies read: have %d, want %d", chain.reads, len(bodies)-1)
+	}
+	if have := db[head.Hash().Hex()]; len(have) != len(stakers) {
+		t.Errorf("staker set mismatch: have %d stakers, want %d", len(have), len(stakers))
+	}
+}
+
+// Tests that the stakers left undecided by the BIP1 cleanup are settled by their
+// stake balances in the given state, whether a list is claimed or not.
+func TestRebuildUndecidedStakers(t *testing.T) {
+	config := *params.TestnetChainConfig
+	config.BIP1Block = big.NewInt(3)
+	signer := types.NewEIP155Signer(config.ChainID)
+
+	stake := func(key *ecdsa.PrivateKey, nonce uint64) *types.Transaction {
+		tx, _ := types.SignTx(types.NewTransaction(nonce, crypto.PubkeyToAddress(key.PublicKey), big.NewInt(1), 21000, big.NewInt(1), nil, types.Main, types.Stake, false), signer, key)
+		return tx
+	}
+	var (
+		keys    = make([]*ecdsa.PrivateKey, 3)
+		stakers = make([]common.Address, 3)
+	)
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+		stakers[i] = crypto.PubkeyToAddress(keys[i].PublicKey)
+	}
+	// All of the stakers are undecided at the cleanup of block #3, the second
+	// one staking again afterwards
+	chain := newTestBlockChain(&config, [][]*types.Transaction{
+		nil,
+		{stake(keys[0], 0)},
+		{stake(keys[1], 0), stake(keys[2], 0)},
+		nil,
+		{stake(keys[1], 1)},
+	})
+	head := chain.CurrentHeader()
+
+	// The first staker keeps the minimum, unlike the third
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(berithdb.NewMemDatabase()))
+	statedb.AddStakeBalance(stakers[0], config.Bsrr.StakeMinimum, big.NewInt(1))
+	statedb.AddStakeBalance(stakers[2], new(big.Int).Sub(config.Bsrr.StakeMinimum, common.Big1), big.NewInt(2))
+
+	db := make(memStakingDB)
+	c := New(config.Bsrr, berithdb.NewMemDatabase())
+	c.stakingDB = db
+
+	tests := []struct {
+		claimed []common.Address
+		err     error
+	}{
+		{stakers[1:2], errStakersMismatch},
+		{stakers, errStakersMismatch},
+		{[]common.Address{stakers[1], stakers[0], stakers[1]}, nil},
+		{nil, nil},
+	}
+	for i, tt := range tests {
+		if err := c.RebuildStakers(chain, head, statedb, tt.claimed); err != tt.err {
+			t.Errorf("test #%d: have error %v, want %v", i, err, tt.err)
+		}
+	}
+	if have := db[head.Hash().Hex()]; len(have) != 2 {
+		t.Errorf("staker set mismatch: have %v, want %v", have, stakers[:2])
+	}
+}
+
+// Tests that the stake changes logged by the staking contract are replayed
+// along with the staking transactions, from the receipts of the blocks.
+func TestRebuildContractStakers(t *testing.T) {
+	config := *params.TestnetChainConfig
+	config.BIP8Block = big.NewInt(0)
+	signer := types.NewEIP155Signer(config.ChainID)
+	key, _ := crypto.GenerateKey()
+
+	var (
+		contracts = []common.Address{{0xc1}, {0xc2}}
+		topics    = []common.Hash{vm.StakedTopic, vm.StakedTopic, vm.UnstakedTopic}
+		stakers   = []common.Address{contracts[0], contracts[1], contracts[0]}
+		bodies    = make([][]*types.Transaction, len(topics)+1)
+	)
+	for i := 1; i < len(bodies); i++ {
+		tx, _ := types.SignTx(types.NewTransaction(uint64(i-1), common.Address{}, big.NewInt(1), 21000, big.NewInt(1), nil, types.Main, types.Main, false), signer, key)
+		bodies[i] = []*types.Transaction{tx}
+	}
+	chain := newTestBlockChain(&config, bodies)
+	head := chain.CurrentHeader()
+
+	db := berithdb.NewMemDatabase()
+	c := New(config.Bsrr, db)
+	c.stakingDB = make(memStakingDB)
+
+	// The receipts are needed to replay the blocks of the staking contract era
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(berithdb.NewMemDatabase()))
+	if err := c.RebuildStakers(chain, head, statedb, nil); err != errMissingReceipts {
+		t.Fatalf("replay without receipts: have error %v, want %v", err, errMissingReceipts)
+	}
+	for i, header := range chain.headers[1:] {
+		rawdb.WriteReceipts(db, header.Hash(), header.Number.Uint64(), types.Receipts{{
+			Logs: []*types.Log{{Address: vm.StakingContractAddress, Topics: []common.Hash{topics[i], stakers[i].Hash()}}},
+		}})
+	}
+	c = New(config.Bsrr, db)
+	c.stakingDB = make(memStakingDB)
+	if err := c.RebuildStakers(chain, head, statedb, []common.Address{contracts[1]}); err != nil {
+		t.Fatalf("failed to rebuild stakers: %v", err)
+	}
+	if err := c.RebuildStakers(chain, head, statedb, contracts); err != errStakersMismatch {
+		t.Errorf("unstaked contract claimed: have error %v, want %v", err, errStakersMismatch)
+	}
+}
+
+// replayChain is a chain with empty states, re-executing its blocks or not.
+type replayChain struct {
+	*testBlockChain
+	replaying bool
+}
+
+func (c *replayChain) StateAt(common.Hash) (*state.StateDB, error) {
+	return state.New(common.Hash{}, state.NewDatabase(berithdb.NewMemDatabase()))
+}
+func (c *replayChain) Replaying() bool { return c.replaying }
+
+// Tests that the creator of a block is not verified again when the block is
+// replayed, the state of its stake target block being possibly pruned.
+func TestFinalizeReplaying(t *testing.T) {
+	config := *params.TestnetChainConfig
+	chain := newTestBlockChain(&config, make([][]*types.Transaction, 4))
+	parent := chain.CurrentHeader()
+
+	c := New(config.Bsrr, berithdb.NewMemDatabase())
+	c.stakingDB = make(memStakingDB)
+
+	for i, tt := range []struct {
+		replaying bool
+		err       error
+	}{
+		{false, consensus.ErrUnknownAncestor},
+		{true, nil},
+	} {
+		header := &types.Header{
+			ParentHash: parent.Hash(),
+			Number:     new(big.Int).Add(parent.Number, common.Big1),
+			Coinbase:   common.Address{1},
+			Difficulty: big.NewInt(1),
+		}
+		statedb, _ := state.New(common.Hash{}, state.NewDatabase(berithdb.NewMemDatabase()))
+		if _, err := c.Finalize(&replayChain{chain, tt.replaying}, header, statedb, nil, nil, nil); err != tt.err {
+			t.Errorf("test #%d: have error %v, want %v", i, err, tt.err)
+		}
+	}
+}
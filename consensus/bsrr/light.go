@@ -0,0 +1,93 @@
+/*
+[BERITH]
+Light client verification of the block creators. A light client has neither
+the staking database nor the state, so the staker list and the stake accounts
+of the stake target block are retrieved from a server, the accounts proven
+against the state root of the target block.
+*/
+
+package bsrr
+
+import (
+	"math/big"
+
+	"github.com/BerithFoundation/berith-chain/berith/staking"
+	"github.com/BerithFoundation/berith-chain/common"
+	"github.com/BerithFoundation/berith-chain/consensus"
+	"github.com/BerithFoundation/berith-chain/core/state"
+	"github.com/BerithFoundation/berith-chain/core/types"
+)
+
+// [BERITH] StakeTarget implements consensus.LightVerifier, looking up the target
+// block of getStakeTargetBlock through the headers only.
+func (c *BSRR) StakeTarget(chain consensus.ChainHeaderReader, parent *types.Header) *types.Header {
+	number := parent.Number.Uint64()
+	switch number / c.config.Epoch {
+	case 0:
+		return chain.GetHeaderByNumber(0)
+	case 1:
+		return chain.GetHeaderByNumber(c.config.Epoch)
+	}
+	target := parent
+	for target != nil && target.Number.Uint64() > number-c.config.Epoch {
+		target = chain.GetHeader(target.ParentHash, target.Number.Uint64()-1)
+	}
+	return target
+}
+
+/*
+[BERITH]
+VerifyCreatorProof implements consensus.LightVerifier, running the checks of
+verifyCreator with the staker list and the state of the target block proven by
+a server. The state needs to hold the accounts of the stakers only.
+
+Every listed staker must hold stake at the target block, at least the minimum
+from BIP1 on, so a server can't make up stakers. A server withholding some of
+them is not detected here, light chains require several servers to serve the
+same staker set for that reason.
+*/
+func (c *BSRR) VerifyCreatorProof(chain consensus.ChainHeaderReader, header, target *types.Header, stakers []common.Address, state *state.StateDB) error {
+	if header.Coinbase == common.HexToAddress("0") {
+		return nil
+	}
+	candidates := signers(stakers)
+	if target.Number.Uint64() < c.config.Epoch {
+		var err error
+		if candidates, err = c.getSignersFromExtraData(chain.GetHeaderByNumber(0)); err != nil {
+			return err
+		}
+	}
+	if _, ok := candidates.signersMap()[header.Coinbase]; !ok {
+		return errUnauthorizedSigner
+	}
+
+	predicted, rank := big.NewInt(diffWithoutStaker), 1
+	if target.Number.Sign() > 0 {
+		minimum := common.Big1
+		if chain.Config().IsBIP1(target.Number) {
+			minimum = c.config.StakeMinimum
+		}
+		for _, addr := range stakers {
+			if state.GetStakeBalance(addr).Cmp(minimum) < 0 {
+				return errInvalidStakerProof
+			}
+		}
+		if err := state.Error(); err != nil {
+			return err
+		}
+		stks := staking.NewStakers()
+		stks.FetchFromList(stakers)
+
+		predicted, rank = c.electedRank(chain.Config(), header.Coinbase, target, stks, state)
+	}
+	if rank < 1 {
+		return errUnauthorizedSigner
+	}
+	if predicted.Cmp(header.Difficulty) != 0 {
+		return errInvalidDifficulty
+	}
+	if header.Nonce.Uint64() != uint64(rank) {
+		return errInvalidNonce
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package bsrr
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/BerithFoundation/berith-chain/berith/staking"
+	"github.com/BerithFoundation/berith-chain/berithdb"
+	"github.com/BerithFoundation/berith-chain/common"
+	"github.com/BerithFoundation/berith-chain/core/state"
+	"github.com/BerithFoundation/berith-chain/core/types"
+	"github.com/BerithFoundation/berith-chain/params"
+)
+
+// testHeaderChain is a canonical chain of headers linked by their parent hashes.
+type testHeaderChain struct {
+	config  *params.ChainConfig
+	headers []*types.Header
+}
+
+func newTestHeaderChain(config *params.ChainConfig, n int) *testHeaderChain {
+	chain := &testHeaderChain{config: config}
+	var parent common.Hash
+	for i := 0; i < n; i++ {
+		header := &types.Header{ParentHash: parent, Number: big.NewInt(int64(i)), Difficulty: big.NewInt(1)}
+		chain.headers = append(chain.headers, header)
+		parent = header.Hash()
+	}
+	return chain
+}
+
+func (hc *testHeaderChain) Config() *params.ChainConfig { return hc.config }
+func (hc *testHeaderChain) CurrentHeader() *types.Header {
+	return hc.headers[len(hc.headers)-1]
+}
+func (hc *testHeaderChain) GetHeaderByHash(hash common.Hash) *types.Header {
+	for _, header := range hc.headers {
+		if header.Hash() == hash {
+			return header
+		}
+	}
+	return nil
+}
+func (hc *testHeaderChain) GetHeader(hash common.Hash, number uint64) *types.Header {
+	if header := hc.GetHeaderByNumber(number); header != nil && header.Hash() == hash {
+		return header
+	}
+	return nil
+}
+func (hc *testHeaderChain) GetHeaderByNumber(number uint64) *types.Header {
+	if number >= uint64(len(hc.headers)) {
+		return nil
+	}
+	return hc.headers[number]
+}
+
+func newLightTestEngine() (*BSRR, *params.ChainConfig) {
+	config := *params.TestnetChainConfig
+	bsrrConfig := *config.Bsrr
+	bsrrConfig.Epoch = 10
+	config.Bsrr = &bsrrConfig
+
+	return New(config.Bsrr, berithdb.NewMemDatabase()), &config
+}
+
+func TestStakeTarget(t *testing.T) {
+	c, config := newLightTestEngine()
+	chain := newTestHeaderChain(config, 30)
+
+	tests := []struct {
+		parent, target uint64
+	}{
+		{0, 0},
+		{9, 0},
+		{10, 10},
+		{19, 10},
+		{20, 10},
+		{25, 15},
+		{29, 19},
+	}
+	for i, tt := range tests {
+		target := c.StakeTarget(chain, chain.headers[tt.parent])
+		if target == nil || target.Number.Uint64() != tt.target {
+			t.Errorf("test #%d: parent %d: have target %v, want %d", i, tt.parent, target, tt.target)
+		}
+	}
+}
+
+func TestVerifyCreatorProof(t *testing.T) {
+	c, config := newLightTestEngine()
+	chain := newTestHeaderChain(config, 30)
+	target := chain.headers[15]
+
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(berithdb.NewMemDatabase()))
+	stakers := []common.Address{common.HexToAddress("0x01"), common.HexToAddress("0x02"), common.HexToAddress("0x03")}
+	for i, addr := range stakers {
+		stake := new(big.Int).Mul(big.NewInt(int64(100000*(i+1))), common.UnitForBer)
+		statedb.AddStakeBalance(addr, stake, big.NewInt(1))
+		statedb.SetPoint(addr, big.NewInt(int64(100000*(i+1))))
+	}
+	stks := staking.NewStakers()
+	stks.FetchFromList(stakers)
+
+	// Find the staker elected for the block
+	var (
+		elected common.Address
+		diff    *big.Int
+	)
+	for _, addr := range stakers {
+		if d, rank := c.electedRank(config, addr, target, stks, statedb); rank == 1 {
+			elected, diff = addr, d
+		}
+	}
+	if diff == nil {
+		t.Fatalf("no staker elected")
+	}
+	header := func(coinbase common.Address, diff *big.Int, nonce uint64) *types.Header {
+		return &types.Header{Number: big.NewInt(26), Coinbase: coinbase, Difficulty: diff, Nonce: types.EncodeNonce(nonce)}
+	}
+	unstaked, dust := common.HexToAddress("0x04"), common.HexToAddress("0x05")
+	statedb.AddStakeBalance(dust, new(big.Int).Sub(config.Bsrr.StakeMinimum, common.Big1), big.NewInt(1))
+
+	tests := []struct {
+		header  *types.Header
+		stakers []common.Address
+		err     error
+	}{
+		{header(elected, diff, 1), stakers, nil},
+		{header(common.Address{}, big.NewInt(1), 0), stakers, nil},
+		{header(unstaked, diff, 1), stakers, errUnauthorizedSigner},
+		{header(elected, new(big.Int).Add(diff, common.Big1), 1), stakers, errInvalidDifficulty},
+		{header(elected, diff, 2), stakers, errInvalidNonce},
+		{header(elected, diff, 1), append(stakers, unstaked), errInvalidStakerProof},
+		{header(elected, diff, 1), append(stakers, dust), errInvalidStakerProof},
+	}
+	for i, tt := range tests {
+		if err := c.VerifyCreatorProof(chain, tt.header, target, tt.stakers, statedb); err != tt.err {
+			t.Errorf("test #%d: have error %v, want %v", i, err, tt.err)
+		}
+	}
+}
@@ -53,6 +53,61 @@ type ChainReader interface {
 	HasBlockAndState(hash common.Hash, number uint64) bool
 }
 
+// ChainHeaderReader defines the methods needed to access the local header chain,
+// which light clients maintain without the blocks and the state.
+type ChainHeaderReader interface {
+	// Config retrieves the blockchain's chain configuration.
+	Config() *params.ChainConfig
+
+	// CurrentHeader retrieves the current header from the local chain.
+	CurrentHeader() *types.Header
+
+	// GetHeader retrieves a block header from the database by hash and number.
+	GetHeader(hash common.Hash, number uint64) *types.Header
+
+	// GetHeaderByNumber retrieves a block header from the database by number.
+	GetHeaderByNumber(number uint64) *types.Header
+
+	// GetHeaderByHash retrieves a block header from the database by its hash.
+	GetHeaderByHash(hash common.Hash) *types.Header
+}
+
+// ChainReplayer is implemented by the chain readers re-executing blocks which
+// were already verified on import, e.g. to regenerate pruned historical state.
+// Engines may skip the checks which only matter when importing a block.
+type ChainReplayer interface {
+	ChainReader
+
+	// Replaying reports whether the blocks are being re-executed.
+	Replaying() bool
+}
+
+// StakersSyncer is implemented by the engines keeping a staker set outside of
+// the state. The set must be served to and rebuilt by the nodes downloading the
+// state of a block by a sync rather than building it by executing the chain.
+type StakersSyncer interface {
+	// Stakers retrieves the staker set after the given block.
+	Stakers(chain ChainReader, header *types.Header) ([]common.Address, error)
+
+	// RebuildStakers reconstructs the staker set at the given block, checking it
+	// against the claimed set if one was retrieved from the network.
+	RebuildStakers(chain ChainReader, header *types.Header, state *state.StateDB, claimed []common.Address) error
+}
+
+// LightVerifier is implemented by the engines electing the block creators from a
+// staker set. Light clients keep neither the staker set nor the state, so they
+// check the creator of a header against those of its stake target block, as
+// proven by a server.
+type LightVerifier interface {
+	// StakeTarget retrieves the block whose staker set and state elect the creator
+	// of a block on top of the given parent.
+	StakeTarget(chain ChainHeaderReader, parent *types.Header) *types.Header
+
+	// VerifyCreatorProof checks the creator of a header against the staker set
+	// and the state of its stake target block.
+	VerifyCreatorProof(chain ChainHeaderReader, header, target *types.Header, stakers []common.Address, state *state.StateDB) error
+}
+
 // Engine is an algorithm agnostic consensus engine.
 type Engine interface {
 	// Author retrieves the Ethereum address of the account that minted the given
@@ -69,6 +69,14 @@ func (v *BlockValidator) ValidateBody(block *types.Block) error {
 	if header.Number.Cmp(v.bc.Config().BIP5Block) < 0 && block.Transactions().ContainEthTx() {
 		return fmt.Errorf("metamask transaction can not be included until BIP5")
 	}
+	// Sponsored transaction can be processed after BIP6
+	if !v.bc.Config().IsBIP6(header.Number) && block.Transactions().ContainSponsoredTx() {
+		return fmt.Errorf("sponsored transaction can not be included until BIP6")
+	}
+	// Multisig transaction can be processed after BIP7
+	if !v.bc.Config().IsBIP7(header.Number) && block.Transactions().ContainMultisigTx() {
+		return fmt.Errorf("multisig transaction can not be included until BIP7")
+	}
 	if hash := types.DeriveSha(block.Transactions()); hash != header.TxHash {
 		return fmt.Errorf("transaction root hash mismatch: have %x, want %x", hash, header.TxHash)
 	}
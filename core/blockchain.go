@@ -36,6 +36,7 @@ import (
 	"github.com/BerithFoundation/berith-chain/consensus"
 	"github.com/BerithFoundation/berith-chain/core/rawdb"
 	"github.com/BerithFoundation/berith-chain/core/state"
+	"github.com/BerithFoundation/berith-chain/core/state/snapshot"
 	"github.com/BerithFoundation/berith-chain/core/types"
 	"github.com/BerithFoundation/berith-chain/core/vm"
 	"github.com/BerithFoundation/berith-chain/crypto"
@@ -66,6 +67,10 @@ const (
 	maxTimeFutureBlocks = 30
 	badBlockLimit       = 10
 
+	// snapshotLayers is the number of in-memory diff layers the snapshot tree
+	// keeps on top of its persisted disk layer. Anything older is flattened.
+	snapshotLayers = 128
+
 	// BlockChainVersion ensures that an incompatible database forces a resync from scratch.
 	BlockChainVersion = 3
 )
@@ -77,6 +82,8 @@ type CacheConfig struct {
 	TrieCleanLimit int           // Memory allowance (MB) to use for caching trie nodes in memory
 	TrieDirtyLimit int           // Memory limit (MB) at which to start flushing dirty trie nodes to disk
 	TrieTimeLimit  time.Duration // Time limit after which to flush the current in-memory trie to disk
+	SnapshotLimit  int           // Memory allowance (MB) to use for caching snapshot entries in memory, 0 disables the snapshot
+	StateReexec    uint64        // Maximum number of blocks to re-execute when regenerating a missing historical state
 }
 
 // BlockChain represents the canonical chain given a database with a genesis
@@ -118,11 +125,13 @@ type BlockChain struct {
 	currentFastBlock atomic.Value // Current head of the fast-sync chain (may be above the block chain!)
 
 	stateCache    state.Database // State database to reuse between imports (contains state cache)
+	snaps         *snapshot.Tree // Snapshot tree for fast trie leaf access
 	bodyCache     *lru.Cache     // Cache for the most recent block bodies
 	bodyRLPCache  *lru.Cache     // Cache for the most recent block bodies in RLP encoded format
 	receiptsCache *lru.Cache     // Cache for the most recent receipts per block
 	blockCache    *lru.Cache     // Cache for the most recent entire blocks
 	futureBlocks  *lru.Cache     // future blocks are blocks added for later processing
+	regenCache    *lru.Cache     // Cache for the most recent regenerated historical states
 
 	quit    chan struct{} // blockchain quit channel
 	running int32         // running must be called atomically
@@ -141,12 +150,16 @@ type BlockChain struct {
 	stakingDB *staking.StakingDB
 
 	triesInMemory uint64 // Number of blocks to be saved in db without being erased when gc mode is not archive
+	txLookupLimit uint64 // Number of recent blocks to maintain transaction lookups for, 0 indexes the entire chain
 }
 
 // NewBlockChain returns a fully initialised block chain using information
 // available in the database. It initialises the default Ethereum Validator and
 // Processor.
-func NewBlockChain(stakingDB *staking.StakingDB, db berithdb.Database, cacheConfig *CacheConfig, chainConfig *params.ChainConfig, engine consensus.Engine, vmConfig vm.Config, shouldPreserve func(block *types.Block) bool) (*BlockChain, error) {
+//
+// If txLookupLimit is non-nil, the transaction index is maintained in the
+// background to cover only the given number of recent blocks (0 for all).
+func NewBlockChain(stakingDB *staking.StakingDB, db berithdb.Database, cacheConfig *CacheConfig, chainConfig *params.ChainConfig, engine consensus.Engine, vmConfig vm.Config, shouldPreserve func(block *types.Block) bool, txLookupLimit *uint64) (*BlockChain, error) {
 	if cacheConfig == nil {
 		cacheConfig = &CacheConfig{
 			TrieCleanLimit: 256,
@@ -160,6 +173,7 @@ func NewBlockChain(stakingDB *staking.StakingDB, db berithdb.Database, cacheConf
 	blockCache, _ := lru.New(blockCacheLimit)
 	futureBlocks, _ := lru.New(maxFutureBlocks)
 	badBlocks, _ := lru.New(badBlockLimit)
+	regenCache, _ := lru.New(regenCacheLimit)
 
 	bc := &BlockChain{
 		chainConfig:    chainConfig,
@@ -174,6 +188,7 @@ func NewBlockChain(stakingDB *staking.StakingDB, db berithdb.Database, cacheConf
 		receiptsCache:  receiptsCache,
 		blockCache:     blockCache,
 		futureBlocks:   futureBlocks,
+		regenCache:     regenCache,
 		engine:         engine,
 		vmConfig:       vmConfig,
 		badBlocks:      badBlocks,
@@ -208,9 +223,21 @@ func NewBlockChain(stakingDB *staking.StakingDB, db berithdb.Database, cacheConf
 			}
 		}
 	}
+	// [BERITH] Load any existing snapshot, regenerating it if loading failed
+	if bc.cacheConfig.SnapshotLimit > 0 {
+		bc.snaps = snapshot.New(bc.db, bc.stateCache.TrieDB(), bc.cacheConfig.SnapshotLimit, bc.CurrentBlock().Root())
+	}
 
 	// Take ownership of this particular state
 	go bc.update()
+
+	// Start the transaction index maintainer if the index is to be managed
+	if txLookupLimit != nil {
+		bc.txLookupLimit = *txLookupLimit
+
+		bc.wg.Add(1)
+		go bc.maintainTxIndex()
+	}
 	return bc, nil
 }
 
@@ -299,12 +326,20 @@ func (bc *BlockChain) SetHead(head uint64) error {
 	bc.hc.SetHead(head, delFn)
 	currentHeader := bc.hc.CurrentHeader()
 
+	// Drop the frozen blocks above the new head, they are no longer canonical
+	if adb, ok := bc.db.(rawdb.AncientWriter); ok {
+		if err := adb.TruncateAncients(currentHeader.Number.Uint64() + 1); err != nil {
+			return err
+		}
+	}
+
 	// Clear out any stale content from the caches
 	bc.bodyCache.Purge()
 	bc.bodyRLPCache.Purge()
 	bc.receiptsCache.Purge()
 	bc.blockCache.Purge()
 	bc.futureBlocks.Purge()
+	bc.regenCache.Purge()
 
 	// Rewind the block chain, ensuring we don't end up with a stateless head block
 	if currentBlock := bc.CurrentBlock(); currentBlock != nil && currentHeader.Number.Uint64() < currentBlock.NumberU64() {
@@ -333,6 +368,10 @@ func (bc *BlockChain) SetHead(head uint64) error {
 	rawdb.WriteHeadBlockHash(bc.db, currentBlock.Hash())
 	rawdb.WriteHeadFastBlockHash(bc.db, currentFastBlock.Hash())
 
+	// Rewound state may be below the snapshot disk layer, regenerate it
+	if bc.snaps != nil {
+		bc.snaps.Rebuild(currentBlock.Root())
+	}
 	return bc.loadLastState()
 }
 
@@ -356,6 +395,45 @@ func (bc *BlockChain) FastSyncCommitHead(hash common.Hash) error {
 	return nil
 }
 
+// [BERITH] Stakers retrieves the staker set of the consensus engine after the
+// given block. Engines keeping no staker set report an empty one.
+func (bc *BlockChain) Stakers(hash common.Hash) ([]common.Address, error) {
+	syncer, ok := bc.engine.(consensus.StakersSyncer)
+	if !ok {
+		return nil, nil
+	}
+	header := bc.GetHeaderByHash(hash)
+	if header == nil {
+		return nil, fmt.Errorf("non existent block [%x…]", hash[:4])
+	}
+	return syncer.Stakers(bc, header)
+}
+
+// [BERITH] RebuildStakers reconstructs the staker set of the consensus engine at
+// the given block, whose state was downloaded rather than built by executing the
+// chain. The rebuilt set is checked against the claimed one, if not nil. Engines
+// keeping no staker set are left untouched.
+func (bc *BlockChain) RebuildStakers(hash common.Hash, claimed []common.Address) error {
+	syncer, ok := bc.engine.(consensus.StakersSyncer)
+	if !ok {
+		return nil
+	}
+	block := bc.GetBlockByHash(hash)
+	if block == nil {
+		return fmt.Errorf("non existent block [%x…]", hash[:4])
+	}
+	statedb, err := bc.StateAt(block.Root())
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	if err := syncer.RebuildStakers(bc, block.Header(), statedb, claimed); err != nil {
+		return err
+	}
+	log.Info("Rebuilt staker set", "number", block.Number(), "hash", hash, "claimed", claimed != nil, "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
+
 // GasLimit returns the gas limit of the current HEAD block.
 func (bc *BlockChain) GasLimit() uint64 {
 	return bc.CurrentBlock().GasLimit()
@@ -409,7 +487,7 @@ func (bc *BlockChain) State() (*state.StateDB, error) {
 // StateAt returns a new mutable state based on a particular point in time.
 // 특정 시점의 불변하는 state를 반환
 func (bc *BlockChain) StateAt(root common.Hash) (*state.StateDB, error) {
-	return state.New(root, bc.stateCache)
+	return state.NewWithSnapshot(root, bc.stateCache, bc.snaps)
 }
 
 // StateCache returns the caching database underpinning the blockchain instance.
@@ -710,6 +788,15 @@ func (bc *BlockChain) Stop() {
 
 	bc.wg.Wait()
 
+	// Persist the in-memory snapshot layers into the journal, keeping the trie
+	// of the snapshot disk layer around for any unfinished generation.
+	var snapBase common.Hash
+	if bc.snaps != nil {
+		var err error
+		if snapBase, err = bc.snaps.Journal(bc.CurrentBlock().Root()); err != nil {
+			log.Error("Failed to journal state snapshot", "err", err)
+		}
+	}
 	// Ensure the state of a recent block is also stored to disk before exiting.
 	// We're writing three different states to catch different restart scenarios:
 	//  - HEAD:     So we don't need to reprocess any blocks in the general case
@@ -728,6 +815,12 @@ func (bc *BlockChain) Stop() {
 				}
 			}
 		}
+		if snapBase != (common.Hash{}) {
+			log.Info("Writing snapshot state to disk", "root", snapBase)
+			if err := triedb.Commit(snapBase, true); err != nil {
+				log.Error("Failed to commit snapshot state trie", "err", err)
+			}
+		}
 		for !bc.triegc.Empty() {
 			triedb.Dereference(bc.triegc.PopItem().(common.Hash))
 		}
@@ -849,7 +942,14 @@ func (bc *BlockChain) InsertReceiptChain(blockChain types.Blocks, receiptChain [
 		start = time.Now()
 		bytes = 0
 		batch = bc.db.NewBatch()
+
+		// Transactions of blocks already outside the lookup limit are not indexed
+		cutoff = bc.txIndexCutoff(bc.CurrentHeader().Number.Uint64())
 	)
+	// A fresh sync starts the limited index right at the cutoff
+	if len(blockChain) > 0 && blockChain[0].NumberU64() == 1 && cutoff > 0 && rawdb.ReadTxIndexTail(bc.db) == nil {
+		rawdb.WriteTxIndexTail(batch, cutoff)
+	}
 	for i, block := range blockChain {
 		receipts := receiptChain[i]
 		// Short circuit insertion if shutting down or processing failed
@@ -872,7 +972,9 @@ func (bc *BlockChain) InsertReceiptChain(blockChain types.Blocks, receiptChain [
 		// Write all the data out into the database
 		rawdb.WriteBody(batch, block.Hash(), block.NumberU64(), block.Body())
 		rawdb.WriteReceipts(batch, block.Hash(), block.NumberU64(), receipts)
-		rawdb.WriteTxLookupEntries(batch, block)
+		if block.NumberU64() >= cutoff {
+			rawdb.WriteTxLookupEntries(batch, block)
+		}
 
 		stats.processed++
 
@@ -1029,6 +1131,19 @@ func (bc *BlockChain) WriteBlockWithState(block *types.Block, receipts []*types.
 	// Set new head.
 	if status == CanonStatTy {
 		bc.insert(block)
+
+		// A reorg deeper than the snapshot diff layers leaves the new head
+		// without a snapshot, regenerate it from the head state. Otherwise
+		// flatten the layers beyond the in-memory limit into the disk layer,
+		// dropping the side branches below it.
+		if bc.snaps != nil {
+			if bc.snaps.Snapshot(block.Root()) == nil {
+				log.Warn("State snapshot missing for new head", "number", block.Number(), "root", block.Root())
+				bc.snaps.Rebuild(block.Root())
+			} else if err := bc.snaps.Cap(block.Root(), snapshotLayers); err != nil {
+				log.Warn("Failed to cap snapshot tree", "root", block.Root(), "layers", snapshotLayers, "err", err)
+			}
+		}
 	}
 	bc.futureBlocks.Remove(block.Hash())
 	return status, nil
@@ -1186,7 +1301,7 @@ func (bc *BlockChain) insertChain(chain types.Blocks, verifySeals bool) (int, []
 		if parent == nil {
 			parent = bc.GetBlock(block.ParentHash(), block.NumberU64()-1)
 		}
-		state, err := state.New(parent.Root(), bc.stateCache)
+		state, err := state.NewWithSnapshot(parent.Root(), bc.stateCache, bc.snaps)
 		if err != nil {
 			return it.index, events, coalescedLogs, err
 		}
@@ -1547,6 +1662,65 @@ func (bc *BlockChain) update() {
 	}
 }
 
+// txIndexCutoff returns the number of the oldest block whose transactions are
+// to be indexed with the given chain head.
+func (bc *BlockChain) txIndexCutoff(head uint64) uint64 {
+	if bc.txLookupLimit == 0 || head < bc.txLookupLimit {
+		return 0
+	}
+	return head - bc.txLookupLimit + 1
+}
+
+// maintainTxIndex is responsible for the construction and deletion of the
+// transaction index, keeping it in line with the configured lookup limit as
+// the chain head progresses. The progress is tracked by the index tail in the
+// database, so an interrupted run is resumed on the next start.
+func (bc *BlockChain) maintainTxIndex() {
+	defer bc.wg.Done()
+
+	// indexBlocks moves the index tail to the cutoff of the given head
+	indexBlocks := func(head uint64, done chan struct{}) {
+		defer close(done)
+
+		cutoff := bc.txIndexCutoff(head)
+		tail := rawdb.ReadTxIndexTail(bc.db)
+		switch {
+		case tail == nil:
+			// The entire chain is indexed, drop everything below the cutoff
+			rawdb.UnindexTransactions(bc.db, 0, cutoff, bc.quit)
+		case *tail < cutoff:
+			rawdb.UnindexTransactions(bc.db, *tail, cutoff, bc.quit)
+		case *tail > cutoff:
+			rawdb.IndexTransactions(bc.db, cutoff, *tail, bc.quit)
+		}
+	}
+	headCh := make(chan ChainHeadEvent, 1)
+	sub := bc.SubscribeChainHeadEvent(headCh)
+	defer sub.Unsubscribe()
+
+	// Launch the initial processing, the chain may have been idle for a while
+	done := make(chan struct{})
+	go indexBlocks(bc.CurrentBlock().NumberU64(), done)
+
+	for {
+		select {
+		case head := <-headCh:
+			if done == nil {
+				done = make(chan struct{})
+				go indexBlocks(head.Block.NumberU64(), done)
+			}
+		case <-done:
+			done = nil
+		case <-bc.quit:
+			if done != nil {
+				log.Info("Waiting for the transaction indexer to exit")
+				<-done
+			}
+			return
+		}
+	}
+}
+
 // BadBlocks returns a list of the last 'bad blocks' that the client has seen on the network
 func (bc *BlockChain) BadBlocks() []*types.Block {
 	blocks := make([]*types.Block, 0, bc.badBlocks.Len())
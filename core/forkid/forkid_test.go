@@ -0,0 +1,190 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package forkid
+
+import (
+	"bytes"
+	"math"
+	"math/big"
+	"testing"
+
+	"github.com/BerithFoundation/berith-chain/common"
+	"github.com/BerithFoundation/berith-chain/params"
+	"github.com/BerithFoundation/berith-chain/rlp"
+)
+
+// TestCreation tests that different genesis and fork rule combinations result in
+// the correct fork ID.
+func TestCreation(t *testing.T) {
+	type testcase struct {
+		head uint64
+		want ID
+	}
+	tests := []struct {
+		config  *params.ChainConfig
+		genesis common.Hash
+		cases   []testcase
+	}{
+		// Mainnet test cases
+		{
+			params.MainnetChainConfig,
+			params.MainnetGenesisHash,
+			[]testcase{
+				{0, ID{Hash: checksumToBytes(0xe7eab0ed), Next: 508000}},          // Unsynced
+				{507999, ID{Hash: checksumToBytes(0xe7eab0ed), Next: 508000}},     // Last pre-BIP1 block
+				{508000, ID{Hash: checksumToBytes(0xf3ae23c1), Next: 545000}},     // First BIP1 block
+				{544999, ID{Hash: checksumToBytes(0xf3ae23c1), Next: 545000}},     // Last BIP1 block
+				{545000, ID{Hash: checksumToBytes(0x1b808774), Next: 1168000}},    // First BIP2 block
+				{1167999, ID{Hash: checksumToBytes(0x1b808774), Next: 1168000}},   // Last BIP2 block
+				{1168000, ID{Hash: checksumToBytes(0x4a5bcbce), Next: 6130000}},   // First BIP3 block
+				{6129999, ID{Hash: checksumToBytes(0x4a5bcbce), Next: 6130000}},   // Last BIP3 block
+				{6130000, ID{Hash: checksumToBytes(0x555036d4), Next: 21314000}},  // First BIP4 block
+				{21313999, ID{Hash: checksumToBytes(0x555036d4), Next: 21314000}}, // Last BIP4 block
+				{21314000, ID{Hash: checksumToBytes(0x9601088b), Next: 0}},        // First BIP5 block
+				{30000000, ID{Hash: checksumToBytes(0x9601088b), Next: 0}},        // Future BIP5 block
+			},
+		},
+		// Testnet test cases
+		{
+			params.TestnetChainConfig,
+			params.TestnetGenesisHash,
+			[]testcase{
+				{0, ID{Hash: checksumToBytes(0x345f139c), Next: 720}},   // Unsynced, BIP1-4 activated at genesis
+				{719, ID{Hash: checksumToBytes(0x345f139c), Next: 720}}, // Last pre-BIP5 block
+				{720, ID{Hash: checksumToBytes(0x56a7ecb6), Next: 0}},   // First BIP5 block
+				{5000, ID{Hash: checksumToBytes(0x56a7ecb6), Next: 0}},  // Future BIP5 block
+			},
+		},
+	}
+	for i, tt := range tests {
+		for j, ttt := range tt.cases {
+			if have := newID(tt.config, tt.genesis, ttt.head); have != ttt.want {
+				t.Errorf("test %d, case %d: fork ID mismatch: have %x, want %x", i, j, have, ttt.want)
+			}
+		}
+	}
+}
+
+// TestValidation tests that a local peer correctly validates and accepts a remote
+// fork ID.
+func TestValidation(t *testing.T) {
+	tests := []struct {
+		head uint64
+		id   ID
+		err  error
+	}{
+		// Local is mainnet BIP3, remote announces the same. No future fork is announced.
+		{2000000, ID{Hash: checksumToBytes(0x4a5bcbce), Next: 0}, nil},
+
+		// Local is mainnet BIP3, remote announces the same. Remote also announces a next fork
+		// at block 0xffffffff, but that is uncertain.
+		{2000000, ID{Hash: checksumToBytes(0x4a5bcbce), Next: math.MaxUint64}, nil},
+
+		// Local is mainnet currently in BIP2 only (so it's aware of BIP3), remote announces
+		// also BIP2, but it's not yet aware of BIP3 (e.g. non updated node before the fork).
+		// In this case we don't know if BIP3 passed yet or not.
+		{1000000, ID{Hash: checksumToBytes(0x1b808774), Next: 0}, nil},
+
+		// Local is mainnet currently in BIP2 only (so it's aware of BIP3), remote announces
+		// also BIP2, and it's also aware of BIP3 (e.g. updated node before the fork). We
+		// don't know if BIP3 passed yet (will pass) or not.
+		{1000000, ID{Hash: checksumToBytes(0x1b808774), Next: 1168000}, nil},
+
+		// Local is mainnet currently in BIP2 only (so it's aware of BIP3), remote announces
+		// also BIP2, and it's also aware of some random fork (e.g. misconfigured BIP3). As
+		// neither forks passed at neither nodes, they may mismatch, but we still connect for now.
+		{1000000, ID{Hash: checksumToBytes(0x1b808774), Next: math.MaxUint64}, nil},
+
+		// Local is mainnet BIP4, remote announces BIP3 + knowledge about BIP4. Remote is simply
+		// out of sync, accept.
+		{7000000, ID{Hash: checksumToBytes(0x4a5bcbce), Next: 6130000}, nil},
+
+		// Local is mainnet BIP4, remote announces BIP1 + knowledge about BIP2. Remote is
+		// definitely out of sync. It may or may not need the BIP4 update, we don't know yet.
+		{7000000, ID{Hash: checksumToBytes(0xf3ae23c1), Next: 545000}, nil},
+
+		// Local is mainnet BIP3, remote announces BIP4. Local is out of sync, accept.
+		{2000000, ID{Hash: checksumToBytes(0x555036d4), Next: 21314000}, nil},
+
+		// Local is mainnet BIP1, remote announces BIP4. Local is definitely out of sync. It may
+		// or may not need the BIP4 update, we don't know yet.
+		{510000, ID{Hash: checksumToBytes(0x555036d4), Next: 21314000}, nil},
+
+		// Local is mainnet BIP4, remote announces BIP3 but is not aware of BIP4. Remote needs
+		// software update.
+		{7000000, ID{Hash: checksumToBytes(0x4a5bcbce), Next: 0}, ErrRemoteStale},
+
+		// Local is mainnet BIP4, remote announces BIP3 and a fork at block 7000000 instead of
+		// BIP4 at 6130000. Remote needs software update.
+		{7000000, ID{Hash: checksumToBytes(0x4a5bcbce), Next: 7000000}, ErrRemoteStale},
+
+		// Local is mainnet BIP5, remote announces BIP5 + a fork at block 30000000 that we're
+		// not aware of, but which we have passed already. Local is incompatible or stale.
+		{30000001, ID{Hash: checksumToBytes(0x9601088b), Next: 30000000}, ErrLocalIncompatibleOrStale},
+
+		// Local is mainnet BIP5, remote is random junk (e.g. a different network). Reject.
+		{30000000, ID{Hash: checksumToBytes(0xafec6b27), Next: 0}, ErrLocalIncompatibleOrStale},
+
+		// Local is mainnet BIP4, remote announces testnet BIP5. Reject.
+		{7000000, ID{Hash: checksumToBytes(0x56a7ecb6), Next: 0}, ErrLocalIncompatibleOrStale},
+	}
+	for i, tt := range tests {
+		filter := newFilter(params.MainnetChainConfig, params.MainnetGenesisHash, func() uint64 { return tt.head })
+		if err := filter(tt.id); err != tt.err {
+			t.Errorf("test %d: validation error mismatch: have %v, want %v", i, err, tt.err)
+		}
+	}
+}
+
+// Tests that only the BIP fork blocks are gathered, deduplicated and stripped of
+// the genesis ruleset.
+func TestGatherForks(t *testing.T) {
+	config := &params.ChainConfig{
+		HomesteadBlock: big.NewInt(10),
+		BIP1Block:      big.NewInt(0),
+		BIP2Block:      big.NewInt(20),
+		BIP3Block:      big.NewInt(20),
+		BIP5Block:      big.NewInt(15),
+	}
+	forks := gatherForks(config)
+	if len(forks) != 2 || forks[0] != 15 || forks[1] != 20 {
+		t.Errorf("fork block mismatch: have %v, want [15 20]", forks)
+	}
+}
+
+// Tests that IDs are properly RLP encoded (specifically important because we
+// use uint32 to store the hash, but we need to encode it as [4]byte).
+func TestEncoding(t *testing.T) {
+	tests := []struct {
+		id   ID
+		want []byte
+	}{
+		{ID{Hash: checksumToBytes(0), Next: 0}, common.Hex2Bytes("c6840000000080")},
+		{ID{Hash: checksumToBytes(0xdeadbeef), Next: 0xBADDCAFE}, common.Hex2Bytes("ca84deadbeef84baddcafe")},
+		{ID{Hash: checksumToBytes(math.MaxUint32), Next: math.MaxUint64}, common.Hex2Bytes("ce84ffffffff88ffffffffffffffff")},
+	}
+	for i, tt := range tests {
+		have, err := rlp.EncodeToBytes(tt.id)
+		if err != nil {
+			t.Errorf("test %d: failed to encode forkid: %v", i, err)
+			continue
+		}
+		if !bytes.Equal(have, tt.want) {
+			t.Errorf("test %d: RLP mismatch: have %x, want %x", i, have, tt.want)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package core
+
+import (
+	"math/big"
+
+	"github.com/BerithFoundation/berith-chain/common"
+	"github.com/BerithFoundation/berith-chain/core/types"
+	"github.com/BerithFoundation/berith-chain/core/vm"
+	"github.com/BerithFoundation/berith-chain/crypto"
+	"github.com/BerithFoundation/berith-chain/params"
+	"github.com/BerithFoundation/berith-chain/rlp"
+)
+
+/*
+[BERITH]
+Multisig accounts keep their configuration in the storage of the account. The
+account has no code, so the storage can not be modified by the EVM.
+
+	slot 0      threshold
+	slot 1      number of owners
+	slot 2..n+1 owners
+*/
+var (
+	multisigThresholdSlot = types.MultisigThresholdSlot
+	multisigOwnersSlot    = common.BigToHash(big.NewInt(1))
+)
+
+func multisigOwnerSlot(i int) common.Hash {
+	return common.BigToHash(big.NewInt(int64(i) + 2))
+}
+
+// ReadMultisig returns the configuration of the multisig account at addr, or
+// nil if addr is not a multisig account.
+func ReadMultisig(db vm.StateDB, addr common.Address) *types.MultisigConfig {
+	threshold := db.GetState(addr, multisigThresholdSlot).Big().Uint64()
+	if threshold == 0 {
+		return nil
+	}
+	count := int(db.GetState(addr, multisigOwnersSlot).Big().Uint64())
+	config := &types.MultisigConfig{
+		Owners:    make([]common.Address, count),
+		Threshold: threshold,
+	}
+	for i := 0; i < count; i++ {
+		config.Owners[i] = common.BytesToAddress(db.GetState(addr, multisigOwnerSlot(i)).Bytes())
+	}
+	return config
+}
+
+func writeMultisig(db vm.StateDB, addr common.Address, config *types.MultisigConfig) {
+	db.SetState(addr, multisigThresholdSlot, common.BigToHash(new(big.Int).SetUint64(config.Threshold)))
+	db.SetState(addr, multisigOwnersSlot, common.BigToHash(big.NewInt(int64(len(config.Owners)))))
+	for i, owner := range config.Owners {
+		db.SetState(addr, multisigOwnerSlot(i), owner.Hash())
+	}
+}
+
+// VerifyMultisig checks that the signers of a transaction of the multisig
+// account at addr are owners of it and reach its threshold.
+func VerifyMultisig(db vm.StateDB, addr common.Address, signers []common.Address) error {
+	config := ReadMultisig(db, addr)
+	if config == nil {
+		return ErrUnknownMultisig
+	}
+	owners := make(map[common.Address]struct{}, len(config.Owners))
+	for _, owner := range config.Owners {
+		owners[owner] = struct{}{}
+	}
+	var approvals uint64
+	for _, signer := range signers {
+		if _, ok := owners[signer]; !ok {
+			return ErrMultisigNotOwner
+		}
+		approvals++
+	}
+	if approvals < config.Threshold {
+		return ErrMultisigThreshold
+	}
+	return nil
+}
+
+// IsMultisigRegistration reports whether the message registers a multisig
+// account at the given block.
+func IsMultisigRegistration(config *params.ChainConfig, num *big.Int, msg Message) bool {
+	return msg.To() != nil && *msg.To() == types.MultisigRegistry && config.IsBIP7(num)
+}
+
+// DecodeMultisigRegistration decodes and validates the payload of a multisig
+// registration transaction.
+func DecodeMultisigRegistration(data []byte) (*types.MultisigConfig, error) {
+	config := new(types.MultisigConfig)
+	if err := rlp.DecodeBytes(data, config); err != nil {
+		return nil, types.ErrInvalidMultisigConfig
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// MultisigRegistrationGas returns the gas charged on top of the intrinsic gas
+// for storing the configuration of a multisig account.
+func MultisigRegistrationGas(config *types.MultisigConfig) uint64 {
+	return params.SstoreSetGas * uint64(len(config.Owners)+2)
+}
+
+// registerMultisig creates the multisig account defined by the payload of the
+// message. The account address is derived from the creator and its nonce in the
+// same way as a contract address. The value of the message funds the account.
+// The returned error is an execution error, the state is reverted and all the
+// remaining gas is consumed.
+func (st *StateTransition) registerMultisig(nonce uint64) error {
+	var (
+		from     = st.msg.From()
+		snapshot = st.state.Snapshot()
+	)
+	err := func() error {
+		if st.msg.Base() != types.Main || st.msg.Target() != types.Main {
+			return types.ErrInvalidMultisigConfig
+		}
+		config, err := DecodeMultisigRegistration(st.data)
+		if err != nil {
+			return err
+		}
+		if err := st.useGas(MultisigRegistrationGas(config)); err != nil {
+			return err
+		}
+		addr := crypto.CreateAddress(from, nonce)
+		if st.state.GetNonce(addr) != 0 || st.state.GetCodeSize(addr) != 0 || ReadMultisig(st.state, addr) != nil {
+			return vm.ErrContractAddressCollision
+		}
+		if !st.evm.Context.CanTransfer(st.state, from, st.value, types.Main) {
+			return vm.ErrInsufficientBalance
+		}
+		// The nonce is set like a contract account so that the account is not
+		// removed as empty before it is funded.
+		st.state.SetNonce(addr, 1)
+		writeMultisig(st.state, addr, config)
+		st.evm.Context.Transfer(st.state, from, addr, st.value, st.evm.BlockNumber, types.Main, types.Main)
+		return nil
+	}()
+	if err != nil {
+		st.state.RevertToSnapshot(snapshot)
+		st.gas = 0
+	}
+	return err
+}
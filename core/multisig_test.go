@@ -0,0 +1,79 @@
+package core
+
+import (
+	"crypto/ecdsa"
+	"math/big"
+	"testing"
+
+	"github.com/BerithFoundation/berith-chain/berithdb"
+	"github.com/BerithFoundation/berith-chain/common"
+	"github.com/BerithFoundation/berith-chain/core/state"
+	"github.com/BerithFoundation/berith-chain/core/types"
+	"github.com/BerithFoundation/berith-chain/core/vm"
+	"github.com/BerithFoundation/berith-chain/crypto"
+	"github.com/BerithFoundation/berith-chain/params"
+	"github.com/BerithFoundation/berith-chain/rlp"
+)
+
+// applyMultisigTx executes tx on statedb as part of block 1.
+func applyMultisigTx(t *testing.T, statedb *state.StateDB, config *params.ChainConfig, tx *types.Transaction) (*ExecutionResult, error) {
+	head := &types.Header{Number: big.NewInt(1), GasLimit: 10000000, Difficulty: big.NewInt(1), Time: big.NewInt(0)}
+	msg, err := tx.AsMessage(types.NewEIP155Signer(config.ChainID))
+	if err != nil {
+		t.Fatalf("could not convert to message: %v", err)
+	}
+	author := common.Address{0xff}
+	evm := vm.NewEVM(NewEVMContext(msg, head, nil, &author), statedb, config, vm.Config{})
+	return ApplyMessage(evm, msg, new(GasPool).AddGas(head.GasLimit))
+}
+
+func TestMultisigStake(t *testing.T) {
+	config := *params.TestnetChainConfig
+	config.BIP7Block = big.NewInt(0)
+	signer := types.NewEIP155Signer(config.ChainID)
+
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(berithdb.NewMemDatabase()))
+	creatorKey, _ := crypto.GenerateKey()
+	creator := crypto.PubkeyToAddress(creatorKey.PublicKey)
+	statedb.AddBalance(creator, new(big.Int).Mul(big.NewInt(1000000), common.UnitForBer))
+
+	keys := make([]*ecdsa.PrivateKey, 3)
+	owners := make([]common.Address, 3)
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+		owners[i] = crypto.PubkeyToAddress(keys[i].PublicKey)
+	}
+	payload, _ := rlp.EncodeToBytes(&types.MultisigConfig{Owners: owners, Threshold: 2})
+	funds := new(big.Int).Mul(big.NewInt(500000), common.UnitForBer)
+
+	// Register the 2-of-3 account and fund it
+	tx, _ := types.SignTx(types.NewTransaction(0, types.MultisigRegistry, funds, 200000, big.NewInt(1), payload, types.Main, types.Main, false), signer, creatorKey)
+	if res, err := applyMultisigTx(t, statedb, &config, tx); err != nil || res.Failed() {
+		t.Fatalf("registration failed: %v %v", err, res.Err)
+	}
+	account := crypto.CreateAddress(creator, 0)
+	if ms := ReadMultisig(statedb, account); ms == nil || ms.Threshold != 2 || len(ms.Owners) != 3 {
+		t.Fatalf("multisig config mismatch: %+v", ms)
+	}
+	if statedb.GetBalance(account).Cmp(funds) != 0 {
+		t.Fatalf("multisig balance mismatch: have %v, want %v", statedb.GetBalance(account), funds)
+	}
+
+	// A single owner can not stake on behalf of the account
+	stake := new(big.Int).Mul(big.NewInt(100000), common.UnitForBer)
+	tx = types.NewTransaction(1, account, stake, 21000, big.NewInt(1), nil, types.Main, types.Stake, false).WithMultisig(account)
+	tx, _ = types.SignTx(tx, signer, keys[0])
+	if _, err := applyMultisigTx(t, statedb, &config, tx); err != ErrMultisigThreshold {
+		t.Fatalf("threshold error mismatch: have %v, want %v", err, ErrMultisigThreshold)
+	}
+
+	// Two owners can
+	sig, _ := crypto.Sign(signer.Hash(tx).Bytes(), keys[2])
+	tx, _ = tx.WithCosignature(signer, sig)
+	if res, err := applyMultisigTx(t, statedb, &config, tx); err != nil || res.Failed() {
+		t.Fatalf("multisig stake failed: %v %v", err, res.Err)
+	}
+	if statedb.GetStakeBalance(account).Cmp(stake) != 0 {
+		t.Fatalf("stake balance mismatch: have %v, want %v", statedb.GetStakeBalance(account), stake)
+	}
+}
@@ -17,12 +17,34 @@
 package rawdb
 
 import (
+	"encoding/binary"
+
 	"github.com/BerithFoundation/berith-chain/common"
 	"github.com/BerithFoundation/berith-chain/core/types"
 	"github.com/BerithFoundation/berith-chain/log"
 	"github.com/BerithFoundation/berith-chain/rlp"
 )
 
+// ReadTxIndexTail retrieves the number of the oldest block whose transactions
+// are indexed. Nil is returned if the index was never limited, in which case
+// every block is indexed.
+func ReadTxIndexTail(db DatabaseReader) *uint64 {
+	data, _ := db.Get(txIndexTailKey)
+	if len(data) != 8 {
+		return nil
+	}
+	number := binary.BigEndian.Uint64(data)
+	return &number
+}
+
+// WriteTxIndexTail stores the number of the oldest block whose transactions
+// are indexed.
+func WriteTxIndexTail(db DatabaseWriter, number uint64) {
+	if err := db.Put(txIndexTailKey, encodeBlockNumber(number)); err != nil {
+		log.Crit("Failed to store transaction index tail", "err", err)
+	}
+}
+
 // ReadTxLookupEntry retrieves the positional metadata associated with a transaction
 // hash to allow retrieving the transaction or receipt by hash.
 func ReadTxLookupEntry(db DatabaseReader, hash common.Hash) (common.Hash, uint64, uint64) {
@@ -108,3 +130,17 @@ func WriteBloomBits(db DatabaseWriter, bit uint, section uint64, head common.Has
 		log.Crit("Failed to store bloom bits", "err", err)
 	}
 }
+
+// ReadSupplyRLP retrieves the RLP encoded supply and issuance record of a block
+// maintained by the supply indexer.
+func ReadSupplyRLP(db DatabaseReader, hash common.Hash, number uint64) rlp.RawValue {
+	data, _ := db.Get(supplyKey(number, hash))
+	return data
+}
+
+// WriteSupplyRLP stores the RLP encoded supply and issuance record of a block.
+func WriteSupplyRLP(db DatabaseWriter, hash common.Hash, number uint64, rlp rlp.RawValue) {
+	if err := db.Put(supplyKey(number, hash), rlp); err != nil {
+		log.Crit("Failed to store block supply", "err", err)
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"time"
+
+	"github.com/BerithFoundation/berith-chain/berithdb"
+	"github.com/BerithFoundation/berith-chain/common"
+	"github.com/BerithFoundation/berith-chain/log"
+)
+
+// IndexTransactions creates the transaction lookup entries of the canonical
+// blocks in the range [from, to), iterating backwards so that the index tail
+// can be moved down after every flushed batch. The iteration stops early if the
+// interrupt channel is closed, leaving the tail at the last completed block.
+func IndexTransactions(db berithdb.Database, from uint64, to uint64, interrupt chan struct{}) {
+	if from >= to {
+		return
+	}
+	var (
+		batch   = db.NewBatch()
+		start   = time.Now()
+		logged  = time.Now()
+		tail    = to
+		indexed int
+	)
+	for number := to; number > from; number-- {
+		if interrupted(interrupt) {
+			break
+		}
+		block := ReadBlock(db, ReadCanonicalHash(db, number-1), number-1)
+		if block == nil {
+			log.Error("Canonical block missing, aborting transaction indexing", "number", number-1)
+			break
+		}
+		WriteTxLookupEntries(batch, block)
+		indexed += len(block.Transactions())
+
+		if batch.ValueSize() > berithdb.IdealBatchSize || number-1 == from {
+			// Only move the tail once the entries below it are on disk
+			WriteTxIndexTail(batch, number-1)
+			if err := batch.Write(); err != nil {
+				log.Crit("Failed to write transaction indexes", "err", err)
+			}
+			batch.Reset()
+			tail = number - 1
+		}
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Indexing transactions", "blocks", to-number+1, "txs", indexed, "tail", number-1, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+	}
+	log.Info("Indexed transactions", "blocks", to-tail, "txs", indexed, "tail", tail, "elapsed", common.PrettyDuration(time.Since(start)))
+}
+
+// UnindexTransactions removes the transaction lookup entries of the canonical
+// blocks in the range [from, to), moving the index tail up after every flushed
+// batch. The iteration stops early if the interrupt channel is closed.
+func UnindexTransactions(db berithdb.Database, from uint64, to uint64, interrupt chan struct{}) {
+	if from >= to {
+		return
+	}
+	var (
+		batch     = db.NewBatch()
+		start     = time.Now()
+		logged    = time.Now()
+		tail      = from
+		unindexed int
+	)
+	for number := from; number < to; number++ {
+		if interrupted(interrupt) {
+			break
+		}
+		body := ReadBody(db, ReadCanonicalHash(db, number), number)
+		if body == nil {
+			log.Error("Canonical body missing, aborting transaction unindexing", "number", number)
+			break
+		}
+		for _, tx := range body.Transactions {
+			DeleteTxLookupEntry(batch, tx.Hash())
+		}
+		unindexed += len(body.Transactions)
+
+		if batch.ValueSize() > berithdb.IdealBatchSize || number+1 == to {
+			// Only move the tail once the entries below it are gone
+			WriteTxIndexTail(batch, number+1)
+			if err := batch.Write(); err != nil {
+				log.Crit("Failed to delete transaction indexes", "err", err)
+			}
+			batch.Reset()
+			tail = number + 1
+		}
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Unindexing transactions", "blocks", number-from+1, "txs", unindexed, "tail", number+1, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+	}
+	log.Info("Unindexed transactions", "blocks", tail-from, "txs", unindexed, "tail", tail, "elapsed", common.PrettyDuration(time.Since(start)))
+}
+
+// interrupted returns whether the given interrupt channel has been closed.
+func interrupted(interrupt chan struct{}) bool {
+	select {
+	case <-interrupt:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/BerithFoundation/berith-chain/berithdb"
+	"github.com/BerithFoundation/berith-chain/common"
+	"github.com/BerithFoundation/berith-chain/core/types"
+)
+
+// Tests that the transaction index can be shrunk and grown back, with the tail
+// tracking the oldest indexed block.
+func TestIndexTransactions(t *testing.T) {
+	db := berithdb.NewMemDatabase()
+
+	var txs []*types.Transaction
+	for i := uint64(0); i < 10; i++ {
+		tx := types.NewTransaction(i, common.Address{0x01}, big.NewInt(1), 21000, big.NewInt(1), nil, types.Main, types.Main, false)
+		block := types.NewBlock(&types.Header{Number: new(big.Int).SetUint64(i)}, []*types.Transaction{tx}, nil, nil)
+		WriteBlock(db, block)
+		WriteCanonicalHash(db, block.Hash(), i)
+		WriteTxLookupEntries(db, block)
+		txs = append(txs, tx)
+	}
+	verify := func(tail uint64) {
+		t.Helper()
+		if have := ReadTxIndexTail(db); have == nil || *have != tail {
+			t.Fatalf("index tail mismatch: have %v, want %d", have, tail)
+		}
+		for i, tx := range txs {
+			hash, _, _ := ReadTxLookupEntry(db, tx.Hash())
+			if indexed := hash != (common.Hash{}); indexed != (uint64(i) >= tail) {
+				t.Fatalf("tx %d: have indexed %v, want %v", i, indexed, uint64(i) >= tail)
+			}
+		}
+	}
+	if tail := ReadTxIndexTail(db); tail != nil {
+		t.Fatalf("unexpected index tail %d", *tail)
+	}
+	UnindexTransactions(db, 0, 6, nil)
+	verify(6)
+	IndexTransactions(db, 3, 6, nil)
+	verify(3)
+
+	// An interrupted run must leave the index untouched
+	interrupt := make(chan struct{})
+	close(interrupt)
+	UnindexTransactions(db, 3, 8, interrupt)
+	verify(3)
+
+	IndexTransactions(db, 0, 3, nil)
+	verify(0)
+}
@@ -0,0 +1,251 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/BerithFoundation/berith-chain/berithdb"
+	"github.com/BerithFoundation/berith-chain/common"
+	"github.com/BerithFoundation/berith-chain/log"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+)
+
+// freezerdb is a database wrapper that enables freezer data retrievals.
+type freezerdb struct {
+	berithdb.Database
+	*freezer
+}
+
+// Close implements berithdb.Database, closing both the fast key-value store
+// and the slow ancient tables.
+func (frdb *freezerdb) Close() {
+	if err := frdb.freezer.Close(); err != nil {
+		log.Error("Failed to close ancient database", "err", err)
+	}
+	frdb.Database.Close()
+}
+
+// NewIteratorWithPrefix implements berithdb.Iteratee, iterating over the
+// key-value store only.
+func (frdb *freezerdb) NewIteratorWithPrefix(prefix []byte) iterator.Iterator {
+	if db, ok := frdb.Database.(berithdb.Iteratee); ok {
+		return db.NewIteratorWithPrefix(prefix)
+	}
+	return iterator.NewEmptyIterator(errNotSupported)
+}
+
+// NewDatabaseWithFreezer creates a high level database on top of a given key-
+// value data store with a freezer moving immutable chain segments into cold
+// storage at the given path.
+func NewDatabaseWithFreezer(db berithdb.Database, freezer string, namespace string) (berithdb.Database, error) {
+	// Create the idle freezer instance
+	frdb, err := newFreezer(freezer, namespace)
+	if err != nil {
+		return nil, err
+	}
+	// Since the freezer can be stored separately from the user's key-value database,
+	// there's a fairly high probability that the user requests invalid combinations
+	// of the freezer and database. Ensure that we don't shoot ourselves in the foot
+	// by serving up conflicting data, leading to both datastores getting corrupted.
+	if frozen, _ := frdb.Ancients(); frozen > 0 {
+		// If the freezer already contains something, ensure that the genesis blocks
+		// match, otherwise we might mix up freezers across chains and destroy both
+		// the freezer and the key-value store.
+		ancient, err := frdb.Ancient(freezerHashTable, 0)
+		if err != nil {
+			frdb.Close()
+			return nil, fmt.Errorf("failed to retrieve genesis from ancient %v", err)
+		}
+		if kvgenesis := ReadCanonicalHash(db, 0); kvgenesis != (common.Hash{}) && kvgenesis != common.BytesToHash(ancient) {
+			frdb.Close()
+			return nil, fmt.Errorf("genesis mismatch: %#x (leveldb) != %#x (ancients)", kvgenesis, ancient)
+		}
+	}
+	// Freezer is consistent with the key-value database, permit combining the two
+	go frdb.freeze(db)
+
+	return &freezerdb{
+		Database: db,
+		freezer:  frdb,
+	}, nil
+}
+
+// KeyValueStore returns the key-value store backing db, stripping off the
+// ancient store if db has one.
+func KeyValueStore(db berithdb.Database) berithdb.Database {
+	if frdb, ok := db.(*freezerdb); ok {
+		return frdb.Database
+	}
+	return db
+}
+
+// MigrateAncients moves every canonical block older than the immutability
+// threshold from the key-value store of db into its ancient store and returns
+// the number of blocks moved. It is used to migrate databases created before
+// the freezer existed without waiting for the background freezer to catch up.
+func MigrateAncients(db berithdb.Database) (uint64, error) {
+	frdb, ok := db.(*freezerdb)
+	if !ok {
+		return 0, errNotSupported
+	}
+	var total uint64
+	for {
+		frozen, err := frdb.freezeBatch(frdb.Database)
+		if err != nil {
+			return total, err
+		}
+		total += uint64(frozen)
+		if frozen < freezerBatchLimit {
+			return total, nil
+		}
+	}
+}
+
+// DatabaseStat is the number of entries and their total size of a category of
+// data stored in a database.
+type DatabaseStat struct {
+	Category string
+	Count    uint64
+	Size     common.StorageSize
+}
+
+// add records a single entry of the given size.
+func (s *DatabaseStat) add(size int) {
+	s.Count++
+	s.Size += common.StorageSize(size)
+}
+
+// InspectDatabase traverses the entire key-value store of db and sums up the
+// entries and their sizes per category of the database schema. The categories
+// of the ancient store are appended if db has one.
+func InspectDatabase(db berithdb.Database) ([]*DatabaseStat, error) {
+	iteratee, ok := KeyValueStore(db).(berithdb.Iteratee)
+	if !ok {
+		return nil, errNotSupported
+	}
+	it := iteratee.NewIteratorWithPrefix(nil)
+	defer it.Release()
+
+	var (
+		start  = time.Now()
+		logged = time.Now()
+		count  int
+
+		headers     = &DatabaseStat{Category: "Headers"}
+		tds         = &DatabaseStat{Category: "Total difficulties"}
+		hashes      = &DatabaseStat{Category: "Canonical hashes"}
+		numbers     = &DatabaseStat{Category: "Block number lookups"}
+		bodies      = &DatabaseStat{Category: "Bodies"}
+		receipts    = &DatabaseStat{Category: "Receipts"}
+		lookups     = &DatabaseStat{Category: "Transaction lookups"}
+		bloombits   = &DatabaseStat{Category: "Bloombits"}
+		supplies    = &DatabaseStat{Category: "Supply index"}
+		tries       = &DatabaseStat{Category: "Trie nodes and codes"}
+		accounts    = &DatabaseStat{Category: "Snapshot accounts"}
+		storages    = &DatabaseStat{Category: "Snapshot storages"}
+		preimages   = &DatabaseStat{Category: "Preimages"}
+		configs     = &DatabaseStat{Category: "Chain configs"}
+		indexes     = &DatabaseStat{Category: "Chain indexes"}
+		metadata    = &DatabaseStat{Category: "Singleton metadata"}
+		unaccounted = &DatabaseStat{Category: "Unaccounted"}
+	)
+	singletons := [][]byte{
+		databaseVerisionKey, headHeaderKey, headBlockKey, headFastBlockKey, fastTrieProgressKey,
+		txIndexTailKey, snapshotRootKey, snapshotJournalKey, snapshotGeneratorKey,
+	}
+	for it.Next() {
+		var (
+			key  = it.Key()
+			size = len(key) + len(it.Value())
+		)
+		switch {
+		case bytes.HasPrefix(key, headerPrefix) && len(key) == len(headerPrefix)+8+common.HashLength:
+			headers.add(size)
+		case bytes.HasPrefix(key, headerPrefix) && len(key) == len(headerPrefix)+8+common.HashLength+len(headerTDSuffix) && bytes.HasSuffix(key, headerTDSuffix):
+			tds.add(size)
+		case bytes.HasPrefix(key, headerPrefix) && len(key) == len(headerPrefix)+8+len(headerHashSuffix) && bytes.HasSuffix(key, headerHashSuffix):
+			hashes.add(size)
+		case bytes.HasPrefix(key, headerNumberPrefix) && len(key) == len(headerNumberPrefix)+common.HashLength:
+			numbers.add(size)
+		case bytes.HasPrefix(key, blockBodyPrefix) && len(key) == len(blockBodyPrefix)+8+common.HashLength:
+			bodies.add(size)
+		case bytes.HasPrefix(key, blockReceiptsPrefix) && len(key) == len(blockReceiptsPrefix)+8+common.HashLength:
+			receipts.add(size)
+		case bytes.HasPrefix(key, txLookupPrefix) && len(key) == len(txLookupPrefix)+common.HashLength:
+			lookups.add(size)
+		case bytes.HasPrefix(key, bloomBitsPrefix) && len(key) == len(bloomBitsPrefix)+2+8+common.HashLength:
+			bloombits.add(size)
+		case bytes.HasPrefix(key, supplyPrefix) && len(key) == len(supplyPrefix)+8+common.HashLength:
+			supplies.add(size)
+		case bytes.HasPrefix(key, SnapshotAccountPrefix) && len(key) == len(SnapshotAccountPrefix)+common.HashLength:
+			accounts.add(size)
+		case bytes.HasPrefix(key, SnapshotStoragePrefix) && len(key) == len(SnapshotStoragePrefix)+2*common.HashLength:
+			storages.add(size)
+		case bytes.HasPrefix(key, preimagePrefix) && len(key) == len(preimagePrefix)+common.HashLength:
+			preimages.add(size)
+		case bytes.HasPrefix(key, configPrefix) && len(key) == len(configPrefix)+common.HashLength:
+			configs.add(size)
+		case len(key) == common.HashLength:
+			tries.add(size)
+		case bytes.HasPrefix(key, []byte("i")):
+			indexes.add(size)
+		default:
+			var singleton bool
+			for _, meta := range singletons {
+				if bytes.Equal(key, meta) {
+					singleton = true
+					break
+				}
+			}
+			if singleton {
+				metadata.add(size)
+			} else {
+				unaccounted.add(size)
+			}
+		}
+		count++
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Inspecting database", "count", count, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+	}
+	if err := it.Error(); err != nil {
+		return nil, err
+	}
+	stats := []*DatabaseStat{
+		headers, tds, hashes, numbers, bodies, receipts, lookups, bloombits,
+		supplies, tries, accounts, storages, preimages, configs, indexes, metadata, unaccounted,
+	}
+	// Append the ancient tables, all of them hold the same number of items
+	if ancients, ok := db.(AncientReader); ok {
+		frozen, err := ancients.Ancients()
+		if err != nil {
+			return nil, err
+		}
+		for _, kind := range []string{freezerHeaderTable, freezerHashTable, freezerBodiesTable, freezerReceiptTable, freezerDifficultyTable} {
+			size, err := ancients.AncientSize(kind)
+			if err != nil {
+				return nil, err
+			}
+			stats = append(stats, &DatabaseStat{Category: "Ancient " + kind, Count: frozen, Size: common.StorageSize(size)})
+		}
+	}
+	return stats, nil
+}
@@ -0,0 +1,69 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/BerithFoundation/berith-chain/berithdb"
+	"github.com/BerithFoundation/berith-chain/common"
+	"github.com/BerithFoundation/berith-chain/core/types"
+)
+
+// Tests that the database inspection sorts the entries into the categories of
+// the database schema.
+func TestInspectDatabase(t *testing.T) {
+	db := berithdb.NewMemDatabase()
+
+	header := &types.Header{Number: big.NewInt(1), Extra: []byte("inspect")}
+	WriteHeader(db, header)
+	WriteCanonicalHash(db, header.Hash(), 1)
+	WriteTd(db, header.Hash(), 1, big.NewInt(1))
+	WriteBody(db, header.Hash(), 1, &types.Body{})
+	WriteHeadBlockHash(db, header.Hash())
+	WritePreimages(db, map[common.Hash][]byte{{0x01}: []byte("preimage")})
+	WriteAccountSnapshot(db, common.Hash{0x02}, []byte("account"))
+	db.Put(common.Hash{0x03}.Bytes(), []byte("trie node"))
+	db.Put([]byte("unknown-key"), []byte("value"))
+
+	stats, err := InspectDatabase(db)
+	if err != nil {
+		t.Fatalf("failed to inspect database: %v", err)
+	}
+	want := map[string]uint64{
+		"Headers":              1,
+		"Total difficulties":   1,
+		"Canonical hashes":     1,
+		"Block number lookups": 1,
+		"Bodies":               1,
+		"Receipts":             0,
+		"Preimages":            1,
+		"Snapshot accounts":    1,
+		"Trie nodes and codes": 1,
+		"Singleton metadata":   1,
+		"Unaccounted":          1,
+	}
+	for _, stat := range stats {
+		if count, ok := want[stat.Category]; ok && stat.Count != count {
+			t.Errorf("%s: count mismatch: have %d, want %d", stat.Category, stat.Count, count)
+		}
+		if stat.Count > 0 && stat.Size == 0 {
+			t.Errorf("%s: size missing", stat.Category)
+		}
+	}
+}
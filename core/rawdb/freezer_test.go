@@ -0,0 +1,186 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+
+	"github.com/BerithFoundation/berith-chain/berithdb"
+	"github.com/BerithFoundation/berith-chain/common"
+	"github.com/BerithFoundation/berith-chain/core/types"
+	"github.com/BerithFoundation/berith-chain/metrics"
+)
+
+// getChunk returns a chunk of data, filled with the given byte.
+func getChunk(size int, b int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(b)
+	}
+	return data
+}
+
+// Tests that items can be appended, retrieved across data file boundaries and
+// survive a reopening of the table.
+func TestFreezerTableReopen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "freezer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rm, wm := metrics.NewMeter(), metrics.NewMeter()
+	for _, noCompression := range []bool{false, true} {
+		name := fmt.Sprintf("table-%v", noCompression)
+		f, err := newCustomTable(dir, name, rm, wm, 50, noCompression)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Write 15 bytes 255 times, this results in 85 files
+		for x := 0; x < 255; x++ {
+			if err := f.Append(uint64(x), getChunk(15, x)); err != nil {
+				t.Fatalf("append %d failed: %v", x, err)
+			}
+		}
+		if err := f.Append(300, getChunk(15, 0)); err == nil {
+			t.Fatalf("out of order append accepted")
+		}
+		f.Close()
+
+		if f, err = newCustomTable(dir, name, rm, wm, 50, noCompression); err != nil {
+			t.Fatal(err)
+		}
+		for y := 0; y < 255; y++ {
+			got, err := f.Retrieve(uint64(y))
+			if err != nil {
+				t.Fatalf("retrieve %d failed: %v", y, err)
+			}
+			if !bytes.Equal(got, getChunk(15, y)) {
+				t.Fatalf("data mismatch for item %d: have %x", y, got)
+			}
+		}
+		if _, err := f.Retrieve(255); err != errOutOfBounds {
+			t.Fatalf("out of bounds error mismatch: have %v, want %v", err, errOutOfBounds)
+		}
+		f.Close()
+	}
+}
+
+// Tests that truncating a table drops the data files above the new length and
+// that appending continues from the truncated position.
+func TestFreezerTableTruncate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "freezer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rm, wm := metrics.NewMeter(), metrics.NewMeter()
+	f, err := newCustomTable(dir, "truncate", rm, wm, 50, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	for x := 0; x < 30; x++ {
+		f.Append(uint64(x), getChunk(20, x))
+	}
+	if err := f.truncate(5); err != nil {
+		t.Fatalf("truncate failed: %v", err)
+	}
+	if _, err := f.Retrieve(5); err != errOutOfBounds {
+		t.Fatalf("truncated item retrievable: %v", err)
+	}
+	if err := f.Append(5, getChunk(20, 0xff)); err != nil {
+		t.Fatalf("append after truncate failed: %v", err)
+	}
+	if got, _ := f.Retrieve(4); !bytes.Equal(got, getChunk(20, 4)) {
+		t.Fatalf("data mismatch for item 4: have %x", got)
+	}
+	if got, _ := f.Retrieve(5); !bytes.Equal(got, getChunk(20, 0xff)) {
+		t.Fatalf("data mismatch for item 5: have %x", got)
+	}
+}
+
+// Tests that the chain accessors transparently read frozen blocks and that
+// truncating the ancient store makes them unavailable again.
+func TestAncientStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ancient")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	kvdb := berithdb.NewMemDatabase()
+	db, err := NewDatabaseWithFreezer(kvdb, dir, "")
+	if err != nil {
+		t.Fatalf("failed to create database with ancient backend: %v", err)
+	}
+	defer db.Close()
+
+	// Write a canonical block with its receipts and total difficulty
+	block := types.NewBlockWithHeader(&types.Header{
+		Number:      big.NewInt(0),
+		Extra:       []byte("test block"),
+		UncleHash:   types.EmptyUncleHash,
+		TxHash:      types.EmptyRootHash,
+		ReceiptHash: types.EmptyRootHash,
+	})
+	hash, number := block.Hash(), block.NumberU64()
+
+	WriteBlock(db, block)
+	WriteCanonicalHash(db, hash, number)
+	WriteReceipts(db, hash, number, nil)
+	WriteTd(db, hash, number, big.NewInt(1))
+
+	// Move it into the ancient store and drop it from the key-value store
+	frdb := db.(AncientWriter)
+	if err := frdb.AppendAncient(number, hash.Bytes(), ReadHeaderRLP(kvdb, hash, number), ReadBodyRLP(kvdb, hash, number), ReadReceiptsRLP(kvdb, hash, number), ReadTdRLP(kvdb, hash, number)); err != nil {
+		t.Fatalf("failed to append ancient block: %v", err)
+	}
+	deleteFrozenBlock(kvdb, hash, number)
+	if HasHeader(kvdb, hash, number) || HasBody(kvdb, hash, number) {
+		t.Fatalf("frozen block still in the key-value store")
+	}
+	if have := ReadCanonicalHash(db, number); have != hash {
+		t.Fatalf("canonical hash mismatch: have %x, want %x", have, hash)
+	}
+	if entry := ReadBlock(db, hash, number); entry == nil || entry.Hash() != hash {
+		t.Fatalf("frozen block mismatch: have %v", entry)
+	}
+	if !HasReceipts(db, hash, number) || ReadReceipts(db, hash, number) == nil {
+		t.Fatalf("frozen receipts not found")
+	}
+	if td := ReadTd(db, hash, number); td == nil || td.Int64() != 1 {
+		t.Fatalf("frozen total difficulty mismatch: have %v", td)
+	}
+	// Blocks with another hash at the same height must not be served
+	if HasHeader(db, common.Hash{0x01}, number) || ReadHeader(db, common.Hash{0x01}, number) != nil {
+		t.Fatalf("non canonical header served from the ancient store")
+	}
+	if err := frdb.TruncateAncients(0); err != nil {
+		t.Fatalf("failed to truncate ancients: %v", err)
+	}
+	if ReadHeader(db, hash, number) != nil || ReadCanonicalHash(db, number) != (common.Hash{}) {
+		t.Fatalf("truncated block still retrievable")
+	}
+}
@@ -0,0 +1,85 @@
+package core
+
+import (
+	"crypto/ecdsa"
+	"math/big"
+	"testing"
+
+	"github.com/BerithFoundation/berith-chain/berithdb"
+	"github.com/BerithFoundation/berith-chain/common"
+	"github.com/BerithFoundation/berith-chain/core/state"
+	"github.com/BerithFoundation/berith-chain/core/types"
+	"github.com/BerithFoundation/berith-chain/crypto"
+	"github.com/BerithFoundation/berith-chain/params"
+)
+
+// signSponsoredTx signs a transfer of value from the sender whose gas is paid
+// by the sponsor, returning the transaction and the sponsor signature.
+func signSponsoredTx(t *testing.T, signer types.Signer, senderKey, sponsorKey *ecdsa.PrivateKey, value *big.Int) (*types.Transaction, []byte) {
+	sponsor := crypto.PubkeyToAddress(sponsorKey.PublicKey)
+	tx := types.NewTransaction(0, common.Address{1}, value, 21000, big.NewInt(1), nil, types.Main, types.Main, false)
+	tx, err := types.SignTx(tx.WithSponsor(sponsor), signer, senderKey)
+	if err != nil {
+		t.Fatalf("could not sign transaction: %v", err)
+	}
+	hash, err := types.SponsorHash(signer, tx)
+	if err != nil {
+		t.Fatalf("could not hash sponsorship: %v", err)
+	}
+	sig, err := crypto.Sign(hash.Bytes(), sponsorKey)
+	if err != nil {
+		t.Fatalf("could not sign sponsorship: %v", err)
+	}
+	if tx, err = tx.WithSponsorSignature(signer, sig); err != nil {
+		t.Fatalf("could not attach sponsorship: %v", err)
+	}
+	return tx, sig
+}
+
+// Tests that the sponsor signature can not be replayed as the sender signature
+// to spend the value from the sponsor.
+func TestSponsorSignatureReplay(t *testing.T) {
+	config := *params.TestnetChainConfig
+	config.BIP6Block = big.NewInt(0)
+	signer := types.NewEIP155Signer(config.ChainID)
+
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(berithdb.NewMemDatabase()))
+	senderKey, _ := crypto.GenerateKey()
+	sponsorKey, _ := crypto.GenerateKey()
+	sponsor := crypto.PubkeyToAddress(sponsorKey.PublicKey)
+	funds := new(big.Int).Mul(big.NewInt(1000), common.UnitForBer)
+	statedb.AddBalance(sponsor, funds)
+
+	value := new(big.Int).Mul(big.NewInt(500), common.UnitForBer)
+	tx, sig := signSponsoredTx(t, signer, senderKey, sponsorKey, value)
+
+	replayed, err := tx.WithSignature(signer, sig)
+	if err != nil {
+		t.Fatalf("could not replay signature: %v", err)
+	}
+	if from, err := types.Sender(signer, replayed); err == nil && from == sponsor {
+		t.Fatalf("sponsor signature accepted as sender signature")
+	}
+	if _, err := replayed.AsMessage(signer); err != types.ErrInvalidSponsor {
+		t.Fatalf("replayed sponsorship error mismatch: have %v, want %v", err, types.ErrInvalidSponsor)
+	}
+	if statedb.GetBalance(sponsor).Cmp(funds) != 0 {
+		t.Fatalf("sponsor balance changed: have %v, want %v", statedb.GetBalance(sponsor), funds)
+	}
+}
+
+// Tests that a transaction sponsored by its own sender is rejected on execution.
+func TestSelfSponsoredTransaction(t *testing.T) {
+	config := *params.TestnetChainConfig
+	config.BIP6Block = big.NewInt(0)
+	signer := types.NewEIP155Signer(config.ChainID)
+
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(berithdb.NewMemDatabase()))
+	key, _ := crypto.GenerateKey()
+	statedb.AddBalance(crypto.PubkeyToAddress(key.PublicKey), new(big.Int).Mul(big.NewInt(1000), common.UnitForBer))
+
+	tx, _ := signSponsoredTx(t, signer, key, key, common.Big1)
+	if _, err := applyMultisigTx(t, statedb, &config, tx); err != ErrInvalidSponsor {
+		t.Fatalf("self sponsorship error mismatch: have %v, want %v", err, ErrInvalidSponsor)
+	}
+}
@@ -19,12 +19,37 @@ package state
 import (
 	"encoding/json"
 	"fmt"
+	"io"
+	"math/big"
 
 	"github.com/BerithFoundation/berith-chain/common"
 	"github.com/BerithFoundation/berith-chain/rlp"
 	"github.com/BerithFoundation/berith-chain/trie"
 )
 
+// DumpConfig is a set of options to control what is dumped.
+type DumpConfig struct {
+	SkipCode    bool
+	SkipStorage bool
+	OnlyStakers bool   // [BERITH] Only dump the accounts with a non-zero stake balance
+	Start       []byte // Hashed address to start the iteration from
+	Max         uint64 // Maximum number of accounts to dump, zero for all
+}
+
+// DumpCollector is the interface the dumped root and accounts are fed to.
+type DumpCollector interface {
+	// OnRoot is called with the state root before any account.
+	OnRoot(common.Hash)
+	// OnAccount is called once for every dumped account.
+	OnAccount(common.Address, DumpAccount)
+}
+
+// [BERITH] DumpBehind is a pending reward entry of an account.
+type DumpBehind struct {
+	Number  string `json:"number"`
+	Balance string `json:"balance"`
+}
+
 type DumpAccount struct {
 	Balance  string            `json:"balance"`
 	Nonce    uint64            `json:"nonce"`
@@ -32,6 +57,16 @@ type DumpAccount struct {
 	CodeHash string            `json:"codeHash"`
 	Code     string            `json:"code"`
 	Storage  map[string]string `json:"storage"`
+
+	// [BERITH] staking fields of the account
+	StakeBalance   string       `json:"stakeBalance"`
+	StakeUpdated   string       `json:"stakeUpdated"`
+	Point          string       `json:"point"`
+	BehindBalance  []DumpBehind `json:"behindBalance"`
+	Penalty        uint64       `json:"penalty"`
+	PenaltyUpdated string       `json:"penaltyUpdated"`
+
+	Address *common.Address `json:"address,omitempty"` // Only set in the iterative dump
 }
 
 type Dump struct {
@@ -39,38 +74,144 @@ type Dump struct {
 	Accounts map[string]DumpAccount `json:"accounts"`
 }
 
-func (self *StateDB) RawDump() Dump {
-	dump := Dump{
-		Root:     fmt.Sprintf("%x", self.trie.Hash()),
-		Accounts: make(map[string]DumpAccount),
+// OnRoot implements DumpCollector.
+func (d *Dump) OnRoot(root common.Hash) {
+	d.Root = fmt.Sprintf("%x", root)
+}
+
+// OnAccount implements DumpCollector.
+func (d *Dump) OnAccount(addr common.Address, account DumpAccount) {
+	d.Accounts[common.Bytes2Hex(addr[:])] = account
+}
+
+// [BERITH] DumpTotals sums up the dumped accounts, so that the total supply, the
+// total stake and the pending behind balances can be reconciled.
+type DumpTotals struct {
+	Accounts      uint64 `json:"accounts"`
+	Balance       string `json:"balance"`
+	StakeBalance  string `json:"stakeBalance"`
+	BehindBalance string `json:"behindBalance"`
+}
+
+// iterativeDump is a DumpCollector writing one JSON object per line.
+type iterativeDump struct {
+	*json.Encoder
+
+	accounts uint64
+	balance  *big.Int
+	stake    *big.Int
+	behind   *big.Int
+}
+
+// OnRoot implements DumpCollector.
+func (d *iterativeDump) OnRoot(root common.Hash) {
+	d.Encode(struct {
+		Root common.Hash `json:"root"`
+	}{root})
+}
+
+// OnAccount implements DumpCollector.
+func (d *iterativeDump) OnAccount(addr common.Address, account DumpAccount) {
+	account.Address = &addr
+	d.Encode(account)
+
+	d.accounts++
+	d.balance.Add(d.balance, decimal(account.Balance))
+	d.stake.Add(d.stake, decimal(account.StakeBalance))
+	for _, behind := range account.BehindBalance {
+		d.behind.Add(d.behind, decimal(behind.Balance))
 	}
+}
 
-	it := trie.NewIterator(self.trie.NodeIterator(nil))
+// decimal parses a base 10 number produced by the dump.
+func decimal(s string) *big.Int {
+	n, _ := new(big.Int).SetString(s, 10)
+	if n == nil {
+		return new(big.Int)
+	}
+	return n
+}
+
+// bigString formats a possibly nil account field.
+func bigString(n *big.Int) string {
+	if n == nil {
+		return "0"
+	}
+	return n.String()
+}
+
+// DumpToCollector iterates the state trie and feeds the accounts matching the
+// config to the collector. If the iteration is stopped by the configured limit,
+// the hashed address of the next account is returned.
+func (self *StateDB) DumpToCollector(c DumpCollector, conf *DumpConfig) (nextKey []byte) {
+	if conf == nil {
+		conf = new(DumpConfig)
+	}
+	c.OnRoot(self.trie.Hash())
+
+	var count uint64
+	it := trie.NewIterator(self.trie.NodeIterator(conf.Start))
 	for it.Next() {
-		addr := self.trie.GetKey(it.Key)
 		var data Account
 		if err := rlp.DecodeBytes(it.Value, &data); err != nil {
 			panic(err)
 		}
-
-		obj := newObject(nil, common.BytesToAddress(addr), data)
+		if conf.OnlyStakers && (data.StakeBalance == nil || data.StakeBalance.Sign() == 0) {
+			continue
+		}
+		if conf.Max > 0 && count == conf.Max {
+			nextKey = common.CopyBytes(it.Key)
+			break
+		}
+		addr := common.BytesToAddress(self.trie.GetKey(it.Key))
+		obj := newObject(nil, addr, data)
 		account := DumpAccount{
-			Balance:  data.Balance.String(),
-			Nonce:    data.Nonce,
-			Root:     common.Bytes2Hex(data.Root[:]),
-			CodeHash: common.Bytes2Hex(data.CodeHash),
-			Code:     common.Bytes2Hex(obj.Code(self.db)),
-			Storage:  make(map[string]string),
+			Balance:        data.Balance.String(),
+			Nonce:          data.Nonce,
+			Root:           common.Bytes2Hex(data.Root[:]),
+			CodeHash:       common.Bytes2Hex(data.CodeHash),
+			Storage:        make(map[string]string),
+			StakeBalance:   bigString(data.StakeBalance),
+			StakeUpdated:   bigString(data.StakeUpdated),
+			Point:          bigString(data.Point),
+			BehindBalance:  make([]DumpBehind, 0, len(data.BehindBalance)),
+			Penalty:        data.Penalty,
+			PenaltyUpdated: bigString(data.PenlatyUpdated),
+		}
+		for _, behind := range data.BehindBalance {
+			account.BehindBalance = append(account.BehindBalance, DumpBehind{
+				Number:  bigString(behind.Number),
+				Balance: bigString(behind.Balance),
+			})
+		}
+		if !conf.SkipCode {
+			account.Code = common.Bytes2Hex(obj.Code(self.db))
 		}
-		storageIt := trie.NewIterator(obj.getTrie(self.db).NodeIterator(nil))
-		for storageIt.Next() {
-			account.Storage[common.Bytes2Hex(self.trie.GetKey(storageIt.Key))] = common.Bytes2Hex(storageIt.Value)
+		if !conf.SkipStorage {
+			storageIt := trie.NewIterator(obj.getTrie(self.db).NodeIterator(nil))
+			for storageIt.Next() {
+				account.Storage[common.Bytes2Hex(self.trie.GetKey(storageIt.Key))] = common.Bytes2Hex(storageIt.Value)
+			}
 		}
-		dump.Accounts[common.Bytes2Hex(addr)] = account
+		c.OnAccount(addr, account)
+		count++
 	}
+	return nextKey
+}
+
+// RawDumpWithConfig returns the accounts matching the config.
+func (self *StateDB) RawDumpWithConfig(conf *DumpConfig) Dump {
+	dump := Dump{
+		Accounts: make(map[string]DumpAccount),
+	}
+	self.DumpToCollector(&dump, conf)
 	return dump
 }
 
+func (self *StateDB) RawDump() Dump {
+	return self.RawDumpWithConfig(nil)
+}
+
 func (self *StateDB) Dump() []byte {
 	json, err := json.MarshalIndent(self.RawDump(), "", "    ")
 	if err != nil {
@@ -79,3 +220,25 @@ func (self *StateDB) Dump() []byte {
 
 	return json
 }
+
+// IterativeDump writes the state as JSON lines: the root first, then one line
+// per account matching the config, and finally the totals of the dumped
+// accounts.
+func (self *StateDB) IterativeDump(conf *DumpConfig, output io.Writer) {
+	d := &iterativeDump{
+		Encoder: json.NewEncoder(output),
+		balance: new(big.Int),
+		stake:   new(big.Int),
+		behind:  new(big.Int),
+	}
+	self.DumpToCollector(d, conf)
+
+	d.Encode(struct {
+		Totals DumpTotals `json:"totals"`
+	}{DumpTotals{
+		Accounts:      d.accounts,
+		Balance:       d.balance.String(),
+		StakeBalance:  d.stake.String(),
+		BehindBalance: d.behind.String(),
+	}})
+}
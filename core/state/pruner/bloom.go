@@ -0,0 +1,180 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package pruner
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/BerithFoundation/berith-chain/common"
+	"github.com/BerithFoundation/berith-chain/crypto"
+)
+
+// bloomMagic is the header of a persisted state bloom file.
+var bloomMagic = []byte("berith-statebloom")
+
+// bloomHashes is the number of bit positions set for every inserted key.
+const bloomHashes = 4
+
+var errBloomCorrupted = errors.New("corrupted state bloom")
+
+// stateBloom is a bloom filter used during the state pruning to record all the
+// live trie nodes and contract codes. The inserted keys are hashes already, so
+// the bit positions are taken from the key itself instead of hashing it again.
+//
+// The filter is persisted to disk along with the head block it was built for,
+// which allows an interrupted pruning to be resumed without rebuilding it.
+type stateBloom struct {
+	bits []uint64    // Bitset of the filter
+	head common.Hash // Hash of the head block the filter was built for
+}
+
+// newStateBloomWithSize creates an empty state bloom with the given size in
+// megabytes.
+func newStateBloomWithSize(size uint64) *stateBloom {
+	if size == 0 {
+		size = 1
+	}
+	return &stateBloom{bits: make([]uint64, size*1024*1024/8)}
+}
+
+// positions returns the bit positions of a key in the filter.
+func (bloom *stateBloom) positions(key []byte) [bloomHashes]uint64 {
+	var (
+		pos  [bloomHashes]uint64
+		size = uint64(len(bloom.bits)) * 64
+	)
+	for i := 0; i < bloomHashes; i++ {
+		pos[i] = binary.BigEndian.Uint64(key[i*8:]) % size
+	}
+	return pos
+}
+
+// Put inserts a trie node or code hash into the filter.
+func (bloom *stateBloom) Put(key []byte) {
+	if len(key) != common.HashLength {
+		panic("invalid state bloom key") // Only hashes are recorded, catch dev errors
+	}
+	for _, pos := range bloom.positions(key) {
+		bloom.bits[pos/64] |= 1 << (pos % 64)
+	}
+}
+
+// Contain reports whether the key was possibly inserted into the filter. Keys
+// of any other length than a hash are never contained.
+func (bloom *stateBloom) Contain(key []byte) bool {
+	if len(key) != common.HashLength {
+		return false
+	}
+	for _, pos := range bloom.positions(key) {
+		if bloom.bits[pos/64]&(1<<(pos%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Commit flushes the filter into the given file. The content is written into a
+// temporary file first and moved into place, so a crash never leaves a partial
+// filter behind under the final name.
+func (bloom *stateBloom) Commit(filename, tempname string) error {
+	f, err := os.Create(tempname)
+	if err != nil {
+		return err
+	}
+	var (
+		hasher = crypto.NewKeccakState()
+		w      = bufio.NewWriter(io.MultiWriter(f, hasher))
+		word   [8]byte
+	)
+	w.Write(bloomMagic)
+	w.Write(bloom.head[:])
+	binary.BigEndian.PutUint64(word[:], uint64(len(bloom.bits)))
+	w.Write(word[:])
+	for _, bits := range bloom.bits {
+		binary.BigEndian.PutUint64(word[:], bits)
+		w.Write(word[:])
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	// Append the checksum of the content to detect truncated files
+	if _, err := f.Write(hasher.Sum(nil)); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tempname, filename)
+}
+
+// newStateBloomFromDisk loads a persisted state bloom from the given file.
+func newStateBloomFromDisk(filename string) (*stateBloom, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var (
+		hasher = crypto.NewKeccakState()
+		r      = io.TeeReader(bufio.NewReader(f), hasher)
+		header = make([]byte, len(bloomMagic)+common.HashLength+8)
+	)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, errBloomCorrupted
+	}
+	if !bytes.Equal(header[:len(bloomMagic)], bloomMagic) {
+		return nil, errBloomCorrupted
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	words := binary.BigEndian.Uint64(header[len(header)-8:])
+	if words == 0 || uint64(info.Size()) != uint64(len(header))+words*8+common.HashLength {
+		return nil, errBloomCorrupted
+	}
+	bloom := &stateBloom{
+		bits: make([]uint64, words),
+		head: common.BytesToHash(header[len(bloomMagic) : len(bloomMagic)+common.HashLength]),
+	}
+	var word [8]byte
+	for i := range bloom.bits {
+		if _, err := io.ReadFull(r, word[:]); err != nil {
+			return nil, errBloomCorrupted
+		}
+		bloom.bits[i] = binary.BigEndian.Uint64(word[:])
+	}
+	// Verify the checksum, it is not part of the hashed content
+	sum := hasher.Sum(nil)
+	checksum := make([]byte, common.HashLength)
+	if _, err := io.ReadFull(r, checksum); err != nil || !bytes.Equal(sum, checksum) {
+		return nil, errBloomCorrupted
+	}
+	return bloom, nil
+}
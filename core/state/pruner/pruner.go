@@ -0,0 +1,375 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package pruner implements the offline pruning of the stale state data.
+package pruner
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/BerithFoundation/berith-chain/berith/staking"
+	"github.com/BerithFoundation/berith-chain/berithdb"
+	"github.com/BerithFoundation/berith-chain/common"
+	"github.com/BerithFoundation/berith-chain/core/rawdb"
+	"github.com/BerithFoundation/berith-chain/core/state"
+	"github.com/BerithFoundation/berith-chain/core/types"
+	"github.com/BerithFoundation/berith-chain/crypto"
+	"github.com/BerithFoundation/berith-chain/log"
+	"github.com/BerithFoundation/berith-chain/rlp"
+	"github.com/BerithFoundation/berith-chain/trie"
+)
+
+const (
+	// stateBloomFileName is the filename of the state bloom filter persisted
+	// into the data directory while the pruning is in progress.
+	stateBloomFileName = "statebloom.bf"
+
+	// stateBloomTempName is the filename the state bloom is written to before
+	// being moved into place.
+	stateBloomTempName = stateBloomFileName + ".tmp"
+
+	// recentStates is the number of the most recent block states retained by the
+	// pruning, matching the depth of the snapshot diff layers.
+	recentStates = 128
+
+	// defaultStakingRetention is the number of recent blocks whose staker entries
+	// are retained if the chain config carries no BSRR epoch.
+	defaultStakingRetention = 720
+)
+
+var (
+	// emptyRoot is the known root hash of an empty trie.
+	emptyRoot = common.HexToHash("56e81f171bcc55a6ff8345e692c0f86e5b48e01b996cadc001622fb5e363b421")
+
+	// emptyCode is the known hash of the empty EVM bytecode.
+	emptyCode = crypto.Keccak256(nil)
+)
+
+// Pruner is an offline tool to prune the stale state data. It builds a bloom
+// filter of all the trie nodes and contract codes reachable from the recent
+// state roots and deletes every other node from the database. The staker
+// entries of the BSRR engine no longer reachable from the recent blocks are
+// pruned along with them.
+//
+// The bloom filter is persisted before any deletion happens, so an interrupted
+// pruning resumes the sweep with the very same filter on the next run.
+type Pruner struct {
+	db        berithdb.Database
+	stakingDB *staking.StakingDB
+	datadir   string
+	bloomSize uint64
+}
+
+// NewPruner creates a pruner for the given chain database. The bloom filter of
+// bloomSize megabytes is persisted into datadir.
+func NewPruner(db berithdb.Database, stakingDB *staking.StakingDB, datadir string, bloomSize uint64) (*Pruner, error) {
+	if rawdb.ReadHeadBlockHash(db) == (common.Hash{}) {
+		return nil, errors.New("failed to load head block")
+	}
+	return &Pruner{
+		db:        db,
+		stakingDB: stakingDB,
+		datadir:   datadir,
+		bloomSize: bloomSize,
+	}, nil
+}
+
+// Prune deletes all the stale state data from the database.
+func (p *Pruner) Prune() error {
+	var (
+		start     = time.Now()
+		bloomPath = filepath.Join(p.datadir, stateBloomFileName)
+		tempPath  = filepath.Join(p.datadir, stateBloomTempName)
+	)
+	head := rawdb.ReadHeadBlockHash(p.db)
+	number := rawdb.ReadHeaderNumber(p.db, head)
+	if number == nil {
+		return errors.New("failed to load head block number")
+	}
+	// Resume an interrupted pruning if the filter of a previous run exists. The
+	// sweep might have deleted nodes already, so the filter must not be rebuilt.
+	bloom, err := newStateBloomFromDisk(bloomPath)
+	switch {
+	case err == nil:
+		if bloom.head != head {
+			return fmt.Errorf("state bloom built for head %x, have %x", bloom.head, head)
+		}
+		log.Info("Resuming state pruning", "head", head, "number", *number)
+
+	case os.IsNotExist(err):
+		bloom = newStateBloomWithSize(p.bloomSize)
+		bloom.head = head
+		if err := p.markLive(bloom, head, *number); err != nil {
+			return err
+		}
+		if err := bloom.Commit(bloomPath, tempPath); err != nil {
+			return err
+		}
+		log.Info("Committed state bloom", "path", bloomPath)
+
+	default:
+		return fmt.Errorf("failed to load state bloom %s: %v", bloomPath, err)
+	}
+	if err := p.sweep(bloom); err != nil {
+		return err
+	}
+	if p.stakingDB != nil {
+		if err := p.pruneStakers(head, *number); err != nil {
+			return err
+		}
+	}
+	// The pruning is done, the filter is only removed once everything is deleted
+	os.Remove(bloomPath)
+
+	log.Info("Compacting database", "elapsed", common.PrettyDuration(time.Since(start)))
+	cstart := time.Now()
+	p.db.Compact()
+	log.Info("State pruning successful", "elapsed", common.PrettyDuration(time.Since(start)), "compaction", common.PrettyDuration(time.Since(cstart)))
+	return nil
+}
+
+// markLive records all the trie nodes and codes reachable from the recent block
+// states, the genesis state and the persisted snapshot into the bloom filter.
+func (p *Pruner) markLive(bloom *stateBloom, head common.Hash, number uint64) error {
+	header := rawdb.ReadHeader(p.db, head, number)
+	if header == nil {
+		return errors.New("failed to load head header")
+	}
+	// The head state is a must, older states are only retained if available
+	if !p.hasState(header.Root) {
+		return fmt.Errorf("head state %x is missing", header.Root)
+	}
+	roots := make(map[common.Hash]struct{})
+	for i := uint64(0); i < recentStates && header != nil; i++ {
+		if p.hasState(header.Root) {
+			roots[header.Root] = struct{}{}
+		}
+		if header.Number.Uint64() == 0 {
+			break
+		}
+		header = rawdb.ReadHeader(p.db, header.ParentHash, header.Number.Uint64()-1)
+	}
+	if genesis := p.readCanonicalHeader(0); genesis != nil && p.hasState(genesis.Root) {
+		roots[genesis.Root] = struct{}{}
+	}
+	if root := rawdb.ReadSnapshotRoot(p.db); root != (common.Hash{}) && p.hasState(root) {
+		roots[root] = struct{}{}
+	}
+	var (
+		start    = time.Now()
+		logged   = time.Now()
+		storages = make(map[common.Hash]struct{}) // Storage tries marked already, shared by the recent states
+		nodes    int
+		codes    int
+	)
+	for root := range roots {
+		log.Info("Marking live state", "root", root)
+
+		t, err := trie.New(root, trie.NewDatabase(p.db))
+		if err != nil {
+			return err
+		}
+		it := t.NodeIterator(nil)
+		for it.Next(true) {
+			if hash := it.Hash(); hash != (common.Hash{}) {
+				bloom.Put(hash[:])
+				nodes++
+			}
+			if it.Leaf() {
+				var acc state.Account
+				if err := rlp.DecodeBytes(it.LeafBlob(), &acc); err != nil {
+					return err
+				}
+				if _, ok := storages[acc.Root]; !ok && acc.Root != emptyRoot {
+					storages[acc.Root] = struct{}{}
+					n, err := p.markStorage(bloom, acc.Root)
+					if err != nil {
+						return err
+					}
+					nodes += n
+				}
+				if !bytes.Equal(acc.CodeHash, emptyCode) {
+					bloom.Put(acc.CodeHash)
+					codes++
+				}
+			}
+			if time.Since(logged) > 8*time.Second {
+				log.Info("Marking live state", "nodes", nodes, "codes", codes, "elapsed", common.PrettyDuration(time.Since(start)))
+				logged = time.Now()
+			}
+		}
+		if it.Error() != nil {
+			return it.Error()
+		}
+	}
+	log.Info("Marked live state", "roots", len(roots), "nodes", nodes, "codes", codes, "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
+
+// markStorage records all the nodes of a storage trie into the bloom filter.
+func (p *Pruner) markStorage(bloom *stateBloom, root common.Hash) (int, error) {
+	t, err := trie.New(root, trie.NewDatabase(p.db))
+	if err != nil {
+		return 0, err
+	}
+	var nodes int
+	it := t.NodeIterator(nil)
+	for it.Next(true) {
+		if hash := it.Hash(); hash != (common.Hash{}) {
+			bloom.Put(hash[:])
+			nodes++
+		}
+	}
+	return nodes, it.Error()
+}
+
+// sweep deletes every trie node and code from the key-value store which is not
+// contained in the bloom filter.
+func (p *Pruner) sweep(bloom *stateBloom) error {
+	var (
+		start   = time.Now()
+		logged  = time.Now()
+		db      = rawdb.KeyValueStore(p.db)
+		batch   = db.NewBatch()
+		count   int
+		deleted int
+		size    common.StorageSize
+	)
+	iteratee, ok := db.(berithdb.Iteratee)
+	if !ok {
+		return errors.New("database does not support iteration")
+	}
+	it := iteratee.NewIteratorWithPrefix(nil)
+	defer it.Release()
+
+	for it.Next() {
+		count++
+
+		// Trie nodes and codes are the only entries keyed by a bare hash
+		key := it.Key()
+		if len(key) != common.HashLength || bloom.Contain(key) {
+			continue
+		}
+		size += common.StorageSize(len(key) + len(it.Value()))
+		if err := batch.Delete(common.CopyBytes(key)); err != nil {
+			return err
+		}
+		deleted++
+
+		if batch.ValueSize() >= berithdb.IdealBatchSize {
+			if err := batch.Write(); err != nil {
+				return err
+			}
+			batch.Reset()
+		}
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Pruning state data", "entries", count, "deleted", deleted, "size", size, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+	}
+	if err := it.Error(); err != nil {
+		return err
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	log.Info("Pruned state data", "entries", count, "deleted", deleted, "size", size, "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
+
+// pruneStakers deletes the staker entries of the BSRR engine which are not on
+// the canonical chain or older than the staking retention. The newest canonical
+// entry before the retention window is kept as the starting point the engine
+// replays the staker list from.
+func (p *Pruner) pruneStakers(head common.Hash, number uint64) error {
+	var cutoff uint64
+	if retention := p.stakingRetention(); number > retention {
+		cutoff = number - retention
+	}
+	var (
+		anchor     common.Hash
+		anchorNum  uint64
+		superseded = make(map[common.Hash]struct{})
+	)
+	keep := func(hash common.Hash) bool {
+		num := rawdb.ReadHeaderNumber(p.db, hash)
+		if num == nil || rawdb.ReadCanonicalHash(p.db, *num) != hash {
+			return false
+		}
+		if *num >= cutoff {
+			return true
+		}
+		// Older canonical entry, only retain it if it's the newest one so far
+		if anchor != (common.Hash{}) {
+			if *num <= anchorNum {
+				return false
+			}
+			superseded[anchor] = struct{}{}
+		}
+		anchor, anchorNum = hash, *num
+		return true
+	}
+	deleted, err := p.stakingDB.Prune(keep)
+	if err != nil {
+		return err
+	}
+	// The entries are visited in key order, drop the anchors replaced later on
+	if len(superseded) > 0 {
+		n, err := p.stakingDB.Prune(func(hash common.Hash) bool {
+			_, stale := superseded[hash]
+			return !stale
+		})
+		if err != nil {
+			return err
+		}
+		deleted += n
+	}
+	log.Info("Pruned staker entries", "deleted", deleted, "cutoff", cutoff, "anchor", anchorNum)
+	return nil
+}
+
+// stakingRetention returns the number of recent blocks whose staker entries are
+// retained, two BSRR epochs.
+func (p *Pruner) stakingRetention() uint64 {
+	genesis := rawdb.ReadCanonicalHash(p.db, 0)
+	if config := rawdb.ReadChainConfig(p.db, genesis); config != nil && config.Bsrr != nil && config.Bsrr.Epoch > 0 {
+		return 2 * config.Bsrr.Epoch
+	}
+	return defaultStakingRetention
+}
+
+// hasState reports whether the root node of a state trie is present.
+func (p *Pruner) hasState(root common.Hash) bool {
+	if root == emptyRoot {
+		return true
+	}
+	ok, _ := p.db.Has(root[:])
+	return ok
+}
+
+// readCanonicalHeader retrieves the canonical header of the given number.
+func (p *Pruner) readCanonicalHeader(number uint64) *types.Header {
+	hash := rawdb.ReadCanonicalHash(p.db, number)
+	if hash == (common.Hash{}) {
+		return nil
+	}
+	return rawdb.ReadHeader(p.db, hash, number)
+}
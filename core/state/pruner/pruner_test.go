@@ -0,0 +1,185 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package pruner
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/BerithFoundation/berith-chain/berith/staking"
+	"github.com/BerithFoundation/berith-chain/berithdb"
+	"github.com/BerithFoundation/berith-chain/common"
+	"github.com/BerithFoundation/berith-chain/core/rawdb"
+	"github.com/BerithFoundation/berith-chain/core/state"
+	"github.com/BerithFoundation/berith-chain/core/types"
+	"github.com/BerithFoundation/berith-chain/params"
+)
+
+// commitState applies the modifier on top of the given state root and flushes
+// the resulting state into the database.
+func commitState(t *testing.T, db state.Database, root common.Hash, modify func(*state.StateDB)) common.Hash {
+	statedb, err := state.New(root, db)
+	if err != nil {
+		t.Fatalf("failed to open state %x: %v", root, err)
+	}
+	modify(statedb)
+	root, err = statedb.Commit(false)
+	if err != nil {
+		t.Fatalf("failed to commit state: %v", err)
+	}
+	if err := db.TrieDB().Commit(root, false); err != nil {
+		t.Fatalf("failed to flush state: %v", err)
+	}
+	return root
+}
+
+// Tests that the state bloom survives a round trip through the disk and that a
+// damaged file is rejected.
+func TestStateBloomCommit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "statebloom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	bloom := newStateBloomWithSize(1)
+	bloom.head = common.Hash{0x01}
+	live := common.HexToHash("0x0badf00d0badf00d0badf00d0badf00d0badf00d0badf00d0badf00d0badf00d")
+	bloom.Put(live[:])
+
+	path := filepath.Join(dir, stateBloomFileName)
+	if err := bloom.Commit(path, filepath.Join(dir, stateBloomTempName)); err != nil {
+		t.Fatalf("failed to commit bloom: %v", err)
+	}
+	loaded, err := newStateBloomFromDisk(path)
+	if err != nil {
+		t.Fatalf("failed to load bloom: %v", err)
+	}
+	if loaded.head != bloom.head {
+		t.Fatalf("head mismatch: have %x, want %x", loaded.head, bloom.head)
+	}
+	if !loaded.Contain(live[:]) {
+		t.Fatalf("inserted key missing from loaded bloom")
+	}
+	if loaded.Contain(common.Hash{0x02}.Bytes()) {
+		t.Fatalf("unexpected key contained in loaded bloom")
+	}
+	// Flip a bit of the content, the checksum must catch it
+	blob, _ := ioutil.ReadFile(path)
+	blob[len(bloomMagic)+common.HashLength+8] ^= 0x01
+	ioutil.WriteFile(path, blob, 0644)
+	if _, err := newStateBloomFromDisk(path); err != errBloomCorrupted {
+		t.Fatalf("corruption not detected: %v", err)
+	}
+}
+
+// Tests that the pruning deletes the trie nodes of the stale states, keeps the
+// recent and genesis states intact and drops the stale staker entries.
+func TestPrune(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pruner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var (
+		db      = berithdb.NewMemDatabase()
+		statedb = state.NewDatabase(db)
+		addr1   = common.HexToAddress("0x01")
+		addr2   = common.HexToAddress("0x02")
+	)
+	genesisRoot := commitState(t, statedb, common.Hash{}, func(s *state.StateDB) {
+		s.AddBalance(addr1, big.NewInt(1))
+	})
+	staleRoot := commitState(t, statedb, genesisRoot, func(s *state.StateDB) {
+		s.AddBalance(addr2, big.NewInt(2))
+		s.SetState(addr2, common.Hash{0x01}, common.Hash{0x01})
+	})
+	liveRoot := commitState(t, statedb, genesisRoot, func(s *state.StateDB) {
+		s.AddBalance(addr2, big.NewInt(3))
+		s.SetCode(addr2, []byte{0x60, 0x00})
+	})
+	// Block 1 carries the stale state, every later block the live one
+	var (
+		headers []*types.Header
+		parent  common.Hash
+	)
+	for i := int64(0); i <= recentStates+2; i++ {
+		root := liveRoot
+		switch i {
+		case 0:
+			root = genesisRoot
+		case 1:
+			root = staleRoot
+		}
+		header := &types.Header{ParentHash: parent, Number: big.NewInt(i), Root: root, Difficulty: common.Big1}
+		rawdb.WriteHeader(db, header)
+		rawdb.WriteCanonicalHash(db, header.Hash(), header.Number.Uint64())
+		headers = append(headers, header)
+		parent = header.Hash()
+	}
+	head := headers[len(headers)-1]
+	rawdb.WriteHeadBlockHash(db, head.Hash())
+	rawdb.WriteChainConfig(db, headers[0].Hash(), &params.ChainConfig{Bsrr: &params.BSRRConfig{Epoch: 10}})
+
+	// Store staker entries for old, recent and non-canonical blocks
+	stakingDB := new(staking.StakingDB)
+	if err := stakingDB.CreateDB(filepath.Join(dir, "stakingDB"), staking.NewStakers); err != nil {
+		t.Fatalf("failed to create staking db: %v", err)
+	}
+	defer stakingDB.Close()
+
+	orphan := common.Hash{0xde, 0xad}
+	for _, hash := range []common.Hash{headers[5].Hash(), headers[50].Hash(), headers[100].Hash(), headers[120].Hash(), orphan} {
+		if err := stakingDB.Commit(hash.Hex(), stakingDB.NewStakers()); err != nil {
+			t.Fatalf("failed to commit stakers: %v", err)
+		}
+	}
+	pruner, err := NewPruner(db, stakingDB, dir, 1)
+	if err != nil {
+		t.Fatalf("failed to create pruner: %v", err)
+	}
+	if err := pruner.Prune(); err != nil {
+		t.Fatalf("failed to prune: %v", err)
+	}
+	if ok, _ := db.Has(staleRoot[:]); ok {
+		t.Fatalf("stale state root retained")
+	}
+	for _, root := range []common.Hash{genesisRoot, liveRoot} {
+		s, err := state.New(root, state.NewDatabase(db))
+		if err != nil {
+			t.Fatalf("live state %x lost: %v", root, err)
+		}
+		if root == liveRoot && len(s.GetCode(addr2)) != 2 {
+			t.Fatalf("live contract code lost")
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, stateBloomFileName)); !os.IsNotExist(err) {
+		t.Fatalf("state bloom not removed: %v", err)
+	}
+	// Head is block 130, the retention of two epochs keeps entries from block 110
+	// and the newest entry before as the replay anchor.
+	for i, hash := range []common.Hash{headers[5].Hash(), headers[50].Hash(), headers[100].Hash(), headers[120].Hash(), orphan} {
+		_, err := stakingDB.GetStakers(hash.Hex())
+		if want := i == 2 || i == 3; (err == nil) != want {
+			t.Errorf("staker entry %d: have retained %v, want %v", i, err == nil, want)
+		}
+	}
+}
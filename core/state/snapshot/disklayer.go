@@ -0,0 +1,213 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package snapshot
+
+import (
+	"bytes"
+	"sync"
+	"time"
+
+	"github.com/BerithFoundation/berith-chain/berithdb"
+	"github.com/BerithFoundation/berith-chain/common"
+	"github.com/BerithFoundation/berith-chain/core/rawdb"
+	"github.com/BerithFoundation/berith-chain/rlp"
+	"github.com/BerithFoundation/berith-chain/trie"
+	"github.com/allegro/bigcache"
+)
+
+// diskLayer is a low level persistent snapshot built on top of a key-value store.
+type diskLayer struct {
+	diskdb berithdb.Database  // Key-value store containing the base snapshot
+	triedb *trie.Database     // Trie node cache for reconstuction purposes
+	cache  *bigcache.BigCache // Cache to avoid hitting the disk for direct access
+
+	root  common.Hash // Root hash of the base snapshot
+	stale bool        // Signals that the layer became stale (state progressed)
+
+	genMarker  []byte             // Marker for the state that's indexed during initial layer generation
+	genPending chan struct{}      // Notification channel when generation is done (test synchronicity)
+	genAbort   chan chan struct{} // Notification channel to abort generating the snapshot in this layer
+
+	lock sync.RWMutex
+}
+
+// newCache creates the clean read cache of a disk layer, sized in megabytes. A
+// nil cache is returned if caching is disabled.
+func newCache(cache int) *bigcache.BigCache {
+	if cache <= 0 {
+		return nil
+	}
+	cleans, _ := bigcache.NewBigCache(bigcache.Config{
+		Shards:             1024,
+		LifeWindow:         time.Hour,
+		MaxEntriesInWindow: cache * 1024,
+		MaxEntrySize:       512,
+		HardMaxCacheSize:   cache,
+	})
+	return cleans
+}
+
+// cacheGet retrieves an entry from the clean cache, if one is configured.
+func (dl *diskLayer) cacheGet(key []byte) ([]byte, bool) {
+	if dl.cache == nil {
+		return nil, false
+	}
+	blob, err := dl.cache.Get(string(key))
+	if err != nil {
+		return nil, false
+	}
+	return blob, true
+}
+
+// cacheSet inserts an entry into the clean cache, if one is configured. Empty
+// entries are cached too, marking the absence of the item.
+func (dl *diskLayer) cacheSet(key []byte, blob []byte) {
+	if dl.cache != nil {
+		dl.cache.Set(string(key), blob)
+	}
+}
+
+// cacheDel drops an entry from the clean cache, if one is configured.
+func (dl *diskLayer) cacheDel(key []byte) {
+	if dl.cache != nil {
+		dl.cache.Delete(string(key))
+	}
+}
+
+// Root returns root hash for which this snapshot was made.
+func (dl *diskLayer) Root() common.Hash {
+	return dl.root
+}
+
+// Parent always returns nil as there's no layer below the disk.
+func (dl *diskLayer) Parent() snapshot {
+	return nil
+}
+
+// Stale return whether this layer has become stale (was flattened across) or if
+// it's still live.
+func (dl *diskLayer) Stale() bool {
+	dl.lock.RLock()
+	defer dl.lock.RUnlock()
+
+	return dl.stale
+}
+
+// Account directly retrieves the account RLP associated with a particular
+// hash in the snapshot.
+func (dl *diskLayer) Account(hash common.Hash) ([]byte, error) {
+	dl.lock.RLock()
+	defer dl.lock.RUnlock()
+
+	// If the layer was flattened into, consider it invalid (any live reference to
+	// the original should be marked as unusable).
+	if dl.stale {
+		return nil, ErrSnapshotStale
+	}
+	// If the layer is being generated, ensure the requested hash has already been
+	// covered by the generator.
+	if dl.genMarker != nil && bytes.Compare(hash[:], dl.genMarker) > 0 {
+		return nil, ErrNotCoveredYet
+	}
+	// Try to retrieve the account from the memory cache
+	if blob, found := dl.cacheGet(hash[:]); found {
+		snapshotCleanAccountHitMeter.Mark(1)
+		if len(blob) == 0 {
+			return nil, nil
+		}
+		return blob, nil
+	}
+	// Cache doesn't contain account, pull from disk and cache for later
+	blob := rawdb.ReadAccountSnapshot(dl.diskdb, hash)
+	dl.cacheSet(hash[:], blob)
+	snapshotCleanAccountMissMeter.Mark(1)
+
+	if len(blob) == 0 {
+		return nil, nil
+	}
+	return blob, nil
+}
+
+// Storage directly retrieves the storage data associated with a particular hash,
+// within a particular account.
+func (dl *diskLayer) Storage(accountHash, storageHash common.Hash) ([]byte, error) {
+	dl.lock.RLock()
+	defer dl.lock.RUnlock()
+
+	// If the layer was flattened into, consider it invalid (any live reference to
+	// the original should be marked as unusable).
+	if dl.stale {
+		return nil, ErrSnapshotStale
+	}
+	// If the layer is being generated, ensure the requested account has already
+	// been covered by the generator. Storage is generated per account, so the
+	// account hash alone decides coverage.
+	if dl.genMarker != nil && bytes.Compare(accountHash[:], dl.genMarker) > 0 {
+		return nil, ErrNotCoveredYet
+	}
+	key := append(accountHash[:], storageHash[:]...)
+
+	// Try to retrieve the storage slot from the memory cache
+	if blob, found := dl.cacheGet(key); found {
+		snapshotCleanStorageHitMeter.Mark(1)
+		if len(blob) == 0 {
+			return nil, nil
+		}
+		return blob, nil
+	}
+	// Cache doesn't contain storage slot, pull from disk and cache for later
+	blob := rawdb.ReadStorageSnapshot(dl.diskdb, accountHash, storageHash)
+	dl.cacheSet(key, blob)
+	snapshotCleanStorageMissMeter.Mark(1)
+
+	if len(blob) == 0 {
+		return nil, nil
+	}
+	return blob, nil
+}
+
+// Update creates a new layer on top of the existing snapshot diff tree with
+// the specified data items. Note, the maps are retained by the method to avoid
+// copying everything.
+func (dl *diskLayer) Update(blockRoot common.Hash, destructs map[common.Hash]struct{}, accounts map[common.Hash][]byte, storage map[common.Hash]map[common.Hash][]byte) *diffLayer {
+	return newDiffLayer(dl, blockRoot, destructs, accounts, storage)
+}
+
+// Journal stops any running snapshot generation, persisting its progress, and
+// writes the root of the disk layer as the head of the journal.
+func (dl *diskLayer) Journal(buffer *bytes.Buffer) (common.Hash, error) {
+	// If the snapshot is currently being generated, abort it
+	if dl.genAbort != nil {
+		abort := make(chan struct{})
+		dl.genAbort <- abort
+		<-abort
+
+		dl.genAbort = nil
+	}
+	// Ensure the layer didn't get stale
+	dl.lock.RLock()
+	defer dl.lock.RUnlock()
+
+	if dl.stale {
+		return common.Hash{}, ErrSnapshotStale
+	}
+	// Write the base root as the journal head, it's checked against the disk on load
+	if err := rlp.Encode(buffer, dl.root); err != nil {
+		return common.Hash{}, err
+	}
+	return dl.root, nil
+}
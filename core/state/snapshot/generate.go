@@ -0,0 +1,378 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package snapshot
+
+import (
+	"bytes"
+	"math/big"
+	"time"
+
+	"github.com/BerithFoundation/berith-chain/berithdb"
+	"github.com/BerithFoundation/berith-chain/common"
+	"github.com/BerithFoundation/berith-chain/core/rawdb"
+	"github.com/BerithFoundation/berith-chain/log"
+	"github.com/BerithFoundation/berith-chain/rlp"
+	"github.com/BerithFoundation/berith-chain/trie"
+)
+
+// emptyRoot is the known root hash of an empty trie.
+var emptyRoot = common.HexToHash("56e81f171bcc55a6ff8345e692c0f86e5b48e01b996cadc001622fb5e363b421")
+
+// accountHeader is the leading part of the Berith consensus encoding of an
+// account. The generator only needs the storage root to find the storage trie,
+// the remaining Berith specific fields (stake balance, point, behind balance,
+// penalty) are carried over verbatim in the snapshot.
+type accountHeader struct {
+	Nonce   uint64
+	Balance *big.Int
+	Root    common.Hash
+	Rest    []rlp.RawValue `rlp:"tail"`
+}
+
+// journalGenerator is a disk layer entry containing the generator progress marker.
+type journalGenerator struct {
+	Wiping   bool // Whether the database was in progress of being wiped
+	Done     bool // Whether the generator finished creating the snapshot
+	Marker   []byte
+	Accounts uint64
+	Slots    uint64
+	Storage  uint64
+}
+
+// generatorStats is a collection of statistics gathered by the snapshot generator
+// for logging purposes.
+type generatorStats struct {
+	wiping   bool               // Whether the leftover snapshot is still being wiped
+	start    time.Time          // Timestamp when generation started
+	accounts uint64             // Number of accounts indexed
+	slots    uint64             // Number of storage slots indexed
+	storage  common.StorageSize // Account and storage slot size
+}
+
+// Log creates an contextual log with the given message and the context pulled
+// from the internally maintained statistics.
+func (gs *generatorStats) Log(msg string, marker []byte) {
+	var ctx []interface{}
+	if marker != nil {
+		ctx = append(ctx, []interface{}{"at", common.BytesToHash(marker)}...)
+	}
+	ctx = append(ctx, []interface{}{
+		"accounts", gs.accounts, "slots", gs.slots,
+		"storage", gs.storage, "elapsed", common.PrettyDuration(time.Since(gs.start)),
+	}...)
+	log.Info(msg, ctx...)
+}
+
+// generateSnapshot regenerates a brand new snapshot based on an existing state
+// database and head block asynchronously. The snapshot is returned immediately
+// and generation is continued in the background until done.
+func generateSnapshot(diskdb berithdb.Database, triedb *trie.Database, cache int, root common.Hash) *diskLayer {
+	// Create a new disk layer with an initialized state marker at zero
+	batch := diskdb.NewBatch()
+	rawdb.WriteSnapshotRoot(batch, root)
+	journalProgress(batch, []byte{}, &generatorStats{wiping: true})
+	if err := batch.Write(); err != nil {
+		log.Crit("Failed to write initialized state marker", "err", err)
+	}
+	base := &diskLayer{
+		diskdb:     diskdb,
+		triedb:     triedb,
+		root:       root,
+		cache:      newCache(cache),
+		genMarker:  []byte{}, // Initialized but empty!
+		genPending: make(chan struct{}),
+		genAbort:   make(chan chan struct{}),
+	}
+	go base.generate()
+	return base
+}
+
+// journalProgress persists the generator stats into a batch to allow resuming
+// the generation after a restart or a disk layer change.
+func journalProgress(db berithdb.Putter, marker []byte, stats *generatorStats) {
+	// Write out the generator marker. Note it's a standalone disk layer generator
+	// which is not mixed with journal. It's ok if the generator is persisted while
+	// journal is not.
+	entry := journalGenerator{
+		Done:   marker == nil,
+		Marker: marker,
+	}
+	if stats != nil {
+		entry.Wiping = stats.wiping
+		entry.Accounts = stats.accounts
+		entry.Slots = stats.slots
+		entry.Storage = uint64(stats.storage)
+	}
+	blob, err := rlp.EncodeToBytes(entry)
+	if err != nil {
+		panic(err) // Cannot happen, here to catch dev errors
+	}
+	rawdb.WriteSnapshotGenerator(db, blob)
+}
+
+// loadGenerator retrieves the persisted generator progress, returning nil if
+// the snapshot generation was already finished.
+func loadGenerator(db berithdb.Database) (*journalGenerator, error) {
+	blob := rawdb.ReadSnapshotGenerator(db)
+	if len(blob) == 0 {
+		return &journalGenerator{Wiping: true, Marker: []byte{}}, nil
+	}
+	var generator journalGenerator
+	if err := rlp.DecodeBytes(blob, &generator); err != nil {
+		return nil, err
+	}
+	if generator.Done {
+		return nil, nil
+	}
+	if generator.Marker == nil {
+		generator.Marker = []byte{}
+	}
+	return &generator, nil
+}
+
+// wipeSnapshot deletes all the account and storage snapshot entries from the
+// database. Only keys of the exact snapshot entry lengths are touched, since
+// the prefixes are shared with the hash keyed trie nodes.
+func wipeSnapshot(db berithdb.Database) error {
+	iteratee, ok := db.(berithdb.Iteratee)
+	if !ok {
+		return nil
+	}
+	for _, wipe := range []struct {
+		prefix []byte
+		keylen int
+	}{
+		{rawdb.SnapshotAccountPrefix, len(rawdb.SnapshotAccountPrefix) + common.HashLength},
+		{rawdb.SnapshotStoragePrefix, len(rawdb.SnapshotStoragePrefix) + 2*common.HashLength},
+	} {
+		batch := db.NewBatch()
+		it := iteratee.NewIteratorWithPrefix(wipe.prefix)
+		for it.Next() {
+			if key := it.Key(); len(key) == wipe.keylen {
+				batch.Delete(common.CopyBytes(key))
+				if batch.ValueSize() > berithdb.IdealBatchSize {
+					if err := batch.Write(); err != nil {
+						it.Release()
+						return err
+					}
+					batch.Reset()
+				}
+			}
+		}
+		err := it.Error()
+		it.Release()
+		if err != nil {
+			return err
+		}
+		if err := batch.Write(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// wipeStorage deletes all the persisted storage snapshot entries of an account
+// into the given batch, invoking the callback with the cache key (account hash
+// and slot hash) of each removed entry.
+func wipeStorage(db berithdb.Database, batch berithdb.Batch, accountHash common.Hash, onDelete func(key []byte)) {
+	it, ok := rawdb.IterateStorageSnapshots(db, accountHash)
+	if !ok {
+		return
+	}
+	defer it.Release()
+
+	keylen := len(rawdb.SnapshotStoragePrefix) + 2*common.HashLength
+	for it.Next() {
+		key := it.Key()
+		if len(key) != keylen {
+			continue
+		}
+		key = common.CopyBytes(key)
+		batch.Delete(key)
+		if onDelete != nil {
+			onDelete(key[len(rawdb.SnapshotStoragePrefix):])
+		}
+	}
+}
+
+// generate is a background thread that iterates over the state and storage tries,
+// constructing the state snapshot. The progress is persisted along the generated
+// data, since the method surfs the blocks as they arrive, often being restarted
+// on top of a newer disk layer.
+func (dl *diskLayer) generate() {
+	progress, err := loadGenerator(dl.diskdb)
+	if err != nil || progress == nil {
+		progress = &journalGenerator{Marker: dl.genMarker}
+	}
+	stats := &generatorStats{
+		wiping:   progress.Wiping,
+		start:    time.Now(),
+		accounts: progress.Accounts,
+		slots:    progress.Slots,
+		storage:  common.StorageSize(progress.Storage),
+	}
+	// Delete any leftover snapshot entries before generating a fresh one
+	if stats.wiping {
+		if err := wipeSnapshot(dl.diskdb); err != nil {
+			log.Error("Failed to wipe state snapshot", "err", err)
+			dl.waitAbort()
+			return
+		}
+		stats.wiping = false
+
+		batch := dl.diskdb.NewBatch()
+		journalProgress(batch, dl.genMarker, stats)
+		if err := batch.Write(); err != nil {
+			log.Error("Failed to persist snapshot wipe", "err", err)
+			dl.waitAbort()
+			return
+		}
+	}
+	// Create an account and state iterator pointing to the current generator marker
+	accTrie, err := trie.NewSecure(dl.root, dl.triedb, 0)
+	if err != nil {
+		// The account trie is missing (GC), surf the chain until one becomes available
+		log.Warn("Generator failed to access account trie", "root", dl.root, "err", err)
+		dl.waitAbort()
+		return
+	}
+	if len(dl.genMarker) == 0 {
+		stats.Log("Started state snapshot generation", nil)
+	} else {
+		stats.Log("Resuming state snapshot generation", dl.genMarker)
+	}
+
+	var accMarker []byte
+	if len(dl.genMarker) > 0 { // []byte{} is the start, use nil for that
+		accMarker = dl.genMarker
+	}
+	var (
+		accIt  = trie.NewIterator(accTrie.NodeIterator(accMarker))
+		batch  = dl.diskdb.NewBatch()
+		logged = time.Now()
+	)
+	for accIt.Next() {
+		// Retrieve the current account and flatten it into the internal format
+		accountHash := common.BytesToHash(accIt.Key)
+		if accMarker != nil && bytes.Equal(accountHash[:], accMarker) {
+			continue // Already generated before the generator was restarted
+		}
+		var acc accountHeader
+		if err := rlp.DecodeBytes(accIt.Value, &acc); err != nil {
+			log.Crit("Invalid account encountered during snapshot creation", "err", err)
+		}
+		rawdb.WriteAccountSnapshot(batch, accountHash, accIt.Value)
+		stats.storage += common.StorageSize(1 + common.HashLength + len(accIt.Value))
+		stats.accounts++
+
+		// Drop any storage left behind by an interrupted run on an older root
+		wipeStorage(dl.diskdb, batch, accountHash, nil)
+
+		// If the account has a storage trie, iterate it too
+		if acc.Root != emptyRoot {
+			storeTrie, err := trie.NewSecure(acc.Root, dl.triedb, 0)
+			if err != nil {
+				log.Error("Generator failed to access storage trie", "accroot", dl.root, "acchash", accountHash, "stroot", acc.Root, "err", err)
+				dl.waitAbort()
+				return
+			}
+			storeIt := trie.NewIterator(storeTrie.NodeIterator(nil))
+			for storeIt.Next() {
+				rawdb.WriteStorageSnapshot(batch, accountHash, common.BytesToHash(storeIt.Key), storeIt.Value)
+				stats.storage += common.StorageSize(1 + 2*common.HashLength + len(storeIt.Value))
+				stats.slots++
+
+				// The account is not covered until all of its slots are written,
+				// so the batch can be flushed without moving the marker.
+				if batch.ValueSize() > berithdb.IdealBatchSize {
+					batch.Write()
+					batch.Reset()
+				}
+			}
+			if storeIt.Err != nil {
+				log.Error("Generator failed to iterate storage trie", "accroot", dl.root, "acchash", accountHash, "stroot", acc.Root, "err", storeIt.Err)
+				dl.waitAbort()
+				return
+			}
+		}
+		// Account fully generated, flush the data and check for termination
+		if batch.ValueSize() > berithdb.IdealBatchSize {
+			if !dl.flushProgress(batch, accountHash[:], stats) {
+				return
+			}
+		}
+		if time.Since(logged) > 8*time.Second {
+			stats.Log("Generating state snapshot", accountHash[:])
+			logged = time.Now()
+		}
+	}
+	if accIt.Err != nil {
+		log.Error("Generator failed to iterate account trie", "root", dl.root, "err", accIt.Err)
+		dl.waitAbort()
+		return
+	}
+	// Snapshot fully generated, set the marker to nil
+	journalProgress(batch, nil, stats)
+	if err := batch.Write(); err != nil {
+		log.Error("Failed to flush snapshot generation", "err", err)
+		dl.waitAbort()
+		return
+	}
+	log.Info("Generated state snapshot", "accounts", stats.accounts, "slots", stats.slots,
+		"storage", stats.storage, "elapsed", common.PrettyDuration(time.Since(stats.start)))
+
+	dl.lock.Lock()
+	dl.genMarker = nil
+	close(dl.genPending)
+	dl.lock.Unlock()
+
+	// Someone will be looking for us, wait it out
+	dl.waitAbort()
+}
+
+// flushProgress writes the generated data along with the progress marker to
+// disk, moves the coverage marker of the layer and checks whether the generator
+// was requested to stop. It returns false if generation must not continue.
+func (dl *diskLayer) flushProgress(batch berithdb.Batch, marker []byte, stats *generatorStats) bool {
+	journalProgress(batch, marker, stats)
+	if err := batch.Write(); err != nil {
+		log.Error("Failed to flush snapshot generation", "err", err)
+		dl.waitAbort()
+		return false
+	}
+	batch.Reset()
+
+	dl.lock.Lock()
+	dl.genMarker = marker
+	dl.lock.Unlock()
+
+	select {
+	case abort := <-dl.genAbort:
+		stats.Log("Aborting state snapshot generation", marker)
+		abort <- struct{}{}
+		return false
+	default:
+	}
+	return true
+}
+
+// waitAbort blocks the generator until it is requested to terminate, so that
+// the abort handshake of the layer owner never deadlocks.
+func (dl *diskLayer) waitAbort() {
+	abort := <-dl.genAbort
+	abort <- struct{}{}
+}
@@ -0,0 +1,262 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package snapshot
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/BerithFoundation/berith-chain/berithdb"
+	"github.com/BerithFoundation/berith-chain/common"
+	"github.com/BerithFoundation/berith-chain/core/rawdb"
+	"github.com/BerithFoundation/berith-chain/crypto"
+	"github.com/BerithFoundation/berith-chain/rlp"
+	"github.com/BerithFoundation/berith-chain/trie"
+)
+
+// testAccount mirrors the Berith consensus encoding of an account, including
+// the staking related fields that the snapshot must carry over verbatim.
+type testAccount struct {
+	Nonce          uint64
+	Balance        *big.Int
+	Root           common.Hash
+	CodeHash       []byte
+	StakeBalance   *big.Int
+	StakeUpdated   *big.Int
+	Point          *big.Int
+	BehindBalance  []testBehind
+	Penalty        uint64
+	PenlatyUpdated *big.Int
+}
+
+type testBehind struct {
+	Number  *big.Int
+	Balance *big.Int
+}
+
+// makeAccount creates the RLP encoding of a staking account with the given
+// storage root.
+func makeAccount(t *testing.T, n int64, root common.Hash) []byte {
+	blob, err := rlp.EncodeToBytes(&testAccount{
+		Nonce:          uint64(n),
+		Balance:        big.NewInt(n * 100),
+		Root:           root,
+		CodeHash:       crypto.Keccak256(nil),
+		StakeBalance:   big.NewInt(n * 1000),
+		StakeUpdated:   big.NewInt(n),
+		Point:          big.NewInt(n * 7),
+		BehindBalance:  []testBehind{{Number: big.NewInt(n), Balance: big.NewInt(n * 3)}},
+		Penalty:        uint64(n % 2),
+		PenlatyUpdated: big.NewInt(n),
+	})
+	if err != nil {
+		t.Fatalf("failed to encode account: %v", err)
+	}
+	return blob
+}
+
+// makeState creates a state trie with a couple of staking accounts, one of them
+// with a storage trie, and flushes it into the database.
+func makeState(t *testing.T, diskdb berithdb.Database) (*trie.Database, common.Hash, map[common.Hash][]byte) {
+	triedb := trie.NewDatabase(diskdb)
+
+	storage, _ := trie.NewSecure(common.Hash{}, triedb, 0)
+	storage.Update([]byte("key-1"), []byte("val-1"))
+	storage.Update([]byte("key-2"), []byte("val-2"))
+	storageRoot, _ := storage.Commit(nil)
+
+	accounts := make(map[common.Hash][]byte)
+	state, _ := trie.NewSecure(common.Hash{}, triedb, 0)
+	for i := int64(1); i <= 3; i++ {
+		addr := []byte{byte(i)}
+		root := emptyRoot
+		if i == 2 {
+			root = storageRoot
+		}
+		blob := makeAccount(t, i, root)
+		state.Update(addr, blob)
+		accounts[crypto.Keccak256Hash(addr)] = blob
+	}
+	root, _ := state.Commit(func(leaf []byte, parent common.Hash) error {
+		var acc accountHeader
+		if err := rlp.DecodeBytes(leaf, &acc); err == nil && acc.Root != emptyRoot {
+			triedb.Reference(acc.Root, parent)
+		}
+		return nil
+	})
+	if err := triedb.Commit(root, false); err != nil {
+		t.Fatalf("failed to commit state: %v", err)
+	}
+	return triedb, root, accounts
+}
+
+// waitGeneration blocks until the snapshot generation of a disk layer finishes.
+func waitGeneration(t *testing.T, layer *diskLayer) {
+	select {
+	case <-layer.genPending:
+	case <-time.After(3 * time.Second):
+		t.Fatalf("snapshot generation timed out")
+	}
+}
+
+// Tests that a snapshot generated from the state trie contains the complete
+// Berith accounts and their storage.
+func TestGeneration(t *testing.T) {
+	diskdb := berithdb.NewMemDatabase()
+	triedb, root, accounts := makeState(t, diskdb)
+
+	snap := generateSnapshot(diskdb, triedb, 16, root)
+	waitGeneration(t, snap)
+
+	for hash, want := range accounts {
+		blob, err := snap.Account(hash)
+		if err != nil {
+			t.Fatalf("account %x: failed to retrieve: %v", hash, err)
+		}
+		if !bytes.Equal(blob, want) {
+			t.Fatalf("account %x: blob mismatch: have %x, want %x", hash, blob, want)
+		}
+		var acc testAccount
+		if err := rlp.DecodeBytes(blob, &acc); err != nil {
+			t.Fatalf("account %x: failed to decode: %v", hash, err)
+		}
+		if acc.StakeBalance.Sign() == 0 || acc.Point.Sign() == 0 || len(acc.BehindBalance) != 1 {
+			t.Fatalf("account %x: staking fields lost: %+v", hash, acc)
+		}
+	}
+	accHash := crypto.Keccak256Hash([]byte{2})
+	blob, err := snap.Storage(accHash, crypto.Keccak256Hash([]byte("key-1")))
+	if err != nil || !bytes.Equal(blob, []byte("val-1")) {
+		t.Fatalf("storage mismatch: have %x (%v), want %x", blob, err, []byte("val-1"))
+	}
+	if blob, err := snap.Account(common.Hash{0xff}); err != nil || blob != nil {
+		t.Fatalf("non-existent account: have %x (%v), want nil", blob, err)
+	}
+}
+
+// Tests that diff layers shadow their parents, that capping the tree persists
+// the flattened layers into the disk layer and drops the abandoned forks.
+func TestDiffLayersCap(t *testing.T) {
+	diskdb := berithdb.NewMemDatabase()
+	triedb, root, _ := makeState(t, diskdb)
+
+	base := generateSnapshot(diskdb, triedb, 16, root)
+	waitGeneration(t, base)
+	tree := &Tree{diskdb: diskdb, triedb: triedb, cache: 16, layers: map[common.Hash]snapshot{root: base}}
+
+	var (
+		acc1   = crypto.Keccak256Hash([]byte{1})
+		acc2   = crypto.Keccak256Hash([]byte{2})
+		slot   = crypto.Keccak256Hash([]byte("key-1"))
+		blob1  = makeAccount(t, 11, emptyRoot)
+		blob2  = makeAccount(t, 12, emptyRoot)
+		rootA  = common.Hash{0xa}
+		rootB  = common.Hash{0xb}
+		rootC  = common.Hash{0xc}
+		rootA2 = common.Hash{0xa, 0x2}
+	)
+	// Block A updates account 1, block B destructs account 2 and its storage,
+	// block C builds on B. A2 is a fork of A and must vanish after capping.
+	if err := tree.Update(rootA, root, nil, map[common.Hash][]byte{acc1: blob1}, nil); err != nil {
+		t.Fatalf("failed to create diff layer: %v", err)
+	}
+	if err := tree.Update(rootA2, root, nil, map[common.Hash][]byte{acc1: blob2}, nil); err != nil {
+		t.Fatalf("failed to create diff layer: %v", err)
+	}
+	if err := tree.Update(rootB, rootA, map[common.Hash]struct{}{acc2: {}}, map[common.Hash][]byte{}, map[common.Hash]map[common.Hash][]byte{}); err != nil {
+		t.Fatalf("failed to create diff layer: %v", err)
+	}
+	if err := tree.Update(rootC, rootB, map[common.Hash]struct{}{}, map[common.Hash][]byte{acc1: blob2}, nil); err != nil {
+		t.Fatalf("failed to create diff layer: %v", err)
+	}
+	if blob, _ := tree.Snapshot(rootB).Account(acc1); !bytes.Equal(blob, blob1) {
+		t.Fatalf("diff account mismatch: have %x, want %x", blob, blob1)
+	}
+	if blob, _ := tree.Snapshot(rootB).Storage(acc2, slot); blob != nil {
+		t.Fatalf("destructed storage still served: %x", blob)
+	}
+	if blob, _ := tree.Snapshot(rootA).Storage(acc2, slot); !bytes.Equal(blob, []byte("val-1")) {
+		t.Fatalf("parent storage mismatch: have %x", blob)
+	}
+	// Keep one diff layer above the disk, flattening A and B
+	if err := tree.Cap(rootC, 1); err != nil {
+		t.Fatalf("failed to cap tree: %v", err)
+	}
+	if tree.Snapshot(rootA2) != nil || tree.Snapshot(rootA) != nil {
+		t.Fatalf("stale layers retained after cap")
+	}
+	disk, ok := tree.Snapshot(rootB).(*diskLayer)
+	if !ok {
+		t.Fatalf("flattened layer not persisted: %T", tree.Snapshot(rootB))
+	}
+	if have := disk.Root(); have != rootB {
+		t.Fatalf("disk layer root mismatch: have %x, want %x", have, rootB)
+	}
+	if blob := rawdb.ReadAccountSnapshot(diskdb, acc1); !bytes.Equal(blob, blob1) {
+		t.Fatalf("persisted account mismatch: have %x, want %x", blob, blob1)
+	}
+	if blob, _ := disk.Storage(acc2, slot); blob != nil {
+		t.Fatalf("destructed storage persisted: %x", blob)
+	}
+	if blob, _ := tree.Snapshot(rootC).Account(acc1); !bytes.Equal(blob, blob2) {
+		t.Fatalf("head account mismatch: have %x, want %x", blob, blob2)
+	}
+	if _, err := base.Account(acc1); err != ErrSnapshotStale {
+		t.Fatalf("stale disk layer error mismatch: have %v, want %v", err, ErrSnapshotStale)
+	}
+}
+
+// Tests that the diff layers survive a restart through the journal and that a
+// journal not matching the chain head is rejected.
+func TestJournal(t *testing.T) {
+	diskdb := berithdb.NewMemDatabase()
+	triedb, root, _ := makeState(t, diskdb)
+
+	tree := New(diskdb, triedb, 16, root)
+	waitGeneration(t, tree.Snapshot(root).(*diskLayer))
+
+	var (
+		acc1  = crypto.Keccak256Hash([]byte{1})
+		blob1 = makeAccount(t, 21, emptyRoot)
+		slot  = crypto.Keccak256Hash([]byte("key-3"))
+		rootA = common.Hash{0xa}
+	)
+	storage := map[common.Hash]map[common.Hash][]byte{acc1: {slot: []byte("val-3")}}
+	if err := tree.Update(rootA, root, map[common.Hash]struct{}{}, map[common.Hash][]byte{acc1: blob1}, storage); err != nil {
+		t.Fatalf("failed to create diff layer: %v", err)
+	}
+	if base, err := tree.Journal(rootA); err != nil || base != root {
+		t.Fatalf("failed to journal: base %x, err %v", base, err)
+	}
+	restored := New(diskdb, triedb, 16, rootA)
+	snap := restored.Snapshot(rootA)
+	if _, ok := snap.(*diffLayer); !ok {
+		t.Fatalf("journalled diff layer not restored: %T", snap)
+	}
+	if blob, _ := snap.Account(acc1); !bytes.Equal(blob, blob1) {
+		t.Fatalf("restored account mismatch: have %x, want %x", blob, blob1)
+	}
+	if blob, _ := snap.Storage(acc1, slot); !bytes.Equal(blob, []byte("val-3")) {
+		t.Fatalf("restored storage mismatch: have %x", blob)
+	}
+	// A journal for a different head must not be loaded
+	if _, err := loadSnapshot(diskdb, triedb, 16, common.Hash{0xff}); err == nil {
+		t.Fatalf("mismatching journal head accepted")
+	}
+}
@@ -83,9 +83,10 @@ type stateObject struct {
 	trie Trie // storage trie, which becomes non-nil on first access
 	code Code // contract bytecode, which gets set when code is loaded
 
-	originStorage Storage // Storage cache of original entries to dedup rewrites
-	dirtyStorage  Storage // Storage entries that need to be flushed to disk
-	fakeStorage   Storage // Fake storage which constructed by caller for debugging purpose.
+	originStorage  Storage // Storage cache of original entries to dedup rewrites
+	dirtyStorage   Storage // Storage entries that need to be flushed to disk
+	pendingStorage Storage // Storage entries written to the trie, to be pushed into the snapshot on commit
+	fakeStorage    Storage // Fake storage which constructed by caller for debugging purpose.
 
 	// Cache flags.
 	// When an object is marked suicided it will be delete from the trie
@@ -93,6 +94,7 @@ type stateObject struct {
 	dirtyCode bool // true if the code was updated
 	suicided  bool
 	deleted   bool
+	created   bool // true if the object was created and not loaded from the state
 }
 
 // empty returns whether the account is considered empty.
@@ -165,12 +167,13 @@ func newObject(db *StateDB, address common.Address, data Account) *stateObject {
 	}
 
 	return &stateObject{
-		db:            db,
-		address:       address,
-		addrHash:      crypto.Keccak256Hash(address[:]),
-		data:          data,
-		originStorage: make(Storage),
-		dirtyStorage:  make(Storage),
+		db:             db,
+		address:        address,
+		addrHash:       crypto.Keccak256Hash(address[:]),
+		data:           data,
+		originStorage:  make(Storage),
+		dirtyStorage:   make(Storage),
+		pendingStorage: make(Storage),
 	}
 }
 
@@ -231,11 +234,22 @@ func (s *stateObject) GetCommittedState(db Database, key common.Hash) common.Has
 	if cached {
 		return value
 	}
-	// Otherwise load the value from the database
-	enc, err := s.getTrie(db).TryGet(key[:])
-	if err != nil {
-		s.setError(err)
-		return common.Hash{}
+	// Otherwise load the value from the snapshot, or from the database if the
+	// snapshot is unavailable. Created objects never had their storage in the
+	// snapshot of the state they were opened at.
+	var (
+		enc []byte
+		err error
+	)
+	if s.db.snap != nil && !s.created {
+		enc, err = s.db.snap.Storage(s.addrHash, crypto.Keccak256Hash(key[:]))
+	}
+	if s.db.snap == nil || s.created || err != nil {
+		enc, err = s.getTrie(db).TryGet(key[:])
+		if err != nil {
+			s.setError(err)
+			return common.Hash{}
+		}
 	}
 	if len(enc) > 0 {
 		_, content, _, err := rlp.Split(enc)
@@ -297,6 +311,9 @@ func (s *stateObject) updateTrie(db Database) Trie {
 			continue
 		}
 		s.originStorage[key] = value
+		if s.db.snap != nil {
+			s.pendingStorage[key] = value
+		}
 
 		if (value == common.Hash{}) {
 			s.setError(tr.TryDelete(key[:]))
@@ -376,9 +393,11 @@ func (s *stateObject) deepCopy(db *StateDB) *stateObject {
 	stateObject.code = s.code
 	stateObject.dirtyStorage = s.dirtyStorage.Copy()
 	stateObject.originStorage = s.originStorage.Copy()
+	stateObject.pendingStorage = s.pendingStorage.Copy()
 	stateObject.suicided = s.suicided
 	stateObject.dirtyCode = s.dirtyCode
 	stateObject.deleted = s.deleted
+	stateObject.created = s.created
 	return stateObject
 }
 
@@ -18,7 +18,9 @@ package state
 
 import (
 	"bytes"
+	"encoding/json"
 	"math/big"
+	"strings"
 	"testing"
 
 	"github.com/BerithFoundation/berith-chain/berithdb"
@@ -53,7 +55,7 @@ func (s *StateSuite) TestDump(c *checker.C) {
 	// check that dump contains the state objects that are in trie
 	got := string(s.state.Dump())
 	want := `{
-    "root": "71edff0130dd2385947095001c73d9e28d862fc286fca2b922ca6f6f3cddfdd2",
+    "root": "f6524bbf738752c67715147a6aaf32e8b03ffc119222d54dc7f9bc76ad0f0688",
     "accounts": {
         "0000000000000000000000000000000000000001": {
             "balance": "22",
@@ -61,7 +63,13 @@ func (s *StateSuite) TestDump(c *checker.C) {
             "root": "56e81f171bcc55a6ff8345e692c0f86e5b48e01b996cadc001622fb5e363b421",
             "codeHash": "c5d2460186f7233c927e7db2dcc703c0e500b653ca82273b7bfad8045d85a470",
             "code": "",
-            "storage": {}
+            "storage": {},
+            "stakeBalance": "0",
+            "stakeUpdated": "0",
+            "point": "0",
+            "behindBalance": [],
+            "penalty": 0,
+            "penaltyUpdated": "0"
         },
         "0000000000000000000000000000000000000002": {
             "balance": "44",
@@ -69,7 +77,13 @@ func (s *StateSuite) TestDump(c *checker.C) {
             "root": "56e81f171bcc55a6ff8345e692c0f86e5b48e01b996cadc001622fb5e363b421",
             "codeHash": "c5d2460186f7233c927e7db2dcc703c0e500b653ca82273b7bfad8045d85a470",
             "code": "",
-            "storage": {}
+            "storage": {},
+            "stakeBalance": "0",
+            "stakeUpdated": "0",
+            "point": "0",
+            "behindBalance": [],
+            "penalty": 0,
+            "penaltyUpdated": "0"
         },
         "0000000000000000000000000000000000000102": {
             "balance": "0",
@@ -77,7 +91,13 @@ func (s *StateSuite) TestDump(c *checker.C) {
             "root": "56e81f171bcc55a6ff8345e692c0f86e5b48e01b996cadc001622fb5e363b421",
             "codeHash": "87874902497a5bb968da31a2998d8f22e949d1ef6214bcdedd8bae24cca4b9e3",
             "code": "03030303030303",
-            "storage": {}
+            "storage": {},
+            "stakeBalance": "0",
+            "stakeUpdated": "0",
+            "point": "0",
+            "behindBalance": [],
+            "penalty": 0,
+            "penaltyUpdated": "0"
         }
     }
 }`
@@ -86,6 +106,35 @@ func (s *StateSuite) TestDump(c *checker.C) {
 	}
 }
 
+func (s *StateSuite) TestIterativeDumpStakers(c *checker.C) {
+	staker := s.state.GetOrNewStateObject(toAddr([]byte{0x01}))
+	staker.AddBalance(big.NewInt(22))
+	staker.SetStaking(big.NewInt(100), big.NewInt(5))
+	staker.SetBehind(big.NewInt(7), big.NewInt(3))
+	other := s.state.GetOrNewStateObject(toAddr([]byte{0x02}))
+	other.AddBalance(big.NewInt(44))
+	s.state.Commit(false)
+
+	var buf bytes.Buffer
+	s.state.IterativeDump(&DumpConfig{OnlyStakers: true, SkipCode: true, SkipStorage: true}, &buf)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	c.Assert(lines, checker.HasLen, 3)
+
+	var account DumpAccount
+	c.Assert(json.Unmarshal([]byte(lines[1]), &account), checker.IsNil)
+	c.Assert(*account.Address, checker.Equals, toAddr([]byte{0x01}))
+	c.Assert(account.StakeBalance, checker.Equals, "100")
+	c.Assert(account.StakeUpdated, checker.Equals, "5")
+	c.Assert(account.BehindBalance, checker.DeepEquals, []DumpBehind{{Number: "7", Balance: "3"}})
+
+	var totals struct {
+		Totals DumpTotals `json:"totals"`
+	}
+	c.Assert(json.Unmarshal([]byte(lines[2]), &totals), checker.IsNil)
+	c.Assert(totals.Totals, checker.DeepEquals, DumpTotals{Accounts: 1, Balance: "22", StakeBalance: "100", BehindBalance: "3"})
+}
+
 func (s *StateSuite) SetUpTest(c *checker.C) {
 	s.db = berithdb.NewMemDatabase()
 	s.state, _ = New(common.Hash{}, NewDatabase(s.db))
@@ -18,12 +18,14 @@
 package state
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"math/big"
 	"sort"
 
 	"github.com/BerithFoundation/berith-chain/common"
+	"github.com/BerithFoundation/berith-chain/core/state/snapshot"
 	"github.com/BerithFoundation/berith-chain/core/types"
 	"github.com/BerithFoundation/berith-chain/crypto"
 	"github.com/BerithFoundation/berith-chain/log"
@@ -60,6 +62,12 @@ type StateDB struct {
 	db   Database
 	trie Trie
 
+	// [BERITH] Flat snapshot of the state the trie was opened at. Accounts and
+	// storage are read through it, falling back to the trie if it is missing
+	// or not yet generated.
+	snaps *snapshot.Tree
+	snap  snapshot.Snapshot
+
 	// This map holds 'live' objects, which will get modified while processing a state transition.
 	stateObjects      map[common.Address]*stateObject
 	stateObjectsDirty map[common.Address]struct{}
@@ -90,20 +98,31 @@ type StateDB struct {
 
 // Create a new state from a given trie.
 func New(root common.Hash, db Database) (*StateDB, error) {
+	return NewWithSnapshot(root, db, nil)
+}
+
+// NewWithSnapshot creates a new state from a given trie, reading accounts and
+// storage through the snapshot of the root if the snapshot tree maintains one.
+// The committed changes are pushed into the snapshot tree as a new diff layer.
+func NewWithSnapshot(root common.Hash, db Database, snaps *snapshot.Tree) (*StateDB, error) {
 	tr, err := db.OpenTrie(root)
 	if err != nil {
 		return nil, err
 	}
-
-	return &StateDB{
+	sdb := &StateDB{
 		db:                db,
 		trie:              tr,
+		snaps:             snaps,
 		stateObjects:      make(map[common.Address]*stateObject),
 		stateObjectsDirty: make(map[common.Address]struct{}),
 		logs:              make(map[common.Hash][]*types.Log),
 		preimages:         make(map[common.Hash][]byte),
 		journal:           newJournal(),
-	}, nil
+	}
+	if snaps != nil {
+		sdb.snap = snaps.Snapshot(root)
+	}
+	return sdb, nil
 }
 
 // setError remembers the first non-nil error it is called with.
@@ -125,6 +144,9 @@ func (s *StateDB) Reset(root common.Hash) error {
 		return err
 	}
 	s.trie = tr
+	if s.snaps != nil {
+		s.snap = s.snaps.Snapshot(root)
+	}
 	s.stateObjects = make(map[common.Address]*stateObject)
 	s.stateObjectsDirty = make(map[common.Address]struct{})
 	s.thash = common.Hash{}
@@ -436,6 +458,15 @@ func (s *StateDB) GetBehindBalance(addr common.Address) []Behind {
 	return []Behind{}
 }
 
+// GetTotalBehindBalance returns the sum of the behind balances of addr.
+func (s *StateDB) GetTotalBehindBalance(addr common.Address) *big.Int {
+	total := new(big.Int)
+	for _, behind := range s.GetBehindBalance(addr) {
+		total.Add(total, behind.Balance)
+	}
+	return total
+}
+
 // [BERITH] Penalty
 func (s *StateDB) AddPenalty(addr common.Address, blockNumber *big.Int) {
 	stateObject := s.getStateObject(addr)
@@ -574,10 +605,19 @@ func (s *StateDB) getStateObject(addr common.Address) (stateObject *stateObject)
 		return obj
 	}
 
-	// Load the object from the database.
-	enc, err := s.trie.TryGet(addr[:])
-	if err != nil {
-		log.Error("getStateObject", "Error", err)
+	// Load the object from the snapshot if available, from the database otherwise.
+	var (
+		enc []byte
+		err error
+	)
+	if s.snap != nil {
+		enc, err = s.snap.Account(crypto.Keccak256Hash(addr[:]))
+	}
+	if s.snap == nil || err != nil {
+		enc, err = s.trie.TryGet(addr[:])
+		if err != nil {
+			log.Error("getStateObject", "Error", err)
+		}
 	}
 	if len(enc) == 0 {
 		s.setError(err)
@@ -612,6 +652,7 @@ func (s *StateDB) GetOrNewStateObject(addr common.Address) *stateObject {
 func (s *StateDB) createObject(addr common.Address) (newobj, prev *stateObject) {
 	prev = s.getStateObject(addr)
 	newobj = newObject(s, addr, Account{})
+	newobj.created = true
 	newobj.setNonce(0) // sets the object to dirty
 	if prev == nil {
 		s.journal.append(createObjectChange{account: &addr})
@@ -669,6 +710,8 @@ func (s *StateDB) Copy() *StateDB {
 		logSize:           s.logSize,
 		preimages:         make(map[common.Hash][]byte),
 		journal:           newJournal(),
+		snaps:             s.snaps,
+		snap:              s.snap,
 	}
 	// Copy the dirty states, logs, and preimages
 	for addr := range s.journal.dirties {
@@ -794,6 +837,17 @@ func (s *StateDB) Commit(deleteEmptyObjects bool) (root common.Hash, err error)
 	for addr := range s.journal.dirties {
 		s.stateObjectsDirty[addr] = struct{}{}
 	}
+	// [BERITH] Collect the changes of the block for the snapshot diff layer
+	var (
+		snapDestructs map[common.Hash]struct{}
+		snapAccounts  map[common.Hash][]byte
+		snapStorage   map[common.Hash]map[common.Hash][]byte
+	)
+	if s.snap != nil {
+		snapDestructs = make(map[common.Hash]struct{})
+		snapAccounts = make(map[common.Hash][]byte)
+		snapStorage = make(map[common.Hash]map[common.Hash][]byte)
+	}
 	// Commit objects to the trie.
 	for addr, stateObject := range s.stateObjects {
 		_, isDirty := s.stateObjectsDirty[addr]
@@ -802,6 +856,9 @@ func (s *StateDB) Commit(deleteEmptyObjects bool) (root common.Hash, err error)
 			// If the object has been removed, don't bother syncing it
 			// and just mark it for deletion in the trie.
 			s.deleteStateObject(stateObject)
+			if s.snap != nil {
+				snapDestructs[stateObject.addrHash] = struct{}{}
+			}
 		case isDirty:
 			// Write any contract code associated with the state object
 			if stateObject.code != nil && stateObject.dirtyCode {
@@ -814,6 +871,31 @@ func (s *StateDB) Commit(deleteEmptyObjects bool) (root common.Hash, err error)
 			}
 			// Update the object in the main account trie.
 			s.updateStateObject(stateObject)
+
+			if s.snap != nil {
+				// A recreated account replaces whatever was stored under its hash
+				if stateObject.created {
+					snapDestructs[stateObject.addrHash] = struct{}{}
+				}
+				data, err := rlp.EncodeToBytes(stateObject)
+				if err != nil {
+					panic(fmt.Errorf("can't encode object at %x: %v", addr[:], err))
+				}
+				snapAccounts[stateObject.addrHash] = data
+				if len(stateObject.pendingStorage) > 0 {
+					storage := make(map[common.Hash][]byte, len(stateObject.pendingStorage))
+					for key, value := range stateObject.pendingStorage {
+						var v []byte
+						if (value != common.Hash{}) {
+							// Encoding []byte cannot fail, ok to ignore the error.
+							v, _ = rlp.EncodeToBytes(bytes.TrimLeft(value[:], "\x00"))
+						}
+						storage[crypto.Keccak256Hash(key[:])] = v
+					}
+					snapStorage[stateObject.addrHash] = storage
+					stateObject.pendingStorage = make(Storage)
+				}
+			}
 		}
 		delete(s.stateObjectsDirty, addr)
 	}
@@ -833,5 +915,16 @@ func (s *StateDB) Commit(deleteEmptyObjects bool) (root common.Hash, err error)
 		return nil
 	})
 	log.Debug("Trie cache stats after commit", "misses", trie.CacheMisses(), "unloads", trie.CacheUnloads())
+
+	// [BERITH] Push the changes into a new snapshot layer. The layers are
+	// flattened by the chain, once the block is known to be canonical.
+	if err == nil && s.snap != nil {
+		if parent := s.snap.Root(); parent != root {
+			if err := s.snaps.Update(root, parent, snapDestructs, snapAccounts, snapStorage); err != nil {
+				log.Warn("Failed to update snapshot tree", "from", parent, "to", root, "err", err)
+			}
+		}
+		s.snap = nil
+	}
 	return root, err
 }
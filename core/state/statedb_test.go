@@ -32,7 +32,10 @@ import (
 
 	"github.com/BerithFoundation/berith-chain/berithdb"
 	"github.com/BerithFoundation/berith-chain/common"
+	"github.com/BerithFoundation/berith-chain/core/state/snapshot"
 	"github.com/BerithFoundation/berith-chain/core/types"
+	"github.com/BerithFoundation/berith-chain/crypto"
+	"github.com/BerithFoundation/berith-chain/rlp"
 )
 
 // Tests that updating a state trie does not leak any database writes prior to
@@ -435,3 +438,64 @@ func TestCopyOfCopy(t *testing.T) {
 		t.Fatalf("2nd copy fail, expected 42, got %v", got)
 	}
 }
+
+// Tests that committing a state pushes the Berith account fields and storage
+// into the snapshot tree and that states opened on top read them back, also
+// after the account was destroyed and recreated.
+func TestSnapshotCommit(t *testing.T) {
+	db := berithdb.NewMemDatabase()
+	sdb := NewDatabase(db)
+
+	state, _ := New(common.Hash{}, sdb)
+	addr := common.BytesToAddress([]byte("staker"))
+	state.AddBalance(addr, big.NewInt(42))
+	state.SetState(addr, common.Hash{1}, common.Hash{2})
+	root, _ := state.Commit(false)
+	sdb.TrieDB().Commit(root, false)
+
+	snaps := snapshot.New(db, sdb.TrieDB(), 16, root)
+
+	state, _ = NewWithSnapshot(root, sdb, snaps)
+	state.AddStakeBalance(addr, big.NewInt(1000), big.NewInt(1))
+	state.SetPoint(addr, big.NewInt(7))
+	state.AddBehindBalance(addr, big.NewInt(1), big.NewInt(3))
+	state.SetState(addr, common.Hash{1}, common.Hash{3})
+	next, err := state.Commit(false)
+	if err != nil {
+		t.Fatalf("failed to commit state: %v", err)
+	}
+	if snaps.Snapshot(next) == nil {
+		t.Fatalf("snapshot layer missing for committed root")
+	}
+	blob, err := snaps.Snapshot(next).Account(crypto.Keccak256Hash(addr[:]))
+	if err != nil {
+		t.Fatalf("failed to read snapshot account: %v", err)
+	}
+	var account Account
+	if err := rlp.DecodeBytes(blob, &account); err != nil {
+		t.Fatalf("failed to decode snapshot account: %v", err)
+	}
+	if account.StakeBalance.Int64() != 1000 || account.Point.Int64() != 7 || len(account.BehindBalance) != 1 {
+		t.Fatalf("snapshot account mismatch: %+v", account)
+	}
+	state, _ = NewWithSnapshot(next, sdb, snaps)
+	if balance := state.GetStakeBalance(addr); balance.Int64() != 1000 {
+		t.Fatalf("stake balance mismatch: have %v, want 1000", balance)
+	}
+	if value := state.GetState(addr, common.Hash{1}); value != (common.Hash{3}) {
+		t.Fatalf("storage mismatch: have %x, want %x", value, common.Hash{3})
+	}
+	// Recreating the account must wipe its storage from the snapshot view
+	state.Suicide(addr)
+	state.Finalise(false)
+	state.CreateAccount(addr)
+	last, _ := state.Commit(false)
+
+	state, _ = NewWithSnapshot(last, sdb, snaps)
+	if value := state.GetState(addr, common.Hash{1}); value != (common.Hash{}) {
+		t.Fatalf("storage of recreated account not wiped: %x", value)
+	}
+	if balance := state.GetStakeBalance(addr); balance.Sign() != 0 {
+		t.Fatalf("stake balance of recreated account: have %v, want 0", balance)
+	}
+}
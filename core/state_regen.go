@@ -0,0 +1,152 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/BerithFoundation/berith-chain/common"
+	"github.com/BerithFoundation/berith-chain/core/state"
+	"github.com/BerithFoundation/berith-chain/core/types"
+	"github.com/BerithFoundation/berith-chain/core/vm"
+	"github.com/BerithFoundation/berith-chain/log"
+)
+
+// regenCacheLimit is the number of regenerated historical states kept around,
+// each of them pinning the trie nodes of its regeneration in memory.
+const regenCacheLimit = 8
+
+// regenChain is the view of the chain the blocks are re-executed against when
+// regenerating historical state. The intermediate states are resolved from the
+// regeneration database and the consensus engine is told that the blocks are
+// replayed, so it does not re-verify them against long pruned states.
+type regenChain struct {
+	*BlockChain
+	database state.Database
+}
+
+// StateAt returns the state of the given root from the regeneration database,
+// which falls back to the persisted tries.
+func (c *regenChain) StateAt(root common.Hash) (*state.StateDB, error) {
+	return state.New(root, c.database)
+}
+
+// Replaying implements consensus.ChainReplayer.
+func (c *regenChain) Replaying() bool {
+	return true
+}
+
+// StateAtHeader returns the state of the given header, regenerating it within
+// the configured re-execution limit if it is no longer available.
+func (bc *BlockChain) StateAtHeader(header *types.Header) (*state.StateDB, error) {
+	if statedb, err := bc.StateAt(header.Root); err == nil {
+		return statedb, nil
+	}
+	block := bc.GetBlock(header.Hash(), header.Number.Uint64())
+	if block == nil {
+		return nil, fmt.Errorf("block #%d not found", header.Number)
+	}
+	return bc.StateAtBlock(block, bc.cacheConfig.StateReexec)
+}
+
+// StateAtBlock returns the state of the given block. If the state is not
+// available, it is regenerated by re-executing at most reexec blocks on top of
+// the nearest available ancestor state. Regenerated states are cached, so that
+// subsequent requests around the same block are cheap.
+func (bc *BlockChain) StateAtBlock(block *types.Block, reexec uint64) (*state.StateDB, error) {
+	// If we have the state fully available, use that
+	if statedb, err := bc.StateAt(block.Root()); err == nil {
+		return statedb, nil
+	}
+	if cached, ok := bc.regenCache.Get(block.Hash()); ok {
+		return cached.(*state.StateDB).Copy(), nil
+	}
+	// Otherwise find the nearest ancestor with a state within the limit, the
+	// blocks in between being the ones to re-execute. The parent hashes are
+	// followed, the block not necessarily being canonical.
+	var (
+		origin   = block.NumberU64()
+		database = state.NewDatabaseWithCache(bc.db, 16)
+		current  = block
+		replay   []*types.Block
+		statedb  *state.StateDB
+		err      error
+	)
+	for i := uint64(0); i < reexec && current.NumberU64() > 0; i++ {
+		replay = append(replay, current)
+		current = bc.GetBlock(current.ParentHash(), current.NumberU64()-1)
+		if current == nil {
+			return nil, fmt.Errorf("block #%d not found", origin-i-1)
+		}
+		if cached, ok := bc.regenCache.Get(current.Hash()); ok {
+			statedb = cached.(*state.StateDB).Copy()
+			database = statedb.Database()
+			break
+		}
+		if statedb, err = state.New(current.Root(), database); err == nil {
+			break
+		}
+		statedb = nil
+	}
+	if statedb == nil {
+		return nil, fmt.Errorf("required historical state unavailable (reexec=%d)", reexec)
+	}
+	// State was available at historical point, regenerate
+	var (
+		chain     = &regenChain{BlockChain: bc, database: database}
+		processor = NewStateProcessor(bc.chainConfig, bc, bc.engine)
+		triedb    = database.TrieDB()
+		start     = time.Now()
+		logged    time.Time
+		parent    common.Hash
+	)
+	for i := len(replay) - 1; i >= 0; i-- {
+		// Print progress logs if long enough time elapsed
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Regenerating historical state", "block", current.NumberU64()+1, "target", origin, "remaining", origin-current.NumberU64()-1, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+		// Process the next block to regenerate
+		current = replay[i]
+		if _, _, _, err := processor.process(chain, current, statedb, vm.Config{}); err != nil {
+			return nil, fmt.Errorf("processing block %d failed: %v", current.NumberU64(), err)
+		}
+		// Finalize the state so any modifications are written to the trie
+		root, err := statedb.Commit(bc.chainConfig.IsEIP158(current.Number()))
+		if err != nil {
+			return nil, err
+		}
+		if root != current.Root() {
+			return nil, fmt.Errorf("regenerated state of block %d mismatch: have %x, want %x", current.NumberU64(), root, current.Root())
+		}
+		if err := statedb.Reset(root); err != nil {
+			return nil, fmt.Errorf("state reset after block %d failed: %v", current.NumberU64(), err)
+		}
+		// Keep the latest state pinned, the next block is executed on top of it
+		triedb.Reference(root, common.Hash{})
+		if parent != (common.Hash{}) {
+			triedb.Dereference(parent)
+		}
+		parent = root
+	}
+	nodes, imgs := triedb.Size()
+	log.Info("Historical state regenerated", "block", origin, "elapsed", common.PrettyDuration(time.Since(start)), "nodes", nodes, "preimages", imgs)
+
+	bc.regenCache.Add(block.Hash(), statedb)
+	return statedb.Copy(), nil
+}
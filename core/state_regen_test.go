@@ -0,0 +1,138 @@
+package core
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/BerithFoundation/berith-chain/berithdb"
+	"github.com/BerithFoundation/berith-chain/common"
+	"github.com/BerithFoundation/berith-chain/consensus"
+	"github.com/BerithFoundation/berith-chain/core/rawdb"
+	"github.com/BerithFoundation/berith-chain/core/state"
+	"github.com/BerithFoundation/berith-chain/core/types"
+	"github.com/BerithFoundation/berith-chain/core/vm"
+	"github.com/BerithFoundation/berith-chain/params"
+)
+
+// regenEngine is a consensus engine crediting the coinbase of every block, and
+// recording whether the blocks were finalized as replayed ones.
+type regenEngine struct {
+	consensus.Engine
+
+	finalized int
+	replayed  int
+}
+
+func (e *regenEngine) CalcDifficulty(consensus.ChainReader, uint64, *types.Header) *big.Int {
+	return big.NewInt(1)
+}
+
+func (e *regenEngine) Finalize(chain consensus.ChainReader, header *types.Header, state *state.StateDB, txs []*types.Transaction, uncles []*types.Header, receipts []*types.Receipt) (*types.Block, error) {
+	e.finalized++
+	if replayer, ok := chain.(consensus.ChainReplayer); ok && replayer.Replaying() {
+		e.replayed++
+	}
+	state.AddBalance(header.Coinbase, common.Big1)
+	header.Root = state.IntermediateRoot(chain.Config().IsEIP158(header.Number))
+	return types.NewBlock(header, txs, uncles, receipts), nil
+}
+
+// newRegenChain creates a chain holding the given canonical and side blocks
+// generated on top of the genesis, of which only the genesis state is kept.
+func newRegenChain(t *testing.T, canonical, side int) (*BlockChain, *regenEngine, []*types.Block, []*types.Block) {
+	var (
+		engine  = new(regenEngine)
+		genesis = &Genesis{Config: params.TestnetChainConfig, GasLimit: 10000000}
+		db      = berithdb.NewMemDatabase()
+		gendb   = berithdb.NewMemDatabase()
+	)
+	genesis.MustCommit(db)
+	parent := genesis.MustCommit(gendb)
+
+	blocks, _ := GenerateChain(params.TestnetChainConfig, parent, engine, gendb, canonical, func(i int, b *BlockGen) {
+		b.SetCoinbase(common.Address{1})
+	})
+	forks, _ := GenerateChain(params.TestnetChainConfig, parent, engine, gendb, side, func(i int, b *BlockGen) {
+		b.SetCoinbase(common.Address{2})
+	})
+	for _, block := range blocks {
+		rawdb.WriteBlock(db, block)
+		rawdb.WriteCanonicalHash(db, block.Hash(), block.NumberU64())
+	}
+	for _, block := range forks {
+		rawdb.WriteBlock(db, block)
+	}
+	rawdb.WriteHeadBlockHash(db, blocks[len(blocks)-1].Hash())
+
+	chain, err := NewBlockChain(nil, db, nil, params.TestnetChainConfig, engine, vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	engine.finalized = 0
+	return chain, engine, blocks, forks
+}
+
+// Tests that the state of a block is regenerated along its own ancestors, even
+// if it is not canonical, and only within the re-execution limit.
+func TestStateAtBlock(t *testing.T) {
+	chain, engine, blocks, forks := newRegenChain(t, 6, 4)
+	defer chain.Stop()
+
+	if _, err := chain.StateAt(forks[3].Root()); err == nil {
+		t.Fatalf("state of block #%d available", forks[3].NumberU64())
+	}
+	if _, err := chain.StateAtBlock(forks[3], 3); err == nil || !strings.Contains(err.Error(), "unavailable") {
+		t.Fatalf("state regenerated beyond the limit: %v", err)
+	}
+	statedb, err := chain.StateAtBlock(forks[3], 4)
+	if err != nil {
+		t.Fatalf("failed to regenerate state: %v", err)
+	}
+	if balance := statedb.GetBalance(common.Address{2}); balance.Cmp(big.NewInt(4)) != 0 {
+		t.Errorf("side chain balance mismatch: have %v, want 4", balance)
+	}
+	if balance := statedb.GetBalance(common.Address{1}); balance.Sign() != 0 {
+		t.Errorf("canonical chain balance mismatch: have %v, want 0", balance)
+	}
+	// The canonical chain is regenerated separately
+	if statedb, err = chain.StateAtBlock(blocks[5], 6); err != nil {
+		t.Fatalf("failed to regenerate state: %v", err)
+	}
+	if balance := statedb.GetBalance(common.Address{1}); balance.Cmp(big.NewInt(6)) != 0 {
+		t.Errorf("canonical chain balance mismatch: have %v, want 6", balance)
+	}
+	if engine.finalized != 4+6 || engine.replayed != engine.finalized {
+		t.Errorf("replayed blocks mismatch: have %d of %d, want %d", engine.replayed, engine.finalized, 4+6)
+	}
+}
+
+// Tests that regenerated states are cached, both to be served again and to
+// regenerate their descendants from.
+func TestStateAtBlockCache(t *testing.T) {
+	chain, engine, blocks, _ := newRegenChain(t, 6, 0)
+	defer chain.Stop()
+
+	if _, err := chain.StateAtBlock(blocks[3], 4); err != nil {
+		t.Fatalf("failed to regenerate state: %v", err)
+	}
+	// The cached state is served without re-execution, as a copy
+	statedb, err := chain.StateAtBlock(blocks[3], 0)
+	if err != nil {
+		t.Fatalf("failed to retrieve cached state: %v", err)
+	}
+	statedb.AddBalance(common.Address{1}, big.NewInt(100))
+	if statedb, _ = chain.StateAtBlock(blocks[3], 0); statedb.GetBalance(common.Address{1}).Cmp(big.NewInt(4)) != 0 {
+		t.Errorf("cached state modified: balance %v, want 4", statedb.GetBalance(common.Address{1}))
+	}
+	// Descendants are regenerated from the cached state
+	if statedb, err = chain.StateAtBlock(blocks[5], 2); err != nil {
+		t.Fatalf("failed to regenerate state from cache: %v", err)
+	}
+	if balance := statedb.GetBalance(common.Address{1}); balance.Cmp(big.NewInt(6)) != 0 {
+		t.Errorf("balance mismatch: have %v, want 6", balance)
+	}
+	if engine.finalized != 4+2 {
+		t.Errorf("executed blocks mismatch: have %d, want %d", engine.finalized, 4+2)
+	}
+}
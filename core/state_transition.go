@@ -83,6 +83,14 @@ type Message interface {
 	Nonce() uint64
 	CheckNonce() bool
 	Data() []byte
+
+	// [BERITH] GasPayer returns the account buying the gas of the message.
+	GasPayer() common.Address
+	// [BERITH] Sponsor returns the sponsor of a sponsored message, or nil.
+	Sponsor() *common.Address
+	// [BERITH] Signers returns the owners that signed a message of a multisig
+	// account, or nil for messages signed by the sender.
+	Signers() []common.Address
 }
 
 // ExecutionResult includes all output after executing given evm
@@ -206,7 +214,7 @@ func (st *StateTransition) useGas(amount uint64) error {
 
 func (st *StateTransition) buyGas() error {
 	mgval := new(big.Int).Mul(new(big.Int).SetUint64(st.msg.Gas()), st.gasPrice)
-	if st.state.GetBalance(st.msg.From()).Cmp(mgval) < 0 {
+	if st.state.GetBalance(st.msg.GasPayer()).Cmp(mgval) < 0 {
 		return errInsufficientBalanceForGas
 	}
 	if err := st.gp.SubGas(st.msg.Gas()); err != nil {
@@ -215,7 +223,7 @@ func (st *StateTransition) buyGas() error {
 	st.gas += st.msg.Gas()
 
 	st.initialGas = st.msg.Gas()
-	st.state.SubBalance(st.msg.From(), mgval)
+	st.state.SubBalance(st.msg.GasPayer(), mgval)
 	return nil
 }
 
@@ -229,6 +237,26 @@ func (st *StateTransition) preCheck() error {
 			return ErrNonceTooLow
 		}
 	}
+	// [BERITH] Sponsored gas is only allowed from BIP6, and never from the
+	// sender itself
+	if sponsor := st.msg.Sponsor(); sponsor != nil {
+		if !st.evm.ChainConfig().IsBIP6(st.evm.BlockNumber) {
+			return ErrSponsoredTx
+		}
+		if *sponsor == st.msg.From() {
+			return ErrInvalidSponsor
+		}
+	}
+	// [BERITH] Multisig accounts are only allowed from BIP7, and their owners
+	// have to reach the threshold of the account
+	if signers := st.msg.Signers(); signers != nil {
+		if !st.evm.ChainConfig().IsBIP7(st.evm.BlockNumber) {
+			return ErrMultisigTx
+		}
+		if err := VerifyMultisig(st.state, st.msg.From(), signers); err != nil {
+			return err
+		}
+	}
 	return st.buyGas()
 }
 
@@ -272,6 +300,11 @@ func (st *StateTransition) TransitionDb(base types.JobWallet, target types.JobWa
 	)
 	if contractCreation {
 		ret, _, st.gas, vmerr = st.evm.Create(sender, st.data, st.gas, st.value)
+	} else if IsMultisigRegistration(st.evm.ChainConfig(), st.evm.BlockNumber, msg) {
+		// [BERITH] Register a multisig account instead of calling the registry
+		nonce := st.state.GetNonce(sender.Address())
+		st.state.SetNonce(msg.From(), nonce+1)
+		vmerr = st.registerMultisig(nonce)
 	} else {
 		// Increment the nonce for the next transaction
 		st.state.SetNonce(msg.From(), st.state.GetNonce(sender.Address())+1)
@@ -300,7 +333,7 @@ func (st *StateTransition) refundGas() {
 
 	// Return BER for remaining gas, exchanged at the original rate.
 	remaining := new(big.Int).Mul(new(big.Int).SetUint64(st.gas), st.gasPrice)
-	st.state.AddBalance(st.msg.From(), remaining)
+	st.state.AddBalance(st.msg.GasPayer(), remaining)
 
 	// Also return remaining gas to the block gas counter so it is
 	// available for the next transaction.
@@ -82,6 +82,30 @@ var (
 	ErrExceedStakeLimit     = errors.New("exceeds stake balance limit")
 	ErrInvalidStakeReceiver = errors.New("berith account only can stake token on itself")
 	ErrMetamaskTx           = errors.New("metamask transaction can be added after BIP5")
+	ErrSponsoredTx          = errors.New("sponsored transaction can be added after BIP6")
+	ErrInvalidSponsor       = errors.New("invalid sponsor")
+
+	// ErrInsufficientSponsorFunds is returned if the sponsor of a transaction
+	// can not pay for the gas of it along with the other transactions it
+	// sponsors in the pool.
+	ErrInsufficientSponsorFunds = errors.New("insufficient sponsor funds for gas * price")
+
+	ErrMultisigTx        = errors.New("multisig transaction can be added after BIP7")
+	ErrUnknownMultisig   = errors.New("unknown multisig account")
+	ErrMultisigNotOwner  = errors.New("multisig signer is not an owner")
+	ErrMultisigThreshold = errors.New("multisig threshold not reached")
+
+	// ErrBatchNonce is returned if the nonces of a sender in a transaction batch
+	// don't follow on from its pending nonce without gaps.
+	ErrBatchNonce = errors.New("batch nonces not consecutive to the pending nonce")
+
+	// ErrBatchReplacement is returned if a transaction batch would replace a
+	// transaction already in the pool.
+	ErrBatchReplacement = errors.New("batch can not replace pooled transactions")
+
+	// ErrBatchPoolFull is returned if a remote transaction batch would overflow
+	// the pool.
+	ErrBatchPoolFull = errors.New("transaction pool can not hold the batch")
 )
 
 var (
@@ -143,6 +167,8 @@ type TxPoolConfig struct {
 	GlobalQueue  uint64 // Maximum number of non-executable transaction slots for all accounts
 
 	Lifetime time.Duration // Maximum amount of time non-executable transaction are queued
+
+	PrivateLifetime uint64 // Number of blocks a private transaction is withheld from public broadcast
 }
 
 // DefaultTxPoolConfig contains the default configurations for the transaction
@@ -160,6 +186,8 @@ var DefaultTxPoolConfig = TxPoolConfig{
 	GlobalQueue:  1024,
 
 	Lifetime: 3 * time.Hour,
+
+	PrivateLifetime: 20,
 }
 
 // sanitize checks the provided user configurations and changes anything that's
@@ -179,6 +207,10 @@ func (config *TxPoolConfig) sanitize() TxPoolConfig {
 		log.Warn("Sanitizing invalid txpool price bump", "provided", conf.PriceBump, "updated", DefaultTxPoolConfig.PriceBump)
 		conf.PriceBump = DefaultTxPoolConfig.PriceBump
 	}
+	if conf.PrivateLifetime < 1 {
+		log.Warn("Sanitizing invalid txpool private lifetime", "provided", conf.PrivateLifetime, "updated", DefaultTxPoolConfig.PrivateLifetime)
+		conf.PrivateLifetime = DefaultTxPoolConfig.PrivateLifetime
+	}
 	return conf
 }
 
@@ -213,6 +245,7 @@ type TxPool struct {
 	beats   map[common.Address]time.Time // Last heartbeat from each known account
 	all     *txLookup                    // All transactions to allow lookups
 	priced  *txPricedList                // All transactions sorted by price
+	private map[common.Hash]uint64       // Private transactions withheld from broadcast, mapped to their submission block
 
 	wg sync.WaitGroup // for shutdown sync
 
@@ -234,10 +267,11 @@ func NewTxPool(config TxPoolConfig, chainconfig *params.ChainConfig, chain block
 		pending:     make(map[common.Address]*txList),
 		queue:       make(map[common.Address]*txList),
 		beats:       make(map[common.Address]time.Time),
-		all:         newTxLookup(),
+		private:     make(map[common.Hash]uint64),
 		chainHeadCh: make(chan ChainHeadEvent, chainHeadChanSize),
 		gasPrice:    new(big.Int).SetUint64(config.PriceLimit),
 	}
+	pool.all = newTxLookup(pool.signer)
 	pool.locals = newAccountSet(pool.signer)
 	for _, addr := range config.Locals {
 		log.Info("Setting new local account", "address", addr)
@@ -430,6 +464,10 @@ func (pool *TxPool) reset(oldHead, newHead *types.Header) {
 	// Pending TxPool을 검증한다. 이는 블록에 포함되었거나 무효화된 모든 TX를 제거한다.
 	pool.demoteUnexecutables()
 
+	// [BERITH]
+	// Drop the sponsored transactions whose sponsor can no longer pay for them
+	pool.dropUnpayableSponsored()
+
 	// Update all accounts to the latest known pending nonce
 	for addr, list := range pool.pending {
 		txs := list.Flatten() // Heavy but will be cached and is needed by the miner anyway
@@ -438,6 +476,34 @@ func (pool *TxPool) reset(oldHead, newHead *types.Header) {
 	// Check the queue and move transactions over to the pending if possible
 	// or remove those that have become invalid
 	pool.promoteExecutables(nil)
+
+	// Release any private transactions that were withheld for too long
+	pool.releasePrivates(newHead.Number.Uint64())
+}
+
+// releasePrivates drops the private mark of every transaction that was submitted
+// more than PrivateLifetime blocks before head, handing the ones still in the
+// pool over to the public broadcast. Marks of transactions that already left the
+// pool are discarded.
+//
+// Note, this method assumes the pool lock is held!
+func (pool *TxPool) releasePrivates(head uint64) {
+	var released types.Transactions
+	for hash, number := range pool.private {
+		tx := pool.all.Get(hash)
+		if tx == nil {
+			delete(pool.private, hash)
+			continue
+		}
+		if head >= number+pool.config.PrivateLifetime {
+			delete(pool.private, hash)
+			released = append(released, tx)
+		}
+	}
+	if len(released) > 0 {
+		log.Debug("Releasing private transactions to public broadcast", "count", len(released))
+		go pool.txFeed.Send(NewTxsEvent{released})
+	}
 }
 
 // Stop terminates the transaction pool.
@@ -645,13 +711,65 @@ func (pool *TxPool) validateTx(tx *types.Transaction, local bool) error {
 	// 	}
 	// }
 
+	// [BERITH]
+	// Multisig transactions have to be signed by enough owners of the account,
+	// and registrations have to define a valid set of owners.
+	isBIP7 := pool.chainconfig.IsBIP7(pool.chain.CurrentBlock().Number())
+	if tx.IsMultisig() {
+		if !isBIP7 {
+			return ErrMultisigTx
+		}
+		signers, err := types.MultisigSigners(pool.signer, tx)
+		if err != nil {
+			return err
+		}
+		if err := VerifyMultisig(pool.currentState, from, signers); err != nil {
+			return err
+		}
+	}
+	if to := tx.To(); to != nil && *to == types.MultisigRegistry {
+		if !isBIP7 {
+			return ErrMultisigTx
+		}
+		if tx.Base() != types.Main || tx.Target() != types.Main {
+			return types.ErrInvalidMultisigConfig
+		}
+		if _, err := DecodeMultisigRegistration(tx.Data()); err != nil {
+			return err
+		}
+	}
+
+	// [BERITH]
+	// The gas of a sponsored transaction is paid by the sponsor, so only the
+	// value is charged to the sender (tx.Cost leaves the gas part out).
+	if tx.IsSponsored() {
+		if !pool.chainconfig.IsBIP6(pool.chain.CurrentBlock().Number()) {
+			return ErrSponsoredTx
+		}
+		sponsor, err := types.SponsorOf(pool.signer, tx)
+		if err != nil || sponsor == from {
+			return ErrInvalidSponsor
+		}
+		// The sponsor pays for all of its pooled transactions, less the one
+		// replaced by this transaction, if any
+		fees := pool.all.Sponsored(sponsor)
+		if old := pool.pooledTx(from, tx.Nonce()); old != nil && old.IsSponsored() {
+			if oldSponsor, err := types.SponsorOf(pool.signer, old); err == nil && oldSponsor == sponsor {
+				fees.Sub(fees, old.MainFee())
+			}
+		}
+		if pool.currentState.GetBalance(sponsor).Cmp(fees.Add(fees, tx.MainFee())) < 0 {
+			return ErrInsufficientSponsorFunds
+		}
+	}
+
 	if tx.Base() == types.Main {
 		if pool.currentState.GetBalance(from).Cmp(tx.Cost()) < 0 {
 			return ErrInsufficientFunds
 		}
 	}
 
-	if tx.Base() == types.Stake {
+	if tx.Base() == types.Stake && !tx.IsSponsored() {
 		balance := pool.currentState.GetBalance(from)
 		cost := tx.MainFee()
 		if balance.Cmp(cost) < 0 {
@@ -892,6 +1010,53 @@ func (pool *TxPool) AddLocal(tx *types.Transaction) error {
 	return pool.addTx(tx, !pool.config.NoLocals) // Local
 }
 
+// AddPrivate enqueues a single local transaction into the pool if it is valid,
+// marking it as private. Private transactions are only handed to the local miner
+// and trusted peers until PrivateLifetime blocks have passed.
+func (pool *TxPool) AddPrivate(tx *types.Transaction) error {
+	return pool.addPrivateTxs([]*types.Transaction{tx}, !pool.config.NoLocals)[0]
+}
+
+// AddPrivateRemotes enqueues a batch of private transactions relayed by trusted
+// peers into the pool if they are valid, keeping them away from the public
+// broadcast in the same way as AddPrivate.
+func (pool *TxPool) AddPrivateRemotes(txs []*types.Transaction) []error {
+	return pool.addPrivateTxs(txs, false)
+}
+
+// addPrivateTxs marks a batch of transactions as private and attempts to queue
+// them if they are valid.
+func (pool *TxPool) addPrivateTxs(txs []*types.Transaction, local bool) []error {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	// Mark the transactions before insertion, the new transaction event is fired
+	// from within the add and subscribers must already see them as private.
+	number := pool.chain.CurrentBlock().NumberU64()
+	for _, tx := range txs {
+		if pool.all.Get(tx.Hash()) == nil {
+			pool.private[tx.Hash()] = number
+		}
+	}
+	errs := pool.addTxsLocked(txs, local)
+	for i, tx := range txs {
+		if errs[i] != nil && pool.all.Get(tx.Hash()) == nil {
+			delete(pool.private, tx.Hash())
+		}
+	}
+	return errs
+}
+
+// IsPrivate reports whether the transaction with the given hash is currently
+// withheld from the public broadcast.
+func (pool *TxPool) IsPrivate(hash common.Hash) bool {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+
+	_, ok := pool.private[hash]
+	return ok
+}
+
 // AddRemote enqueues a single transaction into the pool if it is valid. If the
 // sender is not among the locally tracked ones, full pricing constraints will
 // apply.
@@ -913,6 +1078,87 @@ func (pool *TxPool) AddRemotes(txs []*types.Transaction) []error {
 	return pool.addTxs(txs, false)
 }
 
+// AddLocalBatch enqueues a batch of local transactions into the pool atomically.
+// Either every transaction of the batch is accepted or none of them is, in which
+// case the error of the first failing transaction is returned. The nonces of
+// every sender have to follow on from its pending nonce, and its balance has to
+// cover the whole batch on top of its pending transactions.
+func (pool *TxPool) AddLocalBatch(txs []*types.Transaction) error {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	local := !pool.config.NoLocals
+	if err := pool.validateBatch(txs, local); err != nil {
+		return err
+	}
+	// The batch was checked as a whole, insertion can't fail half way
+	for i, err := range pool.addTxsLocked(txs, local) {
+		if err != nil {
+			log.Error("Failed to insert validated transaction batch", "index", i, "hash", txs[i].Hash(), "err", err)
+			return fmt.Errorf("transaction %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// validateBatch checks that every transaction of the batch is accepted by add
+// without replacing pooled transactions, and that the batch is executable as a
+// whole. Note, this method assumes the pool lock is held!
+func (pool *TxPool) validateBatch(txs []*types.Transaction, local bool) error {
+	// Transactions of the batch may not push others out of the pool, nor be
+	// refused as underpriced for the lack of room
+	if !local && uint64(pool.all.Count()+len(txs)) > pool.config.GlobalSlots+pool.config.GlobalQueue {
+		return ErrBatchPoolFull
+	}
+	var (
+		nonces = make(map[common.Address]uint64)
+		spent  = make(map[common.Address]*big.Int)
+	)
+	for i, tx := range txs {
+		if pool.all.Get(tx.Hash()) != nil {
+			return fmt.Errorf("transaction %d: known transaction: %x", i, tx.Hash())
+		}
+		if err := pool.validateTx(tx, local); err != nil {
+			return fmt.Errorf("transaction %d: %v", i, err)
+		}
+		from, _ := types.Sender(pool.signer, tx) // already validated
+		if _, ok := nonces[from]; !ok {
+			nonces[from] = pool.pendingState.GetNonce(from)
+			spent[from] = new(big.Int)
+			if list := pool.pending[from]; list != nil {
+				for _, pending := range list.Flatten() {
+					spent[from].Add(spent[from], mainCost(pending))
+				}
+			}
+		}
+		for _, list := range []*txList{pool.pending[from], pool.queue[from]} {
+			if list != nil && list.Overlaps(tx) {
+				return fmt.Errorf("transaction %d: %v", i, ErrBatchReplacement)
+			}
+		}
+		if tx.Nonce() != nonces[from] {
+			return fmt.Errorf("transaction %d: %v", i, ErrBatchNonce)
+		}
+		nonces[from]++
+
+		if spent[from].Add(spent[from], mainCost(tx)).Cmp(pool.currentState.GetBalance(from)) > 0 {
+			return fmt.Errorf("transaction %d: %v", i, ErrInsufficientFunds)
+		}
+	}
+	return nil
+}
+
+// mainCost returns the amount charged to the main balance of the sender of tx.
+func mainCost(tx *types.Transaction) *big.Int {
+	switch {
+	case tx.Base() == types.Main:
+		return tx.Cost()
+	case tx.Base() == types.Stake && !tx.IsSponsored():
+		return tx.MainFee()
+	}
+	return new(big.Int)
+}
+
 // addTx enqueues a single transaction into the pool if it is valid.
 func (pool *TxPool) addTx(tx *types.Transaction, local bool) error {
 	pool.mu.Lock()
@@ -992,6 +1238,57 @@ func (pool *TxPool) Get(hash common.Hash) *types.Transaction {
 	return pool.all.Get(hash)
 }
 
+// pooledTx returns the pending or queued transaction of the account with the
+// given nonce, or nil if there is none.
+func (pool *TxPool) pooledTx(addr common.Address, nonce uint64) *types.Transaction {
+	if list := pool.pending[addr]; list != nil {
+		if tx := list.txs.Get(nonce); tx != nil {
+			return tx
+		}
+	}
+	if list := pool.queue[addr]; list != nil {
+		return list.txs.Get(nonce)
+	}
+	return nil
+}
+
+// dropUnpayableSponsored removes sponsored transactions from the pool until
+// every sponsor can pay for the gas of the ones left, starting from the
+// cheapest ones. The subsequent transactions of their senders are moved back
+// to the future queue.
+func (pool *TxPool) dropUnpayableSponsored() {
+	bySponsor := make(map[common.Address]types.Transactions)
+	pool.all.Range(func(hash common.Hash, tx *types.Transaction) bool {
+		if tx.IsSponsored() {
+			if sponsor, err := types.SponsorOf(pool.signer, tx); err == nil {
+				bySponsor[sponsor] = append(bySponsor[sponsor], tx)
+			}
+		}
+		return true
+	})
+	for sponsor, txs := range bySponsor {
+		balance := pool.currentState.GetBalance(sponsor)
+		if pool.all.Sponsored(sponsor).Cmp(balance) <= 0 {
+			continue
+		}
+		sort.Slice(txs, func(i, j int) bool {
+			if cmp := txs[i].GasPrice().Cmp(txs[j].GasPrice()); cmp != 0 {
+				return cmp < 0
+			}
+			return txs[i].Nonce() > txs[j].Nonce()
+		})
+		for _, tx := range txs {
+			if pool.all.Sponsored(sponsor).Cmp(balance) <= 0 {
+				break
+			}
+			hash := tx.Hash()
+			log.Trace("Removed unpayable sponsored transaction", "hash", hash, "sponsor", sponsor)
+			pool.removeTx(hash, true)
+			pendingNofundsCounter.Inc(1)
+		}
+	}
+}
+
 // removeTx removes a single transaction from the queue, moving all subsequent
 // transactions back to the future queue.
 func (pool *TxPool) removeTx(hash common.Hash, outofbound bool) {
@@ -1347,12 +1644,20 @@ func (as *accountSet) flatten() []common.Address {
 type txLookup struct {
 	all  map[common.Hash]*types.Transaction
 	lock sync.RWMutex
+
+	// [BERITH]
+	// The gas of the sponsored transactions is summed up per sponsor, so that a
+	// sponsor can not be made to pay for more than its balance.
+	signer    types.Signer
+	sponsored map[common.Address]*big.Int
 }
 
 // newTxLookup returns a new txLookup structure.
-func newTxLookup() *txLookup {
+func newTxLookup(signer types.Signer) *txLookup {
 	return &txLookup{
-		all: make(map[common.Hash]*types.Transaction),
+		all:       make(map[common.Hash]*types.Transaction),
+		signer:    signer,
+		sponsored: make(map[common.Address]*big.Int),
 	}
 }
 
@@ -1389,7 +1694,11 @@ func (t *txLookup) Add(tx *types.Transaction) {
 	t.lock.Lock()
 	defer t.lock.Unlock()
 
+	if old := t.all[tx.Hash()]; old != nil {
+		t.unsponsor(old)
+	}
 	t.all[tx.Hash()] = tx
+	t.sponsor(tx)
 }
 
 // Remove removes a transaction from the lookup.
@@ -1397,5 +1706,54 @@ func (t *txLookup) Remove(hash common.Hash) {
 	t.lock.Lock()
 	defer t.lock.Unlock()
 
+	if tx := t.all[hash]; tx != nil {
+		t.unsponsor(tx)
+	}
 	delete(t.all, hash)
 }
+
+// Sponsored returns the gas of all the transactions in the lookup sponsored by
+// the given account.
+func (t *txLookup) Sponsored(sponsor common.Address) *big.Int {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	if fees := t.sponsored[sponsor]; fees != nil {
+		return new(big.Int).Set(fees)
+	}
+	return new(big.Int)
+}
+
+// sponsor adds the gas of a sponsored transaction to the fees of its sponsor.
+func (t *txLookup) sponsor(tx *types.Transaction) {
+	if !tx.IsSponsored() {
+		return
+	}
+	sponsor, err := types.SponsorOf(t.signer, tx) // already validated
+	if err != nil {
+		return
+	}
+	fees := t.sponsored[sponsor]
+	if fees == nil {
+		fees = new(big.Int)
+		t.sponsored[sponsor] = fees
+	}
+	fees.Add(fees, tx.MainFee())
+}
+
+// unsponsor removes the gas of a sponsored transaction from the fees of its
+// sponsor.
+func (t *txLookup) unsponsor(tx *types.Transaction) {
+	if !tx.IsSponsored() {
+		return
+	}
+	sponsor, err := types.SponsorOf(t.signer, tx)
+	if err != nil {
+		return
+	}
+	if fees := t.sponsored[sponsor]; fees != nil {
+		if fees.Sub(fees, tx.MainFee()); fees.Sign() <= 0 {
+			delete(t.sponsored, sponsor)
+		}
+	}
+}
@@ -2,15 +2,22 @@ package core
 
 import (
 	"crypto/ecdsa"
+	"io/ioutil"
 	"math/big"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/BerithFoundation/berith-chain/berith/staking"
 	"github.com/BerithFoundation/berith-chain/berithdb"
 	"github.com/BerithFoundation/berith-chain/common/hexutil"
 	"github.com/BerithFoundation/berith-chain/consensus/bsrr"
+	"github.com/BerithFoundation/berith-chain/core/state"
+	"github.com/BerithFoundation/berith-chain/crypto"
 	"github.com/BerithFoundation/berith-chain/crypto/secp256k1"
+	"github.com/BerithFoundation/berith-chain/event"
 	"github.com/BerithFoundation/berith-chain/params"
 
 	"github.com/BerithFoundation/berith-chain/common"
@@ -111,7 +118,7 @@ func TestTransactionValidate(t *testing.T) {
 	SetupGenesisBlockWithOverride(memDB, genesis, big.NewInt(0))
 
 	engine := bsrr.NewCliqueWithStakingDB(stkDB, params.TestnetChainConfig.Bsrr, memDB)
-	chain, err := NewBlockChain(stkDB, memDB, nil, params.TestnetChainConfig, engine, vm.Config{}, nil)
+	chain, err := NewBlockChain(stkDB, memDB, nil, params.TestnetChainConfig, engine, vm.Config{}, nil, nil)
 
 	if err != nil {
 		t.Error(err)
@@ -147,3 +154,254 @@ func TestTransactionValidate(t *testing.T) {
 		}
 	}
 }
+
+// testBlockChain is a minimal chain for the transaction pool, holding a single
+// head block on top of a given state.
+type testBlockChain struct {
+	statedb       *state.StateDB
+	gasLimit      uint64
+	chainHeadFeed *event.Feed
+}
+
+func (bc *testBlockChain) CurrentBlock() *types.Block {
+	return types.NewBlock(&types.Header{Number: new(big.Int), GasLimit: bc.gasLimit}, nil, nil, nil)
+}
+
+func (bc *testBlockChain) GetBlock(hash common.Hash, number uint64) *types.Block {
+	return bc.CurrentBlock()
+}
+
+func (bc *testBlockChain) StateAt(common.Hash) (*state.StateDB, error) {
+	return bc.statedb, nil
+}
+
+func (bc *testBlockChain) SubscribeChainHeadEvent(ch chan<- ChainHeadEvent) event.Subscription {
+	return bc.chainHeadFeed.Subscribe(ch)
+}
+
+// setupTxPool creates a transaction pool over an empty state, journaling the
+// local transactions into journal if it isn't empty.
+func setupTxPool(journal string) (*TxPool, *state.StateDB) {
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(berithdb.NewMemDatabase()))
+	blockchain := &testBlockChain{statedb, 10000000, new(event.Feed)}
+
+	config := DefaultTxPoolConfig
+	config.Journal = journal
+	return NewTxPool(config, params.TestnetChainConfig, blockchain), statedb
+}
+
+func pricedTransaction(nonce uint64, gasprice *big.Int, key *ecdsa.PrivateKey) *types.Transaction {
+	tx, _ := types.SignTx(types.NewTransaction(nonce, common.Address{}, big.NewInt(100), 21000, gasprice, nil, types.Main, types.Main, false), types.NewEIP155Signer(params.TestnetChainConfig.ChainID), key)
+	return tx
+}
+
+// Tests that a transaction batch is either inserted as a whole, or leaves the
+// pool, its subscribers and the journal untouched.
+func TestTransactionBatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "txbatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	journal := filepath.Join(dir, "transactions.rlp")
+
+	pool, statedb := setupTxPool(journal)
+	defer pool.Stop()
+
+	events := make(chan NewTxsEvent, 16)
+	sub := pool.SubscribeNewTxsEvent(events)
+	defer sub.Unsubscribe()
+
+	key, _ := crypto.GenerateKey()
+	other, _ := crypto.GenerateKey()
+	statedb.AddBalance(crypto.PubkeyToAddress(key.PublicKey), big.NewInt(21000*3+299))
+	statedb.AddBalance(crypto.PubkeyToAddress(other.PublicKey), big.NewInt(1000000))
+
+	if err := pool.AddLocal(pricedTransaction(0, big.NewInt(1), key)); err != nil {
+		t.Fatalf("failed to add pending transaction: %v", err)
+	}
+	if err := pool.AddLocal(pricedTransaction(3, big.NewInt(1), key)); err != nil {
+		t.Fatalf("failed to add queued transaction: %v", err)
+	}
+	<-events
+	stat, err := os.Stat(journal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := stat.Size()
+
+	for i, batch := range []struct {
+		txs  []*types.Transaction
+		want error
+	}{
+		// Nonce gaps and replacements
+		{types.Transactions{pricedTransaction(0, big.NewInt(1), other), pricedTransaction(2, big.NewInt(1), key)}, ErrBatchNonce},
+		{types.Transactions{pricedTransaction(0, big.NewInt(1), other), pricedTransaction(0, big.NewInt(2), key)}, ErrBatchReplacement},
+		{types.Transactions{pricedTransaction(1, big.NewInt(1), key), pricedTransaction(3, big.NewInt(2), key)}, ErrBatchReplacement},
+		// Transactions affordable one by one, but not on top of each other
+		{types.Transactions{pricedTransaction(0, big.NewInt(1), other), pricedTransaction(1, big.NewInt(1), key), pricedTransaction(2, big.NewInt(1), key)}, ErrInsufficientFunds},
+	} {
+		err := pool.AddLocalBatch(batch.txs)
+		if err == nil || !strings.Contains(err.Error(), batch.want.Error()) {
+			t.Errorf("batch %d: error mismatch: have %v, want %v", i, err, batch.want)
+		}
+		for _, tx := range batch.txs {
+			if old := pool.Get(tx.Hash()); old != nil && tx.Nonce() != 0 && tx.Nonce() != 3 {
+				t.Errorf("batch %d: transaction %x inserted", i, tx.Hash())
+			}
+		}
+		if pending, queued := pool.Stats(); pending != 1 || queued != 1 {
+			t.Errorf("batch %d: pool changed: pending %d, queued %d", i, pending, queued)
+		}
+	}
+	select {
+	case ev := <-events:
+		t.Fatalf("rejected batch announced: %d transactions", len(ev.Txs))
+	case <-time.After(100 * time.Millisecond):
+	}
+	if stat, err := os.Stat(journal); err != nil || stat.Size() != size {
+		t.Fatalf("rejected batch journaled: have size %d, want %d (%v)", stat.Size(), size, err)
+	}
+
+	// An affordable batch is accepted as a whole
+	batch := types.Transactions{pricedTransaction(0, big.NewInt(1), other), pricedTransaction(1, big.NewInt(1), key)}
+	if err := pool.AddLocalBatch(batch); err != nil {
+		t.Fatalf("failed to add batch: %v", err)
+	}
+	if pending, queued := pool.Stats(); pending != 3 || queued != 1 {
+		t.Fatalf("pool mismatch: pending %d, want 3, queued %d, want 1", pending, queued)
+	}
+}
+
+// Tests that private transactions are announced marked as such, and released to
+// the public broadcast once PrivateLifetime blocks have passed.
+func TestPrivateTransactions(t *testing.T) {
+	pool, statedb := setupTxPool("")
+	defer pool.Stop()
+
+	events := make(chan NewTxsEvent, 16)
+	sub := pool.SubscribeNewTxsEvent(events)
+	defer sub.Unsubscribe()
+
+	key, _ := crypto.GenerateKey()
+	other, _ := crypto.GenerateKey()
+	statedb.AddBalance(crypto.PubkeyToAddress(key.PublicKey), big.NewInt(1000000))
+	statedb.AddBalance(crypto.PubkeyToAddress(other.PublicKey), big.NewInt(1000000))
+
+	// Subscribers see the transaction as private when it is announced
+	tx := pricedTransaction(0, big.NewInt(1), key)
+	if err := pool.AddPrivate(tx); err != nil {
+		t.Fatalf("failed to add private transaction: %v", err)
+	}
+	select {
+	case ev := <-events:
+		if len(ev.Txs) != 1 || ev.Txs[0].Hash() != tx.Hash() {
+			t.Fatalf("announced transactions mismatch: %v", ev.Txs)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("private transaction not announced")
+	}
+	if !pool.IsPrivate(tx.Hash()) {
+		t.Fatalf("transaction not private")
+	}
+	// Rejected transactions are not marked
+	unfunded, _ := crypto.GenerateKey()
+	rejected := pricedTransaction(0, big.NewInt(1), unfunded)
+	if err := pool.AddPrivate(rejected); err == nil {
+		t.Fatalf("unfunded transaction accepted")
+	}
+	if pool.IsPrivate(rejected.Hash()) {
+		t.Fatalf("rejected transaction marked private")
+	}
+	// Marks of the transactions leaving the pool are discarded
+	dropped := pricedTransaction(0, big.NewInt(1), other)
+	if err := pool.AddPrivate(dropped); err != nil {
+		t.Fatalf("failed to add private transaction: %v", err)
+	}
+	<-events
+
+	pool.mu.Lock()
+	pool.removeTx(dropped.Hash(), true)
+	pool.releasePrivates(pool.config.PrivateLifetime - 1)
+	_, marked := pool.private[dropped.Hash()]
+	pool.mu.Unlock()
+
+	if marked {
+		t.Fatalf("mark of dropped transaction kept")
+	}
+	if !pool.IsPrivate(tx.Hash()) {
+		t.Fatalf("transaction released before its lifetime")
+	}
+	select {
+	case ev := <-events:
+		t.Fatalf("transactions released before their lifetime: %v", ev.Txs)
+	case <-time.After(100 * time.Millisecond):
+	}
+	// Once the lifetime passed, the transaction is announced again as public
+	pool.mu.Lock()
+	pool.releasePrivates(pool.config.PrivateLifetime)
+	pool.mu.Unlock()
+
+	if pool.IsPrivate(tx.Hash()) {
+		t.Fatalf("transaction not released")
+	}
+	select {
+	case ev := <-events:
+		if len(ev.Txs) != 1 || ev.Txs[0].Hash() != tx.Hash() {
+			t.Fatalf("released transactions mismatch: %v", ev.Txs)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("released transaction not announced")
+	}
+}
+
+// Tests that a sponsor has to pay for all the transactions it sponsors in the
+// pool, and that the ones it can no longer pay for are dropped on reset.
+func TestSponsoredTransactionFunds(t *testing.T) {
+	config := *params.TestnetChainConfig
+	config.BIP6Block = big.NewInt(0)
+	signer := types.NewEIP155Signer(config.ChainID)
+
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(berithdb.NewMemDatabase()))
+	pool := NewTxPool(DefaultTxPoolConfig, &config, &testBlockChain{statedb, 10000000, new(event.Feed)})
+	defer pool.Stop()
+
+	// The sponsor can pay for two of the three transactions
+	sponsorKey, _ := crypto.GenerateKey()
+	sponsor := crypto.PubkeyToAddress(sponsorKey.PublicKey)
+	statedb.AddBalance(sponsor, big.NewInt(2*21000))
+
+	txs := make([]*types.Transaction, 3)
+	for i := range txs {
+		key, _ := crypto.GenerateKey()
+		statedb.AddBalance(crypto.PubkeyToAddress(key.PublicKey), big.NewInt(1000))
+		txs[i], _ = signSponsoredTx(t, signer, key, sponsorKey, common.Big1)
+	}
+	for i, tx := range txs[:2] {
+		if err := pool.AddRemote(tx); err != nil {
+			t.Fatalf("failed to add sponsored transaction %d: %v", i, err)
+		}
+	}
+	if err := pool.AddRemote(txs[2]); err != ErrInsufficientSponsorFunds {
+		t.Fatalf("overspending sponsorship error mismatch: have %v, want %v", err, ErrInsufficientSponsorFunds)
+	}
+	if fees := pool.all.Sponsored(sponsor); fees.Cmp(big.NewInt(2*21000)) != 0 {
+		t.Fatalf("sponsored fees mismatch: have %v, want %v", fees, 2*21000)
+	}
+	// Once the sponsor spent some of its balance, the exceeding transaction is dropped
+	statedb.SubBalance(sponsor, big.NewInt(21000))
+
+	pool.mu.Lock()
+	pool.reset(nil, nil)
+	pool.mu.Unlock()
+
+	if pending, queued := pool.Stats(); pending != 1 || queued != 0 {
+		t.Fatalf("pool mismatch: pending %d, want 1, queued %d, want 0", pending, queued)
+	}
+	if fees := pool.all.Sponsored(sponsor); fees.Cmp(big.NewInt(21000)) != 0 {
+		t.Fatalf("sponsored fees mismatch: have %v, want %v", fees, 21000)
+	}
+	if err := pool.AddRemote(txs[2]); err != ErrInsufficientSponsorFunds {
+		t.Fatalf("overspending sponsorship error mismatch: have %v, want %v", err, ErrInsufficientSponsorFunds)
+	}
+}
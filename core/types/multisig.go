@@ -0,0 +1,193 @@
+package types
+
+import (
+	"errors"
+	"math/big"
+
+	"github.com/BerithFoundation/berith-chain/common"
+	"github.com/BerithFoundation/berith-chain/common/hexutil"
+)
+
+//go:generate gencodec -type Cosignature -field-override cosignatureMarshaling -out gen_cosignature_json.go
+
+// MaxMultisigOwners is the maximum number of owners of a multisig account.
+const MaxMultisigOwners = 16
+
+// MultisigRegistry is the address that multisig registration transactions are
+// sent to. The payload of a registration is the RLP encoded MultisigConfig.
+var MultisigRegistry = common.HexToAddress("0x000000000000000000000000000000000000b701")
+
+// MultisigThresholdSlot is the storage slot holding the threshold of a multisig
+// account. It is zero in the storage of any other account without code.
+var MultisigThresholdSlot = common.Hash{}
+
+var (
+	// ErrInvalidMultisig is returned if the signatures of a multisig
+	// transaction are malformed or signed twice by the same key.
+	ErrInvalidMultisig = errors.New("invalid multisig signatures")
+
+	// ErrInvalidMultisigConfig is returned if a multisig registration does not
+	// define a usable set of owners and threshold.
+	ErrInvalidMultisigConfig = errors.New("invalid multisig config")
+)
+
+// [BERITH]
+// MultisigConfig defines the owners of a multisig account and the number of
+// them that have to sign a transaction of the account.
+type MultisigConfig struct {
+	Owners    []common.Address `json:"owners"`
+	Threshold uint64           `json:"threshold"`
+}
+
+// Validate checks that the threshold can be reached by distinct owners.
+func (c *MultisigConfig) Validate() error {
+	if len(c.Owners) == 0 || len(c.Owners) > MaxMultisigOwners {
+		return ErrInvalidMultisigConfig
+	}
+	if c.Threshold == 0 || c.Threshold > uint64(len(c.Owners)) {
+		return ErrInvalidMultisigConfig
+	}
+	seen := make(map[common.Address]struct{}, len(c.Owners))
+	for _, owner := range c.Owners {
+		if _, ok := seen[owner]; ok || owner == (common.Address{}) {
+			return ErrInvalidMultisigConfig
+		}
+		seen[owner] = struct{}{}
+	}
+	return nil
+}
+
+// [BERITH]
+// MultisigAuth authorizes a transaction on behalf of a multisig account. The
+// signature of the first owner is kept in the V, R, S fields of the
+// transaction, the signatures of the other owners are carried here.
+type MultisigAuth struct {
+	Account    common.Address `json:"account"    gencodec:"required"`
+	Signatures []*Cosignature `json:"signatures" gencodec:"required"`
+}
+
+// Cosignature is the signature of an additional owner of a multisig account.
+type Cosignature struct {
+	V *big.Int `json:"v" gencodec:"required"`
+	R *big.Int `json:"r" gencodec:"required"`
+	S *big.Int `json:"s" gencodec:"required"`
+}
+
+type cosignatureMarshaling struct {
+	V *hexutil.Big
+	R *hexutil.Big
+	S *hexutil.Big
+}
+
+// Multisig returns the multisig account the transaction is sent from, or nil
+// if the transaction is signed by a single key.
+func (tx *Transaction) Multisig() *common.Address {
+	if !tx.IsMultisig() {
+		return nil
+	}
+	account := tx.ext().Multisig.Account
+	return &account
+}
+
+// IsMultisig reports whether the transaction is sent from a multisig account.
+func (tx *Transaction) IsMultisig() bool {
+	ext := tx.ext()
+	return ext != nil && ext.Multisig != nil
+}
+
+// WithMultisig returns an unsigned copy of the transaction sent from the given
+// multisig account. The first owner signs the returned transaction with SignTx,
+// the others add their signatures with WithCosignature. The signature of a
+// sponsor is dropped, since it commits to the account.
+func (tx *Transaction) WithMultisig(account common.Address) *Transaction {
+	return tx.withExt(func(ext *txExtension) {
+		ext.Multisig = &MultisigAuth{Account: account}
+		if ext.Sponsorship != nil {
+			ext.Sponsorship = &Sponsorship{
+				Sponsor: ext.Sponsorship.Sponsor,
+				V:       new(big.Int),
+				R:       new(big.Int),
+				S:       new(big.Int),
+			}
+		}
+	})
+}
+
+// WithCosignature returns a new transaction with the signature of another owner
+// of the multisig account appended. The signature has to be made over
+// signer.Hash(tx).
+func (tx *Transaction) WithCosignature(signer Signer, sig []byte) (*Transaction, error) {
+	if !tx.IsMultisig() {
+		return nil, ErrInvalidMultisig
+	}
+	r, s, v, err := signer.SignatureValues(tx, sig)
+	if err != nil {
+		return nil, err
+	}
+	ext := *tx.ext()
+	auth := *ext.Multisig
+	auth.Signatures = append(append([]*Cosignature{}, auth.Signatures...), &Cosignature{V: v, R: r, S: s})
+	ext.Multisig = &auth
+
+	cpy := &Transaction{data: tx.data, IsEthTx: tx.IsEthTx}
+	cpy.data.Ext = []*txExtension{&ext}
+	return cpy, nil
+}
+
+// MultisigSigners returns the keys that signed a multisig transaction, starting
+// with the signer of the transaction itself. Whether they are owners of the
+// account and reach its threshold can only be decided against the state.
+func MultisigSigners(signer Signer, tx *Transaction) ([]common.Address, error) {
+	if !tx.IsMultisig() {
+		return nil, ErrInvalidMultisig
+	}
+	if sc := tx.signers.Load(); sc != nil {
+		cache := sc.(signersCache)
+		if cache.signer.Equal(signer) {
+			return cache.signers, nil
+		}
+	}
+	first, err := signer.Sender(tx)
+	if err != nil {
+		return nil, err
+	}
+	auth := tx.ext().Multisig
+	if len(auth.Signatures) >= MaxMultisigOwners {
+		return nil, ErrInvalidMultisig
+	}
+	signers := []common.Address{first}
+	seen := map[common.Address]struct{}{first: {}}
+	for _, cosig := range auth.Signatures {
+		// The co-signatures are made over the same signing hash as the sender
+		// signature, recover them from a copy carrying them in its place.
+		cpy := &Transaction{data: tx.data}
+		cpy.data.V, cpy.data.R, cpy.data.S = cosig.V, cosig.R, cosig.S
+		addr, err := signer.Sender(cpy)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := seen[addr]; ok {
+			return nil, ErrInvalidMultisig
+		}
+		seen[addr] = struct{}{}
+		signers = append(signers, addr)
+	}
+	tx.signers.Store(signersCache{signer: signer, signers: signers})
+	return signers, nil
+}
+
+type signersCache struct {
+	signer  Signer
+	signers []common.Address
+}
+
+// ContainMultisigTx reports whether any of the transactions is sent from a
+// multisig account.
+func (s Transactions) ContainMultisigTx() bool {
+	for _, tx := range s {
+		if tx.IsMultisig() {
+			return true
+		}
+	}
+	return false
+}
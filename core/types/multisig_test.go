@@ -0,0 +1,98 @@
+package types
+
+import (
+	"crypto/ecdsa"
+	"testing"
+
+	"github.com/BerithFoundation/berith-chain/common"
+	"github.com/BerithFoundation/berith-chain/crypto"
+	"github.com/BerithFoundation/berith-chain/rlp"
+)
+
+func cosign(t *testing.T, signer Signer, tx *Transaction, key *ecdsa.PrivateKey) *Transaction {
+	sig, err := crypto.Sign(signer.Hash(tx).Bytes(), key)
+	if err != nil {
+		t.Fatalf("could not cosign: %v", err)
+	}
+	tx, err = tx.WithCosignature(signer, sig)
+	if err != nil {
+		t.Fatalf("could not attach cosignature: %v", err)
+	}
+	return tx
+}
+
+func TestMultisigTransaction(t *testing.T) {
+	signer := NewEIP155Signer(common.Big1)
+	key1, _ := crypto.GenerateKey()
+	key2, _ := crypto.GenerateKey()
+	account := common.Address{0xaa}
+
+	tx := NewTransaction(0, account, common.Big1, 21000, common.Big1, nil, Main, Stake, false).WithMultisig(account)
+	tx, err := SignTx(tx, signer, key1)
+	if err != nil {
+		t.Fatalf("could not sign transaction: %v", err)
+	}
+	tx = cosign(t, signer, tx, key2)
+
+	enc, err := rlp.EncodeToBytes(tx)
+	if err != nil {
+		t.Fatalf("encode error: %v", err)
+	}
+	var dec Transaction
+	if err := rlp.DecodeBytes(enc, &dec); err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if from, err := Sender(signer, &dec); err != nil || from != account {
+		t.Fatalf("sender mismatch: have %x (%v), want %x", from, err, account)
+	}
+	signers, err := MultisigSigners(signer, &dec)
+	if err != nil {
+		t.Fatalf("could not recover signers: %v", err)
+	}
+	want := []common.Address{crypto.PubkeyToAddress(key1.PublicKey), crypto.PubkeyToAddress(key2.PublicKey)}
+	if len(signers) != len(want) || signers[0] != want[0] || signers[1] != want[1] {
+		t.Fatalf("signers mismatch: have %x, want %x", signers, want)
+	}
+	msg, err := dec.AsMessage(signer)
+	if err != nil {
+		t.Fatalf("could not convert to message: %v", err)
+	}
+	if msg.From() != account || len(msg.Signers()) != 2 {
+		t.Errorf("message mismatch: from %x, %d signers", msg.From(), len(msg.Signers()))
+	}
+}
+
+func TestMultisigTransactionDuplicateSigner(t *testing.T) {
+	signer := NewEIP155Signer(common.Big1)
+	key, _ := crypto.GenerateKey()
+
+	tx := NewTransaction(0, common.Address{1}, common.Big1, 21000, common.Big1, nil, Main, Main, false).WithMultisig(common.Address{0xaa})
+	tx, err := SignTx(tx, signer, key)
+	if err != nil {
+		t.Fatalf("could not sign transaction: %v", err)
+	}
+	tx = cosign(t, signer, tx, key)
+	if _, err := Sender(signer, tx); err != ErrInvalidMultisig {
+		t.Fatalf("duplicate signer error mismatch: have %v, want %v", err, ErrInvalidMultisig)
+	}
+}
+
+func TestMultisigConfigValidate(t *testing.T) {
+	a, b := common.Address{1}, common.Address{2}
+	tests := []struct {
+		config MultisigConfig
+		valid  bool
+	}{
+		{MultisigConfig{Owners: []common.Address{a, b}, Threshold: 2}, true},
+		{MultisigConfig{Owners: []common.Address{a, b}, Threshold: 3}, false},
+		{MultisigConfig{Owners: []common.Address{a, b}, Threshold: 0}, false},
+		{MultisigConfig{Owners: []common.Address{a, a}, Threshold: 1}, false},
+		{MultisigConfig{Owners: []common.Address{{}}, Threshold: 1}, false},
+		{MultisigConfig{Threshold: 1}, false},
+	}
+	for i, tt := range tests {
+		if err := tt.config.Validate(); (err == nil) != tt.valid {
+			t.Errorf("test %d: validity mismatch: have %v, want %v", i, err, tt.valid)
+		}
+	}
+}
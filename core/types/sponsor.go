@@ -0,0 +1,155 @@
+package types
+
+import (
+	"errors"
+	"math/big"
+
+	"github.com/BerithFoundation/berith-chain/common"
+	"github.com/BerithFoundation/berith-chain/common/hexutil"
+)
+
+//go:generate gencodec -type Sponsorship -field-override sponsorshipMarshaling -out gen_sponsorship_json.go
+
+var (
+	// ErrInvalidSponsor is returned if the sponsor signature of a transaction
+	// does not match the declared sponsor.
+	ErrInvalidSponsor = errors.New("invalid sponsor")
+
+	// ErrInvalidExtension is returned if a transaction carries a malformed
+	// extension tail.
+	ErrInvalidExtension = errors.New("invalid transaction extension")
+)
+
+// sponsorshipTag separates the signing hash of a sponsor from the one of a sender.
+var sponsorshipTag = []byte("berith-sponsorship")
+
+// [BERITH]
+// Sponsorship names the account paying the gas of a transaction together with
+// its signature over SponsorHash.
+type Sponsorship struct {
+	Sponsor common.Address `json:"sponsor" gencodec:"required"`
+	V       *big.Int       `json:"v"       gencodec:"required"`
+	R       *big.Int       `json:"r"       gencodec:"required"`
+	S       *big.Int       `json:"s"       gencodec:"required"`
+}
+
+type sponsorshipMarshaling struct {
+	V *hexutil.Big
+	R *hexutil.Big
+	S *hexutil.Big
+}
+
+// Sponsor returns the declared gas sponsor of the transaction, or nil if the
+// transaction is paid by its sender.
+func (tx *Transaction) Sponsor() *common.Address {
+	if !tx.IsSponsored() {
+		return nil
+	}
+	sponsor := tx.ext().Sponsorship.Sponsor
+	return &sponsor
+}
+
+// IsSponsored reports whether the gas of the transaction is paid by a sponsor.
+func (tx *Transaction) IsSponsored() bool {
+	ext := tx.ext()
+	return ext != nil && ext.Sponsorship != nil
+}
+
+// WithSponsor returns an unsigned copy of the transaction naming sponsor as the
+// gas payer. The sender has to sign the returned transaction, after which the
+// sponsor adds its own signature with WithSponsorSignature. Co-signatures of a
+// multisig transaction are dropped as well, since they commit to the sponsor.
+func (tx *Transaction) WithSponsor(sponsor common.Address) *Transaction {
+	return tx.withExt(func(ext *txExtension) {
+		ext.Sponsorship = &Sponsorship{
+			Sponsor: sponsor,
+			V:       new(big.Int),
+			R:       new(big.Int),
+			S:       new(big.Int),
+		}
+		if ext.Multisig != nil {
+			ext.Multisig = &MultisigAuth{Account: ext.Multisig.Account}
+		}
+	})
+}
+
+// SponsorHash returns the hash to be signed by the sponsor of a transaction. It
+// is separated from the sender signing hash and commits to the sender, so that a
+// sponsor signature can never pass as the signature of a sender.
+func SponsorHash(signer Signer, tx *Transaction) (common.Hash, error) {
+	from, err := Sender(signer, tx)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return rlpHash([]interface{}{sponsorshipTag, signer.Hash(tx), from}), nil
+}
+
+// WithSponsorSignature returns a new transaction with the given sponsor
+// signature. The signature has to be made over SponsorHash.
+func (tx *Transaction) WithSponsorSignature(signer Signer, sig []byte) (*Transaction, error) {
+	if !tx.IsSponsored() {
+		return nil, ErrInvalidSponsor
+	}
+	r, s, v, err := signer.SignatureValues(tx, sig)
+	if err != nil {
+		return nil, err
+	}
+	ext := *tx.ext()
+	ext.Sponsorship = &Sponsorship{
+		Sponsor: ext.Sponsorship.Sponsor,
+		V:       v,
+		R:       r,
+		S:       s,
+	}
+	cpy := &Transaction{data: tx.data, IsEthTx: tx.IsEthTx}
+	cpy.data.Ext = []*txExtension{&ext}
+	return cpy, nil
+}
+
+// SponsorOf returns the sponsor of the transaction after verifying that the
+// sponsor signature was made by the declared sponsor. The result is cached
+// in the same way as the sender.
+func SponsorOf(signer Signer, tx *Transaction) (common.Address, error) {
+	if !tx.IsSponsored() {
+		return common.Address{}, ErrInvalidSponsor
+	}
+	if sc := tx.sponsor.Load(); sc != nil {
+		sigCache := sc.(sigCache)
+		if sigCache.signer.Equal(signer) {
+			return sigCache.from, nil
+		}
+	}
+	sp := tx.ext().Sponsorship
+
+	hash, err := SponsorHash(signer, tx)
+	if err != nil {
+		return common.Address{}, err
+	}
+	// Signature values of replay protected signers carry the chain id
+	v := sp.V
+	switch s := signer.(type) {
+	case EIP155Signer:
+		v = s.plainV(v)
+	case eip2930Signer:
+		v = s.plainV(v)
+	}
+	addr, err := recoverPlain(hash, sp.R, sp.S, v, true)
+	if err != nil {
+		return common.Address{}, err
+	}
+	if addr != sp.Sponsor {
+		return common.Address{}, ErrInvalidSponsor
+	}
+	tx.sponsor.Store(sigCache{signer: signer, from: addr})
+	return addr, nil
+}
+
+// ContainSponsoredTx reports whether any of the transactions is sponsored.
+func (s Transactions) ContainSponsoredTx() bool {
+	for _, tx := range s {
+		if tx.IsSponsored() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/BerithFoundation/berith-chain/common"
+	"github.com/BerithFoundation/berith-chain/crypto"
+	"github.com/BerithFoundation/berith-chain/rlp"
+)
+
+func signSponsored(t *testing.T, signer Signer, nonce uint64) (*Transaction, common.Address, common.Address) {
+	senderKey, _ := crypto.GenerateKey()
+	sponsorKey, _ := crypto.GenerateKey()
+	sponsor := crypto.PubkeyToAddress(sponsorKey.PublicKey)
+
+	tx := NewTransaction(nonce, common.Address{1}, common.Big1, 21000, common.Big2, nil, Main, Main, false)
+	tx, err := SignTx(tx.WithSponsor(sponsor), signer, senderKey)
+	if err != nil {
+		t.Fatalf("could not sign transaction: %v", err)
+	}
+	hash, err := SponsorHash(signer, tx)
+	if err != nil {
+		t.Fatalf("could not hash sponsorship: %v", err)
+	}
+	sig, err := crypto.Sign(hash.Bytes(), sponsorKey)
+	if err != nil {
+		t.Fatalf("could not sign sponsorship: %v", err)
+	}
+	if tx, err = tx.WithSponsorSignature(signer, sig); err != nil {
+		t.Fatalf("could not attach sponsorship: %v", err)
+	}
+	return tx, crypto.PubkeyToAddress(senderKey.PublicKey), sponsor
+}
+
+func TestSponsoredTransaction(t *testing.T) {
+	signer := NewEIP155Signer(common.Big1)
+	tx, sender, sponsor := signSponsored(t, signer, 0)
+
+	enc, err := rlp.EncodeToBytes(tx)
+	if err != nil {
+		t.Fatalf("encode error: %v", err)
+	}
+	var dec Transaction
+	if err := rlp.DecodeBytes(enc, &dec); err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if dec.Hash() != tx.Hash() {
+		t.Fatalf("hash mismatch: have %x, want %x", dec.Hash(), tx.Hash())
+	}
+	if from, err := Sender(signer, &dec); err != nil || from != sender {
+		t.Fatalf("sender mismatch: have %x (%v), want %x", from, err, sender)
+	}
+	if have, err := SponsorOf(signer, &dec); err != nil || have != sponsor {
+		t.Fatalf("sponsor mismatch: have %x (%v), want %x", have, err, sponsor)
+	}
+	if dec.Cost().Cmp(common.Big1) != 0 {
+		t.Errorf("sponsored cost mismatch: have %v, want %v", dec.Cost(), common.Big1)
+	}
+	msg, err := dec.AsMessage(signer)
+	if err != nil {
+		t.Fatalf("could not convert to message: %v", err)
+	}
+	if msg.GasPayer() != sponsor {
+		t.Errorf("gas payer mismatch: have %x, want %x", msg.GasPayer(), sponsor)
+	}
+}
+
+func TestSponsoredTransactionReplacedSponsor(t *testing.T) {
+	signer := NewEIP155Signer(common.Big1)
+	tx, _, _ := signSponsored(t, signer, 0)
+
+	// A sponsor signature made by another account must not be accepted, even
+	// when the declared sponsor is swapped to match it.
+	otherKey, _ := crypto.GenerateKey()
+	forged := tx.WithSponsor(crypto.PubkeyToAddress(otherKey.PublicKey))
+	forged.data.V, forged.data.R, forged.data.S = tx.RawSignatureValues()
+	hash, _ := SponsorHash(signer, forged)
+	sig, _ := crypto.Sign(hash.Bytes(), otherKey)
+	forged, err := forged.WithSponsorSignature(signer, sig)
+	if err != nil {
+		t.Fatalf("could not attach sponsorship: %v", err)
+	}
+	if _, err := SponsorOf(signer, forged); err != nil {
+		t.Fatalf("sponsor signature rejected: %v", err)
+	}
+	if from, _ := Sender(signer, forged); from == mustSender(t, signer, tx) {
+		t.Errorf("sender signature valid for a different sponsor")
+	}
+}
+
+// Tests that a sponsor signature is no valid sender signature, so that it can
+// not be replayed to spend from the sponsor.
+func TestSponsorSignatureNotSenderSignature(t *testing.T) {
+	signer := NewEIP155Signer(common.Big1)
+	tx, _, sponsor := signSponsored(t, signer, 0)
+
+	replayed := &Transaction{data: tx.data}
+	sp := tx.ext().Sponsorship
+	replayed.data.V, replayed.data.R, replayed.data.S = sp.V, sp.R, sp.S
+	if from, err := Sender(signer, replayed); err == nil && from == sponsor {
+		t.Fatalf("sponsor signature recovered as sender signature")
+	}
+}
+
+func mustSender(t *testing.T, signer Signer, tx *Transaction) common.Address {
+	from, err := Sender(signer, tx)
+	if err != nil {
+		t.Fatalf("could not recover sender: %v", err)
+	}
+	return from
+}
@@ -38,9 +38,11 @@ var (
 type Transaction struct {
 	data txdata
 	// caches
-	hash atomic.Value
-	size atomic.Value
-	from atomic.Value
+	hash    atomic.Value
+	size    atomic.Value
+	from    atomic.Value
+	sponsor atomic.Value
+	signers atomic.Value
 	// [Berith]
 	// To identify transaction sent from metamask
 	IsEthTx bool
@@ -64,6 +66,55 @@ type txdata struct {
 
 	// This is only used when marshaling to JSON.
 	Hash *common.Hash `json:"hash" rlp:"-"`
+
+	// [Berith] Optional extension of the transaction, appended as a list tail
+	// so that the encoding of ordinary transactions is unaffected.
+	Ext []*txExtension `json:"ext,omitempty" rlp:"tail"`
+}
+
+// [BERITH]
+// txExtension holds the optional parts of a transaction. Unset parts are
+// encoded as empty lists.
+type txExtension struct {
+	Sponsorship *Sponsorship  `json:"sponsorship,omitempty" rlp:"nil"`
+	Multisig    *MultisigAuth `json:"multisig,omitempty"    rlp:"nil"`
+}
+
+// validExtension reports whether the decoded extension tail is canonical: a
+// single extension with at least one part set.
+func validExtension(ext []*txExtension) bool {
+	switch len(ext) {
+	case 0:
+		return true
+	case 1:
+		return ext[0].Sponsorship != nil || ext[0].Multisig != nil
+	default:
+		return false
+	}
+}
+
+// ext returns the extension of the transaction, or nil if it has none.
+func (tx *Transaction) ext() *txExtension {
+	if len(tx.data.Ext) == 0 {
+		return nil
+	}
+	return tx.data.Ext[0]
+}
+
+// withExt returns a copy of the transaction carrying the extension returned by
+// update, which is given a copy of the current one. The signature values of the
+// copy are reset, as every signature commits to the extension.
+func (tx *Transaction) withExt(update func(ext *txExtension)) *Transaction {
+	ext := new(txExtension)
+	if cur := tx.ext(); cur != nil {
+		*ext = *cur
+	}
+	update(ext)
+
+	cpy := &Transaction{data: tx.data, IsEthTx: tx.IsEthTx}
+	cpy.data.V, cpy.data.R, cpy.data.S = new(big.Int), new(big.Int), new(big.Int)
+	cpy.data.Ext = []*txExtension{ext}
+	return cpy
 }
 
 type txdataMarshaling struct {
@@ -152,6 +203,9 @@ func (tx *Transaction) DecodeRLP(s *rlp.Stream) error {
 	if err == nil {
 		tx.size.Store(common.StorageSize(rlp.ListSize(size)))
 	}
+	if err == nil && !validExtension(tx.data.Ext) {
+		err = ErrInvalidExtension
+	}
 	// [Berith]
 	// JobWallet 타입에 세 번째 타입인 EthTx를 추가하여 txdata만을 디코딩 하여도 구분 할 수 있도록 처리함
 	if tx.data.Base == EthTx || tx.data.Target == EthTx {
@@ -267,7 +321,20 @@ func (tx *Transaction) AsMessage(s Signer) (Message, error) {
 
 	var err error
 	msg.from, err = Sender(s, tx)
-	return msg, err
+	if err != nil {
+		return msg, err
+	}
+	if tx.IsMultisig() {
+		if msg.signers, err = MultisigSigners(s, tx); err != nil {
+			return msg, err
+		}
+	}
+	if tx.IsSponsored() {
+		sponsor, err := SponsorOf(s, tx)
+		msg.sponsor = &sponsor
+		return msg, err
+	}
+	return msg, nil
 }
 
 func (tx *Transaction) ChangeBaseTarget(base, target JobWallet) {
@@ -287,8 +354,12 @@ func (tx *Transaction) WithSignature(signer Signer, sig []byte) (*Transaction, e
 	return cpy, nil
 }
 
-// Cost returns amount + gasprice * gaslimit.
+// Cost returns amount + gasprice * gaslimit. The gas part is left out for
+// sponsored transactions, as it is paid by the sponsor instead of the sender.
 func (tx *Transaction) Cost() *big.Int {
+	if tx.IsSponsored() {
+		return new(big.Int).Set(tx.data.Amount)
+	}
 	total := new(big.Int).Mul(tx.data.Price, new(big.Int).SetUint64(tx.data.GasLimit))
 	total.Add(total, tx.data.Amount)
 	return total
@@ -463,6 +534,8 @@ type Message struct {
 	checkNonce bool
 	base       JobWallet
 	target     JobWallet
+	sponsor    *common.Address
+	signers    []common.Address
 }
 
 func NewMessage(from common.Address, to *common.Address, nonce uint64, amount *big.Int, gasLimit uint64, gasPrice *big.Int, data []byte, checkNonce bool) Message {
@@ -507,3 +580,20 @@ func (m Message) CheckNonce() bool     { return m.checkNonce }
 // [Berith]
 func (m Message) Base() JobWallet   { return m.base }
 func (m Message) Target() JobWallet { return m.target }
+
+// Signers returns the owners that signed a message of a multisig account, or
+// nil if the message is signed by the sender itself.
+func (m Message) Signers() []common.Address { return m.signers }
+
+// Sponsor returns the sponsor paying the gas of the message, or nil if the
+// message is paid by its sender.
+func (m Message) Sponsor() *common.Address { return m.sponsor }
+
+// GasPayer returns the account paying the gas of the message, which is the
+// sponsor for sponsored transactions and the sender otherwise.
+func (m Message) GasPayer() common.Address {
+	if m.sponsor != nil {
+		return *m.sponsor
+	}
+	return m.from
+}
@@ -37,6 +37,8 @@ type TransactionInterface interface {
 	RawSignatureValues() (*big.Int, *big.Int, *big.Int)
 	From() *atomic.Value
 	IsEthTransaction() bool
+	Sponsor() *common.Address
+	Multisig() *common.Address
 }
 
 type OriginTransaction struct {
@@ -176,6 +178,12 @@ func (o *OriginTransaction) From() *atomic.Value {
 }
 func (o *OriginTransaction) IsEthTransaction() bool { return o.IsEthTx } //[Berith] Tx JobWallet Target
 
+// Sponsor returns nil as ethereum formatted transactions can not be sponsored.
+func (o *OriginTransaction) Sponsor() *common.Address { return nil }
+
+// Multisig returns nil as ethereum formatted transactions are signed by a single key.
+func (o *OriginTransaction) Multisig() *common.Address { return nil }
+
 // To returns the recipient address of the transaction.
 // It returns nil if the transaction is a contract creation.
 func (o *OriginTransaction) To() *common.Address {
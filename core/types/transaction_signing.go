@@ -96,7 +96,20 @@ func Sender(signer Signer, tx TransactionInterface) (common.Address, error) {
 		}
 	}
 
-	addr, err := signer.Sender(tx)
+	var (
+		addr common.Address
+		err  error
+	)
+	// [BERITH] A multisig transaction is sent from the account it names once
+	// the signatures of its owners are recovered. Whether they reach the
+	// threshold of the account is checked against the state when executed.
+	if msTx, ok := tx.(*Transaction); ok && msTx.IsMultisig() {
+		if _, err = MultisigSigners(signer, msTx); err == nil {
+			addr = *msTx.Multisig()
+		}
+	} else {
+		addr, err = signer.Sender(tx)
+	}
 	if err != nil {
 		return common.Address{}, err
 	}
@@ -165,6 +178,16 @@ func (es EIP155Signer) Sender(tx TransactionInterface) (common.Address, error) {
 	return recoverPlain(es.Hash(tx), r, s, V, true)
 }
 
+// [BERITH] plainV strips the chain id from the V value of a signature made by
+// the signer, leaving the 27 or 28 expected by recoverPlain.
+func (s EIP155Signer) plainV(v *big.Int) *big.Int {
+	if s.chainId.Sign() == 0 {
+		return v
+	}
+	V := new(big.Int).Sub(v, s.chainIdMul)
+	return V.Sub(V, big8)
+}
+
 // SignatureValues returns signature values. This signature
 // needs to be in the [R || S || V] format where V is 0 or 1.
 func (s EIP155Signer) SignatureValues(tx TransactionInterface, sig []byte) (R, S, V *big.Int, err error) {
@@ -193,7 +216,7 @@ func (s EIP155Signer) Hash(tx TransactionInterface) common.Hash {
 			s.chainId, uint(0), uint(0),
 		})
 	} else {
-		return rlpHash([]interface{}{
+		fields := []interface{}{
 			tx.Nonce(),
 			tx.GasPrice(),
 			tx.Gas(),
@@ -202,8 +225,18 @@ func (s EIP155Signer) Hash(tx TransactionInterface) common.Hash {
 			tx.Data(),
 			tx.Base(),
 			tx.Target(),
-			s.chainId, uint(0), uint(0),
-		})
+		}
+		// [Berith] The sponsor and the multisig account are committed to by
+		// every signature, so that a signature can not be replayed with another
+		// gas payer or on behalf of another account. The multisig account is
+		// preceded by a marker to tell it apart from a sponsor.
+		if sponsor := tx.Sponsor(); sponsor != nil {
+			fields = append(fields, *sponsor)
+		}
+		if account := tx.Multisig(); account != nil {
+			fields = append(fields, uint(1), *account)
+		}
+		return rlpHash(append(fields, s.chainId, uint(0), uint(0)))
 	}
 }
 
@@ -0,0 +1,216 @@
+package vm
+
+import (
+	"errors"
+	"math/big"
+
+	"github.com/BerithFoundation/berith-chain/common"
+	"github.com/BerithFoundation/berith-chain/common/math"
+	"github.com/BerithFoundation/berith-chain/core/types"
+	"github.com/BerithFoundation/berith-chain/crypto"
+	"github.com/BerithFoundation/berith-chain/params"
+)
+
+// StakingContractAddress is the address of the staking precompiled contract.
+var StakingContractAddress = common.BytesToAddress([]byte{1, 0})
+
+// PrecompiledContractsBIP8 contains the default set of pre-compiled Berith
+// contracts used from BIP8, which adds the staking contract to Byzantium's.
+var PrecompiledContractsBIP8 = map[common.Address]PrecompiledContract{
+	common.BytesToAddress([]byte{1}): &ecrecover{},
+	common.BytesToAddress([]byte{2}): &sha256hash{},
+	common.BytesToAddress([]byte{3}): &ripemd160hash{},
+	common.BytesToAddress([]byte{4}): &dataCopy{},
+	common.BytesToAddress([]byte{5}): &bigModExp{},
+	common.BytesToAddress([]byte{6}): &bn256Add{},
+	common.BytesToAddress([]byte{7}): &bn256ScalarMul{},
+	common.BytesToAddress([]byte{8}): &bn256Pairing{},
+	StakingContractAddress:           &staking{},
+}
+
+// statefulPrecompiledContract is a native contract that needs the state and
+// the calling context besides its input.
+type statefulPrecompiledContract interface {
+	PrecompiledContract
+	RunStateful(evm *EVM, contract *Contract, input []byte, readOnly bool) ([]byte, error)
+}
+
+// runStatefulPrecompiledContract runs and evaluates the output of a stateful
+// precompiled contract.
+func runStatefulPrecompiledContract(evm *EVM, p statefulPrecompiledContract, input []byte, contract *Contract, readOnly bool) ([]byte, error) {
+	gas := p.RequiredGas(input)
+	if contract.UseGas(gas) {
+		return p.RunStateful(evm, contract, input, readOnly)
+	}
+	return nil, ErrOutOfGas
+}
+
+var (
+	errStakingInput       = errors.New("invalid staking contract input")
+	errStakingFork        = errors.New("staking contract not enabled before BIP8")
+	errStakingValue       = errors.New("staking contract does not accept value")
+	errStakingMinimum     = errors.New("stake below the minimum")
+	errStakingLimit       = errors.New("stake exceeds the limit")
+	errStakingNoBalance   = errors.New("insufficient balance for stake")
+	errStatefulPrecompile = errors.New("stateful precompiled contract run without context")
+)
+
+// Method selectors of the staking contract, as computed by solidity.
+var (
+	stakeOfSelector  = selector("stakeOf(address)")
+	pointOfSelector  = selector("pointOf(address)")
+	behindOfSelector = selector("behindOf(address)")
+	isStakerSelector = selector("isStaker(address)")
+	stakeSelector    = selector("stake(uint256)")
+	unstakeSelector  = selector("unstake()")
+)
+
+// Topics of the logs emitted by the staking contract when an account stakes or
+// unstakes, from which the consensus engine keeps its staker list.
+var (
+	StakedTopic   = crypto.Keccak256Hash([]byte("Staked(address,uint256)"))
+	UnstakedTopic = crypto.Keccak256Hash([]byte("Unstaked(address,uint256)"))
+)
+
+// word encodes n as an ABI uint256 without modifying it.
+func word(n *big.Int) []byte {
+	return math.U256Bytes(new(big.Int).Set(n))
+}
+
+func selector(method string) [4]byte {
+	var sel [4]byte
+	copy(sel[:], crypto.Keccak256([]byte(method)))
+	return sel
+}
+
+// [BERITH]
+// staking implements the staking precompiled contract. It gives contracts
+// read access to the staking state of any account, and lets the calling
+// contract stake and unstake its own balance:
+//
+//	stakeOf(address) returns (uint256)
+//	pointOf(address) returns (uint256)
+//	behindOf(address) returns (uint256)
+//	isStaker(address) returns (bool)
+//	stake(uint256 amount)
+//	unstake() returns (uint256 amount)
+//
+// The stake changed is the one of the account running the call, that is the
+// delegating contract when delegated to, or the caller when called directly.
+// Every change is logged, so the consensus engine updates its staker list as
+// it does for staking transactions.
+type staking struct{}
+
+func (c *staking) RequiredGas(input []byte) uint64 {
+	if len(input) < 4 {
+		return params.StakingQueryGas
+	}
+	var sel [4]byte
+	copy(sel[:], input)
+	switch sel {
+	case stakeSelector, unstakeSelector:
+		return params.StakingUpdateGas
+	}
+	return params.StakingQueryGas
+}
+
+func (c *staking) Run(input []byte) ([]byte, error) {
+	return nil, errStatefulPrecompile
+}
+
+func (c *staking) RunStateful(evm *EVM, contract *Contract, input []byte, readOnly bool) ([]byte, error) {
+	if len(input) < 4 {
+		return nil, errStakingInput
+	}
+	var sel [4]byte
+	copy(sel[:], input)
+	args := input[4:]
+
+	switch sel {
+	case stakeOfSelector, pointOfSelector, behindOfSelector, isStakerSelector:
+		if len(args) != 32 {
+			return nil, errStakingInput
+		}
+		addr := common.BytesToAddress(args)
+		switch sel {
+		case stakeOfSelector:
+			return word(evm.StateDB.GetStakeBalance(addr)), nil
+		case pointOfSelector:
+			return word(evm.StateDB.GetPoint(addr)), nil
+		case behindOfSelector:
+			return word(evm.StateDB.GetTotalBehindBalance(addr)), nil
+		default:
+			if evm.StateDB.GetStakeBalance(addr).Sign() > 0 {
+				return word(common.Big1), nil
+			}
+			return word(common.Big0), nil
+		}
+
+	case stakeSelector, unstakeSelector:
+		if readOnly {
+			return nil, ErrWriteProtection
+		}
+		if !evm.ChainConfig().IsBIP8(evm.BlockNumber) {
+			return nil, errStakingFork
+		}
+		// A delegating contract stakes its own balance. A called contract would
+		// be the staking contract itself, so the stake is the one of the caller,
+		// which must not send value the contract would keep.
+		account := contract.Address()
+		if account == StakingContractAddress {
+			if contract.Value().Sign() != 0 {
+				return nil, errStakingValue
+			}
+			account = contract.Caller()
+		}
+		if sel == unstakeSelector {
+			if len(args) != 0 {
+				return nil, errStakingInput
+			}
+			return c.unstake(evm, account)
+		}
+		if len(args) != 32 {
+			return nil, errStakingInput
+		}
+		return c.stake(evm, account, new(big.Int).SetBytes(args))
+	}
+	return nil, errStakingInput
+}
+
+// stake moves amount from the main balance of account to its stake balance,
+// subject to the same minimum and limit as a staking transaction.
+func (c *staking) stake(evm *EVM, account common.Address, amount *big.Int) ([]byte, error) {
+	if amount.Sign() == 0 || !evm.Context.CanTransfer(evm.StateDB, account, amount, types.Main) {
+		return nil, errStakingNoBalance
+	}
+	total := new(big.Int).Add(evm.StateDB.GetStakeBalance(account), amount)
+	if bsrr := evm.ChainConfig().Bsrr; bsrr != nil {
+		if bsrr.StakeMinimum != nil && total.Cmp(bsrr.StakeMinimum) < 0 {
+			return nil, errStakingMinimum
+		}
+		if evm.ChainConfig().IsBIP4(evm.BlockNumber) && bsrr.LimitStakeBalance != nil && total.Cmp(bsrr.LimitStakeBalance) > 0 {
+			return nil, errStakingLimit
+		}
+	}
+	evm.Context.Transfer(evm.StateDB, account, account, amount, evm.BlockNumber, types.Main, types.Stake)
+	c.log(evm, StakedTopic, account, amount)
+	return nil, nil
+}
+
+// unstake moves the whole stake balance of account back to its main balance
+// and returns the unstaked amount.
+func (c *staking) unstake(evm *EVM, account common.Address) ([]byte, error) {
+	amount := new(big.Int).Set(evm.StateDB.GetStakeBalance(account))
+	evm.Context.Transfer(evm.StateDB, account, account, amount, evm.BlockNumber, types.Stake, types.Main)
+	c.log(evm, UnstakedTopic, account, amount)
+	return word(amount), nil
+}
+
+func (c *staking) log(evm *EVM, topic common.Hash, account common.Address, amount *big.Int) {
+	evm.StateDB.AddLog(&types.Log{
+		Address:     StakingContractAddress,
+		Topics:      []common.Hash{topic, account.Hash()},
+		Data:        word(amount),
+		BlockNumber: evm.BlockNumber.Uint64(),
+	})
+}
@@ -0,0 +1,187 @@
+package vm_test
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/BerithFoundation/berith-chain/berithdb"
+	"github.com/BerithFoundation/berith-chain/common"
+	"github.com/BerithFoundation/berith-chain/common/math"
+	"github.com/BerithFoundation/berith-chain/core/state"
+	"github.com/BerithFoundation/berith-chain/core/types"
+	"github.com/BerithFoundation/berith-chain/core/vm"
+	"github.com/BerithFoundation/berith-chain/core/vm/runtime"
+	"github.com/BerithFoundation/berith-chain/crypto"
+	"github.com/BerithFoundation/berith-chain/params"
+)
+
+func stakingCall(method string, args ...*big.Int) []byte {
+	input := crypto.Keccak256([]byte(method))[:4]
+	for _, arg := range args {
+		input = append(input, math.PaddedBigBytes(arg, 32)...)
+	}
+	return input
+}
+
+func TestStakingPrecompile(t *testing.T) {
+	config := *params.TestnetChainConfig
+	config.BIP8Block = big.NewInt(0)
+	config.Bsrr = &params.BSRRConfig{StakeMinimum: big.NewInt(100)}
+
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(berithdb.NewMemDatabase()))
+	caller, staker := common.Address{0xca}, common.Address{0x57}
+	statedb.AddBalance(caller, big.NewInt(1000))
+	statedb.AddStakeBalance(staker, big.NewInt(400), big.NewInt(1))
+	statedb.SetPoint(staker, big.NewInt(7))
+	statedb.AddBehindBalance(staker, big.NewInt(1), big.NewInt(30))
+	statedb.AddBehindBalance(staker, big.NewInt(2), big.NewInt(20))
+
+	env := runtime.NewEnv(&runtime.Config{
+		ChainConfig: &config,
+		State:       statedb,
+		BlockNumber: big.NewInt(1),
+		Time:        big.NewInt(0),
+		Difficulty:  big.NewInt(1),
+		GasLimit:    10000000,
+		GasPrice:    big.NewInt(1),
+	})
+	call := func(input []byte) ([]byte, error) {
+		ret, _, err := env.Call(vm.AccountRef(caller), vm.StakingContractAddress, input, 100000, new(big.Int), types.Main, types.Main)
+		return ret, err
+	}
+	addr := new(big.Int).SetBytes(staker.Bytes())
+
+	for _, tt := range []struct {
+		method string
+		want   int64
+	}{
+		{"stakeOf(address)", 400},
+		{"pointOf(address)", 7},
+		{"behindOf(address)", 50},
+		{"isStaker(address)", 1},
+	} {
+		if ret, err := call(stakingCall(tt.method, addr)); err != nil || !bytes.Equal(ret, math.PaddedBigBytes(big.NewInt(tt.want), 32)) {
+			t.Errorf("%s mismatch: have %x (%v), want %d", tt.method, ret, err, tt.want)
+		}
+	}
+	if ret, _ := call(stakingCall("isStaker(address)", new(big.Int).SetBytes(caller.Bytes()))); !bytes.Equal(ret, math.PaddedBigBytes(common.Big0, 32)) {
+		t.Errorf("isStaker mismatch for non-staker: have %x", ret)
+	}
+	// Queries are allowed in static calls
+	if ret, _, err := env.StaticCall(vm.AccountRef(caller), vm.StakingContractAddress, stakingCall("stakeOf(address)", addr), 100000); err != nil || new(big.Int).SetBytes(ret).Int64() != 400 {
+		t.Errorf("static stakeOf mismatch: have %x (%v), want 400", ret, err)
+	}
+
+	// Static calls can query but not stake
+	if _, _, err := env.StaticCall(vm.AccountRef(caller), vm.StakingContractAddress, stakingCall("stake(uint256)", big.NewInt(400)), 100000); err != vm.ErrWriteProtection {
+		t.Errorf("static stake error mismatch: have %v, want %v", err, vm.ErrWriteProtection)
+	}
+	// Stakes below the minimum are rejected, others move the main balance
+	if _, err := call(stakingCall("stake(uint256)", big.NewInt(50))); err == nil {
+		t.Errorf("stake below minimum accepted")
+	}
+	if _, _, err := env.Call(vm.AccountRef(caller), vm.StakingContractAddress, stakingCall("stake(uint256)", big.NewInt(400)), 100000, big.NewInt(1), types.Main, types.Main); err == nil {
+		t.Errorf("stake with value accepted")
+	}
+	if _, err := call(stakingCall("stake(uint256)", big.NewInt(400))); err != nil {
+		t.Fatalf("stake failed: %v", err)
+	}
+	if stake := statedb.GetStakeBalance(caller); stake.Int64() != 400 {
+		t.Errorf("stake balance mismatch: have %v, want 400", stake)
+	}
+	if bal := statedb.GetBalance(caller); bal.Int64() != 600 {
+		t.Errorf("main balance mismatch: have %v, want 600", bal)
+	}
+	if ret, err := call(stakingCall("unstake()")); err != nil || new(big.Int).SetBytes(ret).Int64() != 400 {
+		t.Fatalf("unstake mismatch: have %x (%v), want 400", ret, err)
+	}
+	if stake := statedb.GetStakeBalance(caller); stake.Sign() != 0 {
+		t.Errorf("stake balance not cleared: %v", stake)
+	}
+	if bal := statedb.GetBalance(caller); bal.Int64() != 1000 {
+		t.Errorf("main balance mismatch: have %v, want 1000", bal)
+	}
+	// Every stake change is logged for the staker list
+	logs := statedb.Logs()
+	if len(logs) != 2 || logs[0].Topics[0] != vm.StakedTopic || logs[1].Topics[0] != vm.UnstakedTopic {
+		t.Fatalf("stake logs mismatch: have %v", logs)
+	}
+	for _, l := range logs {
+		if l.Address != vm.StakingContractAddress || l.Topics[1] != caller.Hash() || new(big.Int).SetBytes(l.Data).Int64() != 400 {
+			t.Errorf("stake log mismatch: have %v", l)
+		}
+	}
+}
+
+// Tests that a contract delegating to the staking contract stakes its own
+// balance.
+func TestStakingPrecompileDelegated(t *testing.T) {
+	config := *params.TestnetChainConfig
+	config.BIP8Block = big.NewInt(0)
+	config.Bsrr = &params.BSRRConfig{StakeMinimum: big.NewInt(100)}
+
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(berithdb.NewMemDatabase()))
+	caller, wallet := common.Address{0xca}, common.Address{0xc0}
+	statedb.AddBalance(wallet, big.NewInt(1000))
+	// Forwards the call data to the staking contract by delegatecall, reverting
+	// on failure and returning the first word of the output otherwise
+	statedb.SetCode(wallet, common.Hex2Bytes("366000600037602060003660006101005af4601957600080fd5b60206000f3"))
+
+	env := runtime.NewEnv(&runtime.Config{
+		ChainConfig: &config,
+		State:       statedb,
+		BlockNumber: big.NewInt(1),
+		Time:        big.NewInt(0),
+		Difficulty:  big.NewInt(1),
+		GasLimit:    10000000,
+		GasPrice:    big.NewInt(1),
+	})
+	call := func(input []byte) ([]byte, error) {
+		ret, _, err := env.Call(vm.AccountRef(caller), wallet, input, 100000, new(big.Int), types.Main, types.Main)
+		return ret, err
+	}
+	if _, err := call(stakingCall("stake(uint256)", big.NewInt(300))); err != nil {
+		t.Fatalf("stake failed: %v", err)
+	}
+	if stake := statedb.GetStakeBalance(wallet); stake.Int64() != 300 {
+		t.Errorf("contract stake balance mismatch: have %v, want 300", stake)
+	}
+	if stake := statedb.GetStakeBalance(caller); stake.Sign() != 0 {
+		t.Errorf("caller stake balance changed: %v", stake)
+	}
+	if logs := statedb.Logs(); len(logs) != 1 || logs[0].Topics[1] != wallet.Hash() {
+		t.Errorf("stake logs mismatch: have %v", logs)
+	}
+	if ret, err := call(stakingCall("unstake()")); err != nil || new(big.Int).SetBytes(ret).Int64() != 300 {
+		t.Fatalf("unstake mismatch: have %x (%v), want 300", ret, err)
+	}
+	if bal := statedb.GetBalance(wallet); bal.Int64() != 1000 {
+		t.Errorf("contract balance mismatch: have %v, want 1000", bal)
+	}
+}
+
+// Tests that stakes can't be changed before BIP8, where the staking contract
+// doesn't exist.
+func TestStakingPrecompileFork(t *testing.T) {
+	config := *params.TestnetChainConfig
+	config.BIP8Block = big.NewInt(10)
+
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(berithdb.NewMemDatabase()))
+	caller := common.Address{0xca}
+	statedb.AddBalance(caller, big.NewInt(1000))
+
+	env := runtime.NewEnv(&runtime.Config{
+		ChainConfig: &config,
+		State:       statedb,
+		BlockNumber: big.NewInt(1),
+		Time:        big.NewInt(0),
+		Difficulty:  big.NewInt(1),
+		GasLimit:    10000000,
+		GasPrice:    big.NewInt(1),
+	})
+	env.Call(vm.AccountRef(caller), vm.StakingContractAddress, stakingCall("stake(uint256)", big.NewInt(400)), 100000, new(big.Int), types.Main, types.Main)
+	if stake := statedb.GetStakeBalance(caller); stake.Sign() != 0 {
+		t.Errorf("stake before BIP8: %v", stake)
+	}
+}
@@ -46,14 +46,30 @@ type (
 	GetHashFunc func(uint64) common.Hash
 )
 
+// ActivePrecompiles returns the precompiled contracts active at the given block.
+func ActivePrecompiles(config *params.ChainConfig, number *big.Int) map[common.Address]PrecompiledContract {
+	switch {
+	case config.IsBIP8(number):
+		return PrecompiledContractsBIP8
+	case config.IsByzantium(number):
+		return PrecompiledContractsByzantium
+	default:
+		return PrecompiledContractsHomestead
+	}
+}
+
+// precompiles returns the precompiled contracts active at the current block.
+func (evm *EVM) precompiles() map[common.Address]PrecompiledContract {
+	return ActivePrecompiles(evm.ChainConfig(), evm.BlockNumber)
+}
+
 // run runs the given contract and takes care of running precompiles with a fallback to the byte code interpreter.
 func run(evm *EVM, contract *Contract, input []byte, readOnly bool) ([]byte, error) {
 	if contract.CodeAddr != nil {
-		precompiles := PrecompiledContractsHomestead
-		if evm.ChainConfig().IsByzantium(evm.BlockNumber) {
-			precompiles = PrecompiledContractsByzantium
-		}
-		if p := precompiles[*contract.CodeAddr]; p != nil {
+		if p := evm.precompiles()[*contract.CodeAddr]; p != nil {
+			if sp, ok := p.(statefulPrecompiledContract); ok {
+				return runStatefulPrecompiledContract(evm, sp, input, contract, readOnly)
+			}
 			return RunPrecompiledContract(p, input, contract)
 		}
 	}
@@ -222,11 +238,7 @@ func (evm *EVM) Call(caller ContractRef, addr common.Address, input []byte, gas
 		snapshot = evm.StateDB.Snapshot()
 	)
 	if !evm.StateDB.Exist(addr) {
-		precompiles := PrecompiledContractsHomestead
-		if evm.ChainConfig().IsByzantium(evm.BlockNumber) {
-			precompiles = PrecompiledContractsByzantium
-		}
-		if precompiles[addr] == nil && evm.ChainConfig().IsEIP158(evm.BlockNumber) && value.Sign() == 0 {
+		if evm.precompiles()[addr] == nil && evm.ChainConfig().IsEIP158(evm.BlockNumber) && value.Sign() == 0 {
 			// Calling a non existing account, don't do anything, but ping the tracer
 			if evm.Config.Debug && evm.depth == 0 {
 				evm.Config.Tracer.CaptureStart(caller.Address(), addr, false, input, gas, value)
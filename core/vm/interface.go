@@ -76,6 +76,9 @@ type StateDB interface {
 	GetPoint(common.Address) *big.Int
 	AddPoint(addr common.Address, amount *big.Int)
 
+	//Behind Balance
+	GetTotalBehindBalance(common.Address) *big.Int
+
 	//Penalty
 	AddPenalty(common.Address, *big.Int)
 	RemovePenalty(common.Address, *big.Int)
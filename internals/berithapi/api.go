@@ -1106,18 +1106,37 @@ func (s *PublicTransactionPoolAPI) GetTransactionCount(ctx context.Context, addr
 	return (*hexutil.Uint64)(&nonce), state.Error()
 }
 
+// txIndexError returns an error explaining that the transaction with the given
+// hash can't be retrieved because the transaction index is limited to the recent
+// blocks, its lookup being either below the tail or already deleted. Nil is
+// returned if the whole chain is indexed, the transaction being unknown then.
+func txIndexError(db rawdb.DatabaseReader, hash common.Hash) error {
+	tail := rawdb.ReadTxIndexTail(db)
+	if tail == nil || *tail == 0 {
+		return nil
+	}
+	blockHash, number, _ := rawdb.ReadTxLookupEntry(db, hash)
+	if blockHash == (common.Hash{}) {
+		return fmt.Errorf("transaction not found, lookups are limited to blocks since #%d", *tail)
+	}
+	if number < *tail {
+		return fmt.Errorf("transaction in block #%d not found, lookups are limited to blocks since #%d", number, *tail)
+	}
+	return nil
+}
+
 // GetTransactionByHash returns the transaction for the given hash
-func (s *PublicTransactionPoolAPI) GetTransactionByHash(ctx context.Context, hash common.Hash) *RPCTransaction {
+func (s *PublicTransactionPoolAPI) GetTransactionByHash(ctx context.Context, hash common.Hash) (*RPCTransaction, error) {
 	// Try to return an already finalized transaction
 	if tx, blockHash, blockNumber, index, base, target := rawdb.ReadTransaction(s.b.ChainDb(), hash); tx != nil {
-		return newRPCTransaction(tx, blockHash, blockNumber, index, base, target)
+		return newRPCTransaction(tx, blockHash, blockNumber, index, base, target), nil
 	}
 	// No finalized transaction, try to retrieve it from the pool
 	if tx := s.b.GetPoolTransaction(hash); tx != nil {
-		return newRPCPendingTransaction(tx)
+		return newRPCPendingTransaction(tx), nil
 	}
 	// Transaction unknown, return as such
-	return nil
+	return nil, txIndexError(s.b.ChainDb(), hash)
 }
 
 // GetRawTransactionByHash returns the bytes of the transaction for the given hash.
@@ -1128,7 +1147,7 @@ func (s *PublicTransactionPoolAPI) GetRawTransactionByHash(ctx context.Context,
 	if tx, _, _, _, _, _ = rawdb.ReadTransaction(s.b.ChainDb(), hash); tx == nil {
 		if tx = s.b.GetPoolTransaction(hash); tx == nil {
 			// Transaction not found anywhere, abort
-			return nil, nil
+			return nil, txIndexError(s.b.ChainDb(), hash)
 		}
 	}
 	// Serialize to RLP and return
@@ -1139,7 +1158,7 @@ func (s *PublicTransactionPoolAPI) GetRawTransactionByHash(ctx context.Context,
 func (s *PublicTransactionPoolAPI) GetTransactionReceipt(ctx context.Context, hash common.Hash) (map[string]interface{}, error) {
 	tx, blockHash, blockNumber, index, _, _ := rawdb.ReadTransaction(s.b.ChainDb(), hash)
 	if tx == nil {
-		return nil, nil
+		return nil, txIndexError(s.b.ChainDb(), hash)
 	}
 	receipts, err := s.b.GetReceipts(ctx, blockHash)
 	if err != nil {
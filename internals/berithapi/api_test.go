@@ -0,0 +1,63 @@
+package berithapi
+
+import (
+	"context"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/BerithFoundation/berith-chain/berithdb"
+	"github.com/BerithFoundation/berith-chain/common"
+	"github.com/BerithFoundation/berith-chain/core/rawdb"
+	"github.com/BerithFoundation/berith-chain/core/types"
+)
+
+// testBackend is a Backend over a chain database, with an empty pool.
+type testBackend struct {
+	Backend
+	db berithdb.Database
+}
+
+func (b *testBackend) ChainDb() berithdb.Database                        { return b.db }
+func (b *testBackend) GetPoolTransaction(common.Hash) *types.Transaction { return nil }
+func (b *testBackend) GetReceipts(ctx context.Context, hash common.Hash) (types.Receipts, error) {
+	return rawdb.ReadReceipts(b.db, hash, *rawdb.ReadHeaderNumber(b.db, hash)), nil
+}
+
+// Tests that the transactions of the blocks below the tail of the transaction
+// index are reported as such, rather than as unknown.
+func TestUnindexedTransaction(t *testing.T) {
+	db := berithdb.NewMemDatabase()
+	var blocks []*types.Block
+	for i := 0; i < 3; i++ {
+		tx := types.NewTransaction(uint64(i), common.Address{1}, big.NewInt(1), 21000, big.NewInt(1), nil, types.Main, types.Main, false)
+		block := types.NewBlock(&types.Header{Number: big.NewInt(int64(i))}, []*types.Transaction{tx}, nil, nil)
+		rawdb.WriteBlock(db, block)
+		rawdb.WriteReceipts(db, block.Hash(), block.NumberU64(), types.Receipts{{GasUsed: 21000}})
+		rawdb.WriteCanonicalHash(db, block.Hash(), block.NumberU64())
+		blocks = append(blocks, block)
+	}
+	rawdb.IndexTransactions(db, 0, 3, nil)
+
+	api := NewPublicTransactionPoolAPI(&testBackend{db: db}, new(AddrLocker))
+	pruned, indexed := blocks[0].Transactions()[0].Hash(), blocks[2].Transactions()[0].Hash()
+
+	// Nothing to report while the whole chain is indexed
+	if tx, err := api.GetTransactionByHash(context.Background(), common.Hash{1}); tx != nil || err != nil {
+		t.Fatalf("unknown transaction: have %v, %v, want nil", tx, err)
+	}
+	rawdb.UnindexTransactions(db, 0, 2, nil)
+
+	if tx, err := api.GetTransactionByHash(context.Background(), pruned); tx != nil || err == nil || !strings.Contains(err.Error(), "since #2") {
+		t.Errorf("unindexed transaction: have %v, %v, want index error", tx, err)
+	}
+	if receipt, err := api.GetTransactionReceipt(context.Background(), pruned); receipt != nil || err == nil || !strings.Contains(err.Error(), "since #2") {
+		t.Errorf("unindexed receipt: have %v, %v, want index error", receipt, err)
+	}
+	if tx, err := api.GetTransactionByHash(context.Background(), indexed); tx == nil || err != nil {
+		t.Errorf("indexed transaction: have %v, %v", tx, err)
+	}
+	if receipt, err := api.GetTransactionReceipt(context.Background(), indexed); receipt == nil || err != nil {
+		t.Errorf("indexed receipt: have %v, %v", receipt, err)
+	}
+}
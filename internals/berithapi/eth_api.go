@@ -625,24 +625,24 @@ func (s *Eth_PublicTransactionPoolAPI) GetTransactionCount(ctx context.Context,
 }
 
 // GetTransactionByHash returns the transaction for the given hash
-func (s *Eth_PublicTransactionPoolAPI) GetTransactionByHash(ctx context.Context, hash common.Hash) *EthRPCTransaction {
+func (s *Eth_PublicTransactionPoolAPI) GetTransactionByHash(ctx context.Context, hash common.Hash) (*EthRPCTransaction, error) {
 	if s.b.CurrentBlock().Number().Cmp(s.b.ChainConfig().BIP5Block) < 0 {
 		log.Error("eth_getTransactionByHash is not supported untill BIP5")
-		return &EthRPCTransaction{}
+		return &EthRPCTransaction{}, nil
 	}
 	// Try to return an already finalized transaction
 	if tx, blockHash, blockNumber, index, _, _ := rawdb.ReadTransaction(s.b.ChainDb(), hash); tx != nil {
 		tx.IsEthTx = true
 		// fmt.Println("Get req : ", hash.Hex(), "res : ", tx.Hash().Hex())
-		return newEthRPCTransaction(tx, blockHash, blockNumber, index)
+		return newEthRPCTransaction(tx, blockHash, blockNumber, index), nil
 	}
 	// No finalized transaction, try to retrieve it from the pool
 	if tx := s.b.GetPoolTransaction(hash); tx != nil {
 		tx.IsEthTx = true
-		return newEthRPCPendingTransaction(tx)
+		return newEthRPCPendingTransaction(tx), nil
 	}
 	// Transaction unknown, return as such
-	return nil
+	return nil, txIndexError(s.b.ChainDb(), hash)
 }
 
 // RPCTransaction represents a transaction that will serialize to the RPC representation of a transaction
@@ -710,7 +710,7 @@ func (s *Eth_PublicTransactionPoolAPI) GetRawTransactionByHash(ctx context.Conte
 	if tx, _, _, _, _, _ = rawdb.ReadTransaction(s.b.ChainDb(), hash); tx == nil {
 		if tx = s.b.GetPoolTransaction(hash); tx == nil {
 			// Transaction not found anywhere, abort
-			return nil, nil
+			return nil, txIndexError(s.b.ChainDb(), hash)
 		}
 	}
 	tx.IsEthTx = true
@@ -725,7 +725,7 @@ func (s *Eth_PublicTransactionPoolAPI) GetTransactionReceipt(ctx context.Context
 	}
 	tx, blockHash, blockNumber, index, _, _ := rawdb.ReadTransaction(s.b.ChainDb(), hash)
 	if tx == nil {
-		return nil, nil
+		return nil, txIndexError(s.b.ChainDb(), hash)
 	}
 	tx.IsEthTx = true
 	receipts, err := s.b.GetReceipts(ctx, blockHash) // 배포된 Contract의 Setter를 호출한 TX의 Receipt가 확인 되어야 Contract의 Setter가 작동함.
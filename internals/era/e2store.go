@@ -0,0 +1,96 @@
+// Copyright 2023 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package era
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// headerSize is the size of the type-length header in front of every entry:
+// type (uint16) | length (uint32) | reserved (uint16), all little endian.
+const headerSize = 8
+
+// Entry is a type-length-value record of an e2store file.
+type Entry struct {
+	Type  uint16
+	Value []byte
+}
+
+// e2Writer appends entries to an e2store stream.
+type e2Writer struct {
+	w io.Writer
+}
+
+// Write writes a single entry, returning the number of bytes written.
+func (w *e2Writer) Write(typ uint16, value []byte) (int, error) {
+	var header [headerSize]byte
+	binary.LittleEndian.PutUint16(header[0:], typ)
+	binary.LittleEndian.PutUint32(header[2:], uint32(len(value)))
+	if n, err := w.w.Write(header[:]); err != nil {
+		return n, err
+	}
+	n, err := w.w.Write(value)
+	return headerSize + n, err
+}
+
+// e2Reader reads entries from an e2store file at given offsets.
+type e2Reader struct {
+	r io.ReaderAt
+}
+
+// ReadMetadataAt reads the type and the length of the entry at the offset.
+func (r *e2Reader) ReadMetadataAt(off int64) (uint16, uint32, error) {
+	var header [headerSize]byte
+	if _, err := r.r.ReadAt(header[:], off); err != nil {
+		return 0, 0, err
+	}
+	if header[6] != 0 || header[7] != 0 {
+		return 0, 0, errors.New("reserved bytes are non-zero")
+	}
+	return binary.LittleEndian.Uint16(header[0:]), binary.LittleEndian.Uint32(header[2:]), nil
+}
+
+// ReadAt reads the entry at the offset, returning it along with its total size.
+func (r *e2Reader) ReadAt(off int64) (*Entry, int64, error) {
+	typ, length, err := r.ReadMetadataAt(off)
+	if err != nil {
+		return nil, 0, err
+	}
+	value := make([]byte, length)
+	if _, err := r.r.ReadAt(value, off+headerSize); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, 0, err
+	}
+	return &Entry{Type: typ, Value: value}, headerSize + int64(length), nil
+}
+
+// ReadTypeAt reads the entry at the offset, failing if it is of another type.
+func (r *e2Reader) ReadTypeAt(typ uint16, off int64) (*Entry, int64, error) {
+	entry, n, err := r.ReadAt(off)
+	if err != nil {
+		return nil, 0, err
+	}
+	if entry.Type != typ {
+		return nil, 0, fmt.Errorf("invalid entry type at offset %d: have %#x, want %#x", off, entry.Type, typ)
+	}
+	return entry, n, nil
+}
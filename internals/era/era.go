@@ -0,0 +1,406 @@
+// Copyright 2023 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package era implements the segmented chain archive format. An archive file
+// holds up to MaxSize consecutive blocks as an e2store stream:
+//
+//	Version | (Header | Body | Receipts | TotalDifficulty)* | Accumulator | BlockIndex
+//
+// Headers, bodies and receipts are snappy compressed RLP, total difficulties
+// are 32 byte big endian integers. The accumulator is the merkle root of the
+// header records of the file, the block index maps block numbers to offsets.
+package era
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+
+	"github.com/BerithFoundation/berith-chain/common"
+	"github.com/BerithFoundation/berith-chain/core/types"
+	"github.com/BerithFoundation/berith-chain/rlp"
+	"github.com/golang/snappy"
+)
+
+const (
+	TypeVersion            uint16 = 0x3265
+	TypeCompressedHeader   uint16 = 0x03
+	TypeCompressedBody     uint16 = 0x04
+	TypeCompressedReceipts uint16 = 0x05
+	TypeTotalDifficulty    uint16 = 0x06
+	TypeAccumulator        uint16 = 0x07
+	TypeBlockIndex         uint16 = 0x3266
+
+	// MaxSize is the maximum number of blocks in an archive file.
+	MaxSize = 8192
+)
+
+// Filename returns the name of the archive file of the given epoch, suffixed
+// with the prefix of its accumulator root.
+func Filename(epoch uint64, root common.Hash) string {
+	return fmt.Sprintf("berith-%05d-%x.era", epoch, root[:4])
+}
+
+// Builder writes blocks with their receipts and total difficulty into an
+// archive file. Blocks must be added in ascending order without gaps.
+type Builder struct {
+	w       *e2Writer
+	start   *uint64
+	offsets []uint64
+	hashes  []common.Hash
+	tds     []*big.Int
+	written uint64
+}
+
+// NewBuilder creates a builder writing into w.
+func NewBuilder(w io.Writer) *Builder {
+	return &Builder{w: &e2Writer{w: w}}
+}
+
+// Add appends a block, its receipts and its total difficulty to the archive.
+func (b *Builder) Add(block *types.Block, receipts types.Receipts, td *big.Int) error {
+	header, err := rlp.EncodeToBytes(block.Header())
+	if err != nil {
+		return err
+	}
+	body, err := rlp.EncodeToBytes(block.Body())
+	if err != nil {
+		return err
+	}
+	storage := make([]*types.ReceiptForStorage, len(receipts))
+	for i, receipt := range receipts {
+		storage[i] = (*types.ReceiptForStorage)(receipt)
+	}
+	blob, err := rlp.EncodeToBytes(storage)
+	if err != nil {
+		return err
+	}
+	return b.AddRLP(block.NumberU64(), block.Hash(), header, body, blob, td)
+}
+
+// AddRLP appends the RLP encoded header, body and storage receipts of a block
+// along with its total difficulty to the archive.
+func (b *Builder) AddRLP(number uint64, hash common.Hash, header, body, receipts []byte, td *big.Int) error {
+	if b.start == nil {
+		b.start = &number
+		if err := b.write(TypeVersion, nil); err != nil {
+			return err
+		}
+	}
+	if want := *b.start + uint64(len(b.offsets)); number != want {
+		return fmt.Errorf("non contiguous block: have #%d, want #%d", number, want)
+	}
+	if len(b.offsets) == MaxSize {
+		return fmt.Errorf("archive full (%d blocks)", MaxSize)
+	}
+	b.offsets = append(b.offsets, b.written)
+	b.hashes = append(b.hashes, hash)
+	b.tds = append(b.tds, new(big.Int).Set(td))
+
+	for _, entry := range []struct {
+		typ  uint16
+		data []byte
+	}{
+		{TypeCompressedHeader, snappy.Encode(nil, header)},
+		{TypeCompressedBody, snappy.Encode(nil, body)},
+		{TypeCompressedReceipts, snappy.Encode(nil, receipts)},
+		{TypeTotalDifficulty, common.BigToHash(td).Bytes()},
+	} {
+		if err := b.write(entry.typ, entry.data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Finalize writes the accumulator and the block index, returning the root of
+// the accumulator.
+func (b *Builder) Finalize() (common.Hash, error) {
+	if b.start == nil {
+		return common.Hash{}, errors.New("empty archive")
+	}
+	root, err := ComputeAccumulator(b.hashes, b.tds)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	if err := b.write(TypeAccumulator, root[:]); err != nil {
+		return common.Hash{}, err
+	}
+	// Block index: start | offset* | count, offsets are absolute
+	index := make([]byte, 16+8*len(b.offsets))
+	binary.LittleEndian.PutUint64(index, *b.start)
+	for i, offset := range b.offsets {
+		binary.LittleEndian.PutUint64(index[8+8*i:], offset)
+	}
+	binary.LittleEndian.PutUint64(index[8+8*len(b.offsets):], uint64(len(b.offsets)))
+	if err := b.write(TypeBlockIndex, index); err != nil {
+		return common.Hash{}, err
+	}
+	return root, nil
+}
+
+// write appends an entry to the archive, tracking the written size.
+func (b *Builder) write(typ uint16, data []byte) error {
+	n, err := b.w.Write(typ, data)
+	b.written += uint64(n)
+	return err
+}
+
+// Era is an archive file opened for reading.
+type Era struct {
+	f       *os.File
+	r       *e2Reader
+	start   uint64
+	offsets []uint64
+}
+
+// Open opens the archive file at the given path.
+func Open(path string) (*Era, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	e, err := newEra(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return e, nil
+}
+
+// newEra loads the block index of the archive.
+func newEra(f *os.File) (*Era, error) {
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	r := &e2Reader{r: f}
+	if _, _, err := r.ReadTypeAt(TypeVersion, 0); err != nil {
+		return nil, err
+	}
+	// The block count closes the file, locate the index with it
+	if stat.Size() < headerSize+24 {
+		return nil, errors.New("archive too short")
+	}
+	var blob [8]byte
+	if _, err := f.ReadAt(blob[:], stat.Size()-8); err != nil {
+		return nil, err
+	}
+	count := binary.LittleEndian.Uint64(blob[:])
+	if count == 0 || count > MaxSize {
+		return nil, fmt.Errorf("invalid block count %d", count)
+	}
+	off := stat.Size() - int64(headerSize+16+8*count)
+	if off < 0 {
+		return nil, errors.New("archive too short")
+	}
+	entry, _, err := r.ReadTypeAt(TypeBlockIndex, off)
+	if err != nil {
+		return nil, err
+	}
+	e := &Era{
+		f:       f,
+		r:       r,
+		start:   binary.LittleEndian.Uint64(entry.Value),
+		offsets: make([]uint64, count),
+	}
+	for i := range e.offsets {
+		e.offsets[i] = binary.LittleEndian.Uint64(entry.Value[8+8*i:])
+	}
+	return e, nil
+}
+
+// Close closes the archive file.
+func (e *Era) Close() error {
+	return e.f.Close()
+}
+
+// Start returns the number of the first block in the archive.
+func (e *Era) Start() uint64 {
+	return e.start
+}
+
+// Count returns the number of blocks in the archive.
+func (e *Era) Count() uint64 {
+	return uint64(len(e.offsets))
+}
+
+// GetBlockByNumber returns the block with the given number from the archive.
+func (e *Era) GetBlockByNumber(number uint64) (*types.Block, error) {
+	off, err := e.offset(number)
+	if err != nil {
+		return nil, err
+	}
+	var header types.Header
+	n, err := e.readCompressed(TypeCompressedHeader, off, &header)
+	if err != nil {
+		return nil, err
+	}
+	var body types.Body
+	if _, err := e.readCompressed(TypeCompressedBody, off+n, &body); err != nil {
+		return nil, err
+	}
+	return types.NewBlockWithHeader(&header).WithBody(body.Transactions, body.Uncles), nil
+}
+
+// GetReceipts returns the receipts of the block with the given number.
+func (e *Era) GetReceipts(number uint64) (types.Receipts, error) {
+	off, err := e.offset(number)
+	if err != nil {
+		return nil, err
+	}
+	for _, typ := range []uint16{TypeCompressedHeader, TypeCompressedBody} {
+		_, n, err := e.r.ReadTypeAt(typ, off)
+		if err != nil {
+			return nil, err
+		}
+		off += n
+	}
+	var storage []*types.ReceiptForStorage
+	if _, err := e.readCompressed(TypeCompressedReceipts, off, &storage); err != nil {
+		return nil, err
+	}
+	receipts := make(types.Receipts, len(storage))
+	for i, receipt := range storage {
+		receipts[i] = (*types.Receipt)(receipt)
+	}
+	return receipts, nil
+}
+
+// GetTD returns the total difficulty of the block with the given number.
+func (e *Era) GetTD(number uint64) (*big.Int, error) {
+	off, err := e.offset(number)
+	if err != nil {
+		return nil, err
+	}
+	for _, typ := range []uint16{TypeCompressedHeader, TypeCompressedBody, TypeCompressedReceipts} {
+		_, n, err := e.r.ReadTypeAt(typ, off)
+		if err != nil {
+			return nil, err
+		}
+		off += n
+	}
+	entry, _, err := e.r.ReadTypeAt(TypeTotalDifficulty, off)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(entry.Value), nil
+}
+
+// Accumulator returns the accumulator root stored in the archive.
+func (e *Era) Accumulator() (common.Hash, error) {
+	// The accumulator directly follows the total difficulty of the last block
+	last := e.start + e.Count() - 1
+	off, err := e.offset(last)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	for _, typ := range []uint16{TypeCompressedHeader, TypeCompressedBody, TypeCompressedReceipts, TypeTotalDifficulty} {
+		_, n, err := e.r.ReadTypeAt(typ, off)
+		if err != nil {
+			return common.Hash{}, err
+		}
+		off += n
+	}
+	entry, _, err := e.r.ReadTypeAt(TypeAccumulator, off)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return common.BytesToHash(entry.Value), nil
+}
+
+// Verify checks the integrity of the archive: the blocks must be linked, their
+// bodies and receipts must match the headers and the header records must
+// match the accumulator. The verified accumulator root is returned.
+func (e *Era) Verify() (common.Hash, error) {
+	var (
+		hashes = make([]common.Hash, 0, e.Count())
+		tds    = make([]*big.Int, 0, e.Count())
+		parent common.Hash
+	)
+	for number := e.start; number < e.start+e.Count(); number++ {
+		block, err := e.GetBlockByNumber(number)
+		if err != nil {
+			return common.Hash{}, fmt.Errorf("block #%d: %v", number, err)
+		}
+		if block.NumberU64() != number {
+			return common.Hash{}, fmt.Errorf("block #%d: number mismatch %d", number, block.NumberU64())
+		}
+		if number > e.start && block.ParentHash() != parent {
+			return common.Hash{}, fmt.Errorf("block #%d: parent hash mismatch", number)
+		}
+		if hash := types.DeriveSha(block.Transactions()); hash != block.TxHash() {
+			return common.Hash{}, fmt.Errorf("block #%d: transaction root mismatch", number)
+		}
+		if hash := types.CalcUncleHash(block.Uncles()); hash != block.UncleHash() {
+			return common.Hash{}, fmt.Errorf("block #%d: uncle hash mismatch", number)
+		}
+		receipts, err := e.GetReceipts(number)
+		if err != nil {
+			return common.Hash{}, fmt.Errorf("block #%d: %v", number, err)
+		}
+		if hash := types.DeriveSha(receipts); hash != block.ReceiptHash() {
+			return common.Hash{}, fmt.Errorf("block #%d: receipt root mismatch", number)
+		}
+		td, err := e.GetTD(number)
+		if err != nil {
+			return common.Hash{}, fmt.Errorf("block #%d: %v", number, err)
+		}
+		parent = block.Hash()
+		hashes, tds = append(hashes, parent), append(tds, td)
+	}
+	want, err := e.Accumulator()
+	if err != nil {
+		return common.Hash{}, err
+	}
+	have, err := ComputeAccumulator(hashes, tds)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	if have != want {
+		return common.Hash{}, fmt.Errorf("accumulator mismatch: have %x, want %x", have, want)
+	}
+	return have, nil
+}
+
+// offset returns the offset of the entries of the given block.
+func (e *Era) offset(number uint64) (int64, error) {
+	if number < e.start || number >= e.start+e.Count() {
+		return 0, fmt.Errorf("block #%d out of range [%d, %d)", number, e.start, e.start+e.Count())
+	}
+	return int64(e.offsets[number-e.start]), nil
+}
+
+// readCompressed decodes the snappy compressed RLP entry of the given type at
+// the offset into val, returning the size of the entry.
+func (e *Era) readCompressed(typ uint16, off int64, val interface{}) (int64, error) {
+	entry, n, err := e.r.ReadTypeAt(typ, off)
+	if err != nil {
+		return 0, err
+	}
+	blob, err := snappy.Decode(nil, entry.Value)
+	if err != nil {
+		return 0, err
+	}
+	if err := rlp.Decode(bytes.NewReader(blob), val); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
@@ -0,0 +1,173 @@
+// Copyright 2023 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package era
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/BerithFoundation/berith-chain/common"
+	"github.com/BerithFoundation/berith-chain/core/types"
+)
+
+// makeBlocks creates a linked chain of blocks carrying one transaction each.
+func makeBlocks(start uint64, count int) ([]*types.Block, []types.Receipts, []*big.Int) {
+	var (
+		blocks   []*types.Block
+		receipts []types.Receipts
+		tds      []*big.Int
+		parent   common.Hash
+		td       = big.NewInt(0)
+	)
+	for i := 0; i < count; i++ {
+		number := start + uint64(i)
+		tx := types.NewTransaction(number, common.Address{0x01}, big.NewInt(1), 21000, big.NewInt(1), nil, types.Main, types.Main, false)
+		receipt := types.NewReceipt(nil, false, 21000*(number+1))
+		receipt.Logs = []*types.Log{}
+		receipt.Bloom = types.CreateBloom(types.Receipts{receipt})
+
+		header := &types.Header{ParentHash: parent, Number: new(big.Int).SetUint64(number), Difficulty: big.NewInt(2)}
+		block := types.NewBlock(header, []*types.Transaction{tx}, nil, []*types.Receipt{receipt})
+		td = new(big.Int).Add(td, block.Difficulty())
+
+		blocks, receipts, tds = append(blocks, block), append(receipts, types.Receipts{receipt}), append(tds, td)
+		parent = block.Hash()
+	}
+	return blocks, receipts, tds
+}
+
+// writeArchive builds an archive of the given blocks into a file in dir.
+func writeArchive(t *testing.T, dir string, blocks []*types.Block, receipts []types.Receipts, tds []*big.Int) (string, common.Hash) {
+	path := filepath.Join(dir, "test.era")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	builder := NewBuilder(f)
+	for i, block := range blocks {
+		if err := builder.Add(block, receipts[i], tds[i]); err != nil {
+			t.Fatalf("failed to add block %d: %v", i, err)
+		}
+	}
+	root, err := builder.Finalize()
+	if err != nil {
+		t.Fatalf("failed to finalize archive: %v", err)
+	}
+	return path, root
+}
+
+// Tests that the archived blocks, receipts and difficulties can be read back
+// and verified against the accumulator.
+func TestArchiveRoundtrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "era")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	blocks, receipts, tds := makeBlocks(100, 16)
+	path, root := writeArchive(t, dir, blocks, receipts, tds)
+
+	e, err := Open(path)
+	if err != nil {
+		t.Fatalf("failed to open archive: %v", err)
+	}
+	defer e.Close()
+
+	if e.Start() != 100 || e.Count() != 16 {
+		t.Fatalf("range mismatch: have [%d, +%d], want [100, +16]", e.Start(), e.Count())
+	}
+	for i, want := range blocks {
+		number := want.NumberU64()
+		block, err := e.GetBlockByNumber(number)
+		if err != nil {
+			t.Fatalf("block %d: %v", number, err)
+		}
+		if block.Hash() != want.Hash() || len(block.Transactions()) != 1 || block.Transactions()[0].Hash() != want.Transactions()[0].Hash() {
+			t.Fatalf("block %d: content mismatch", number)
+		}
+		rs, err := e.GetReceipts(number)
+		if err != nil {
+			t.Fatalf("receipts %d: %v", number, err)
+		}
+		if types.DeriveSha(rs) != want.ReceiptHash() {
+			t.Fatalf("receipts %d: root mismatch", number)
+		}
+		td, err := e.GetTD(number)
+		if err != nil || td.Cmp(tds[i]) != 0 {
+			t.Fatalf("td %d: have %v (%v), want %v", number, td, err, tds[i])
+		}
+	}
+	if _, err := e.GetBlockByNumber(99); err == nil {
+		t.Fatalf("block out of range retrieved")
+	}
+	if have, err := e.Verify(); err != nil || have != root {
+		t.Fatalf("verification failed: have %x (%v), want %x", have, err, root)
+	}
+}
+
+// Tests that an archive with tampered header records fails verification.
+func TestArchiveTampering(t *testing.T) {
+	dir, err := ioutil.TempDir("", "era")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	blocks, receipts, tds := makeBlocks(0, 8)
+	tds[3] = new(big.Int).Add(tds[3], common.Big1)
+
+	builder := NewBuilder(ioutil.Discard)
+	for i, block := range blocks {
+		builder.Add(block, receipts[i], tds[i])
+	}
+	forged, _ := builder.Finalize()
+
+	// Write the honest archive, then swap in the accumulator of the forged one
+	blocks, receipts, tds = makeBlocks(0, 8)
+	path, _ := writeArchive(t, dir, blocks, receipts, tds)
+
+	e, err := Open(path)
+	if err != nil {
+		t.Fatalf("failed to open archive: %v", err)
+	}
+	defer e.Close()
+
+	blob, _ := ioutil.ReadFile(path)
+	original, _ := e.Accumulator()
+	for i := 0; i+common.HashLength <= len(blob); i++ {
+		if common.BytesToHash(blob[i:i+common.HashLength]) == original {
+			copy(blob[i:], forged[:])
+			break
+		}
+	}
+	ioutil.WriteFile(path, blob, 0644)
+	if _, err := e.Verify(); err == nil {
+		t.Fatalf("forged accumulator accepted")
+	}
+	// Adding blocks out of order must be refused
+	builder = NewBuilder(ioutil.Discard)
+	builder.Add(blocks[0], receipts[0], tds[0])
+	if err := builder.Add(blocks[2], receipts[2], tds[2]); err == nil {
+		t.Fatalf("non contiguous block accepted")
+	}
+}
@@ -0,0 +1,257 @@
+// Copyright 2023 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package era
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BerithFoundation/berith-chain/common"
+	"github.com/BerithFoundation/berith-chain/core"
+	"github.com/BerithFoundation/berith-chain/core/types"
+	"github.com/BerithFoundation/berith-chain/log"
+)
+
+const (
+	// ChecksumsFile is the name of the file listing the SHA-256 checksums of
+	// the archive files of a directory, in the format of sha256sum.
+	ChecksumsFile = "checksums.txt"
+
+	// importBatchSize is the number of blocks inserted into the chain at once.
+	importBatchSize = 2500
+)
+
+// Export writes the canonical blocks [first, last] of the chain into archive
+// files in dir, one per MaxSize blocks epoch, and lists their checksums in the
+// checksums file of the directory.
+func Export(chain *core.BlockChain, dir string, first, last uint64) error {
+	if first > last {
+		return fmt.Errorf("invalid range [%d, %d]", first, last)
+	}
+	if head := chain.CurrentBlock().NumberU64(); last > head {
+		return fmt.Errorf("last block #%d beyond chain head #%d", last, head)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	var (
+		checksums []string
+		start     = time.Now()
+	)
+	for epoch := first / MaxSize; epoch <= last/MaxSize; epoch++ {
+		from, to := epoch*MaxSize, (epoch+1)*MaxSize-1
+		if from < first {
+			from = first
+		}
+		if to > last {
+			to = last
+		}
+		name, checksum, err := exportEpoch(chain, dir, epoch, from, to)
+		if err != nil {
+			return err
+		}
+		checksums = append(checksums, fmt.Sprintf("%s  %s", checksum, name))
+		log.Info("Exported chain archive", "file", name, "first", from, "last", to, "elapsed", common.PrettyDuration(time.Since(start)))
+	}
+	return ioutil.WriteFile(filepath.Join(dir, ChecksumsFile), []byte(strings.Join(checksums, "\n")+"\n"), 0644)
+}
+
+// exportEpoch writes the blocks [from, to] into the archive file of the epoch,
+// returning the name of the file and its checksum.
+func exportEpoch(chain *core.BlockChain, dir string, epoch, from, to uint64) (string, string, error) {
+	tmp := filepath.Join(dir, fmt.Sprintf("berith-%05d.era.tmp", epoch))
+	f, err := os.Create(tmp)
+	if err != nil {
+		return "", "", err
+	}
+	defer os.Remove(tmp)
+	defer f.Close()
+
+	var (
+		hasher  = sha256.New()
+		writer  = bufio.NewWriter(io.MultiWriter(f, hasher))
+		builder = NewBuilder(writer)
+	)
+	for number := from; number <= to; number++ {
+		block := chain.GetBlockByNumber(number)
+		if block == nil {
+			return "", "", fmt.Errorf("block #%d not found", number)
+		}
+		td := chain.GetTd(block.Hash(), number)
+		if td == nil {
+			return "", "", fmt.Errorf("total difficulty of block #%d not found", number)
+		}
+		if err := builder.Add(block, chain.GetReceiptsByHash(block.Hash()), td); err != nil {
+			return "", "", err
+		}
+	}
+	root, err := builder.Finalize()
+	if err != nil {
+		return "", "", err
+	}
+	if err := writer.Flush(); err != nil {
+		return "", "", err
+	}
+	if err := f.Sync(); err != nil {
+		return "", "", err
+	}
+	name := Filename(epoch, root)
+	if err := os.Rename(tmp, filepath.Join(dir, name)); err != nil {
+		return "", "", err
+	}
+	return name, hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// Import verifies the archive files listed in the checksums file of dir and
+// inserts their blocks into the chain. The blocks are fully executed, so the
+// state and the staking database of the consensus engine are rebuilt along.
+func Import(chain *core.BlockChain, dir string) error {
+	entries, err := readChecksums(filepath.Join(dir, ChecksumsFile))
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.name)
+		if err := verifyChecksum(path, entry.checksum); err != nil {
+			return err
+		}
+		if err := importFile(chain, path); err != nil {
+			return fmt.Errorf("%s: %v", entry.name, err)
+		}
+		log.Info("Imported chain archive", "file", entry.name, "head", chain.CurrentBlock().NumberU64(), "elapsed", common.PrettyDuration(time.Since(start)))
+	}
+	return nil
+}
+
+// importFile verifies a single archive file and inserts its blocks.
+func importFile(chain *core.BlockChain, path string) error {
+	e, err := Open(path)
+	if err != nil {
+		return err
+	}
+	defer e.Close()
+
+	root, err := e.Verify()
+	if err != nil {
+		return err
+	}
+	if name := Filename(e.Start()/MaxSize, root); name != filepath.Base(path) {
+		return fmt.Errorf("accumulator root %x does not match file name", root)
+	}
+	blocks := make([]*types.Block, 0, importBatchSize)
+	for number := e.Start(); number < e.Start()+e.Count(); number++ {
+		block, err := e.GetBlockByNumber(number)
+		if err != nil {
+			return err
+		}
+		// The genesis is never imported, but it must be ours
+		if number == 0 {
+			if block.Hash() != chain.Genesis().Hash() {
+				return fmt.Errorf("genesis mismatch: have %x, want %x", block.Hash(), chain.Genesis().Hash())
+			}
+			continue
+		}
+		if chain.HasBlockAndState(block.Hash(), number) {
+			continue
+		}
+		blocks = append(blocks, block)
+		if len(blocks) == cap(blocks) {
+			if err := insertBatch(chain, e, blocks); err != nil {
+				return err
+			}
+			blocks = blocks[:0]
+		}
+	}
+	return insertBatch(chain, e, blocks)
+}
+
+// insertBatch inserts the blocks into the chain and checks that the resulting
+// total difficulty matches the archived one.
+func insertBatch(chain *core.BlockChain, e *Era, blocks []*types.Block) error {
+	if len(blocks) == 0 {
+		return nil
+	}
+	if _, err := chain.InsertChain(blocks); err != nil {
+		return err
+	}
+	last := blocks[len(blocks)-1]
+	want, err := e.GetTD(last.NumberU64())
+	if err != nil {
+		return err
+	}
+	if have := chain.GetTd(last.Hash(), last.NumberU64()); have == nil || have.Cmp(want) != 0 {
+		return fmt.Errorf("block #%d: total difficulty mismatch: have %v, want %v", last.NumberU64(), have, want)
+	}
+	return nil
+}
+
+// checksumEntry is a line of the checksums file.
+type checksumEntry struct {
+	checksum string
+	name     string
+}
+
+// readChecksums parses the checksums file.
+func readChecksums(path string) ([]checksumEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []checksumEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 || filepath.Base(fields[1]) != fields[1] {
+			return nil, fmt.Errorf("invalid checksum line %q", line)
+		}
+		entries = append(entries, checksumEntry{checksum: strings.ToLower(fields[0]), name: fields[1]})
+	}
+	return entries, scanner.Err()
+}
+
+// verifyChecksum checks the SHA-256 checksum of the file at path.
+func verifyChecksum(path string, want string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return err
+	}
+	if have := hex.EncodeToString(hasher.Sum(nil)); have != want {
+		return fmt.Errorf("%s: checksum mismatch: have %s, want %s", filepath.Base(path), have, want)
+	}
+	return nil
+}
@@ -186,6 +186,16 @@ web3._extend({
 			call: 'admin_importChain',
 			params: 1
 		}),
+		new web3._extend.Method({
+			name: 'exportHistory',
+			call: 'admin_exportHistory',
+			params: 3
+		}),
+		new web3._extend.Method({
+			name: 'importHistory',
+			call: 'admin_importHistory',
+			params: 1
+		}),
 		new web3._extend.Method({
 			name: 'sleepBlocks',
 			call: 'admin_sleepBlocks',
@@ -507,6 +517,12 @@ web3._extend({
 			params: 3,
 			inputFormatter: [web3._extend.formatters.inputAddressFormatter, null, web3._extend.formatters.inputBlockNumberFormatter]
 		}),
+		new web3._extend.Method({
+			name: 'getSupply',
+			call: 'berith_getSupply',
+			params: 1,
+			inputFormatter: [web3._extend.formatters.inputDefaultBlockNumberFormatter]
+		}),
 		new web3._extend.Method({
 			name: 'stake',
 			call: 'berith_stake',
@@ -519,6 +535,57 @@ web3._extend({
 			params: 1,
 			inputFormatter: [web3._extend.formatters.inputTransactionFormatter]
 		}),
+		new web3._extend.Method({
+			name: 'sendPrivateTransaction',
+			call: 'berith_sendPrivateTransaction',
+			params: 1
+		}),
+		new web3._extend.Method({
+			name: 'sendBatch',
+			call: 'berith_sendBatch',
+			params: 1
+		}),
+		new web3._extend.Method({
+			name: 'scheduleTransaction',
+			call: 'berith_scheduleTransaction',
+			params: 2,
+			inputFormatter: [web3._extend.formatters.inputTransactionFormatter, web3._extend.utils.fromDecimal]
+		}),
+		new web3._extend.Method({
+			name: 'signSponsoredTransaction',
+			call: 'berith_signSponsoredTransaction',
+			params: 2,
+			inputFormatter: [web3._extend.formatters.inputTransactionFormatter, web3._extend.formatters.inputAddressFormatter]
+		}),
+		new web3._extend.Method({
+			name: 'sponsorTransaction',
+			call: 'berith_sponsorTransaction',
+			params: 1
+		}),
+		new web3._extend.Method({
+			name: 'registerMultisig',
+			call: 'berith_registerMultisig',
+			params: 3,
+			inputFormatter: [web3._extend.formatters.inputTransactionFormatter, null, web3._extend.utils.fromDecimal]
+		}),
+		new web3._extend.Method({
+			name: 'getMultisig',
+			call: 'berith_getMultisig',
+			params: 2,
+			inputFormatter: [web3._extend.formatters.inputAddressFormatter, web3._extend.formatters.inputDefaultBlockNumberFormatter]
+		}),
+		new web3._extend.Method({
+			name: 'signMultisigTransaction',
+			call: 'berith_signMultisigTransaction',
+			params: 2,
+			inputFormatter: [web3._extend.formatters.inputTransactionFormatter, web3._extend.formatters.inputAddressFormatter]
+		}),
+		new web3._extend.Method({
+			name: 'cosignMultisigTransaction',
+			call: 'berith_cosignMultisigTransaction',
+			params: 2,
+			inputFormatter: [null, web3._extend.formatters.inputAddressFormatter]
+		}),
 		new web3._extend.Method({
 			name: 'getStakeBalance',
 			call: 'berith_getStakeBalance',
@@ -547,6 +614,10 @@ web3._extend({
 		}),
 	],
 	properties: [
+		new web3._extend.Property({
+			name: 'scheduledTransactions',
+			getter: 'berith_scheduledTransactions'
+		}),
 		new web3._extend.Property({
 			name: 'pendingTransactions',
 			getter: 'berith_pendingTransactions',
@@ -92,7 +92,7 @@ func New(ctx *node.ServiceContext, config *berith.Config) (*LightBerith, error)
 	peers := newPeerSet()
 	quitSync := make(chan struct{})
 
-	stakingDB := &staking.StakingDB{NoPruning: config.NoPruning}
+	stakingDB := &staking.StakingDB{NoPruning: config.NoPruning, Engine: ctx.DatabaseEngine()}
 	stakingDBPath := ctx.ResolvePath("stakingDB")
 	if stkErr := stakingDB.CreateDB(stakingDBPath, staking.NewStakers); stkErr != nil {
 		return nil, stkErr
@@ -162,6 +162,8 @@ func lesTopic(genesisHash common.Hash, protocolVersion uint) discv5.Topic {
 		name = "LES"
 	case lpv2:
 		name = "LES2"
+	case lpv3:
+		name = "LES3"
 	default:
 		panic(nil)
 	}
@@ -201,11 +201,26 @@ func (f *lightFetcher) syncLoop() {
 				f.pm.serverPool.adjustResponseTime(req.peer.poolEntry, time.Duration(mclock.Now()-req.sent), req.timeout)
 			}
 			f.lock.Lock()
-			if !ok || !(f.syncing || f.processResponse(req, resp)) {
+			syncing := f.syncing
+			f.lock.Unlock()
+
+			switch {
+			case !ok:
 				resp.peer.Log().Debug("Failed processing response")
 				go f.pm.removePeer(resp.peer.id)
+			case !syncing:
+				// [BERITH] Inserting the headers retrieves the staker sets of their
+				// creators through the ODR, which needs the fetcher lock. Process
+				// them in the background not to hold up the fetcher.
+				f.pm.wg.Add(1)
+				go func() {
+					defer f.pm.wg.Done()
+					if !f.processResponse(req, resp) {
+						resp.peer.Log().Debug("Failed processing response")
+						f.pm.removePeer(resp.peer.id)
+					}
+				}()
 			}
-			f.lock.Unlock()
 		case p := <-f.syncDone:
 			f.lock.Lock()
 			p.Log().Debug("Done synchronising with peer")
@@ -510,7 +525,8 @@ func (f *lightFetcher) deliverHeaders(peer *peer, reqID uint64, headers []*types
 	f.deliverChn <- fetchResponse{reqID: reqID, headers: headers, peer: peer}
 }
 
-// processResponse processes header download request responses, returns true if successful
+// processResponse processes header download request responses, returns true if successful.
+// [BERITH] It must be called without holding the fetcher lock.
 func (f *lightFetcher) processResponse(req fetchRequest, resp fetchResponse) bool {
 	if uint64(len(resp.headers)) != req.amount || resp.headers[0].Hash() != req.hash {
 		req.peer.Log().Debug("Response content mismatch", "requested", len(resp.headers), "reqfrom", resp.headers[0], "delivered", req.amount, "delfrom", req.hash)
@@ -536,7 +552,9 @@ func (f *lightFetcher) processResponse(req fetchRequest, resp fetchResponse) boo
 		}
 		tds[i] = td
 	}
+	f.lock.Lock()
 	f.newHeaders(headers, tds)
+	f.lock.Unlock()
 	return true
 }
 
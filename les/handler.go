@@ -35,6 +35,7 @@ import (
 	"github.com/BerithFoundation/berith-chain/core/rawdb"
 	"github.com/BerithFoundation/berith-chain/core/state"
 	"github.com/BerithFoundation/berith-chain/core/types"
+	"github.com/BerithFoundation/berith-chain/crypto"
 	"github.com/BerithFoundation/berith-chain/event"
 	"github.com/BerithFoundation/berith-chain/light"
 	"github.com/BerithFoundation/berith-chain/log"
@@ -57,6 +58,7 @@ const (
 	MaxCodeFetch             = 64  // Amount of contract codes to allow fetching per request
 	MaxProofsFetch           = 64  // Amount of merkle proofs to be fetched per retrieval request
 	MaxHelperTrieProofsFetch = 64  // Amount of merkle proofs to be fetched per retrieval request
+	MaxStakersProofsFetch    = 16  // Amount of staker sets to be fetched per retrieval request
 	MaxTxSend                = 64  // Amount of transactions to be send per request
 	MaxTxStatus              = 256 // Amount of transactions to queried per request
 
@@ -83,6 +85,12 @@ type BlockChain interface {
 	SubscribeChainHeadEvent(ch chan<- core.ChainHeadEvent) event.Subscription
 }
 
+// [BERITH] stakersReader is implemented by the server chains able to serve the
+// staker sets of the blocks
+type stakersReader interface {
+	Stakers(hash common.Hash) ([]common.Address, error)
+}
+
 type txPool interface {
 	AddRemotes(txs []*types.Transaction) []error
 	Status(hashes []common.Hash) []core.TxStatus
@@ -324,7 +332,7 @@ func (pm *ProtocolManager) handle(p *peer) error {
 	}
 }
 
-var reqList = []uint64{GetBlockHeadersMsg, GetBlockBodiesMsg, GetCodeMsg, GetReceiptsMsg, GetProofsV1Msg, SendTxMsg, SendTxV2Msg, GetTxStatusMsg, GetHeaderProofsMsg, GetProofsV2Msg, GetHelperTrieProofsMsg}
+var reqList = []uint64{GetBlockHeadersMsg, GetBlockBodiesMsg, GetCodeMsg, GetReceiptsMsg, GetProofsV1Msg, SendTxMsg, SendTxV2Msg, GetTxStatusMsg, GetHeaderProofsMsg, GetProofsV2Msg, GetHelperTrieProofsMsg, GetStakersProofsMsg}
 
 // handleMsg is invoked whenever an inbound message is received from a remote
 // peer. The remote connection is torn down upon returning any error.
@@ -1063,6 +1071,81 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 
 		return p.SendTxStatus(req.ReqID, bv, stats)
 
+	case GetStakersProofsMsg:
+		p.Log().Trace("Received staker set proof request")
+		// Decode the retrieval message
+		var req struct {
+			ReqID uint64
+			Reqs  []StakersReq
+		}
+		if err := msg.Decode(&req); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		reader, ok := pm.blockchain.(stakersReader)
+		if !ok {
+			return errResp(ErrUnexpectedResponse, "")
+		}
+		// Gather staker sets until the fetch or network limits is reached
+		var resp StakersResps
+		reqCnt := len(req.Reqs)
+		if reject(uint64(reqCnt), MaxStakersProofsFetch) {
+			return errResp(ErrRequestRejected, "")
+		}
+		nodes := light.NewNodeSet()
+
+		for _, req := range req.Reqs {
+			header := pm.blockchain.GetHeaderByHash(req.BHash)
+			if header == nil {
+				continue
+			}
+			stakers, err := reader.Stakers(req.BHash)
+			if err != nil {
+				continue
+			}
+			statedb, err := pm.blockchain.State()
+			if err != nil {
+				continue
+			}
+			trie, err := statedb.Database().OpenTrie(header.Root)
+			if err != nil {
+				continue
+			}
+			// Prove the accounts of the stakers from the state trie of the block
+			for _, addr := range stakers {
+				trie.Prove(crypto.Keccak256(addr[:]), 0, nodes)
+			}
+			resp.Stakers = append(resp.Stakers, stakers)
+			if nodes.DataSize() >= softResponseLimit {
+				break
+			}
+		}
+		resp.Proofs = nodes.NodeList()
+
+		bv, rcost := p.fcClient.RequestProcessed(costs.baseCost + uint64(reqCnt)*costs.reqCost)
+		pm.server.fcCostStats.update(msg.Code, uint64(reqCnt), rcost)
+		return p.SendStakersProofs(req.ReqID, bv, resp)
+
+	case StakersProofsMsg:
+		if pm.odr == nil {
+			return errResp(ErrUnexpectedResponse, "")
+		}
+
+		p.Log().Trace("Received staker set proof response")
+		var resp struct {
+			ReqID, BV uint64
+			Data      StakersResps
+		}
+		if err := msg.Decode(&resp); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+
+		p.fcServer.GotReply(resp.ReqID, resp.BV)
+		deliverMsg = &Msg{
+			MsgType: MsgStakersProofs,
+			ReqID:   resp.ReqID,
+			Obj:     resp.Data,
+		}
+
 	case GetTxStatusMsg:
 		if pm.txpool == nil {
 			return errResp(ErrUnexpectedResponse, "")
@@ -88,6 +88,7 @@ const (
 	MsgProofsV2
 	MsgHeaderProofs
 	MsgHelperTrieProofs
+	MsgStakersProofs
 )
 
 // Msg encodes a LES message that delivers reply data for a request
@@ -119,7 +120,17 @@ func (odr *LesOdr) Retrieve(ctx context.Context, req light.OdrRequest) (err erro
 		},
 	}
 
-	if err = odr.retriever.retrieve(ctx, reqID, rq, func(p distPeer, msg *Msg) error { return lreq.Validate(odr.db, msg) }, odr.stop); err == nil {
+	validate := func(p distPeer, msg *Msg) error {
+		if err := lreq.Validate(odr.db, msg); err != nil {
+			return err
+		}
+		// [BERITH] Staker sets are cross-checked by distinct servers
+		if r, ok := req.(*light.StakersRequest); ok {
+			r.Server = p.(*peer).id
+		}
+		return nil
+	}
+	if err = odr.retriever.retrieve(ctx, reqID, rq, validate, odr.stop); err == nil {
 		// retrieved from network, store in db
 		req.StoreResult(odr.db)
 	} else {
@@ -45,6 +45,7 @@ var (
 	errCHTHashMismatch     = errors.New("cht hash mismatch")
 	errCHTNumberMismatch   = errors.New("cht number mismatch")
 	errUselessNodes        = errors.New("useless nodes in merkle proof nodeset")
+	errStakerNotProven     = errors.New("staker account missing from proof")
 )
 
 type LesOdrRequest interface {
@@ -68,6 +69,8 @@ func LesRequest(req light.OdrRequest) LesOdrRequest {
 		return (*ChtRequest)(r)
 	case *light.BloomRequest:
 		return (*BloomRequest)(r)
+	case *light.StakersRequest:
+		return (*StakersRequest)(r)
 	default:
 		return nil
 	}
@@ -193,7 +196,7 @@ func (r *TrieRequest) GetCost(peer *peer) uint64 {
 	switch peer.version {
 	case lpv1:
 		return peer.GetRequestCost(GetProofsV1Msg, 1)
-	case lpv2:
+	case lpv2, lpv3:
 		return peer.GetRequestCost(GetProofsV2Msg, 1)
 	default:
 		panic(nil)
@@ -353,7 +356,7 @@ func (r *ChtRequest) GetCost(peer *peer) uint64 {
 	switch peer.version {
 	case lpv1:
 		return peer.GetRequestCost(GetHeaderProofsMsg, 1)
-	case lpv2:
+	case lpv2, lpv3:
 		return peer.GetRequestCost(GetHelperTrieProofsMsg, 1)
 	default:
 		panic(nil)
@@ -389,7 +392,7 @@ func (r *ChtRequest) Request(reqID uint64, peer *peer) error {
 		// convert HelperTrie request to old CHT request
 		reqsV1 = ChtReq{ChtNum: (req.TrieIdx + 1) * (r.Config.ChtSize / r.Config.PairChtSize), BlockNum: blockNum, FromLevel: req.FromLevel}
 		return peer.RequestHelperTrieProofs(reqID, r.GetCost(peer), []ChtReq{reqsV1})
-	case lpv2:
+	case lpv2, lpv3:
 		return peer.RequestHelperTrieProofs(reqID, r.GetCost(peer), []HelperTrieReq{req})
 	default:
 		panic(nil)
@@ -557,6 +560,78 @@ func (r *BloomRequest) Validate(db berithdb.Database, msg *Msg) error {
 	return nil
 }
 
+// [BERITH] StakersReq is a request for the staker set of a block
+type StakersReq struct {
+	BHash common.Hash
+}
+
+// [BERITH] StakersResps describes all responses to a staker set request, the
+// accounts of the stakers being proven by a single node set
+type StakersResps struct {
+	Stakers [][]common.Address
+	Proofs  light.NodeList
+}
+
+// [BERITH] ODR request type for the staker set of a block, see LesOdrRequest interface
+type StakersRequest light.StakersRequest
+
+// GetCost returns the cost of the given ODR request according to the serving
+// peer's cost table (implementation of LesOdrRequest)
+func (r *StakersRequest) GetCost(peer *peer) uint64 {
+	return peer.GetRequestCost(GetStakersProofsMsg, 1)
+}
+
+// CanSend tells if a certain peer is suitable for serving the given request
+func (r *StakersRequest) CanSend(peer *peer) bool {
+	if peer.version < lpv3 || r.Exclude[peer.id] {
+		return false
+	}
+	// The states of the stake target blocks are kept by the servers for the
+	// verification of the block creators, even beyond serverStateAvailable
+	return peer.HasBlock(r.Header.Hash(), r.Header.Number.Uint64(), false)
+}
+
+// Request sends an ODR request to the LES network (implementation of LesOdrRequest)
+func (r *StakersRequest) Request(reqID uint64, peer *peer) error {
+	peer.Log().Debug("Requesting staker set", "number", r.Header.Number, "hash", r.Header.Hash())
+	return peer.RequestStakersProofs(reqID, r.GetCost(peer), []StakersReq{{BHash: r.Header.Hash()}})
+}
+
+// Valid processes an ODR request reply message from the LES network
+// returns true and stores results in memory if the message was a valid reply
+// to the request (implementation of LesOdrRequest)
+func (r *StakersRequest) Validate(db berithdb.Database, msg *Msg) error {
+	log.Debug("Validating staker set", "number", r.Header.Number, "hash", r.Header.Hash())
+
+	// Ensure we have a correct message with a single staker set
+	if msg.MsgType != MsgStakersProofs {
+		return errInvalidMessageType
+	}
+	resps := msg.Obj.(StakersResps)
+	if len(resps.Stakers) != 1 {
+		return errInvalidEntryCount
+	}
+	nodeSet := resps.Proofs.NodeSet()
+	reads := &readTraceDB{db: nodeSet}
+
+	// Verify that the account of every staker is proven
+	for _, addr := range resps.Stakers[0] {
+		value, _, err := trie.VerifyProof(r.Header.Root, crypto.Keccak256(addr[:]), reads)
+		if err != nil {
+			return fmt.Errorf("merkle proof verification failed: %v", err)
+		}
+		if value == nil {
+			return errStakerNotProven
+		}
+	}
+	if len(reads.reads) != nodeSet.KeyCount() {
+		return errUselessNodes
+	}
+	r.Stakers = resps.Stakers[0]
+	r.Proof = nodeSet
+	return nil
+}
+
 // readTraceDB stores the keys of database reads. We use this to check that received node
 // sets contain only the trie nodes necessary to make proofs pass.
 type readTraceDB struct {
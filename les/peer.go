@@ -227,6 +227,12 @@ func (p *peer) SendHelperTrieProofs(reqID, bv uint64, resp HelperTrieResps) erro
 	return sendResponse(p.rw, HelperTrieProofsMsg, reqID, bv, resp)
 }
 
+// [BERITH] SendStakersProofs sends a batch of staker sets along with the proofs
+// of the staker accounts, corresponding to the ones requested.
+func (p *peer) SendStakersProofs(reqID, bv uint64, resp StakersResps) error {
+	return sendResponse(p.rw, StakersProofsMsg, reqID, bv, resp)
+}
+
 // SendTxStatus sends a batch of transaction status records, corresponding to the ones requested.
 func (p *peer) SendTxStatus(reqID, bv uint64, stats []txStatus) error {
 	return sendResponse(p.rw, TxStatusMsg, reqID, bv, stats)
@@ -272,7 +278,7 @@ func (p *peer) RequestProofs(reqID, cost uint64, reqs []ProofReq) error {
 	switch p.version {
 	case lpv1:
 		return sendRequest(p.rw, GetProofsV1Msg, reqID, cost, reqs)
-	case lpv2:
+	case lpv2, lpv3:
 		return sendRequest(p.rw, GetProofsV2Msg, reqID, cost, reqs)
 	default:
 		panic(nil)
@@ -289,7 +295,7 @@ func (p *peer) RequestHelperTrieProofs(reqID, cost uint64, data interface{}) err
 		}
 		p.Log().Debug("Fetching batch of header proofs", "count", len(reqs))
 		return sendRequest(p.rw, GetHeaderProofsMsg, reqID, cost, reqs)
-	case lpv2:
+	case lpv2, lpv3:
 		reqs, ok := data.([]HelperTrieReq)
 		if !ok {
 			return errInvalidHelpTrieReq
@@ -301,6 +307,13 @@ func (p *peer) RequestHelperTrieProofs(reqID, cost uint64, data interface{}) err
 	}
 }
 
+// [BERITH] RequestStakersProofs fetches a batch of staker sets along with the
+// proofs of the staker accounts from a remote node.
+func (p *peer) RequestStakersProofs(reqID, cost uint64, reqs []StakersReq) error {
+	p.Log().Debug("Fetching batch of staker sets", "count", len(reqs))
+	return sendRequest(p.rw, GetStakersProofsMsg, reqID, cost, reqs)
+}
+
 // RequestTxStatus fetches a batch of transaction status records from a remote node.
 func (p *peer) RequestTxStatus(reqID, cost uint64, txHashes []common.Hash) error {
 	p.Log().Debug("Requesting transaction status", "count", len(txHashes))
@@ -313,7 +326,7 @@ func (p *peer) SendTxs(reqID, cost uint64, txs types.Transactions) error {
 	switch p.version {
 	case lpv1:
 		return p2p.Send(p.rw, SendTxMsg, txs) // old message format does not include reqID
-	case lpv2:
+	case lpv2, lpv3:
 		return sendRequest(p.rw, SendTxV2Msg, reqID, cost, txs)
 	default:
 		panic(nil)
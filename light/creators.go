@@ -0,0 +1,186 @@
+/*
+[BERITH]
+Verification of the block creators of a light chain. The creator of every header
+is checked against the staker set and the state of its stake target block before
+the header is inserted, the staker sets being proven and cross-checked by several
+servers. Headers whose staker set can't be obtained are rejected.
+*/
+
+package light
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/BerithFoundation/berith-chain/common"
+	"github.com/BerithFoundation/berith-chain/consensus"
+	"github.com/BerithFoundation/berith-chain/core/types"
+	"github.com/BerithFoundation/berith-chain/log"
+)
+
+const (
+	stakersTimeout    = 5 * time.Second // Timeout for retrieving the staker sets of a header chain
+	stakersServers    = 2               // Number of distinct servers cross-checking each staker set
+	stakersFetchers   = 16              // Number of staker sets retrieved concurrently
+	stakersCacheLimit = 256             // Number of cross-checked staker sets kept in memory
+)
+
+// errNoStakeTarget is returned if the stake target block of a header is unknown.
+var errNoStakeTarget = errors.New("unknown stake target block")
+
+// verifyCreators checks the creators of the headers against the staker sets of
+// their stake target blocks, if the consensus engine supports it. Headers known
+// already are skipped. The index of the first rejected header is returned along
+// with the error.
+func (self *LightChain) verifyCreators(chain []*types.Header) (int, error) {
+	verifier, ok := self.engine.(consensus.LightVerifier)
+	if !ok {
+		return 0, nil
+	}
+	batch := newHeaderBatch(self, chain)
+
+	targets := make([]*types.Header, len(chain))
+	for i, header := range chain {
+		if self.HasHeader(header.Hash(), header.Number.Uint64()) {
+			continue
+		}
+		parent := batch.GetHeader(header.ParentHash, header.Number.Uint64()-1)
+		if parent == nil {
+			return i, consensus.ErrUnknownAncestor
+		}
+		if targets[i] = verifier.StakeTarget(batch, parent); targets[i] == nil {
+			return i, errNoStakeTarget
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), stakersTimeout)
+	defer cancel()
+
+	stakers, err := self.fetchStakers(ctx, targets)
+	if err != nil {
+		return 0, err
+	}
+	for i, header := range chain {
+		target := targets[i]
+		if target == nil {
+			continue
+		}
+		if target.Number.Sign() == 0 {
+			err = verifier.VerifyCreatorProof(batch, header, target, nil, nil)
+		} else {
+			err = verifier.VerifyCreatorProof(batch, header, target, stakers[target.Hash()], NewState(ctx, target, self.odr))
+		}
+		if err != nil {
+			log.Debug("Invalid block creator", "number", header.Number, "hash", header.Hash(), "coinbase", header.Coinbase, "err", err)
+			return i, err
+		}
+	}
+	return 0, nil
+}
+
+// fetchStakers retrieves the staker sets of the given stake target blocks from
+// the cache or concurrently from the servers.
+func (self *LightChain) fetchStakers(ctx context.Context, targets []*types.Header) (map[common.Hash][]common.Address, error) {
+	var (
+		stakers = make(map[common.Hash][]common.Address)
+		missing []*types.Header
+	)
+	for _, target := range targets {
+		if target == nil || target.Number.Sign() == 0 {
+			continue
+		}
+		hash := target.Hash()
+		if _, ok := stakers[hash]; ok {
+			continue
+		}
+		if cached, ok := self.stakersCache.Get(hash); ok {
+			stakers[hash] = cached.([]common.Address)
+			continue
+		}
+		stakers[hash] = nil
+		missing = append(missing, target)
+	}
+	var (
+		lock sync.Mutex
+		wg   sync.WaitGroup
+		fail error
+		next = make(chan *types.Header)
+	)
+	for i := 0; i < stakersFetchers && i < len(missing); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for target := range next {
+				list, _, err := GetStakers(ctx, self.odr, target, stakersServers)
+
+				lock.Lock()
+				if err != nil && fail == nil {
+					fail = fmt.Errorf("staker set of block #%d [%x…] unavailable: %v", target.Number, target.Hash().Bytes()[:4], err)
+				}
+				stakers[target.Hash()] = list
+				lock.Unlock()
+			}
+		}()
+	}
+	for _, target := range missing {
+		next <- target
+	}
+	close(next)
+	wg.Wait()
+
+	if fail != nil {
+		return nil, fail
+	}
+	for _, target := range missing {
+		self.stakersCache.Add(target.Hash(), stakers[target.Hash()])
+	}
+	return stakers, nil
+}
+
+// headerBatch is a header reader over the light chain extended with a batch of
+// headers not inserted yet.
+type headerBatch struct {
+	*LightChain
+	byHash   map[common.Hash]*types.Header
+	byNumber map[uint64]*types.Header
+}
+
+func newHeaderBatch(chain *LightChain, headers []*types.Header) *headerBatch {
+	batch := &headerBatch{
+		LightChain: chain,
+		byHash:     make(map[common.Hash]*types.Header),
+		byNumber:   make(map[uint64]*types.Header),
+	}
+	for _, header := range headers {
+		batch.byHash[header.Hash()] = header
+		batch.byNumber[header.Number.Uint64()] = header
+	}
+	return batch
+}
+
+// GetHeader retrieves a header by hash and number from the batch or the chain.
+func (b *headerBatch) GetHeader(hash common.Hash, number uint64) *types.Header {
+	if header := b.byHash[hash]; header != nil {
+		return header
+	}
+	return b.LightChain.GetHeader(hash, number)
+}
+
+// GetHeaderByHash retrieves a header by hash from the batch or the chain.
+func (b *headerBatch) GetHeaderByHash(hash common.Hash) *types.Header {
+	if header := b.byHash[hash]; header != nil {
+		return header
+	}
+	return b.LightChain.GetHeaderByHash(hash)
+}
+
+// GetHeaderByNumber retrieves a header by number, the batch being considered the
+// canonical extension of the chain.
+func (b *headerBatch) GetHeaderByNumber(number uint64) *types.Header {
+	if header := b.byNumber[number]; header != nil {
+		return header
+	}
+	return b.LightChain.GetHeaderByNumber(number)
+}
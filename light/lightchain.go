@@ -63,6 +63,7 @@ type LightChain struct {
 	bodyCache    *lru.Cache // Cache for the most recent block bodies
 	bodyRLPCache *lru.Cache // Cache for the most recent block bodies in RLP encoded format
 	blockCache   *lru.Cache // Cache for the most recent entire blocks
+	stakersCache *lru.Cache // [BERITH] Cache for the cross-checked staker sets of the stake target blocks
 
 	quit    chan struct{}
 	running int32 // running must be called automically
@@ -80,6 +81,7 @@ func NewLightChain(odr OdrBackend, config *params.ChainConfig, engine consensus.
 	bodyCache, _ := lru.New(bodyCacheLimit)
 	bodyRLPCache, _ := lru.New(bodyCacheLimit)
 	blockCache, _ := lru.New(blockCacheLimit)
+	stakersCache, _ := lru.New(stakersCacheLimit)
 
 	bc := &LightChain{
 		chainDb:       odr.Database(),
@@ -89,6 +91,7 @@ func NewLightChain(odr OdrBackend, config *params.ChainConfig, engine consensus.
 		bodyCache:     bodyCache,
 		bodyRLPCache:  bodyRLPCache,
 		blockCache:    blockCache,
+		stakersCache:  stakersCache,
 		engine:        engine,
 	}
 	var err error
@@ -354,11 +357,17 @@ func (self *LightChain) postChainEvents(events []interface{}) {
 //
 // In the case of a light chain, InsertHeaderChain also creates and posts light
 // chain events when necessary.
+//
+// [BERITH] The block creators are checked against the staker sets proven by the
+// servers, the staker sets are retrieved without holding the chain lock.
 func (self *LightChain) InsertHeaderChain(chain []*types.Header, checkFreq int) (int, error) {
 	start := time.Now()
 	if i, err := self.hc.ValidateHeaderChain(chain, checkFreq); err != nil {
 		return i, err
 	}
+	if i, err := self.verifyCreators(chain); err != nil {
+		return i, err
+	}
 
 	// Make sure only one thread manipulates the chain at once
 	self.chainmu.Lock()
@@ -37,6 +37,14 @@ var NoOdr = context.Background()
 // ErrNoPeers is returned if no peers capable of serving a queued request are available
 var ErrNoPeers = errors.New("no suitable peers available")
 
+// [BERITH] ErrStakersServer is returned if a staker set could not be cross-checked
+// by distinct servers.
+var ErrStakersServer = errors.New("staker set not served by distinct servers")
+
+// [BERITH] ErrStakersMismatch is returned if the servers of a staker set disagree
+// on it.
+var ErrStakersMismatch = errors.New("staker set served differently by distinct servers")
+
 // OdrBackend is an interface to a backend service that handles ODR retrievals type
 type OdrBackend interface {
 	Database() berithdb.Database
@@ -134,6 +142,22 @@ func (req *ReceiptsRequest) StoreResult(db berithdb.Database) {
 	rawdb.WriteReceipts(db, req.Hash, req.Number, req.Receipts)
 }
 
+// [BERITH] StakersRequest is the ODR request type for retrieving the staker set of
+// a block along with the state trie nodes proving the accounts of the stakers
+type StakersRequest struct {
+	OdrRequest
+	Header  *types.Header
+	Exclude map[string]bool // Servers not to be asked, having served the set already
+	Server  string          // Server which served the set
+	Stakers []common.Address
+	Proof   *NodeSet
+}
+
+// StoreResult stores the retrieved data in local database
+func (req *StakersRequest) StoreResult(db berithdb.Database) {
+	req.Proof.Store(db)
+}
+
 // ChtRequest is the ODR request type for state/storage trie entries
 type ChtRequest struct {
 	OdrRequest
@@ -23,6 +23,7 @@ import (
 	"github.com/BerithFoundation/berith-chain/common"
 	"github.com/BerithFoundation/berith-chain/core"
 	"github.com/BerithFoundation/berith-chain/core/rawdb"
+	"github.com/BerithFoundation/berith-chain/core/state"
 	"github.com/BerithFoundation/berith-chain/core/types"
 	"github.com/BerithFoundation/berith-chain/crypto"
 	"github.com/BerithFoundation/berith-chain/rlp"
@@ -232,3 +233,46 @@ func GetBloomBits(ctx context.Context, odr OdrBackend, bitIdx uint, sectionIdxLi
 		return result, nil
 	}
 }
+
+// [BERITH] GetStakers retrieves the staker set of the given block from the given
+// number of distinct servers, along with a state holding the proven accounts of
+// the stakers. Every server proves the stake of the stakers it lists, but could
+// withhold some of them, so the servers must all serve the same set.
+func GetStakers(ctx context.Context, odr OdrBackend, header *types.Header, servers int) ([]common.Address, *state.StateDB, error) {
+	var (
+		served  = make(map[string]bool)
+		listed  map[common.Address]bool
+		stakers []common.Address
+	)
+	for len(served) < servers {
+		r := &StakersRequest{Header: header, Exclude: served}
+		if err := odr.Retrieve(ctx, r); err != nil {
+			return nil, nil, err
+		}
+		if r.Server == "" || served[r.Server] {
+			return nil, nil, ErrStakersServer
+		}
+		served[r.Server] = true
+
+		set := make(map[common.Address]bool, len(r.Stakers))
+		for _, addr := range r.Stakers {
+			if !set[addr] && listed == nil {
+				stakers = append(stakers, addr)
+			}
+			set[addr] = true
+		}
+		if listed == nil {
+			listed = set
+			continue
+		}
+		if len(set) != len(listed) {
+			return nil, nil, ErrStakersMismatch
+		}
+		for addr := range set {
+			if !listed[addr] {
+				return nil, nil, ErrStakersMismatch
+			}
+		}
+	}
+	return stakers, NewState(ctx, header, odr), nil
+}
@@ -381,6 +381,32 @@ func (pool *TxPool) validateTx(ctx context.Context, tx *types.Transaction) error
 	if b := currentState.GetBalance(from); b.Cmp(tx.Cost()) < 0 {
 		return core.ErrInsufficientFunds
 	}
+	// Owners of a multisig account should reach its threshold
+	if tx.IsMultisig() {
+		if !pool.config.IsBIP7(header.Number) {
+			return core.ErrMultisigTx
+		}
+		signers, err := types.MultisigSigners(pool.signer, tx)
+		if err != nil {
+			return err
+		}
+		if err := core.VerifyMultisig(currentState, from, signers); err != nil {
+			return err
+		}
+	}
+	// Sponsor should cover the gas of a sponsored transaction
+	if tx.IsSponsored() {
+		if !pool.config.IsBIP6(header.Number) {
+			return core.ErrSponsoredTx
+		}
+		sponsor, err := types.SponsorOf(pool.signer, tx)
+		if err != nil || sponsor == from {
+			return core.ErrInvalidSponsor
+		}
+		if b := currentState.GetBalance(sponsor); b.Cmp(tx.MainFee()) < 0 {
+			return core.ErrInsufficientSponsorFunds
+		}
+	}
 
 	// Should supply enough intrinsic gas
 	gas, err := core.IntrinsicGas(tx.Data(), tx.To() == nil, pool.homestead, pool.bip5)
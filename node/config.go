@@ -90,6 +90,11 @@ type Config struct {
 	// NoUSB disables hardware wallet monitoring and connectivity.
 	NoUSB bool `toml:",omitempty"`
 
+	// DBEngine is the key-value store engine of the node's databases, either
+	// leveldb or pebble. If empty, the engine of the existing databases is used,
+	// new ones default to leveldb.
+	DBEngine string `toml:",omitempty"`
+
 	// IPCPath is the requested location to place the IPC endpoint. If the path is
 	// a simple file name, it is placed inside the data directory (or on the root
 	// pipe path on Windows), whereas if it's a resolvable path name (absolute or
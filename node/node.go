@@ -586,7 +586,19 @@ func (n *Node) OpenDatabase(name string, cache, handles int) (berithdb.Database,
 	if n.config.DataDir == "" {
 		return berithdb.NewMemDatabase(), nil
 	}
-	return berithdb.NewLDBDatabase(n.config.ResolvePath(name), cache, handles)
+	return berithdb.Open(n.config.DBEngine, n.config.ResolvePath(name), cache, handles)
+}
+
+// OpenDatabaseWithFreezer opens an existing database with the given name (or
+// creates one if no previous can be found) from within the node's data directory,
+// also attaching a chain freezer to it that moves ancient chain data from the
+// database to immutable append-only files. If the node is an ephemeral one, a
+// memory database is returned.
+func (n *Node) OpenDatabaseWithFreezer(name string, cache, handles int, freezer, namespace string) (berithdb.Database, error) {
+	if n.config.DataDir == "" {
+		return berithdb.NewMemDatabase(), nil
+	}
+	return openDatabaseWithFreezer(n.config.DBEngine, n.config.ResolvePath(name), cache, handles, freezer, namespace)
 }
 
 // ResolvePath returns the absolute path of a resource in the instance directory.
@@ -17,10 +17,12 @@
 package node
 
 import (
+	"path/filepath"
 	"reflect"
 
 	"github.com/BerithFoundation/berith-chain/accounts"
 	"github.com/BerithFoundation/berith-chain/berithdb"
+	"github.com/BerithFoundation/berith-chain/core/rawdb"
 	"github.com/BerithFoundation/berith-chain/event"
 	"github.com/BerithFoundation/berith-chain/p2p"
 	"github.com/BerithFoundation/berith-chain/rpc"
@@ -43,13 +45,56 @@ func (ctx *ServiceContext) OpenDatabase(name string, cache int, handles int) (be
 	if ctx.config.DataDir == "" {
 		return berithdb.NewMemDatabase(), nil
 	}
-	db, err := berithdb.NewLDBDatabase(ctx.config.ResolvePath(name), cache, handles)
+	db, err := berithdb.Open(ctx.config.DBEngine, ctx.config.ResolvePath(name), cache, handles)
 	if err != nil {
 		return nil, err
 	}
 	return db, nil
 }
 
+// OpenDatabaseWithFreezer opens an existing database with the given name (or
+// creates one if no previous can be found) from within the node's data directory,
+// also attaching a chain freezer to it that moves ancient chain data from the
+// database to immutable append-only files. If the node is an ephemeral one, a
+// memory database is returned.
+func (ctx *ServiceContext) OpenDatabaseWithFreezer(name string, cache int, handles int, freezer string, namespace string) (berithdb.Database, error) {
+	if ctx.config.DataDir == "" {
+		return berithdb.NewMemDatabase(), nil
+	}
+	return openDatabaseWithFreezer(ctx.config.DBEngine, ctx.config.ResolvePath(name), cache, handles, freezer, namespace)
+}
+
+// DatabaseEngine returns the key-value store engine configured for the node's
+// databases.
+func (ctx *ServiceContext) DatabaseEngine() string {
+	return ctx.config.DBEngine
+}
+
+// openDatabaseWithFreezer opens the key-value store at path with the freezer
+// stored at the given location. The freezer defaults to the "ancient" folder of
+// the database, a relative location is resolved against the database folder.
+func openDatabaseWithFreezer(engine string, path string, cache int, handles int, freezer string, namespace string) (berithdb.Database, error) {
+	switch {
+	case freezer == "":
+		freezer = filepath.Join(path, "ancient")
+	case !filepath.IsAbs(freezer):
+		freezer = filepath.Join(path, freezer)
+	}
+	kvdb, err := berithdb.Open(engine, path, cache, handles)
+	if err != nil {
+		return nil, err
+	}
+	if namespace != "" {
+		kvdb.Meter(namespace)
+	}
+	db, err := rawdb.NewDatabaseWithFreezer(kvdb, freezer, namespace)
+	if err != nil {
+		kvdb.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
 // ResolvePath resolves a user path into the data directory if that was relative
 // and if the user actually uses persistent storage. It will return an empty string
 // for emphemeral storage and the user's own input for absolute paths.
@@ -37,6 +37,11 @@ const (
 	// once every few seconds.
 	lookupInterval = 4 * time.Second
 
+	// [BERITH] Number of protocol dial candidates fetched with every lookup, and
+	// the time to wait for a fairly chosen candidate source.
+	candidateBatchSize = 16
+	candidateTimeout   = 5 * time.Second
+
 	// If no peers are found for this amount of time, the initial bootnodes are
 	// attempted to be connected.
 	fallbackInterval = 20 * time.Second
@@ -91,6 +96,44 @@ type discoverTable interface {
 	ReadRandomNodes([]*enode.Node) int
 }
 
+// [BERITH] candidateTable extends the discovery table with the dial candidates
+// of the protocols. The table is nil if the discovery is disabled.
+type candidateTable struct {
+	discoverTable
+	candidates enode.Iterator
+}
+
+func (t *candidateTable) Close() {
+	t.candidates.Close()
+	if t.discoverTable != nil {
+		t.discoverTable.Close()
+	}
+}
+
+func (t *candidateTable) Resolve(n *enode.Node) *enode.Node {
+	if t.discoverTable == nil {
+		return nil
+	}
+	return t.discoverTable.Resolve(n)
+}
+
+// LookupRandom performs a random lookup in the discovery table and then tops
+// the results up with a batch of dial candidates.
+func (t *candidateTable) LookupRandom() []*enode.Node {
+	var nodes []*enode.Node
+	if t.discoverTable != nil {
+		nodes = t.discoverTable.LookupRandom()
+	}
+	return append(nodes, enode.ReadNodes(t.candidates, candidateBatchSize)...)
+}
+
+func (t *candidateTable) ReadRandomNodes(buf []*enode.Node) int {
+	if t.discoverTable == nil {
+		return 0
+	}
+	return t.discoverTable.ReadRandomNodes(buf)
+}
+
 // the dial history remembers recent dials.
 type dialHistory []pastDial
 
@@ -62,6 +62,7 @@ const (
 	seedMinTableTime    = 5 * time.Minute
 	seedCount           = 30
 	seedMaxAge          = 5 * 24 * time.Hour
+	lookupRetryDelay    = 4 * time.Second // [BERITH] Delay of random node lookups on an empty table
 )
 
 type Table struct {
@@ -78,7 +79,9 @@ type Table struct {
 	closeReq   chan struct{}
 	closed     chan struct{}
 
-	nodeAddedHook func(*node) // for testing
+	nodeFilter    func(*enode.Node) bool // [BERITH] filter of the dialing candidates
+	hidden        map[enode.ID]bool      // [BERITH] nodes never returned by closest
+	nodeAddedHook func(*node)            // for testing
 }
 
 // transport is implemented by the UDP transport.
@@ -86,8 +89,9 @@ type Table struct {
 // sockets and without generating a private key.
 type transport interface {
 	self() *enode.Node
-	ping(enode.ID, *net.UDPAddr) error
+	ping(enode.ID, *net.UDPAddr) (seq uint64, err error)
 	findnode(toid enode.ID, addr *net.UDPAddr, target encPubkey) ([]*node, error)
+	requestENR(*enode.Node) (*enode.Node, error)
 	close()
 }
 
@@ -109,6 +113,7 @@ func newTable(t transport, db *enode.DB, bootnodes []*enode.Node) (*Table, error
 		closed:     make(chan struct{}),
 		rand:       mrand.New(mrand.NewSource(0)),
 		ips:        netutil.DistinctNetSet{Subnet: tableSubnet, Limit: tableIPLimit},
+		hidden:     make(map[enode.ID]bool),
 	}
 	if err := tab.setFallbackNodes(bootnodes); err != nil {
 		return nil, err
@@ -147,11 +152,11 @@ func (tab *Table) ReadRandomNodes(buf []*enode.Node) (n int) {
 	tab.mutex.Lock()
 	defer tab.mutex.Unlock()
 
-	// Find all non-empty buckets and get a fresh slice of their entries.
+	// Find all non-empty buckets and get a fresh slice of their dialable entries.
 	var buckets [][]*node
 	for _, b := range &tab.buckets {
-		if len(b.entries) > 0 {
-			buckets = append(buckets, b.entries)
+		if entries := tab.dialable(b.entries); len(entries) > 0 {
+			buckets = append(buckets, entries)
 		}
 	}
 	if len(buckets) == 0 {
@@ -178,6 +183,26 @@ func (tab *Table) ReadRandomNodes(buf []*enode.Node) (n int) {
 	return i + 1
 }
 
+// [BERITH] dialable returns the nodes accepted by the node filter. The given slice
+// is returned as is without a filter.
+func (tab *Table) dialable(nodes []*node) []*node {
+	if tab.nodeFilter == nil {
+		return nodes
+	}
+	var result []*node
+	for _, n := range nodes {
+		if tab.nodeFilter(unwrapNode(n)) {
+			result = append(result, n)
+		}
+	}
+	return result
+}
+
+// [BERITH] RequestENR retrieves the most recent record of the given node (EIP-868).
+func (tab *Table) RequestENR(n *enode.Node) (*enode.Node, error) {
+	return tab.net.requestENR(n)
+}
+
 // Close terminates the network listener and flushes the node database.
 func (tab *Table) Close() {
 	if tab.net != nil {
@@ -241,7 +266,62 @@ func (tab *Table) Resolve(n *enode.Node) *enode.Node {
 func (tab *Table) LookupRandom() []*enode.Node {
 	var target encPubkey
 	crand.Read(target[:])
-	return unwrapNodes(tab.lookup(target, true))
+	return unwrapNodes(tab.dialable(tab.lookup(target, true)))
+}
+
+// [BERITH] RandomNodes returns an iterator over the nodes found by random
+// lookups. The iterator ends when it is closed or when the table is closed.
+func (tab *Table) RandomNodes() enode.Iterator {
+	return &lookupIterator{tab: tab, closed: make(chan struct{})}
+}
+
+// lookupIterator buffers the results of random lookups.
+type lookupIterator struct {
+	tab       *Table
+	buffer    []*enode.Node
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// Next moves to the next lookup result, running a new lookup when the results
+// of the previous one have been consumed.
+func (it *lookupIterator) Next() bool {
+	if len(it.buffer) > 0 {
+		it.buffer = it.buffer[1:]
+	}
+	for len(it.buffer) == 0 {
+		select {
+		case <-it.closed:
+			return false
+		case <-it.tab.closed:
+			return false
+		default:
+		}
+		if it.buffer = it.tab.LookupRandom(); len(it.buffer) == 0 {
+			// Avoid spinning while the table is empty
+			select {
+			case <-time.After(lookupRetryDelay):
+			case <-it.closed:
+				return false
+			case <-it.tab.closed:
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Node returns the current lookup result.
+func (it *lookupIterator) Node() *enode.Node {
+	if len(it.buffer) == 0 {
+		return nil
+	}
+	return it.buffer[0]
+}
+
+// Close ends the iterator.
+func (it *lookupIterator) Close() {
+	it.closeOnce.Do(func() { close(it.closed) })
 }
 
 // lookup performs a network search for nodes close to the given target. It approaches the
@@ -451,8 +531,20 @@ func (tab *Table) doRevalidate(done chan<- struct{}) {
 	}
 
 	// Ping the selected node and wait for a pong.
-	err := tab.net.ping(last.ID(), last.addr())
-
+	remoteSeq, err := tab.net.ping(last.ID(), last.addr())
+
+	// [BERITH] Also fetch the record if the node announced a newer one.
+	if err == nil && last.Seq() < remoteSeq {
+		n, rerr := tab.net.requestENR(unwrapNode(last))
+		switch {
+		case rerr != nil:
+			log.Debug("ENR request failed", "id", last.ID(), "addr", last.addr(), "err", rerr)
+		case !n.IP().Equal(last.IP()) || n.UDP() != last.UDP():
+			log.Debug("Ignored ENR with changed endpoint", "id", last.ID(), "addr", last.addr())
+		default:
+			last = &node{Node: *n, addedAt: last.addedAt}
+		}
+	}
 	tab.mutex.Lock()
 	defer tab.mutex.Unlock()
 	b := tab.buckets[bi]
@@ -511,6 +603,8 @@ func (tab *Table) copyLiveNodes() {
 
 // closest returns the n nodes in the table that are closest to the
 // given id. The caller must hold tab.mutex.
+//
+// [BERITH] Hidden nodes are skipped, keeping them out of the neighbors responses.
 func (tab *Table) closest(target enode.ID, nresults int) *nodesByDistance {
 	// This is a very wasteful way to find the closest nodes but
 	// obviously correct. I believe that tree-based buckets would make
@@ -518,6 +612,9 @@ func (tab *Table) closest(target enode.ID, nresults int) *nodesByDistance {
 	close := &nodesByDistance{target: target}
 	for _, b := range &tab.buckets {
 		for _, n := range b.entries {
+			if tab.hidden[n.ID()] {
+				continue
+			}
 			close.push(n, nresults)
 		}
 	}
@@ -662,6 +759,10 @@ func (tab *Table) replace(b *bucket, last *node) *node {
 func (b *bucket) bump(n *node) bool {
 	for i := range b.entries {
 		if b.entries[i].ID() == n.ID() {
+			// [BERITH] keep the newer record fetched for the same endpoint
+			if old := b.entries[i]; old.Seq() > n.Seq() && old.IP().Equal(n.IP()) && old.UDP() == n.UDP() {
+				n = old
+			}
 			// move it to the front
 			copy(b.entries[1:], b.entries[:i])
 			b.entries[0] = n
@@ -258,6 +258,126 @@ func TestTable_ReadRandomNodesGetAll(t *testing.T) {
 	}
 }
 
+// Tests that the nodes rejected by the node filter are not handed out as dialing
+// candidates, while staying in the table.
+func TestTable_ReadRandomNodesFilter(t *testing.T) {
+	transport := newPingRecorder()
+	tab, db := newTestTable(transport)
+	defer tab.Close()
+	defer db.Close()
+	<-tab.initDone
+
+	tab.nodeFilter = func(n *enode.Node) bool { return n.IP()[3]%2 == 0 }
+	for i := 0; i < 10; i++ {
+		var r enr.Record
+		r.Set(enr.IP(net.IP{10, 0, 0, byte(i)}))
+		tab.stuff([]*node{wrapNode(enode.SignNull(&r, idAtDistance(tab.self().ID(), 256-i)))})
+	}
+	buf := make([]*enode.Node, 20)
+	n := tab.ReadRandomNodes(buf)
+	if n != 5 {
+		t.Fatalf("wrong number of nodes: have %d, want 5", n)
+	}
+	for _, node := range buf[:n] {
+		if !tab.nodeFilter(node) {
+			t.Errorf("filtered node %v returned", node.IP())
+		}
+	}
+	if size := tab.len(); size != 10 {
+		t.Errorf("wrong table size: have %d, want 10", size)
+	}
+}
+
+// Tests that hidden nodes are never returned as the closest nodes, keeping them
+// out of the neighbors responses.
+func TestTable_closestHidden(t *testing.T) {
+	transport := newPingRecorder()
+	tab, db := newTestTable(transport)
+	defer tab.Close()
+	defer db.Close()
+	<-tab.initDone
+
+	var nodes []*node
+	for i := 0; i < 10; i++ {
+		var r enr.Record
+		r.Set(enr.IP(net.IP{10, 0, 0, byte(i)}))
+		nodes = append(nodes, wrapNode(enode.SignNull(&r, idAtDistance(tab.self().ID(), 256-i))))
+	}
+	tab.stuff(nodes)
+	tab.mutex.Lock()
+	tab.hidden[nodes[3].ID()] = true
+	closest := tab.closest(tab.self().ID(), bucketSize).entries
+	tab.mutex.Unlock()
+
+	if len(closest) != 9 {
+		t.Fatalf("wrong number of nodes: have %d, want 9", len(closest))
+	}
+	for _, n := range closest {
+		if n.ID() == nodes[3].ID() {
+			t.Errorf("hidden node %v returned", n.IP())
+		}
+	}
+}
+
+// Tests that the random node iterator returns lookup results until it is closed.
+func TestTable_RandomNodes(t *testing.T) {
+	transport := newPingRecorder()
+	tab, db := newTestTable(transport)
+	defer tab.Close()
+	defer db.Close()
+	<-tab.initDone
+
+	for i := 0; i < 10; i++ {
+		var r enr.Record
+		r.Set(enr.IP(net.IP{10, 0, 0, byte(i)}))
+		tab.stuff([]*node{wrapNode(enode.SignNull(&r, idAtDistance(tab.self().ID(), 256-i)))})
+	}
+	it := tab.RandomNodes()
+	nodes := enode.ReadNodes(it, 20)
+	if len(nodes) != 10 {
+		t.Fatalf("wrong number of nodes: have %d, want 10", len(nodes))
+	}
+	for _, n := range nodes {
+		if !contains(tab.bucket(n.ID()).entries, n.ID()) {
+			t.Errorf("node %v not in table", n.ID())
+		}
+	}
+	it.Close()
+	if it.Next() {
+		t.Fatal("Next returned true after Close")
+	}
+}
+
+// Tests that revalidation fetches the newer record announced by a node.
+func TestTable_revalidateSyncRecord(t *testing.T) {
+	transport := newPingRecorder()
+	tab, db := newTestTable(transport)
+	<-tab.initDone
+	defer db.Close()
+	defer tab.Close()
+
+	// Insert a node.
+	var r enr.Record
+	r.Set(enr.IP(net.IP{127, 0, 0, 1}))
+	id := enode.ID{1}
+	n1 := wrapNode(enode.SignNull(&r, id))
+	tab.add(n1)
+
+	// Update the node record.
+	r.Set(enr.WithEntry("foo", "bar"))
+	n2 := enode.SignNull(&r, id)
+	transport.updateRecord(n2)
+
+	tab.doRevalidate(make(chan struct{}, 1))
+
+	tab.mutex.Lock()
+	defer tab.mutex.Unlock()
+	entries := tab.bucket(id).entries
+	if len(entries) != 1 || !reflect.DeepEqual(unwrapNode(entries[0]), n2) {
+		t.Fatalf("table contains old record with seq %d, want seq %d", entries[0].Seq(), n2.Seq())
+	}
+}
+
 type closeTest struct {
 	Self   enode.ID
 	Target enode.ID
@@ -530,9 +650,10 @@ func (tn *preminedTestnet) findnode(toid enode.ID, toaddr *net.UDPAddr, target e
 	return result, nil
 }
 
-func (*preminedTestnet) close()                                        {}
-func (*preminedTestnet) waitping(from enode.ID) error                  { return nil }
-func (*preminedTestnet) ping(toid enode.ID, toaddr *net.UDPAddr) error { return nil }
+func (*preminedTestnet) close()                                                  {}
+func (*preminedTestnet) waitping(from enode.ID) error                            { return nil }
+func (*preminedTestnet) ping(toid enode.ID, toaddr *net.UDPAddr) (uint64, error) { return 0, nil }
+func (*preminedTestnet) requestENR(n *enode.Node) (*enode.Node, error)           { return n, nil }
 
 // mine generates a testnet struct literal with nodes at
 // various distances to the given target.
@@ -29,6 +29,7 @@ import (
 	"github.com/BerithFoundation/berith-chain/crypto"
 	"github.com/BerithFoundation/berith-chain/log"
 	"github.com/BerithFoundation/berith-chain/p2p/enode"
+	"github.com/BerithFoundation/berith-chain/p2p/enr"
 	"github.com/BerithFoundation/berith-chain/p2p/netutil"
 	"github.com/BerithFoundation/berith-chain/rlp"
 )
@@ -63,6 +64,8 @@ const (
 	pongPacket
 	findnodePacket
 	neighborsPacket
+	enrRequestPacket  // [BERITH] EIP-868
+	enrResponsePacket // [BERITH] EIP-868
 )
 
 // RPC request structures
@@ -104,6 +107,21 @@ type (
 		Rest []rlp.RawValue `rlp:"tail"`
 	}
 
+	// enrRequest queries for the remote node's record.
+	enrRequest struct {
+		Expiration uint64
+		// Ignore additional fields (for forward compatibility).
+		Rest []rlp.RawValue `rlp:"tail"`
+	}
+
+	// enrResponse is the reply to enrRequest.
+	enrResponse struct {
+		ReplyTok []byte // Hash of the enrRequest packet.
+		Record   enr.Record
+		// Ignore additional fields (for forward compatibility).
+		Rest []rlp.RawValue `rlp:"tail"`
+	}
+
 	rpcNode struct {
 		IP  net.IP // len 4 for IPv4 or 16 for IPv6
 		UDP uint16 // for discovery protocol
@@ -235,6 +253,14 @@ type Config struct {
 	NetRestrict *netutil.Netlist  // network whitelist
 	Bootnodes   []*enode.Node     // list of bootstrap nodes
 	Unhandled   chan<- ReadPacket // unhandled packets are sent on this channel
+
+	// [BERITH] NodeFilter, if set, hides the nodes it rejects from the dialing
+	// candidates. The rejected nodes still take part in the node discovery.
+	NodeFilter func(*enode.Node) bool
+
+	// [BERITH] HiddenNodes are never advertised to other nodes, e.g. the
+	// validators guarded by a sentry.
+	HiddenNodes []*enode.Node
 }
 
 // ListenUDP returns a new table that listens for UDP packets on laddr.
@@ -262,6 +288,12 @@ func newUDP(c conn, ln *enode.LocalNode, cfg Config) (*Table, *udp, error) {
 		return nil, nil, err
 	}
 	udp.tab = tab
+	tab.nodeFilter = cfg.NodeFilter
+	tab.mutex.Lock()
+	for _, n := range cfg.HiddenNodes {
+		tab.hidden[n.ID()] = true
+	}
+	tab.mutex.Unlock()
 
 	udp.wg.Add(2)
 	go udp.loop()
@@ -285,19 +317,22 @@ func (t *udp) ourEndpoint() rpcEndpoint {
 	return makeEndpoint(a, uint16(n.TCP()))
 }
 
-// ping sends a ping message to the given node and waits for a reply.
-func (t *udp) ping(toid enode.ID, toaddr *net.UDPAddr) error {
-	return <-t.sendPing(toid, toaddr, nil)
+// ping sends a ping message to the given node and waits for a reply, returning
+// the sequence number of the node record announced in the reply.
+func (t *udp) ping(toid enode.ID, toaddr *net.UDPAddr) (seq uint64, err error) {
+	err = <-t.sendPing(toid, toaddr, func(p *pong) { seq = seqFromTail(p.Rest) })
+	return seq, err
 }
 
 // sendPing sends a ping message to the given node and invokes the callback
 // when the reply arrives.
-func (t *udp) sendPing(toid enode.ID, toaddr *net.UDPAddr, callback func()) <-chan error {
+func (t *udp) sendPing(toid enode.ID, toaddr *net.UDPAddr, callback func(*pong)) <-chan error {
 	req := &ping{
 		Version:    4,
 		From:       t.ourEndpoint(),
 		To:         makeEndpoint(toaddr, 0), // TODO: maybe use known TCP port from DB
 		Expiration: uint64(time.Now().Add(expiration).Unix()),
+		Rest:       []rlp.RawValue{t.enrSeq()},
 	}
 	packet, hash, err := encodePacket(t.priv, pingPacket, req)
 	if err != nil {
@@ -308,7 +343,7 @@ func (t *udp) sendPing(toid enode.ID, toaddr *net.UDPAddr, callback func()) <-ch
 	errc := t.pending(toid, pongPacket, func(p interface{}) bool {
 		ok := bytes.Equal(p.(*pong).ReplyTok, hash)
 		if ok && callback != nil {
-			callback()
+			callback(p.(*pong))
 		}
 		return ok
 	})
@@ -353,6 +388,73 @@ func (t *udp) findnode(toid enode.ID, toaddr *net.UDPAddr, target encPubkey) ([]
 	return nodes, <-errc
 }
 
+// [BERITH] requestENR sends an ENR request to the given node and waits until the
+// node has sent its record (EIP-868).
+func (t *udp) requestENR(n *enode.Node) (*enode.Node, error) {
+	addr := &net.UDPAddr{IP: n.IP(), Port: n.UDP()}
+
+	// If we haven't seen a ping from the destination node for a while, it won't remember
+	// our endpoint proof and reject the request. Solicit a ping first.
+	if time.Since(t.db.LastPingReceived(n.ID())) > bondExpiration {
+		t.ping(n.ID(), addr)
+		t.waitping(n.ID())
+	}
+	req := &enrRequest{
+		Expiration: uint64(time.Now().Add(expiration).Unix()),
+	}
+	packet, hash, err := encodePacket(t.priv, enrRequestPacket, req)
+	if err != nil {
+		return nil, err
+	}
+	// Add a matcher for the reply to the pending reply queue. Responses are matched if
+	// they reference the request we're about to send.
+	var resp *enrResponse
+	errc := t.pending(n.ID(), enrResponsePacket, func(r interface{}) bool {
+		match := bytes.Equal(r.(*enrResponse).ReplyTok, hash)
+		if match {
+			resp = r.(*enrResponse)
+		}
+		return match
+	})
+	t.write(addr, req.name(), packet)
+	if err := <-errc; err != nil {
+		return nil, err
+	}
+	// Verify the response record
+	respN, err := enode.New(enode.ValidSchemes, &resp.Record)
+	if err != nil {
+		return nil, err
+	}
+	if respN.ID() != n.ID() {
+		return nil, errors.New("invalid ID in response record")
+	}
+	if respN.Seq() < n.Seq() {
+		return n, nil // response record is older
+	}
+	if err := netutil.CheckRelayIP(addr.IP, respN.IP()); err != nil {
+		return nil, fmt.Errorf("invalid IP in response record: %v", err)
+	}
+	return respN, nil
+}
+
+// enrSeq encodes the sequence number of the local record, announced in the tail
+// of the ping and pong packets.
+func (t *udp) enrSeq() rlp.RawValue {
+	enc, _ := rlp.EncodeToBytes(t.localNode.Node().Seq())
+	return enc
+}
+
+// seqFromTail returns the record sequence number from the tail of a ping or pong
+// packet, zero if the remote node does not announce it.
+func seqFromTail(tail []rlp.RawValue) uint64 {
+	if len(tail) == 0 {
+		return 0
+	}
+	var seq uint64
+	rlp.DecodeBytes(tail[0], &seq)
+	return seq
+}
+
 // pending adds a reply callback to the pending reply queue.
 // see the documentation of type pending for a detailed explanation.
 func (t *udp) pending(id enode.ID, ptype byte, callback func(interface{}) bool) <-chan error {
@@ -608,6 +710,10 @@ func decodePacket(buf []byte) (packet, encPubkey, []byte, error) {
 		req = new(findnode)
 	case neighborsPacket:
 		req = new(neighbors)
+	case enrRequestPacket:
+		req = new(enrRequest)
+	case enrResponsePacket:
+		req = new(enrResponse)
 	default:
 		return nil, fromKey, hash, fmt.Errorf("unknown type: %d", ptype)
 	}
@@ -628,11 +734,12 @@ func (req *ping) handle(t *udp, from *net.UDPAddr, fromKey encPubkey, mac []byte
 		To:         makeEndpoint(from, req.From.TCP),
 		ReplyTok:   mac,
 		Expiration: uint64(time.Now().Add(expiration).Unix()),
+		Rest:       []rlp.RawValue{t.enrSeq()},
 	})
 	n := wrapNode(enode.NewV4(key, from.IP, int(req.From.TCP), from.Port))
 	t.handleReply(n.ID(), pingPacket, req)
 	if time.Since(t.db.LastPongReceived(n.ID())) > bondExpiration {
-		t.sendPing(n.ID(), from, func() { t.tab.addThroughPing(n) })
+		t.sendPing(n.ID(), from, func(*pong) { t.tab.addThroughPing(n) })
 	} else {
 		t.tab.addThroughPing(n)
 	}
@@ -711,6 +818,32 @@ func (req *neighbors) handle(t *udp, from *net.UDPAddr, fromKey encPubkey, mac [
 
 func (req *neighbors) name() string { return "NEIGHBORS/v4" }
 
+func (req *enrRequest) handle(t *udp, from *net.UDPAddr, fromKey encPubkey, mac []byte) error {
+	if expired(req.Expiration) {
+		return errExpired
+	}
+	if time.Since(t.db.LastPongReceived(fromKey.id())) > bondExpiration {
+		// Same as for findnode, the record is not served without an endpoint proof.
+		return errUnknownNode
+	}
+	t.send(from, enrResponsePacket, &enrResponse{
+		ReplyTok: mac,
+		Record:   *t.localNode.Node().Record(),
+	})
+	return nil
+}
+
+func (req *enrRequest) name() string { return "ENRREQUEST/v4" }
+
+func (req *enrResponse) handle(t *udp, from *net.UDPAddr, fromKey encPubkey, mac []byte) error {
+	if !t.handleReply(fromKey.id(), enrResponsePacket, req) {
+		return errUnsolicitedReply
+	}
+	return nil
+}
+
+func (req *enrResponse) name() string { return "ENRRESPONSE/v4" }
+
 func expired(ts uint64) bool {
 	return time.Unix(int64(ts), 0).Before(time.Now())
 }
@@ -36,6 +36,7 @@ import (
 	"github.com/BerithFoundation/berith-chain/common"
 	"github.com/BerithFoundation/berith-chain/crypto"
 	"github.com/BerithFoundation/berith-chain/p2p/enode"
+	"github.com/BerithFoundation/berith-chain/p2p/enr"
 	"github.com/BerithFoundation/berith-chain/rlp"
 	"github.com/davecgh/go-spew/spew"
 )
@@ -140,7 +141,7 @@ func TestUDP_pingTimeout(t *testing.T) {
 
 	toaddr := &net.UDPAddr{IP: net.ParseIP("1.2.3.4"), Port: 2222}
 	toid := enode.ID{1, 2, 3, 4}
-	if err := test.udp.ping(toid, toaddr); err != errTimeout {
+	if _, err := test.udp.ping(toid, toaddr); err != errTimeout {
 		t.Error("expected timeout error, got", err)
 	}
 }
@@ -329,6 +330,83 @@ func TestUDP_findnodeMultiReply(t *testing.T) {
 	}
 }
 
+// This test checks that EIP-868 requests work.
+func TestUDP_EIP868(t *testing.T) {
+	test := newUDPTest(t)
+	defer test.table.Close()
+
+	test.udp.localNode.Set(enr.WithEntry("foo", "bar"))
+	wantNode := test.udp.localNode.Node()
+
+	// ENR requests aren't allowed before endpoint proof.
+	test.packetIn(errUnknownNode, enrRequestPacket, &enrRequest{Expiration: futureExp})
+
+	// Perform endpoint proof and check for sequence number in packet tail.
+	test.packetIn(nil, pingPacket, &ping{From: testRemote, To: testLocalAnnounced, Version: 4, Expiration: futureExp})
+	test.waitPacketOut(func(p *pong) {
+		if seq := seqFromTail(p.Rest); seq != wantNode.Seq() {
+			t.Errorf("wrong sequence number in pong: %d, want %d", seq, wantNode.Seq())
+		}
+	})
+	hash, _ := test.waitPacketOut(func(p *ping) {
+		if seq := seqFromTail(p.Rest); seq != wantNode.Seq() {
+			t.Errorf("wrong sequence number in ping: %d, want %d", seq, wantNode.Seq())
+		}
+	})
+	test.packetIn(nil, pongPacket, &pong{ReplyTok: hash, Expiration: futureExp})
+
+	// Request should work now.
+	test.packetIn(nil, enrRequestPacket, &enrRequest{Expiration: futureExp})
+	test.waitPacketOut(func(p *enrResponse) {
+		n, err := enode.New(enode.ValidSchemes, &p.Record)
+		if err != nil {
+			t.Fatalf("invalid record: %v", err)
+		}
+		if !reflect.DeepEqual(n, wantNode) {
+			t.Fatalf("wrong node in enrResponse: %v", n)
+		}
+	})
+}
+
+// This test checks that the records served on ENR requests are verified.
+func TestUDP_requestENR(t *testing.T) {
+	test := newUDPTest(t)
+	defer test.table.Close()
+
+	var r enr.Record
+	r.Set(enr.IP(test.remoteaddr.IP))
+	r.Set(enr.UDP(test.remoteaddr.Port))
+	enode.SignV4(&r, test.remotekey)
+	remote, _ := enode.New(enode.ValidSchemes, &r)
+
+	r.Set(enr.WithEntry("foo", "bar"))
+	enode.SignV4(&r, test.remotekey)
+	want, _ := enode.New(enode.ValidSchemes, &r)
+
+	// Skip the endpoint proof, the remote node pinged us recently.
+	test.udp.db.UpdateLastPingReceived(remote.ID(), time.Now())
+
+	type result struct {
+		n   *enode.Node
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		n, err := test.udp.requestENR(remote)
+		done <- result{n, err}
+	}()
+	hash, _ := test.waitPacketOut(func(p *enrRequest) {})
+	test.packetIn(nil, enrResponsePacket, &enrResponse{ReplyTok: hash, Record: r})
+
+	res := <-done
+	if res.err != nil {
+		t.Fatalf("ENR request failed: %v", res.err)
+	}
+	if !reflect.DeepEqual(res.n, want) {
+		t.Fatalf("wrong record: have seq %d, want seq %d", res.n.Seq(), want.Seq())
+	}
+}
+
 func TestUDP_successfulPing(t *testing.T) {
 	test := newUDPTest(t)
 	added := make(chan *node, 1)
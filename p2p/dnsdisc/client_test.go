@@ -0,0 +1,286 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package dnsdisc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"math/rand"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/BerithFoundation/berith-chain/crypto"
+	"github.com/BerithFoundation/berith-chain/p2p/enode"
+	"github.com/BerithFoundation/berith-chain/p2p/enr"
+)
+
+const (
+	signingKeySeed = 0x111111
+	nodesSeed1     = 0x2945237
+	nodesSeed2     = 0x4567299
+)
+
+func TestClientSyncTree(t *testing.T) {
+	keys := testKeys(nodesSeed1, 3)
+	nodes := testNodesFromKeys(keys)
+	link := newLinkEntry("morenodes.example.org", &testKey(nodesSeed2).PublicKey).String()
+	tree, url := makeTestTree("n", nodes, []string{link})
+
+	c := NewClient(Config{Resolver: newMapResolver(tree.ToTXT("n"))})
+	stree, err := c.SyncTree(url)
+	if err != nil {
+		t.Fatal("sync error:", err)
+	}
+	if !reflect.DeepEqual(sortByID(stree.Nodes()), sortByID(nodes)) {
+		t.Errorf("wrong nodes in synced tree")
+	}
+	if !reflect.DeepEqual(stree.Links(), []string{link}) {
+		t.Errorf("wrong links in synced tree: %v", stree.Links())
+	}
+	if stree.Seq() != tree.Seq() {
+		t.Errorf("synced tree has wrong seq %d, want %d", stree.Seq(), tree.Seq())
+	}
+}
+
+// In this test, syncing the tree fails because it contains an invalid ENR entry.
+func TestClientSyncTreeBadNode(t *testing.T) {
+	nodes := testNodes(nodesSeed1, 3)
+	tree, url := makeTestTree("n", nodes, nil)
+	txt := tree.ToTXT("n")
+
+	// Replace one node record by a record of another node. The subdomain doesn't
+	// match the content anymore.
+	other := testNodes(nodesSeed2, 1)[0]
+	for name, record := range txt {
+		if name == "n" {
+			continue
+		}
+		if _, ok := mustParseEntry(t, record).(*enrEntry); ok {
+			txt[name] = (&enrEntry{other}).String()
+			break
+		}
+	}
+	c := NewClient(Config{Resolver: newMapResolver(txt)})
+	_, err := c.SyncTree(url)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if ne, ok := err.(nameError); !ok || ne.err != errHashMismatch {
+		t.Fatalf("expected hash mismatch error, got %v", err)
+	}
+}
+
+// In this test, syncing the tree fails because the root is signed by the wrong key.
+func TestClientSyncTreeBadSignature(t *testing.T) {
+	tree, _ := makeTestTree("n", testNodes(nodesSeed1, 3), nil)
+	url := newLinkEntry("n", &testKey(nodesSeed2).PublicKey).String()
+
+	c := NewClient(Config{Resolver: newMapResolver(tree.ToTXT("n"))})
+	_, err := c.SyncTree(url)
+	if want := (entryError{"root", errInvalidSig}); err != want {
+		t.Fatalf("wrong error %v, want %v", err, want)
+	}
+}
+
+// This test checks that randomIterator finds all entries.
+func TestIterator(t *testing.T) {
+	nodes := testNodes(nodesSeed1, 30)
+	tree, url := makeTestTree("n", nodes, nil)
+	c := NewClient(Config{Resolver: newMapResolver(tree.ToTXT("n"))})
+
+	it, err := c.NewIterator(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+
+	checkIterator(t, it, nodes)
+}
+
+// This test checks that the iterator follows the links to other trees.
+func TestIteratorLinks(t *testing.T) {
+	nodes := testNodes(nodesSeed1, 40)
+	tree1, url1 := makeTestTree("t1", nodes[:10], nil)
+	tree2, url2 := makeTestTree("t2", nodes[10:], []string{url1})
+	c := NewClient(Config{Resolver: newMapResolver(tree1.ToTXT("t1"), tree2.ToTXT("t2"))})
+
+	it, err := c.NewIterator(url2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+
+	checkIterator(t, it, nodes)
+}
+
+// This test checks that Close interrupts a Next call waiting on an empty tree.
+func TestIteratorEmptyTree(t *testing.T) {
+	tree, url := makeTestTree("n", nil, nil)
+	c := NewClient(Config{Resolver: newMapResolver(tree.ToTXT("n"))})
+
+	it, err := c.NewIterator(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan bool)
+	go func() { done <- it.Next() }()
+
+	select {
+	case <-done:
+		t.Fatal("Next returned on empty tree")
+	case <-time.After(100 * time.Millisecond):
+	}
+	it.Close()
+	select {
+	case next := <-done:
+		if next {
+			t.Fatal("Next returned true after Close")
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Next didn't unblock on Close")
+	}
+}
+
+// checkIterator reads nodes from the iterator until all wanted nodes were seen.
+func checkIterator(t *testing.T, it enode.Iterator, wantNodes []*enode.Node) {
+	t.Helper()
+
+	var (
+		want     = make(map[enode.ID]*enode.Node)
+		maxCalls = len(wantNodes) * 20
+		calls    = 0
+	)
+	for _, n := range wantNodes {
+		want[n.ID()] = n
+	}
+	for ; len(want) > 0 && calls < maxCalls; calls++ {
+		if !it.Next() {
+			t.Fatalf("Next returned false (call %d)", calls)
+		}
+		n := it.Node()
+		if want[n.ID()] == nil && !containsNode(wantNodes, n) {
+			t.Fatalf("iterator returned unexpected node %v", n.ID())
+		}
+		delete(want, n.ID())
+	}
+	if len(want) > 0 {
+		t.Fatalf("%d nodes not seen after %d calls", len(want), calls)
+	}
+}
+
+func containsNode(nodes []*enode.Node, n *enode.Node) bool {
+	for _, node := range nodes {
+		if node.ID() == n.ID() {
+			return true
+		}
+	}
+	return false
+}
+
+func makeTestTree(domain string, nodes []*enode.Node, links []string) (*Tree, string) {
+	tree, err := MakeTree(1, nodes, links)
+	if err != nil {
+		panic(err)
+	}
+	url, err := tree.Sign(testKey(signingKeySeed), domain)
+	if err != nil {
+		panic(err)
+	}
+	return tree, url
+}
+
+// testKeys creates deterministic private keys for testing.
+func testKeys(seed int64, n int) []*ecdsa.PrivateKey {
+	rand := rand.New(rand.NewSource(seed))
+	keys := make([]*ecdsa.PrivateKey, n)
+	for i := 0; i < n; i++ {
+		key, err := ecdsa.GenerateKey(crypto.S256(), rand)
+		if err != nil {
+			panic("can't generate key: " + err.Error())
+		}
+		keys[i] = key
+	}
+	return keys
+}
+
+func testKey(seed int64) *ecdsa.PrivateKey {
+	return testKeys(seed, 1)[0]
+}
+
+func testNodes(seed int64, n int) []*enode.Node {
+	return testNodesFromKeys(testKeys(seed, n))
+}
+
+func testNodesFromKeys(keys []*ecdsa.PrivateKey) []*enode.Node {
+	nodes := make([]*enode.Node, len(keys))
+	for i, key := range keys {
+		record := new(enr.Record)
+		record.SetSeq(uint64(i))
+		record.Set(enr.IP(net.IP{127, 0, 0, 1}))
+		record.Set(enr.TCP(30303))
+		enode.SignV4(record, key)
+		n, err := enode.New(enode.ValidSchemes, record)
+		if err != nil {
+			panic(err)
+		}
+		nodes[i] = n
+	}
+	return nodes
+}
+
+func mustParseEntry(t *testing.T, record string) entry {
+	e, err := parseEntry(record, enode.ValidSchemes)
+	if err != nil {
+		t.Fatalf("can't parse record %q: %v", record, err)
+	}
+	return e
+}
+
+func mustDecodeB64(s string) []byte {
+	b, err := b64format.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// mapResolver is a fake DNS resolver serving the TXT records of a map.
+type mapResolver map[string]string
+
+func newMapResolver(maps ...map[string]string) mapResolver {
+	mr := make(mapResolver)
+	for _, m := range maps {
+		mr.add(m)
+	}
+	return mr
+}
+
+func (mr mapResolver) add(m map[string]string) {
+	for k, v := range m {
+		mr[k] = v
+	}
+}
+
+func (mr mapResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	if record, ok := mr[name]; ok {
+		return []string{record}, nil
+	}
+	return nil, errors.New("not found")
+}
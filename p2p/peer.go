@@ -176,6 +176,12 @@ func (p *Peer) Inbound() bool {
 	return p.rw.is(inboundConn)
 }
 
+// [BERITH] SentryLink returns true if the peer is a sentry of this validator or
+// a validator guarded by this sentry.
+func (p *Peer) SentryLink() bool {
+	return p.rw.is(sentryConn)
+}
+
 func newPeer(conn *conn, protocols []Protocol) *Peer {
 	protomap := matchProtocols(protocols, conn.caps, conn)
 	p := &Peer{
@@ -56,6 +56,15 @@ type Protocol struct {
 
 	// Attributes contains protocol specific information for the node record.
 	Attributes []enr.Entry
+
+	// [BERITH] NodeFilter optionally reports whether a node found by the discovery
+	// is worth dialing for this protocol, based on the entries of its record.
+	NodeFilter func(*enode.Node) bool
+
+	// [BERITH] DialCandidates, if non-nil, is a source of nodes worth dialing for
+	// this protocol besides the discovery table, e.g. nodes found through DNS.
+	// The server mixes all sources and closes them when it stops.
+	DialCandidates enode.Iterator
 }
 
 func (p Protocol) cap() Cap {
@@ -113,6 +113,16 @@ type Config struct {
 	// IP networks contained in the list are considered.
 	NetRestrict *netutil.Netlist `toml:",omitempty"`
 
+	// [BERITH] SentryNodes hide a validator behind the given private sentries.
+	// If set, the discovery is disabled, only the sentries are dialed and the
+	// connections of any other node are refused.
+	SentryNodes []*enode.Node `toml:",omitempty"`
+
+	// [BERITH] GuardedNodes are the validators a sentry relays for. They are
+	// always allowed to connect, are dialed like static nodes and are never
+	// advertised by the discovery.
+	GuardedNodes []*enode.Node `toml:",omitempty"`
+
 	// NodeDatabase is the path to the database containing the previously seen
 	// live nodes in the network.
 	NodeDatabase string `toml:",omitempty"`
@@ -180,6 +190,7 @@ type Server struct {
 	removestatic  chan *enode.Node
 	addtrusted    chan *enode.Node
 	removetrusted chan *enode.Node
+	sentryLinks   map[enode.ID]bool // [BERITH] sentries or guarded validators
 	posthandshake chan *conn
 	addpeer       chan *conn
 	delpeer       chan peerDrop
@@ -203,6 +214,7 @@ const (
 	staticDialedConn
 	inboundConn
 	trustedConn
+	sentryConn // [BERITH] link between a validator and one of its sentries
 )
 
 // conn wraps a network connection with information gathered
@@ -254,6 +266,9 @@ func (f connFlag) String() string {
 	if f&inboundConn != 0 {
 		s += "-inbound"
 	}
+	if f&sentryConn != 0 {
+		s += "-sentry"
+	}
 	if s != "" {
 		s = s[1:]
 	}
@@ -361,6 +376,11 @@ func (srv *Server) Self() *enode.Node {
 	return ln.Node()
 }
 
+// [BERITH] LocalNode returns the local node record.
+func (srv *Server) LocalNode() *enode.LocalNode {
+	return srv.localnode
+}
+
 // Stop terminates the server and all active peer connections.
 // It blocks until all active connections have been closed.
 func (srv *Server) Stop() {
@@ -443,6 +463,9 @@ func (srv *Server) Start() (err error) {
 	srv.peerOp = make(chan peerOpFunc)
 	srv.peerOpDone = make(chan struct{})
 
+	if err := srv.setupSentryMode(); err != nil {
+		return err
+	}
 	if err := srv.setupLocalNode(); err != nil {
 		return err
 	}
@@ -454,6 +477,7 @@ func (srv *Server) Start() (err error) {
 	if err := srv.setupDiscovery(); err != nil {
 		return err
 	}
+	srv.setupDialCandidates()
 
 	dynPeers := srv.maxDialedConns()
 	dialer := newDialState(srv.localnode.ID(), srv.StaticNodes, srv.BootstrapNodes, srv.ntab, dynPeers, srv.NetRestrict)
@@ -507,6 +531,74 @@ func (srv *Server) setupLocalNode() error {
 	return nil
 }
 
+// [BERITH] setupSentryMode applies the sentry topology. A validator hidden
+// behind sentries keeps in touch with the sentries only, a sentry keeps the
+// validators it guards connected.
+func (srv *Server) setupSentryMode() error {
+	if len(srv.SentryNodes) > 0 && len(srv.GuardedNodes) > 0 {
+		return errors.New("a validator hidden behind sentries can't guard other validators")
+	}
+	srv.sentryLinks = make(map[enode.ID]bool)
+	if len(srv.SentryNodes) > 0 {
+		if len(srv.StaticNodes) > 0 || len(srv.TrustedNodes) > 0 {
+			srv.log.Warn("Ignoring static and trusted nodes behind sentries")
+		}
+		srv.NoDiscovery, srv.DiscoveryV5 = true, false
+		srv.BootstrapNodes, srv.BootstrapNodesV5 = nil, nil
+		srv.StaticNodes, srv.TrustedNodes = srv.SentryNodes, srv.SentryNodes
+
+		for _, n := range srv.SentryNodes {
+			srv.sentryLinks[n.ID()] = true
+		}
+		srv.log.Info("Hiding behind sentries", "sentries", len(srv.SentryNodes))
+		return nil
+	}
+	if len(srv.GuardedNodes) > 0 {
+		// Copy the node lists, they may be shared with the caller
+		srv.StaticNodes = append(append([]*enode.Node{}, srv.StaticNodes...), srv.GuardedNodes...)
+		srv.TrustedNodes = append(append([]*enode.Node{}, srv.TrustedNodes...), srv.GuardedNodes...)
+
+		for _, n := range srv.GuardedNodes {
+			srv.sentryLinks[n.ID()] = true
+		}
+		srv.log.Info("Guarding validators as a sentry", "validators", len(srv.GuardedNodes))
+	}
+	return nil
+}
+
+// [BERITH] hasDialCandidates reports whether any protocol provides dial
+// candidates besides the discovery. Validators behind sentries dial nothing
+// but their sentries.
+func (srv *Server) hasDialCandidates() bool {
+	if len(srv.SentryNodes) > 0 {
+		return false
+	}
+	for _, p := range srv.Protocols {
+		if p.DialCandidates != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// [BERITH] setupDialCandidates mixes the dial candidates of all protocols into
+// the random lookups of the dialer.
+func (srv *Server) setupDialCandidates() {
+	if !srv.hasDialCandidates() {
+		return
+	}
+	// Protocol versions usually share their source, add every source once
+	mix := enode.NewFairMix(candidateTimeout)
+	added := make(map[enode.Iterator]bool)
+	for _, p := range srv.Protocols {
+		if p.DialCandidates != nil && !added[p.DialCandidates] {
+			mix.AddSource(p.DialCandidates)
+			added[p.DialCandidates] = true
+		}
+	}
+	srv.ntab = &candidateTable{discoverTable: srv.ntab, candidates: mix}
+}
+
 func (srv *Server) setupDiscovery() error {
 	if srv.NoDiscovery && !srv.DiscoveryV5 {
 		return nil
@@ -542,6 +634,8 @@ func (srv *Server) setupDiscovery() error {
 			NetRestrict: srv.NetRestrict,
 			Bootnodes:   srv.BootstrapNodes,
 			Unhandled:   unhandled,
+			NodeFilter:  srv.nodeFilter(),
+			HiddenNodes: srv.GuardedNodes,
 		}
 		ntab, err := discover.ListenUDP(conn, srv.localnode, cfg)
 		if err != nil {
@@ -569,6 +663,28 @@ func (srv *Server) setupDiscovery() error {
 	return nil
 }
 
+// [BERITH] nodeFilter combines the node filters of the protocols, rejecting the
+// discovered nodes any of the protocols is not willing to dial.
+func (srv *Server) nodeFilter() func(*enode.Node) bool {
+	var filters []func(*enode.Node) bool
+	for _, p := range srv.Protocols {
+		if p.NodeFilter != nil {
+			filters = append(filters, p.NodeFilter)
+		}
+	}
+	if len(filters) == 0 {
+		return nil
+	}
+	return func(n *enode.Node) bool {
+		for _, filter := range filters {
+			if !filter(n) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
 func (srv *Server) setupListening() error {
 	// Launch the TCP listener.
 	listener, err := net.Listen("tcp", srv.ListenAddr)
@@ -711,6 +827,9 @@ running:
 				// Ensure that the trusted flag is set before checking against MaxPeers.
 				c.flags |= trustedConn
 			}
+			if srv.sentryLinks[c.node.ID()] {
+				c.flags |= sentryConn
+			}
 			// TODO: track in-progress inbound node IDs (pre-Peer) to avoid dialing them.
 			select {
 			case c.cont <- srv.encHandshakeChecks(peers, inboundCount, c):
@@ -791,6 +910,9 @@ func (srv *Server) protoHandshakeChecks(peers map[enode.ID]*Peer, inboundCount i
 
 func (srv *Server) encHandshakeChecks(peers map[enode.ID]*Peer, inboundCount int, c *conn) error {
 	switch {
+	case len(srv.SentryNodes) > 0 && !c.is(sentryConn):
+		// [BERITH] Validators behind sentries talk to their sentries only
+		return DiscUselessPeer
 	case !c.is(trustedConn|staticDialedConn) && len(peers) >= srv.MaxPeers:
 		return DiscTooManyPeers
 	case !c.is(trustedConn) && c.is(inboundConn) && inboundCount >= srv.maxInboundConns():
@@ -808,7 +930,7 @@ func (srv *Server) maxInboundConns() int {
 	return srv.MaxPeers - srv.maxDialedConns()
 }
 func (srv *Server) maxDialedConns() int {
-	if srv.NoDiscovery || srv.NoDial {
+	if srv.NoDial || (srv.NoDiscovery && !srv.hasDialCandidates()) {
 		return 0
 	}
 	r := srv.DialRatio
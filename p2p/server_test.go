@@ -414,6 +414,46 @@ func TestServerAtCap(t *testing.T) {
 	}
 }
 
+// Tests that a validator behind sentries only accepts its sentries.
+func TestServerSentryMode(t *testing.T) {
+	sentryKey := newkey()
+	sentryID := enode.PubkeyToIDV4(&sentryKey.PublicKey)
+	srv := &Server{
+		Config: Config{
+			PrivateKey:  newkey(),
+			MaxPeers:    10,
+			NoDial:      true,
+			StaticNodes: []*enode.Node{newNode(randomID(), nil)},
+			SentryNodes: []*enode.Node{newNode(sentryID, nil)},
+		},
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatalf("could not start: %v", err)
+	}
+	defer srv.Stop()
+
+	if !srv.NoDiscovery || len(srv.StaticNodes) != 1 || srv.StaticNodes[0].ID() != sentryID {
+		t.Fatalf("validator not restricted to its sentries: nodisc %t, static %v", srv.NoDiscovery, srv.StaticNodes)
+	}
+	newconn := func(id enode.ID) *conn {
+		fd, _ := net.Pipe()
+		tx := newTestTransport(&sentryKey.PublicKey, fd)
+		node := enode.SignNull(new(enr.Record), id)
+		return &conn{fd: fd, transport: tx, flags: inboundConn, node: node, cont: make(chan error)}
+	}
+	c := newconn(randomID())
+	if err := srv.checkpoint(c, srv.posthandshake); err != DiscUselessPeer {
+		t.Error("wrong error for non-sentry conn:", err)
+	}
+	c = newconn(sentryID)
+	if err := srv.checkpoint(c, srv.posthandshake); err != nil {
+		t.Error("unexpected error for sentry conn @posthandshake:", err)
+	}
+	if !c.is(sentryConn) || !c.is(trustedConn) {
+		t.Error("Server did not set sentry and trusted flags")
+	}
+}
+
 func TestServerPeerLimits(t *testing.T) {
 	srvkey := newkey()
 	clientkey := newkey()
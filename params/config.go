@@ -153,6 +153,9 @@ type ChainConfig struct {
 	BIP3Block *big.Int    `json:"bip3Block,omitempty"`
 	BIP4Block *big.Int    `json:"bip4Block,omitempty"`
 	BIP5Block *big.Int    `json:"bip5Block,omitempty"`
+	BIP6Block *big.Int    `json:"bip6Block,omitempty"` // Sponsored transactions (nil = no fork)
+	BIP7Block *big.Int    `json:"bip7Block,omitempty"` // Multisig accounts (nil = no fork)
+	BIP8Block *big.Int    `json:"bip8Block,omitempty"` // Staking precompile (nil = no fork)
 }
 
 type BSRRConfig struct {
@@ -178,7 +181,7 @@ func (c *ChainConfig) String() string {
 	default:
 		engine = "unknown"
 	}
-	return fmt.Sprintf("{ChainID: %v Homestead: %v DAO: %v DAOSupport: %v EIP150: %v EIP155: %v EIP158: %v Byzantium: %v Constantinople: %v BIP1: %v BIP2: %v BIP3: %v BIP4: %v BIP5: %v Engine: %v}",
+	return fmt.Sprintf("{ChainID: %v Homestead: %v DAO: %v DAOSupport: %v EIP150: %v EIP155: %v EIP158: %v Byzantium: %v Constantinople: %v BIP1: %v BIP2: %v BIP3: %v BIP4: %v BIP5: %v BIP6: %v BIP7: %v BIP8: %v Engine: %v}",
 		c.ChainID,
 		c.HomesteadBlock,
 		c.DAOForkBlock,
@@ -193,6 +196,9 @@ func (c *ChainConfig) String() string {
 		c.BIP3Block,
 		c.BIP4Block,
 		c.BIP5Block,
+		c.BIP6Block,
+		c.BIP7Block,
+		c.BIP8Block,
 		engine,
 	)
 }
@@ -252,6 +258,24 @@ func (c *ChainConfig) IsBIP5(num *big.Int) bool {
 	return isForked(c.BIP5Block, num)
 }
 
+// IsBIP6 returns whether num is either equal to the BIP6 fork block or greater.
+// BIP6 enables sponsored transactions whose gas is paid by a second signer.
+func (c *ChainConfig) IsBIP6(num *big.Int) bool {
+	return isForked(c.BIP6Block, num)
+}
+
+// IsBIP7 returns whether num is either equal to the BIP7 fork block or greater.
+// BIP7 enables native multisig accounts.
+func (c *ChainConfig) IsBIP7(num *big.Int) bool {
+	return isForked(c.BIP7Block, num)
+}
+
+// IsBIP8 returns whether num is either equal to the BIP8 fork block or greater.
+// BIP8 enables the staking precompiled contract.
+func (c *ChainConfig) IsBIP8(num *big.Int) bool {
+	return isForked(c.BIP8Block, num)
+}
+
 func (c *ChainConfig) IsBIP1Block(num *big.Int) bool {
 	if c.BIP1Block == nil || num == nil {
 		return false
@@ -347,6 +371,15 @@ func (c *ChainConfig) checkCompatible(newcfg *ChainConfig, head *big.Int) *Confi
 	if isForkIncompatible(c.BIP5Block, newcfg.BIP5Block, head) {
 		return newCompatError("bip5 fork block", c.BIP5Block, newcfg.BIP5Block)
 	}
+	if isForkIncompatible(c.BIP6Block, newcfg.BIP6Block, head) {
+		return newCompatError("bip6 fork block", c.BIP6Block, newcfg.BIP6Block)
+	}
+	if isForkIncompatible(c.BIP7Block, newcfg.BIP7Block, head) {
+		return newCompatError("bip7 fork block", c.BIP7Block, newcfg.BIP7Block)
+	}
+	if isForkIncompatible(c.BIP8Block, newcfg.BIP8Block, head) {
+		return newCompatError("bip8 fork block", c.BIP8Block, newcfg.BIP8Block)
+	}
 	return nil
 }
 
@@ -415,6 +448,7 @@ type Rules struct {
 	IsHomestead, IsEIP150, IsEIP155, IsEIP158 bool
 	IsByzantium, IsConstantinople             bool
 	IsBIP1, IsBIP2, IsBIP3, IsBIP4, IsBIP5    bool
+	IsBIP6, IsBIP7, IsBIP8                    bool
 }
 
 // Rules ensures c's ChainID is not nil.
@@ -436,5 +470,8 @@ func (c *ChainConfig) Rules(num *big.Int) Rules {
 		IsBIP3:           c.IsBIP3(num),
 		IsBIP4:           c.IsBIP4(num),
 		IsBIP5:           c.IsBIP5(num),
+		IsBIP6:           c.IsBIP6(num),
+		IsBIP7:           c.IsBIP7(num),
+		IsBIP8:           c.IsBIP8(num),
 	}
 }
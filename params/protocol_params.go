@@ -94,6 +94,8 @@ const (
 	Bn256ScalarMulGas       uint64 = 40000  // Gas needed for an elliptic curve scalar multiplication
 	Bn256PairingBaseGas     uint64 = 100000 // Base price for an elliptic curve pairing check
 	Bn256PairingPerPointGas uint64 = 80000  // Per-point price for an elliptic curve pairing check
+	StakingQueryGas         uint64 = 800    // Price for reading the staking state of an account
+	StakingUpdateGas        uint64 = 20000  // Price for staking or unstaking the balance of the caller
 
 	SloadGasEIP150        uint64 = 200
 	SloadGasEIP1884       uint64 = 800 // Cost of SLOAD after EIP 1884 (part of Istanbul)
@@ -20,6 +20,7 @@ import (
 	"bytes"
 	crand "crypto/rand"
 	mrand "math/rand"
+	"sort"
 	"testing"
 	"time"
 
@@ -144,6 +145,102 @@ func TestMissingKeyProof(t *testing.T) {
 }
 
 // mutateByte changes one byte in b.
+type entrySlice []*kv
+
+func (p entrySlice) Len() int           { return len(p) }
+func (p entrySlice) Less(i, j int) bool { return bytes.Compare(p[i].k, p[j].k) < 0 }
+func (p entrySlice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// sortedEntries returns the entries of a random trie in key order.
+func sortedEntries(vals map[string]*kv) entrySlice {
+	var entries entrySlice
+	for _, kv := range vals {
+		entries = append(entries, kv)
+	}
+	sort.Sort(entries)
+	return entries
+}
+
+// Tests that consecutive ranges of leaves with edge proofs are accepted, and
+// that the flag reporting further leaves is correct.
+func TestRangeProof(t *testing.T) {
+	trie, vals := randomTrie(4096)
+	entries := sortedEntries(vals)
+	root := trie.Hash()
+
+	for i := 0; i < 500; i++ {
+		start := mrand.Intn(len(entries))
+		end := start + mrand.Intn(len(entries)-start) + 1
+
+		proof := berithdb.NewMemDatabase()
+		if err := trie.Prove(entries[start].k, 0, proof); err != nil {
+			t.Fatalf("failed to prove the first node %v", err)
+		}
+		if err := trie.Prove(entries[end-1].k, 0, proof); err != nil {
+			t.Fatalf("failed to prove the last node %v", err)
+		}
+		var keys, values [][]byte
+		for j := start; j < end; j++ {
+			keys = append(keys, entries[j].k)
+			values = append(values, entries[j].v)
+		}
+		more, err := VerifyRangeProof(root, keys[0], keys[len(keys)-1], keys, values, proof)
+		if err != nil {
+			t.Fatalf("case %d(%d->%d): %v", i, start, end-1, err)
+		}
+		if more != (end < len(entries)) {
+			t.Fatalf("case %d(%d->%d): more entries flag mismatch: have %v", i, start, end-1, more)
+		}
+	}
+	// The whole leaf set needs no proof
+	var keys, values [][]byte
+	for _, entry := range entries {
+		keys = append(keys, entry.k)
+		values = append(values, entry.v)
+	}
+	if _, err := VerifyRangeProof(root, nil, nil, keys, values, nil); err != nil {
+		t.Fatalf("failed to verify whole range: %v", err)
+	}
+}
+
+// Tests that ranges with altered, missing or extra leaves are rejected.
+func TestBadRangeProof(t *testing.T) {
+	trie, vals := randomTrie(4096)
+	entries := sortedEntries(vals)
+	root := trie.Hash()
+
+	for i := 0; i < 500; i++ {
+		start := mrand.Intn(len(entries) - 3)
+		end := start + mrand.Intn(len(entries)-start-2) + 3
+
+		proof := berithdb.NewMemDatabase()
+		trie.Prove(entries[start].k, 0, proof)
+		trie.Prove(entries[end-1].k, 0, proof)
+
+		var keys, values [][]byte
+		for j := start; j < end; j++ {
+			keys = append(keys, entries[j].k)
+			values = append(values, common.CopyBytes(entries[j].v))
+		}
+		first, last := keys[0], keys[len(keys)-1]
+
+		index := 1 + mrand.Intn(len(keys)-2)
+		switch mrand.Intn(3) {
+		case 0: // Modified leaf
+			values[index] = randBytes(20)
+		case 1: // Gapped leaf
+			keys = append(keys[:index], keys[index+1:]...)
+			values = append(values[:index], values[index+1:]...)
+		case 2: // Extra leaf
+			keys = append(keys[:index+1], append([][]byte{randBytes(32)}, keys[index+1:]...)...)
+			values = append(values[:index+1], append([][]byte{randBytes(20)}, values[index+1:]...)...)
+		}
+		if _, err := VerifyRangeProof(root, first, last, keys, values, proof); err == nil {
+			t.Fatalf("case %d(%d->%d): expected error for bad range", i, start, end-1)
+		}
+	}
+}
+
 func mutateByte(b []byte) {
 	for r := mrand.Intn(len(b)); ; {
 		new := byte(mrand.Intn(255))